@@ -0,0 +1,625 @@
+// client package is a library for applications that read and write to the
+// chains. It hides the topology of the deployment: writes are sent to the
+// Coordinator, and reads are spread across every node in the chain that's
+// responsible for the key. If a node fails, the read is retried on another
+// node in the chain.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+const defaultRefreshInterval = 5 * time.Second
+
+var (
+	// ErrNotFound is returned by Get if the key doesn't exist.
+	ErrNotFound = errors.New("key doesn't exist")
+
+	// ErrVersionNotFound is returned by GetVersion if the version isn't
+	// committed or is no longer kept by the nodes.
+	ErrVersionNotFound = errors.New("version of key doesn't exist")
+
+	// ErrWatchLost is returned by Watch if a node can't carry on the watch
+	// where it left off, because the watch fell too far behind, or the node
+	// restarted or left the chain. Commits may have been missed, so reload the
+	// keys and watch again.
+	ErrWatchLost = errors.New("watch can't be resumed")
+
+	// ErrNoReplicas is returned if there are no nodes in the chain responsible
+	// for a key.
+	ErrNoReplicas = errors.New("no nodes available")
+
+	errNoCoordinator = errors.New("no coordinator client")
+)
+
+// Opts is for passing options to the Client constructor.
+type Opts struct {
+	// Address of a Coordinator
+	CdrAddress string
+	// Addresses of the other Coordinators in the cluster. If the Coordinator
+	// can't be reached, the next one is tried.
+	CdrAddresses []string
+	// For communication with the Coordinator. It's only connected once, so
+	// the Client can't reconnect; set CoordinatorTransport instead.
+	CoordinatorClient transport.CoordinatorClient
+	// CoordinatorTransport creates new clients for communication with the
+	// Coordinators. It's used when CoordinatorClient is nil, and lets the
+	// Client reconnect, to the next Coordinator, when the connection fails.
+	CoordinatorTransport transport.CoordinatorClientFactory
+	// Transport creates new clients for communication with nodes
+	Transport transport.NodeClientFactory
+	// How often to ask the Coordinator for the membership of the chains.
+	// Default: 5s
+	RefreshInterval time.Duration
+	// Log
+	Log *log.Logger
+}
+
+// Client reads and writes to the chains managed by a Coordinator. It's safe
+// for concurrent use.
+type Client struct {
+	mu        sync.Mutex
+	transport transport.NodeClientFactory
+	routes    *transport.RoutingTable
+	nodes     map[string]transport.NodeClient
+	refresh   time.Duration
+	next      uint64 // for spreading reads across replicas
+	done      chan struct{}
+	closeOnce sync.Once
+	log       *log.Logger
+	// Connection to the Coordinator, and the address it's connected to, as an
+	// index into cdrAddresses. Protected by cdrMu.
+	cdrMu        sync.Mutex
+	cdr          transport.CoordinatorClient
+	cdrTport     transport.CoordinatorClientFactory
+	cdrAddresses []string
+	cdrNext      int
+}
+
+// New creates a new Client. Connect must be called before using it.
+func New(opts Opts) *Client {
+	logger := opts.Log
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	refresh := opts.RefreshInterval
+	if refresh == 0 {
+		refresh = defaultRefreshInterval
+	}
+
+	return &Client{
+		transport:    opts.Transport,
+		routes:       &transport.RoutingTable{},
+		nodes:        make(map[string]transport.NodeClient),
+		refresh:      refresh,
+		done:         make(chan struct{}),
+		log:          logger,
+		cdr:          opts.CoordinatorClient,
+		cdrTport:     opts.CoordinatorTransport,
+		cdrAddresses: append([]string{opts.CdrAddress}, opts.CdrAddresses...),
+	}
+}
+
+// Connect to the Coordinator and fetch the membership of the chains. If the
+// Coordinator can't be reached, the other Coordinators in Opts.CdrAddresses
+// are tried. The membership is refreshed in the background until Close is
+// called.
+func (c *Client) Connect() error {
+	if c.cdrTport == nil {
+		if err := c.cdr.Connect(c.cdrAddresses[0]); err != nil {
+			return err
+		}
+	}
+
+	if err := c.refreshRoutes(); err != nil {
+		return err
+	}
+
+	go c.refreshLoop()
+	return nil
+}
+
+// Close all connections. It's safe to call more than once.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+
+	c.mu.Lock()
+	for addr, n := range c.nodes {
+		n.Close()
+		delete(c.nodes, addr)
+	}
+	c.mu.Unlock()
+
+	c.cdrMu.Lock()
+	defer c.cdrMu.Unlock()
+
+	if c.cdr == nil {
+		return nil
+	}
+	err := c.cdr.Close()
+	if c.cdrTport != nil {
+		c.cdr = nil
+	}
+	return err
+}
+
+// Routes returns the membership of the chains as last seen by the Client.
+func (c *Client) Routes() *transport.RoutingTable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.routes
+}
+
+// Get the latest committed value for key.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := c.GetWithOpts(ctx, key, nil)
+	return value, err
+}
+
+// GetWithOpts reads key with the consistency in opts and returns the value and
+// it's version. Eventual and bounded reads are served by whichever node the
+// read is sent to without asking the tail, so eventual reads may return a
+// value that isn't committed yet and bounded reads may return an older value
+// than Get. A nil opts is the same as Get.
+func (c *Client) GetWithOpts(
+	ctx context.Context,
+	key string,
+	opts *transport.ReadOpts,
+) ([]byte, uint64, error) {
+	var value []byte
+	var version uint64
+
+	err := c.tryReplicas(ctx, c.chainFor(key), func(n transport.NodeClient) error {
+		_, v, ver, err := n.Read(key, opts)
+		value, version = v, ver
+		return err
+	})
+
+	return value, version, err
+}
+
+// GetVersion reads a committed version of key. Together with the version
+// returned by GetWithOpts, it lets applications read the value they based a
+// CompareAndSwap on, or look at an earlier value of the key, as long as the
+// nodes' stores still keep that version. If they don't, ErrVersionNotFound is
+// returned.
+func (c *Client) GetVersion(
+	ctx context.Context,
+	key string,
+	version uint64,
+) ([]byte, error) {
+	var value []byte
+
+	err := c.tryReplicas(ctx, c.chainFor(key), func(n transport.NodeClient) error {
+		_, v, err := n.ReadAtVersion(key, version)
+		value = v
+		return err
+	})
+
+	return value, err
+}
+
+// History lists the committed versions of key that the nodes' stores keep,
+// oldest first. Tombstones are included, so the history shows when the key was
+// deleted. How far back it goes depends on the stores' store.Retention.
+func (c *Client) History(
+	ctx context.Context,
+	key string,
+) ([]transport.HistoryItem, error) {
+	var items []transport.HistoryItem
+
+	err := c.tryReplicas(ctx, c.chainFor(key), func(n transport.NodeClient) error {
+		h, err := n.History(key)
+		items = h
+		return err
+	})
+
+	return items, err
+}
+
+// Put writes a new version of key. The write is sent to the Coordinator, which
+// forwards it to the head of the chain responsible for the key. Put returns the
+// new version once it's been committed by every node in the chain, so reads
+// that start after Put returns see the new value.
+func (c *Client) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	return c.write(ctx, func(cdr transport.CoordinatorClient) (uint64, error) {
+		return cdr.Write(key, value, 0)
+	})
+}
+
+// PutWithTTL is Put for a key that expires. Once ttl has passed, the head of
+// the chain deletes the key, unless a newer version has been written. The key
+// can still be read for a moment after it expires, until the delete is
+// committed.
+func (c *Client) PutWithTTL(
+	ctx context.Context,
+	key string,
+	value []byte,
+	ttl time.Duration,
+) (uint64, error) {
+	return c.write(ctx, func(cdr transport.CoordinatorClient) (uint64, error) {
+		return cdr.Write(key, value, ttl)
+	})
+}
+
+// CompareAndSwap writes a new version of key if the newest version of the key
+// is expected. If it isn't, a *transport.ConflictError is returned with the
+// newest version.
+func (c *Client) CompareAndSwap(
+	ctx context.Context,
+	key string,
+	expected uint64,
+	value []byte,
+) (uint64, error) {
+	return c.write(ctx, func(cdr transport.CoordinatorClient) (uint64, error) {
+		return cdr.CompareAndSwap(key, expected, value)
+	})
+}
+
+// PutIfAbsent writes key if it doesn't exist. If it does, a
+// *transport.ConflictError is returned.
+func (c *Client) PutIfAbsent(
+	ctx context.Context,
+	key string,
+	value []byte,
+) (uint64, error) {
+	return c.write(ctx, func(cdr transport.CoordinatorClient) (uint64, error) {
+		return cdr.PutIfAbsent(key, value)
+	})
+}
+
+// Delete removes key. Like Put, the delete is sent to the Coordinator and the
+// committed version of the tombstone is returned.
+func (c *Client) Delete(ctx context.Context, key string) (uint64, error) {
+	return c.write(ctx, func(cdr transport.CoordinatorClient) (uint64, error) {
+		return cdr.Delete(key)
+	})
+}
+
+// WriteBatch writes every item and returns the committed versions in the same
+// order as items. The items for each chain are sent down the chain as a single
+// message and committed together, which is much faster than a Put per item
+// when loading lots of keys. A batch that spans several chains isn't atomic.
+func (c *Client) WriteBatch(
+	ctx context.Context,
+	items []transport.Item,
+) ([]uint64, error) {
+	var versions []uint64
+	err := c.callCoordinator(ctx, func(cdr transport.CoordinatorClient) error {
+		v, err := cdr.WriteBatch(items)
+		versions = v
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Transaction applies the writes of txn atomically, and only if every
+// precondition holds. If one doesn't, a *transport.ConflictError is returned
+// and nothing is written. Every key in txn has to belong to the same chain.
+func (c *Client) Transaction(
+	ctx context.Context,
+	txn *transport.Transaction,
+) ([]uint64, error) {
+	var versions []uint64
+	err := c.callCoordinator(ctx, func(cdr transport.CoordinatorClient) error {
+		v, err := cdr.Transaction(txn)
+		versions = v
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// write runs fn with callCoordinator and returns the version written by fn.
+func (c *Client) write(
+	ctx context.Context,
+	fn func(transport.CoordinatorClient) (uint64, error),
+) (uint64, error) {
+	var version uint64
+	err := c.callCoordinator(ctx, func(cdr transport.CoordinatorClient) error {
+		v, err := fn(cdr)
+		version = v
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// List returns every committed key/value pair in every chain. The pairs are
+// exported a chunk at a time, but they're all returned at once, so use Export
+// for large namespaces.
+func (c *Client) List(ctx context.Context) ([]transport.Item, error) {
+	items := []transport.Item{}
+
+	err := c.Export(ctx, func(item transport.VersionedItem) error {
+		items = append(items, transport.Item{Key: item.Key, Value: item.Value})
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (c *Client) chainFor(key string) int {
+	return c.Routes().ChainFor(key)
+}
+
+// replicas returns the addresses of the nodes in the chain. The order is
+// rotated on every call so that reads are spread across the chain. Nodes that
+// aren't serving yet come last, so they're only tried if the others fail.
+func (c *Client) replicas(chain int) []string {
+	rt := c.Routes()
+	if chain >= len(rt.Chains) || len(rt.Chains[chain]) == 0 {
+		return nil
+	}
+
+	addrs := rt.Chains[chain]
+	start := int(atomic.AddUint64(&c.next, 1) % uint64(len(addrs)))
+	rotated := make([]string, 0, len(addrs))
+	rotated = append(rotated, addrs[start:]...)
+	rotated = append(rotated, addrs[:start]...)
+
+	ordered := make([]string, 0, len(addrs))
+	for _, addr := range rotated {
+		if rt.Serving(addr) {
+			ordered = append(ordered, addr)
+		}
+	}
+	for _, addr := range rotated {
+		if !rt.Serving(addr) {
+			ordered = append(ordered, addr)
+		}
+	}
+	return ordered
+}
+
+// tryReplicas calls fn with a node from the chain. If the call fails, it's
+// retried with the next node in the chain. If every node fails, the membership
+// of the chains is refreshed and every node is tried one more time.
+func (c *Client) tryReplicas(
+	ctx context.Context,
+	chain int,
+	fn func(transport.NodeClient) error,
+) error {
+	lastErr := ErrNoReplicas
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			if err := c.refreshRoutes(); err != nil {
+				c.log.Printf("Failed to refresh routes: %v\n", err)
+			}
+		}
+
+		for _, addr := range c.replicas(chain) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			n, err := c.node(addr)
+			if err != nil {
+				c.log.Printf("Failed to connect to node %s: %v\n", addr, err)
+				lastErr = err
+				continue
+			}
+
+			err = call(ctx, func() error { return fn(n) })
+			if err == nil {
+				return nil
+			}
+
+			if transport.IsNotFound(err) {
+				return ErrNotFound
+			}
+
+			if transport.IsVersionNotFound(err) {
+				return ErrVersionNotFound
+			}
+
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			c.log.Printf("Request to node %s failed, trying another: %v\n", addr, err)
+			// A draining node is still up, so the connection is kept until it
+			// leaves the chain. So is a node that's still catching up.
+			if !transport.IsDraining(err) && !transport.IsNotServing(err) {
+				c.dropNode(addr, n)
+			}
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// call runs fn and waits for it to finish or for ctx to be done, whichever
+// happens first.
+func call(ctx context.Context, fn func() error) error {
+	result := make(chan error, 1)
+	go func() { result <- fn() }()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callCoordinator runs fn with the connection to the Coordinator, using call.
+// If fn fails, and the Coordinator doesn't answer Routes either, the
+// connection is dropped, so the next request connects to the next Coordinator.
+// fn isn't retried, since writes aren't safe to repeat.
+func (c *Client) callCoordinator(
+	ctx context.Context,
+	fn func(transport.CoordinatorClient) error,
+) error {
+	cdr, err := c.coordinator()
+	if err != nil {
+		return err
+	}
+
+	err = call(ctx, func() error { return fn(cdr) })
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	if _, conflict := err.(*transport.ConflictError); conflict {
+		return err
+	}
+
+	if _, routesErr := cdr.Routes(); routesErr != nil {
+		c.dropCoordinator(cdr)
+	}
+	return err
+}
+
+// coordinator returns the connection to the Coordinator. If there isn't one,
+// it connects to the Coordinators in turn, starting with the one after the
+// last that failed.
+func (c *Client) coordinator() (transport.CoordinatorClient, error) {
+	c.cdrMu.Lock()
+	defer c.cdrMu.Unlock()
+
+	if c.cdr != nil {
+		return c.cdr, nil
+	}
+	if c.cdrTport == nil {
+		return nil, errNoCoordinator
+	}
+
+	var err error
+	for range c.cdrAddresses {
+		addr := c.cdrAddresses[c.cdrNext%len(c.cdrAddresses)]
+		cdr := c.cdrTport()
+		if err = cdr.Connect(addr); err == nil {
+			c.cdr = cdr
+			return cdr, nil
+		}
+		c.log.Printf("Failed to connect to coordinator %s: %v\n", addr, err)
+		c.cdrNext++
+	}
+	return nil, err
+}
+
+// dropCoordinator closes the connection to the Coordinator so that the next
+// request connects to the next one. A CoordinatorClient from Opts is kept,
+// since it can't be replaced.
+func (c *Client) dropCoordinator(cdr transport.CoordinatorClient) {
+	c.cdrMu.Lock()
+	defer c.cdrMu.Unlock()
+	if c.cdr != cdr || c.cdrTport == nil {
+		return
+	}
+	c.log.Printf("Lost the connection to coordinator %s\n", c.cdrAddresses[c.cdrNext%len(c.cdrAddresses)])
+	cdr.Close()
+	c.cdr = nil
+	c.cdrNext++
+}
+
+// node returns a connection to the node, connecting if there isn't one.
+func (c *Client) node(addr string) (transport.NodeClient, error) {
+	c.mu.Lock()
+	n, has := c.nodes[addr]
+	c.mu.Unlock()
+
+	if has {
+		return n, nil
+	}
+
+	n = c.transport()
+	if err := n.Connect(addr); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine may have connected in the meantime.
+	if existing, has := c.nodes[addr]; has {
+		n.Close()
+		return existing, nil
+	}
+
+	c.nodes[addr] = n
+	return n, nil
+}
+
+// dropNode closes the connection to a node so that the next request
+// reconnects.
+func (c *Client) dropNode(addr string, n transport.NodeClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes[addr] == n {
+		n.Close()
+		delete(c.nodes, addr)
+	}
+}
+
+// refreshRoutes asks the Coordinator for the membership of the chains.
+// Connections to nodes that are no longer in any chain are closed.
+func (c *Client) refreshRoutes() error {
+	cdr, err := c.coordinator()
+	if err != nil {
+		return err
+	}
+
+	rt, err := cdr.Routes()
+	if err != nil {
+		c.dropCoordinator(cdr)
+		return err
+	}
+
+	current := make(map[string]bool)
+	for _, chain := range rt.Chains {
+		for _, addr := range chain {
+			current[addr] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, n := range c.nodes {
+		if !current[addr] {
+			c.log.Printf("Node %s left the chain\n", addr)
+			n.Close()
+			delete(c.nodes, addr)
+		}
+	}
+
+	c.routes = rt
+	return nil
+}
+
+func (c *Client) refreshLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.refresh):
+		}
+
+		if err := c.refreshRoutes(); err != nil {
+			c.log.Printf("Failed to refresh routes: %v\n", err)
+		}
+	}
+}
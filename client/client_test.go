@@ -0,0 +1,706 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+type FakeCoordinator struct {
+	transport.CoordinatorClient
+	mu      sync.Mutex
+	routes  *transport.RoutingTable
+	writes  map[string][]byte
+	ttls    map[string]time.Duration
+	batches [][]transport.Item
+	// Progress returned by each call to Decommission, in order. The last one
+	// is repeated.
+	decommissions []transport.DecommissionStatus
+	// Whether the Coordinator has stopped answering.
+	down bool
+}
+
+var errConnRefused = errors.New("connection refused")
+
+func (f *FakeCoordinator) Connect(string) error { return nil }
+func (f *FakeCoordinator) Close() error         { return nil }
+
+func (f *FakeCoordinator) Routes() (*transport.RoutingTable, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errConnRefused
+	}
+	return f.routes, nil
+}
+
+func (f *FakeCoordinator) Write(
+	key string,
+	value []byte,
+	ttl time.Duration,
+) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return 0, errConnRefused
+	}
+	f.writes[key] = value
+	f.ttls[key] = ttl
+	return uint64(len(f.writes)), nil
+}
+
+// ClusterCoordinator connects to the FakeCoordinator in cluster at the address
+// it's given, unless it's down.
+type ClusterCoordinator struct {
+	*FakeCoordinator
+	cluster map[string]*FakeCoordinator
+}
+
+func (c *ClusterCoordinator) Connect(address string) error {
+	cdr, has := c.cluster[address]
+	if !has {
+		return errConnRefused
+	}
+	cdr.mu.Lock()
+	defer cdr.mu.Unlock()
+	if cdr.down {
+		return errConnRefused
+	}
+	c.FakeCoordinator = cdr
+	return nil
+}
+
+func (f *FakeCoordinator) WriteBatch(items []transport.Item) ([]uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, items)
+	versions := make([]uint64, len(items))
+	for i, item := range items {
+		f.writes[item.Key] = item.Value
+		versions[i] = uint64(len(f.writes))
+	}
+	return versions, nil
+}
+
+func (f *FakeCoordinator) Decommission(
+	address string,
+) (*transport.DecommissionStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.decommissions[0]
+	if len(f.decommissions) > 1 {
+		f.decommissions = f.decommissions[1:]
+	}
+	return &status, nil
+}
+
+func (f *FakeCoordinator) CompareAndSwap(
+	key string,
+	expected uint64,
+	value []byte,
+) (uint64, error) {
+	return 0, &transport.ConflictError{Key: key, Latest: expected + 1, Exists: true}
+}
+
+// cluster is a set of fake nodes. Each node stores the items of it's chain.
+type cluster struct {
+	mu    sync.Mutex
+	items map[string][]transport.Item // by node address
+	down  map[string]bool
+	reads map[string]int
+	// Nodes that can't carry on a watch.
+	compacted map[string]bool
+}
+
+type FakeNode struct {
+	transport.NodeClient
+	c    *cluster
+	addr string
+}
+
+func (f *FakeNode) Connect(addr string) error {
+	f.addr = addr
+	return nil
+}
+
+func (f *FakeNode) Close() error { return nil }
+
+// Read returns the index of the item on the node as it's version.
+func (f *FakeNode) Read(
+	key string,
+	_ *transport.ReadOpts,
+) (string, []byte, uint64, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	if f.c.down[f.addr] {
+		return "", nil, 0, errors.New("connection refused")
+	}
+	f.c.reads[f.addr]++
+	for i, item := range f.c.items[f.addr] {
+		if item.Key == key {
+			return key, item.Value, uint64(i), nil
+		}
+	}
+	return "", nil, 0, transport.ErrNotFound
+}
+
+func (f *FakeNode) Status() (*transport.NodeStatus, error) {
+	return &transport.NodeStatus{Address: f.addr, State: transport.NodeServing}, nil
+}
+
+func (f *FakeNode) History(key string) ([]transport.HistoryItem, error) {
+	_, value, version, err := f.Read(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []transport.HistoryItem{{Value: value, Version: version}}, nil
+}
+
+// Scan returns one key per page, so callers have to follow Next.
+func (f *FakeNode) Scan(start, end string, _ int) (*transport.ScanResult, error) {
+	result, err := f.scanPage(func(key string) bool {
+		return key >= start && (end == "" || key < end)
+	})
+	if result != nil && result.Next != "" {
+		result.Next += "\x00"
+	}
+	return result, err
+}
+
+func (f *FakeNode) ScanPrefix(prefix, cursor string, _ int) (*transport.ScanResult, error) {
+	return f.scanPage(func(key string) bool {
+		return strings.HasPrefix(key, prefix) && key > cursor
+	})
+}
+
+// scanPage returns the first key on the node that matches.
+func (f *FakeNode) scanPage(match func(string) bool) (*transport.ScanResult, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	if f.c.down[f.addr] {
+		return nil, errors.New("connection refused")
+	}
+
+	result := &transport.ScanResult{}
+	for _, item := range f.c.items[f.addr] {
+		if !match(item.Key) || result.Next != "" && item.Key > result.Next {
+			continue
+		}
+		result.Items = []transport.VersionedItem{{Key: item.Key, Value: item.Value}}
+		result.Next = item.Key
+	}
+	return result, nil
+}
+
+func (f *FakeNode) ReadAtVersion(key string, version uint64) (string, []byte, error) {
+	_, value, latest, err := f.Read(key, nil)
+	if err == nil && version != latest {
+		return "", nil, transport.ErrVersionNotFound
+	}
+	return key, value, err
+}
+
+// Export returns one key per chunk, so callers have to follow Next.
+func (f *FakeNode) Export(cursor string, _ int) (*transport.ExportChunk, error) {
+	result, err := f.scanPage(func(key string) bool { return key > cursor })
+	if err != nil {
+		return nil, err
+	}
+	return &transport.ExportChunk{Items: result.Items, Next: result.Next}, nil
+}
+
+// Watch treats every item on the node as a commit made after the watch started.
+// Item i has revision i+2, and a from of 0 is answered with revision 1.
+func (f *FakeNode) Watch(prefix string, from uint64) (*transport.WatchResult, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	if f.c.down[f.addr] {
+		return nil, errors.New("connection refused")
+	}
+	if f.c.compacted[f.addr] {
+		return nil, transport.ErrWatchCompacted
+	}
+
+	result := &transport.WatchResult{Revision: 1}
+	if from == 0 {
+		return result, nil
+	}
+
+	for i, item := range f.c.items[f.addr] {
+		revision := uint64(i + 2)
+		if revision > from && strings.HasPrefix(item.Key, prefix) {
+			result.Events = append(result.Events, transport.WatchEvent{
+				Key:      item.Key,
+				Value:    item.Value,
+				Revision: revision,
+			})
+		}
+		result.Revision = revision
+	}
+	return result, nil
+}
+
+func setup(t *testing.T) (*Client, *FakeCoordinator, *cluster) {
+	t.Helper()
+
+	routes := &transport.RoutingTable{Chains: [][]string{{"a", "b"}, {"c"}}}
+	c := &cluster{
+		items: make(map[string][]transport.Item),
+		down:  make(map[string]bool),
+		reads: make(map[string]int),
+	}
+
+	for _, key := range []string{"hello", "foo", "bar"} {
+		item := transport.Item{Key: key, Value: []byte(key + "-value")}
+		for _, addr := range routes.Chains[routes.ChainFor(key)] {
+			c.items[addr] = append(c.items[addr], item)
+		}
+	}
+
+	cdr := &FakeCoordinator{
+		routes: routes,
+		writes: make(map[string][]byte),
+		ttls:   make(map[string]time.Duration),
+	}
+	client := New(Opts{
+		CoordinatorClient: cdr,
+		Transport: func() transport.NodeClient {
+			return &FakeNode{c: c}
+		},
+		Log: log.New(io.Discard, "", 0),
+	})
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() unexpected error\n  got: %#v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client, cdr, c
+}
+
+func TestGet(t *testing.T) {
+	client, _, _ := setup(t)
+
+	got, err := client.Get(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Get(hello) unexpected error\n  got: %#v", err)
+	}
+	if want := []byte("hello-value"); !bytes.Equal(want, got) {
+		t.Fatalf("Get(hello) unexpected value\n  want: %s\n  got: %s", want, got)
+	}
+
+	if _, err := client.Get(context.Background(), "unknown"); err != ErrNotFound {
+		t.Fatalf("Get(unknown) unexpected error\n  want: %#v\n  got: %#v", ErrNotFound, err)
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	client, _, _ := setup(t)
+
+	value, version, err := client.GetWithOpts(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatalf("GetWithOpts(hello) unexpected error\n  got: %#v", err)
+	}
+
+	got, err := client.GetVersion(context.Background(), "hello", version)
+	if err != nil {
+		t.Fatalf("GetVersion(hello, %d) unexpected error\n  got: %#v", version, err)
+	}
+	if !bytes.Equal(value, got) {
+		t.Fatalf("GetVersion(hello, %d) unexpected value\n  want: %s\n  got: %s", version, value, got)
+	}
+
+	_, err = client.GetVersion(context.Background(), "hello", version+1)
+	if err != ErrVersionNotFound {
+		t.Fatalf("GetVersion(hello, %d) unexpected error\n  want: %#v\n  got: %#v", version+1, ErrVersionNotFound, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	client, _, _ := setup(t)
+
+	value, version, err := client.GetWithOpts(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatalf("GetWithOpts(hello) unexpected error\n  got: %#v", err)
+	}
+
+	want := []transport.HistoryItem{{Value: value, Version: version}}
+	got, err := client.History(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("History(hello) unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("History(hello) unexpected items (-want +got):\n%s", diff)
+	}
+
+	if _, err := client.History(context.Background(), "unknown"); err != ErrNotFound {
+		t.Fatalf("History(unknown) unexpected error\n  want: %#v\n  got: %#v", ErrNotFound, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	client, _, c := setup(t)
+	c.down["a"] = true
+
+	scanned := func(scan func(fn func(transport.VersionedItem) error) error) []string {
+		keys := []string{}
+		err := scan(func(item transport.VersionedItem) error {
+			if !bytes.Equal([]byte(item.Key+"-value"), item.Value) {
+				t.Fatalf("unexpected value for %s\n  got: %s", item.Key, item.Value)
+			}
+			keys = append(keys, item.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error\n  got: %#v", err)
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name string
+		scan func(fn func(transport.VersionedItem) error) error
+		want []string
+	}{
+		{
+			name: "everything",
+			scan: func(fn func(transport.VersionedItem) error) error {
+				return client.Scan(context.Background(), "", "", fn)
+			},
+			want: []string{"bar", "foo", "hello"},
+		},
+		{
+			name: "range",
+			scan: func(fn func(transport.VersionedItem) error) error {
+				return client.Scan(context.Background(), "c", "hello", fn)
+			},
+			want: []string{"foo"},
+		},
+		{
+			name: "prefix",
+			scan: func(fn func(transport.VersionedItem) error) error {
+				return client.ScanPrefix(context.Background(), "h", fn)
+			},
+			want: []string{"hello"},
+		},
+		{
+			name: "empty prefix",
+			scan: func(fn func(transport.VersionedItem) error) error {
+				return client.ScanPrefix(context.Background(), "", fn)
+			},
+			want: []string{"bar", "foo", "hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, scanned(tt.scan)); diff != "" {
+				t.Fatalf("unexpected keys (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// An error from fn stops the scan.
+	stop := errors.New("stop")
+	calls := 0
+	err := client.Scan(context.Background(), "", "", func(transport.VersionedItem) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("Scan() unexpected result\n  want: %#v after 1 call\n  got: %#v after %d calls", stop, err, calls)
+	}
+}
+
+// Reads are spread across every node in the chain, and a failed node is
+// skipped.
+func TestGetSpreadAndRetry(t *testing.T) {
+	client, cdr, c := setup(t)
+
+	// "bar" is assigned to the chain with two nodes.
+	key := "bar"
+	replicas := cdr.routes.Chains[cdr.routes.ChainFor(key)]
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.Get(context.Background(), key); err != nil {
+			t.Fatalf("Get(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+
+	c.mu.Lock()
+	for _, addr := range replicas {
+		if c.reads[addr] != 2 {
+			t.Errorf("expected reads to be spread evenly\n  reads: %v", c.reads)
+		}
+	}
+	c.down[replicas[0]] = true
+	c.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(context.Background(), key); err != nil {
+			t.Fatalf("Get(%s) with a failed node unexpected error\n  got: %#v", key, err)
+		}
+	}
+}
+
+// Nodes that aren't serving are only read from when the others fail.
+func TestGetNotServing(t *testing.T) {
+	client, cdr, c := setup(t)
+
+	key := "bar"
+	replicas := cdr.routes.Chains[cdr.routes.ChainFor(key)]
+	cdr.mu.Lock()
+	cdr.routes.States = map[string]transport.NodeState{replicas[0]: transport.NodeSyncing}
+	cdr.mu.Unlock()
+	if err := client.refreshRoutes(); err != nil {
+		t.Fatalf("refreshRoutes() unexpected error\n  got: %#v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.Get(context.Background(), key); err != nil {
+			t.Fatalf("Get(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+
+	c.mu.Lock()
+	want := map[string]int{replicas[1]: 4}
+	if diff := cmp.Diff(want, c.reads); diff != "" {
+		c.mu.Unlock()
+		t.Fatalf("unexpected reads (-want +got):\n%s", diff)
+	}
+	c.down[replicas[1]] = true
+	c.mu.Unlock()
+
+	if _, err := client.Get(context.Background(), key); err != nil {
+		t.Fatalf("Get(%s) with a failed node unexpected error\n  got: %#v", key, err)
+	}
+}
+
+func TestNodeStatus(t *testing.T) {
+	client, _, _ := setup(t)
+
+	status, err := client.NodeStatus(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("NodeStatus(a) unexpected error\n  got: %#v", err)
+	}
+	want := &transport.NodeStatus{Address: "a", State: transport.NodeServing}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Fatalf("NodeStatus(a) unexpected status (-want +got):\n%s", diff)
+	}
+}
+
+func TestPut(t *testing.T) {
+	client, cdr, _ := setup(t)
+
+	version, err := client.Put(context.Background(), "hello", []byte("world"))
+	if err != nil {
+		t.Fatalf("Put(hello) unexpected error\n  got: %#v", err)
+	}
+	if version != 1 {
+		t.Fatalf("Put(hello) unexpected version\n  want: %d\n  got: %d", 1, version)
+	}
+
+	if got := cdr.writes["hello"]; !bytes.Equal(got, []byte("world")) {
+		t.Fatalf("Put(hello) unexpected value at coordinator\n  got: %s", got)
+	}
+}
+
+func TestPutWithTTL(t *testing.T) {
+	client, cdr, _ := setup(t)
+
+	version, err := client.PutWithTTL(context.Background(), "session", []byte("abc"), time.Minute)
+	if err != nil {
+		t.Fatalf("PutWithTTL(session) unexpected error\n  got: %#v", err)
+	}
+	if version != 1 {
+		t.Fatalf("PutWithTTL(session) unexpected version\n  want: %d\n  got: %d", 1, version)
+	}
+
+	if got := cdr.writes["session"]; !bytes.Equal(got, []byte("abc")) {
+		t.Fatalf("PutWithTTL(session) unexpected value at coordinator\n  got: %s", got)
+	}
+	if got := cdr.ttls["session"]; got != time.Minute {
+		t.Fatalf("PutWithTTL(session) unexpected ttl\n  want: %s\n  got: %s", time.Minute, got)
+	}
+}
+
+// Decommission follows the progress until the node has left the chain, and
+// only reports changes.
+func TestDecommission(t *testing.T) {
+	client, cdr, _ := setup(t)
+
+	draining := transport.DecommissionStatus{
+		Address: "b",
+		Stage:   transport.DecommissionDraining,
+		Node:    transport.DrainStatus{Replicating: 1},
+	}
+	done := transport.DecommissionStatus{Address: "b", Stage: transport.DecommissionDone}
+	cdr.decommissions = []transport.DecommissionStatus{draining, draining, done}
+
+	got := []transport.DecommissionStatus{}
+	err := client.Decommission(context.Background(), "b", func(status transport.DecommissionStatus) {
+		got = append(got, status)
+	})
+	if err != nil {
+		t.Fatalf("Decommission(b) unexpected error\n  got: %#v", err)
+	}
+
+	if diff := cmp.Diff([]transport.DecommissionStatus{draining, done}, got); diff != "" {
+		t.Fatalf("Decommission(b) unexpected progress (-want +got):\n%s", diff)
+	}
+
+	failed := transport.DecommissionStatus{
+		Address: "b",
+		Stage:   transport.DecommissionFailed,
+		Error:   "timed out",
+	}
+	cdr.decommissions = []transport.DecommissionStatus{failed}
+
+	err = client.Decommission(context.Background(), "b", func(transport.DecommissionStatus) {})
+	if err == nil || err.Error() != "timed out" {
+		t.Fatalf("Decommission(b) unexpected error\n  want: timed out\n  got: %#v", err)
+	}
+}
+
+func TestCompareAndSwapConflict(t *testing.T) {
+	client, _, _ := setup(t)
+
+	_, err := client.CompareAndSwap(context.Background(), "hello", 1, []byte("world"))
+
+	want := &transport.ConflictError{Key: "hello", Latest: 2, Exists: true}
+	var got *transport.ConflictError
+	if !errors.As(err, &got) {
+		t.Fatalf("CompareAndSwap(hello) unexpected error\n  want: %#v\n  got: %#v", want, err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("CompareAndSwap(hello) unexpected conflict (-want +got):\n%s", diff)
+	}
+}
+
+func TestList(t *testing.T) {
+	client, _, c := setup(t)
+	c.down["a"] = true
+
+	items, err := client.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() unexpected error\n  got: %#v", err)
+	}
+
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	sort.Strings(keys)
+
+	if diff := cmp.Diff([]string{"bar", "foo", "hello"}, keys); diff != "" {
+		t.Fatalf("List() unexpected keys (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client, _, c := setup(t)
+
+	keys := []string{}
+	stop := errors.New("stop")
+	err := client.Watch(context.Background(), "", func(event transport.WatchEvent) error {
+		keys = append(keys, event.Key)
+		if len(keys) == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("Watch() unexpected error\n  want: %#v\n  got: %#v", stop, err)
+	}
+
+	sort.Strings(keys)
+	if diff := cmp.Diff([]string{"bar", "foo", "hello"}, keys); diff != "" {
+		t.Fatalf("Watch() unexpected keys (-want +got):\n%s", diff)
+	}
+
+	c.mu.Lock()
+	c.compacted = map[string]bool{"c": true}
+	c.mu.Unlock()
+
+	err = client.Watch(context.Background(), "", func(transport.WatchEvent) error {
+		return nil
+	})
+	if err != ErrWatchLost {
+		t.Fatalf("Watch() unexpected error\n  want: %#v\n  got: %#v", ErrWatchLost, err)
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	client, _, _ := setup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.Get(ctx, "hello"); err != context.Canceled {
+		t.Fatalf("Get(hello) unexpected error\n  want: %#v\n  got: %#v", context.Canceled, err)
+	}
+}
+
+// When the Coordinator stops answering, the Client moves on to the next one.
+func TestCoordinatorFailover(t *testing.T) {
+	routes := &transport.RoutingTable{Chains: [][]string{{"a"}}}
+	cluster := map[string]*FakeCoordinator{
+		"c1": {routes: routes, writes: map[string][]byte{}, ttls: map[string]time.Duration{}},
+		"c2": {routes: routes, writes: map[string][]byte{}, ttls: map[string]time.Duration{}},
+	}
+	cluster["c1"].down = true
+
+	client := New(Opts{
+		CdrAddress:   "c1",
+		CdrAddresses: []string{"c2"},
+		CoordinatorTransport: func() transport.CoordinatorClient {
+			return &ClusterCoordinator{cluster: cluster}
+		},
+		Log: log.New(io.Discard, "", 0),
+	})
+
+	// c1 is down, so the Client connects to c2.
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() unexpected error\n  got: %#v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Put(context.Background(), "hello", []byte("world")); err != nil {
+		t.Fatalf("Put(hello) unexpected error\n  got: %#v", err)
+	}
+
+	// Once c2 goes down too, and c1 comes back, the failed write moves the
+	// Client back to c1.
+	cluster["c1"].mu.Lock()
+	cluster["c1"].down = false
+	cluster["c1"].mu.Unlock()
+	cluster["c2"].mu.Lock()
+	cluster["c2"].down = true
+	cluster["c2"].mu.Unlock()
+
+	if _, err := client.Put(context.Background(), "hello", []byte("again")); err != errConnRefused {
+		t.Fatalf("Put(hello) unexpected error\n  want: %#v\n  got: %#v", errConnRefused, err)
+	}
+	if _, err := client.Put(context.Background(), "hello", []byte("again")); err != nil {
+		t.Fatalf("Put(hello) unexpected error\n  got: %#v", err)
+	}
+
+	cluster["c1"].mu.Lock()
+	defer cluster["c1"].mu.Unlock()
+	if got := cluster["c1"].writes["hello"]; !bytes.Equal(got, []byte("again")) {
+		t.Fatalf("unexpected value written to c1\n  want: %s\n  got: %s", "again", got)
+	}
+
+	// Closing twice doesn't panic.
+	client.Close()
+}
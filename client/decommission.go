@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+// decommissionPollInterval is how often Decommission asks the Coordinator for
+// progress.
+const decommissionPollInterval = 500 * time.Millisecond
+
+// Decommission gracefully takes the node at address out of it's chain, for
+// example before upgrading the machine it runs on. The Coordinator drains the
+// node, waits for it's neighbors to catch up, and then splices it out. fn is
+// called with the progress every time it changes. Decommission returns once
+// the node has left the chain and can be stopped, or with an error if the
+// Coordinator gave up. Canceling ctx stops following the progress, but not
+// the decommission.
+func (c *Client) Decommission(
+	ctx context.Context,
+	address string,
+	fn func(transport.DecommissionStatus),
+) error {
+	var last *transport.DecommissionStatus
+
+	for {
+		var status *transport.DecommissionStatus
+		err := c.callCoordinator(ctx, func(cdr transport.CoordinatorClient) error {
+			s, err := cdr.Decommission(address)
+			status = s
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if last == nil || *status != *last {
+			fn(*status)
+		}
+		last = status
+
+		switch status.Stage {
+		case transport.DecommissionDone:
+			return nil
+		case transport.DecommissionFailed:
+			return errors.New(status.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(decommissionPollInterval):
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+// exportChunkSize is how many keys Export asks a node for at a time.
+const exportChunkSize = 1000
+
+// Export calls fn with every committed key/value pair in every chain. Each
+// chain is exported a chunk at a time, and the next chunk is only asked for
+// once fn has seen the last one, so a slow fn slows the export down instead of
+// piling up items in memory. Keys are in order within a chain, but the chains
+// are exported one after the other. If a node fails, the export carries on
+// from the same chunk on another node in the chain. If fn returns an error,
+// the export stops and returns it.
+func (c *Client) Export(
+	ctx context.Context,
+	fn func(transport.VersionedItem) error,
+) error {
+	for chain := range c.Routes().Chains {
+		if err := c.exportChain(ctx, chain, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) exportChain(
+	ctx context.Context,
+	chain int,
+	fn func(transport.VersionedItem) error,
+) error {
+	var cursor string
+
+	for {
+		var chunk *transport.ExportChunk
+
+		err := c.tryReplicas(ctx, chain, func(n transport.NodeClient) error {
+			ch, err := n.Export(cursor, exportChunkSize)
+			chunk = ch
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, item := range chunk.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if chunk.Next == "" {
+			return nil
+		}
+		cursor = chunk.Next
+	}
+}
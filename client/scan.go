@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+// scanPageSize is how many keys a scan asks each chain for at a time.
+const scanPageSize = 100
+
+// pageFunc fetches the page of a scan that continues from next.
+type pageFunc func(n transport.NodeClient, next string) (*transport.ScanResult, error)
+
+// chainScan is where a scan is in one chain.
+type chainScan struct {
+	chain int
+	items []transport.VersionedItem
+	next  string
+	done  bool
+}
+
+// Scan calls fn with every key from start to end, in key order, and it's latest
+// committed value. An empty end means there's no upper bound. Keys are spread
+// across the chains, so every chain is scanned a page at a time and the pages
+// are merged; only one page per chain is held in memory. If fn returns an
+// error, the scan stops and returns it.
+func (c *Client) Scan(
+	ctx context.Context,
+	start, end string,
+	fn func(transport.VersionedItem) error,
+) error {
+	return c.scan(ctx, start, fn, func(n transport.NodeClient, next string) (*transport.ScanResult, error) {
+		return n.Scan(next, end, scanPageSize)
+	})
+}
+
+// ScanPrefix is Scan for every key that starts with prefix.
+func (c *Client) ScanPrefix(
+	ctx context.Context,
+	prefix string,
+	fn func(transport.VersionedItem) error,
+) error {
+	return c.scan(ctx, "", fn, func(n transport.NodeClient, next string) (*transport.ScanResult, error) {
+		return n.ScanPrefix(prefix, next, scanPageSize)
+	})
+}
+
+// scan merges the scans of every chain, starting each one from first.
+func (c *Client) scan(
+	ctx context.Context,
+	first string,
+	fn func(transport.VersionedItem) error,
+	page pageFunc,
+) error {
+	chains := make([]*chainScan, len(c.Routes().Chains))
+	for i := range chains {
+		chains[i] = &chainScan{chain: i, next: first}
+	}
+
+	for {
+		var lowest *chainScan
+		for _, cs := range chains {
+			if err := c.fill(ctx, cs, page); err != nil {
+				return err
+			}
+			if len(cs.items) > 0 && (lowest == nil || cs.items[0].Key < lowest.items[0].Key) {
+				lowest = cs
+			}
+		}
+
+		if lowest == nil {
+			return nil
+		}
+
+		item := lowest.items[0]
+		lowest.items = lowest.items[1:]
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
+
+// fill fetches the next page of the chain once the current one is used up.
+// Deleted keys are left out of pages, so a page can be empty even though there
+// are more keys; fill keeps going until it has items or the chain has no more
+// keys.
+func (c *Client) fill(ctx context.Context, cs *chainScan, page pageFunc) error {
+	for len(cs.items) == 0 && !cs.done {
+		var result *transport.ScanResult
+
+		err := c.tryReplicas(ctx, cs.chain, func(n transport.NodeClient) error {
+			r, err := page(n, cs.next)
+			result = r
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		cs.items = result.Items
+		cs.next = result.Next
+		cs.done = result.Next == ""
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+// NodeStatus asks the node at address for it's state, such as whether it's
+// still catching up, and where it sits in it's chain.
+func (c *Client) NodeStatus(
+	ctx context.Context,
+	address string,
+) (*transport.NodeStatus, error) {
+	n, err := c.node(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var status *transport.NodeStatus
+	err = call(ctx, func() error {
+		s, err := n.Status()
+		status = s
+		return err
+	})
+	if err != nil {
+		c.dropNode(address, n)
+		return nil, err
+	}
+
+	return status, nil
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+// watchRetryInterval is how long Watch waits before reconnecting to a node.
+const watchRetryInterval = time.Second
+
+// Watch calls fn with every commit of a key that starts with prefix, from now
+// until ctx is done or fn returns an error. Each chain is watched on one of it's
+// nodes. Commits are in order within a chain, but the chains are watched at the
+// same time, so commits from different chains are interleaved. fn is never
+// called concurrently.
+//
+// If the connection to a node fails, Watch reconnects to the same node and
+// carries on from the last commit it saw, so nothing is missed. If the node
+// can't carry on, ErrWatchLost is returned.
+func (c *Client) Watch(
+	ctx context.Context,
+	prefix string,
+	fn func(transport.WatchEvent) error,
+) error {
+	chains := len(c.Routes().Chains)
+	if chains == 0 {
+		return ErrNoReplicas
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan transport.WatchEvent)
+	errs := make(chan error, chains)
+
+	for chain := 0; chain < chains; chain++ {
+		go func(chain int) {
+			errs <- c.watchChain(ctx, chain, prefix, events)
+		}(chain)
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if err := fn(event); err != nil {
+				return err
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// watchChain watches prefix on a node in the chain and sends the commits to
+// events.
+func (c *Client) watchChain(
+	ctx context.Context,
+	chain int,
+	prefix string,
+	events chan<- transport.WatchEvent,
+) error {
+	var addr string
+	var from uint64
+
+	for {
+		// Until the first reply, nothing has been watched, so any node in the
+		// chain will do.
+		if from == 0 {
+			replicas := c.replicas(chain)
+			if len(replicas) == 0 {
+				return ErrNoReplicas
+			}
+			addr = replicas[0]
+		}
+
+		n, err := c.node(addr)
+		if err == nil {
+			var result *transport.WatchResult
+			err = call(ctx, func() error {
+				var err error
+				result, err = n.Watch(prefix, from)
+				return err
+			})
+
+			if err == nil {
+				for _, event := range result.Events {
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				from = result.Revision
+				continue
+			}
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if transport.IsWatchCompacted(err) {
+			return ErrWatchLost
+		}
+
+		c.log.Printf("Watch on node %s failed, reconnecting: %v\n", addr, err)
+		if n != nil {
+			c.dropNode(addr, n)
+		}
+
+		select {
+		case <-time.After(watchRetryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if from != 0 && !c.inChain(chain, addr) {
+			return ErrWatchLost
+		}
+	}
+}
+
+// inChain reports whether the node is still in the chain.
+func (c *Client) inChain(chain int, addr string) bool {
+	rt := c.Routes()
+	if chain >= len(rt.Chains) {
+		return false
+	}
+	for _, a := range rt.Chains[chain] {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -1,20 +1,59 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/despreston/go-craq/client"
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc"
 	"github.com/despreston/go-craq/transport/netrpc"
 )
 
+// newNodeClient creates clients for the transport picked with -transport.
+var newNodeClient transport.NodeClientFactory = netrpc.NewNodeClient
+
 func main() {
-	var cdr, node string
+	var cdr, node, consistency, tport string
+	var timeout, staleness, ttl time.Duration
+	var gap uint64
 
-	flag.StringVar(&cdr, "c", ":1234", "coordinator address")
-	flag.StringVar(&node, "n", ":1235", "node address to read from")
+	flag.StringVar(&cdr, "c", ":1234", "coordinator address, or comma-separated addresses of the coordinators in a cluster")
+	flag.StringVar(&node, "n", "", "node address to read from. Default: any node in the chain")
+	flag.DurationVar(&timeout, "t", 10*time.Second, "request timeout")
+	flag.StringVar(&tport, "transport", "netrpc", "transport the coordinator and nodes serve: netrpc or grpc")
+	flag.StringVar(&consistency, "consistency", "strong", "read consistency: strong, eventual or bounded")
+	flag.DurationVar(&staleness, "staleness", 0, "max staleness of bounded reads")
+	flag.Uint64Var(&gap, "gap", 0, "max number of versions bounded reads may be behind")
+	flag.DurationVar(&ttl, "ttl", 0, "how long written keys live before they're deleted. Default: forever")
 	flag.Parse()
 
+	mode, err := transport.ParseReadMode(consistency)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	opts := &transport.ReadOpts{
+		Mode:          mode,
+		MaxStaleness:  staleness,
+		MaxVersionGap: gap,
+	}
+
+	var newCdrClient transport.CoordinatorClientFactory
+	switch tport {
+	case "netrpc":
+		newCdrClient = netrpc.NewCoordinatorClient
+	case "grpc":
+		newCdrClient = grpc.NewCoordinatorClient
+		newNodeClient = grpc.NewNodeClient
+	default:
+		log.Fatalf("Unknown transport %s", tport)
+	}
+
 	args := flag.Args()
 
 	if len(args) < 1 {
@@ -23,20 +62,165 @@ func main() {
 
 	cmd := args[0]
 
-	if cmd == "readall" {
-		n := netrpc.NewNodeClient()
+	addresses := strings.Split(cdr, ",")
+	c := client.New(client.Opts{
+		CdrAddress:           addresses[0],
+		CdrAddresses:         addresses[1:],
+		CoordinatorTransport: newCdrClient,
+		Transport:            newNodeClient,
+	})
 
-		if err := n.Connect(node); err != nil {
-			log.Fatalf("Failed to connect to node\n  %#v", err)
+	if err := c.Connect(); err != nil {
+		log.Fatalf("Failed to connect to coordinator\n  %#v", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch cmd {
+	case "readall":
+		// Items are logged as they arrive, a chunk at a time, so the export
+		// isn't limited by the request timeout.
+		logItem := func(item transport.VersionedItem) error {
+			log.Printf("key: %s, value: %s", item.Key, string(item.Value))
+			return nil
+		}
+
+		var err error
+		if node != "" {
+			err = exportFromNode(node, logItem)
+		} else {
+			err = c.Export(context.Background(), logItem)
 		}
 
-		items, err := n.ReadAll()
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
-		for _, item := range *items {
-			log.Printf("key: %s, value: %s", item.Key, string(item.Value))
+		return
+	case "batch":
+		items := []transport.Item{}
+		for _, pair := range args[1:] {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				log.Fatalf("Invalid item %s. Usage: batch <key>=<value> ...", pair)
+			}
+			items = append(items, transport.Item{Key: kv[0], Value: []byte(kv[1]), TTL: ttl})
+		}
+
+		versions, err := c.WriteBatch(ctx, items)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		for i, item := range items {
+			log.Printf("key: %s, committed version %d", item.Key, versions[i])
+		}
+
+		return
+	case "scan", "prefix":
+		var from, to string
+		if len(args) > 1 {
+			from = args[1]
+		}
+		if len(args) > 2 {
+			to = args[2]
+		}
+
+		logItem := func(item transport.VersionedItem) error {
+			log.Printf("key: %s, value: %s, version: %d", item.Key, string(item.Value), item.Version)
+			return nil
+		}
+
+		var err error
+		if cmd == "scan" {
+			err = c.Scan(ctx, from, to, logItem)
+		} else {
+			err = c.ScanPrefix(ctx, from, logItem)
+		}
+
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return
+	case "watch":
+		var prefix string
+		if len(args) > 1 {
+			prefix = args[1]
+		}
+
+		// Watches run until they're interrupted, so they aren't limited by the
+		// request timeout.
+		err := c.Watch(context.Background(), prefix, func(event transport.WatchEvent) error {
+			if event.Deleted {
+				log.Printf("key: %s, deleted, version: %d", event.Key, event.Version)
+			} else {
+				log.Printf("key: %s, value: %s, version: %d", event.Key, string(event.Value), event.Version)
+			}
+			return nil
+		})
+
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return
+	case "decommission":
+		if len(args) < 2 {
+			log.Fatal("Usage: decommission <node address>")
+		}
+
+		// Draining can take longer than a request, so the decommission isn't
+		// limited by the request timeout.
+		err := c.Decommission(context.Background(), args[1], func(status transport.DecommissionStatus) {
+			log.Printf(
+				"node: %s, stage: %s, dirty keys: %d, unacknowledged replication: %d, unsent commits: %d, pending writes: %d",
+				status.Address,
+				status.Stage,
+				status.Node.Dirty,
+				status.Node.Replicating,
+				status.Node.Committing,
+				status.Node.Writing,
+			)
+		})
+
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return
+	case "status":
+		if len(args) < 2 {
+			log.Fatal("Usage: status <node address>")
+		}
+
+		status, err := c.NodeStatus(ctx, args[1])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		log.Printf(
+			"node: %s, state: %s, head: %t, tail: %t, prev: %s, next: %s, tail address: %s",
+			status.Address,
+			status.State,
+			status.IsHead,
+			status.IsTail,
+			status.Prev,
+			status.Next,
+			status.Tail,
+		)
+
+		return
+	case "routes":
+		rt := c.Routes()
+		for id, nodes := range rt.Chains {
+			log.Printf("chain: %d, nodes: %s", id, strings.Join(nodes, " -> "))
+		}
+		for address, state := range rt.States {
+			log.Printf("node: %s, state: %s", address, state)
 		}
 
 		return
@@ -54,21 +238,173 @@ func main() {
 			log.Fatal("No value given.")
 		}
 		val := strings.Join(args[2:], " ")
-		c := netrpc.NewCoordinatorClient()
-		c.Connect(cdr)
-		log.Println(c.Write(key, []byte(val)))
+		if ttl > 0 {
+			logWrite(c.PutWithTTL(ctx, key, []byte(val), ttl))
+		} else {
+			logWrite(c.Put(ctx, key, []byte(val)))
+		}
+	case "cas":
+		if len(args) < 4 {
+			log.Fatal("Usage: cas <key> <expected version> <value>")
+		}
+		expected, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid version %s", args[2])
+		}
+		val := strings.Join(args[3:], " ")
+		logWrite(c.CompareAndSwap(ctx, key, expected, []byte(val)))
+	case "putifabsent":
+		if len(args) < 3 {
+			log.Fatal("No value given.")
+		}
+		val := strings.Join(args[2:], " ")
+		logWrite(c.PutIfAbsent(ctx, key, []byte(val)))
+	case "delete":
+		logWrite(c.Delete(ctx, key))
 	case "read":
-		n := netrpc.NewNodeClient()
+		if len(args) > 2 {
+			readAtVersion(ctx, c, node, key, args[2])
+			return
+		}
 
+		var v []byte
+		var version uint64
+		var err error
+
+		if node != "" {
+			v, version, err = readFromNode(node, key, opts)
+		} else {
+			v, version, err = c.GetWithOpts(ctx, key, opts)
+		}
+
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		log.Printf("key: %s, value: %s, version: %d", key, string(v), version)
+	case "history":
+		var items []transport.HistoryItem
+		var err error
+
+		if node != "" {
+			items, err = historyFromNode(node, key)
+		} else {
+			items, err = c.History(ctx, key)
+		}
+
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		for _, item := range items {
+			value := string(item.Value)
+			if item.Deleted {
+				value = "<deleted>"
+			}
+			log.Printf(
+				"version: %d, value: %s, committed: %s",
+				item.Version,
+				value,
+				item.CommittedAt.Format(time.RFC3339),
+			)
+		}
+	}
+}
+
+// readAtVersion reads a committed version of a key, from a specific node if
+// there is one.
+func readAtVersion(ctx context.Context, c *client.Client, node, key, arg string) {
+	version, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid version %s", arg)
+	}
+
+	var v []byte
+
+	if node != "" {
+		n := newNodeClient()
 		if err := n.Connect(node); err != nil {
 			log.Fatalf("Failed to connect to node\n  %#v", err)
 		}
+		defer n.Close()
+		_, v, err = n.ReadAtVersion(key, version)
+	} else {
+		v, err = c.GetVersion(ctx, key, version)
+	}
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Printf("key: %s, value: %s, version: %d", key, string(v), version)
+}
+
+// logWrite logs the committed version of a write, or exits if it failed.
+func logWrite(version uint64, err error) {
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	log.Printf("committed version %d", version)
+}
+
+// readFromNode reads a key from a specific node instead of letting the client
+// choose one.
+func readFromNode(
+	addr, key string,
+	opts *transport.ReadOpts,
+) ([]byte, uint64, error) {
+	n := newNodeClient()
+
+	if err := n.Connect(addr); err != nil {
+		log.Fatalf("Failed to connect to node\n  %#v", err)
+	}
+
+	defer n.Close()
+
+	_, v, version, err := n.Read(key, opts)
+	return v, version, err
+}
+
+// historyFromNode lists the versions of a key kept by a specific node.
+func historyFromNode(addr, key string) ([]transport.HistoryItem, error) {
+	n := newNodeClient()
+
+	if err := n.Connect(addr); err != nil {
+		log.Fatalf("Failed to connect to node\n  %#v", err)
+	}
+
+	defer n.Close()
+
+	return n.History(key)
+}
+
+// exportFromNode exports every committed item from a specific node, a chunk at
+// a time.
+func exportFromNode(addr string, fn func(transport.VersionedItem) error) error {
+	n := newNodeClient()
 
-		k, v, err := n.Read(key)
+	if err := n.Connect(addr); err != nil {
+		log.Fatalf("Failed to connect to node\n  %#v", err)
+	}
+
+	defer n.Close()
+
+	var cursor string
+	for {
+		chunk, err := n.Export(cursor, 0)
 		if err != nil {
-			log.Fatal(err.Error())
+			return err
 		}
 
-		log.Printf("key: %s, value: %s", k, string(v))
+		for _, item := range chunk.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if chunk.Next == "" {
+			return nil
+		}
+		cursor = chunk.Next
 	}
 }
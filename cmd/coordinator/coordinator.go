@@ -3,29 +3,107 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"net/rpc"
+	"strings"
+	"time"
 
 	"github.com/despreston/go-craq/coordinator"
+	"github.com/despreston/go-craq/transport/grpc"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
 	"github.com/despreston/go-craq/transport/netrpc"
 )
 
 func main() {
-	addr := *flag.String("a", ":1234", "Local address to listen on")
+	var addr, pub, peers, stateFile, tport string
+	var chains, misses int
+	var pingInterval, pingTimeout, grace, drainTimeout time.Duration
+	var phi float64
+
+	flag.StringVar(&addr, "a", ":1234", "Local address to listen on")
+	flag.StringVar(&pub, "p", ":1234", "Public address reachable by the other coordinators")
+	flag.StringVar(&peers, "peers", "", "Comma separated addresses of the other coordinators in the cluster")
+	flag.StringVar(&stateFile, "f", "coordinator.db", "Bolt DB file for the chain state")
+	flag.IntVar(&chains, "chains", 1, "Number of chains to shard keys across")
+	flag.StringVar(&tport, "transport", "netrpc", "Transport for talking to nodes and the other coordinators: netrpc or grpc")
+	flag.DurationVar(&pingInterval, "ping-interval", time.Second, "How often each node is pinged")
+	flag.DurationVar(&pingTimeout, "ping-timeout", 5*time.Second, "How long to wait for a node to answer a ping")
+	flag.IntVar(&misses, "misses", 3, "Failed pings in a row before a node is suspected")
+	flag.Float64Var(&phi, "phi", 8, "Suspicion level at which a quiet node is suspected")
+	flag.DurationVar(&grace, "grace", 5*time.Second, "How long a node is suspected before it's removed from the chain")
+	flag.DurationVar(&drainTimeout, "drain-timeout", time.Minute, "How long to wait for a decommissioned node's neighbors to catch up")
 	flag.Parse()
 
-	c := coordinator.New(netrpc.NewNodeClient)
+	state := coordinator.NewBoltState(stateFile)
+	if err := state.Connect(); err != nil {
+		log.Fatal(err)
+	}
+
+	defer state.DB.Close()
+
+	opts := coordinator.Opts{
+		Chains:       chains,
+		Address:      pub,
+		State:        state,
+		PingInterval: pingInterval,
+		PingTimeout:  pingTimeout,
+		DrainTimeout: drainTimeout,
+		FailureDetector: coordinator.NewPhiDetector(coordinator.PhiOpts{
+			MaxMisses:   misses,
+			Threshold:   phi,
+			GracePeriod: grace,
+		}),
+	}
+
+	if peers != "" {
+		opts.Peers = strings.Split(peers, ",")
+	}
+
+	switch tport {
+	case "netrpc":
+		opts.Transport = netrpc.NewNodeClient
+		opts.RaftTransport = netrpc.NewRaftClient
+		opts.CoordinatorTransport = netrpc.NewCoordinatorClient
+	case "grpc":
+		opts.Transport = grpc.NewNodeClient
+		opts.RaftTransport = grpc.NewRaftClient
+		opts.CoordinatorTransport = grpc.NewCoordinatorClient
+	default:
+		log.Fatalf("Unknown transport %s", tport)
+	}
+
+	c := coordinator.New(opts)
 
-	binding := netrpc.CoordinatorBinding{Svc: c}
-	if err := rpc.RegisterName("RPC", &binding); err != nil {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
 		log.Fatal(err)
 	}
-	rpc.HandleHTTP()
+
+	var serve func() error
+	if tport == "grpc" {
+		s := grpc.NewServer()
+		craqpb.RegisterCoordinatorServiceServer(s, &grpc.CoordinatorBinding{Svc: c})
+		craqpb.RegisterRaftServiceServer(s, &grpc.RaftBinding{Svc: c})
+		serve = func() error { return s.Serve(lis) }
+	} else {
+		binding := netrpc.CoordinatorBinding{Svc: c}
+		if err := rpc.RegisterName("RPC", &binding); err != nil {
+			log.Fatal(err)
+		}
+
+		raftBinding := netrpc.RaftBinding{Svc: c}
+		if err := rpc.RegisterName("Raft", &raftBinding); err != nil {
+			log.Fatal(err)
+		}
+		rpc.HandleHTTP()
+		serve = func() error { return http.Serve(lis, nil) }
+	}
 
 	// Start the Coordinator
 	go c.Start()
 
 	// Start the rpc server
 	log.Println("Listening at " + addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(serve())
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/despreston/go-craq/client"
+	"github.com/despreston/go-craq/transport/grpc"
+	"github.com/despreston/go-craq/transport/httpjson"
+	"github.com/despreston/go-craq/transport/netrpc"
+)
+
+func main() {
+	var addr, cdr, tport string
+
+	flag.StringVar(&addr, "a", ":8080", "Local address to listen on")
+	flag.StringVar(&cdr, "c", ":1234", "Coordinator address, or comma-separated addresses of the Coordinators in a cluster")
+	flag.StringVar(&tport, "transport", "netrpc", "Transport the coordinator and nodes serve: netrpc or grpc")
+	flag.Parse()
+
+	addresses := strings.Split(cdr, ",")
+	opts := client.Opts{CdrAddress: addresses[0], CdrAddresses: addresses[1:]}
+	switch tport {
+	case "netrpc":
+		opts.CoordinatorTransport = netrpc.NewCoordinatorClient
+		opts.Transport = netrpc.NewNodeClient
+	case "grpc":
+		opts.CoordinatorTransport = grpc.NewCoordinatorClient
+		opts.Transport = grpc.NewNodeClient
+	default:
+		log.Fatalf("Unknown transport %s", tport)
+	}
+
+	c := client.New(opts)
+
+	if err := c.Connect(); err != nil {
+		log.Fatalf("Failed to connect to coordinator\n  %#v", err)
+	}
+
+	defer c.Close()
+
+	log.Println("Listening at " + addr)
+	log.Fatal(http.ListenAndServe(addr, httpjson.NewGateway(c)))
+}
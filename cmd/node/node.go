@@ -3,50 +3,108 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"net/rpc"
+	"time"
 
 	"github.com/despreston/go-craq/node"
+	"github.com/despreston/go-craq/store"
 	"github.com/despreston/go-craq/store/boltdb"
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
 	"github.com/despreston/go-craq/transport/netrpc"
 )
 
 func main() {
-	var addr, pub, cdr, dbFile string
+	var addr, pub, cdr, dbFile, tport string
+	var chain, position, window, keep, watchLog int
+	var writeTimeout, keepFor, antiEntropy time.Duration
+	var syncReplication, learner bool
 
 	flag.StringVar(&addr, "a", ":1235", "Local address to listen on")
 	flag.StringVar(&pub, "p", ":1235", "Public address reachable by coordinator and other nodes")
 	flag.StringVar(&cdr, "c", ":1234", "Coordinator address")
 	flag.StringVar(&dbFile, "f", "craq.db", "Bolt DB database file")
+	flag.StringVar(&tport, "transport", "netrpc", "Transport for talking to the coordinator and other nodes: netrpc or grpc")
+	flag.IntVar(&chain, "chain", transport.AnyChain, "Chain to join. Default: chain with the fewest nodes")
+	flag.IntVar(&position, "position", transport.TailPosition, "Position in the chain to join, 1 for the head. Default: the tail")
+	flag.BoolVar(&learner, "learner", false, "Catch up before joining the chain")
+	flag.DurationVar(&writeTimeout, "wt", 10*time.Second, "How long the head waits for a write to commit")
+	flag.BoolVar(&syncReplication, "sync", false, "Replicate writes with synchronous nested RPCs instead of a pipelined stream")
+	flag.IntVar(&window, "window", 64, "Max replication messages in flight to the successor")
+	flag.IntVar(&keep, "keep", 1, "Committed versions of each key to keep for versioned reads and history")
+	flag.DurationVar(&keepFor, "keep-for", 0, "Also keep committed versions younger than this, e.g. 720h")
+	flag.IntVar(&watchLog, "watch-log", 10000, "Commits kept for watchers to resume from")
+	flag.DurationVar(&antiEntropy, "anti-entropy", time.Minute, "How often to compare committed versions with a neighbor. Negative turns it off")
 	flag.Parse()
 
+	var nodeClient transport.NodeClientFactory
+	var cdrClient transport.CoordinatorClient
+
+	switch tport {
+	case "netrpc":
+		nodeClient = netrpc.NewNodeClient
+		cdrClient = netrpc.NewCoordinatorClient()
+	case "grpc":
+		nodeClient = grpc.NewNodeClient
+		cdrClient = grpc.NewCoordinatorClient()
+	default:
+		log.Fatalf("Unknown transport %s", tport)
+	}
+
 	db := boltdb.New(dbFile, "yessir")
 	if err := db.Connect(); err != nil {
 		log.Fatal(err)
 	}
 
 	defer db.DB.Close()
+	db.SetRetention(store.Retention{Versions: keep, MaxAge: keepFor})
 
 	n := node.New(node.Opts{
-		Address:           addr,
-		CdrAddress:        cdr,
-		PubAddress:        pub,
-		Store:             db,
-		Transport:         netrpc.NewNodeClient,
-		CoordinatorClient: netrpc.NewCoordinatorClient(),
-		Log:               log.Default(),
+		Address:             addr,
+		CdrAddress:          cdr,
+		PubAddress:          pub,
+		Chain:               chain,
+		Position:            position,
+		Learner:             learner,
+		WriteTimeout:        writeTimeout,
+		SyncReplication:     syncReplication,
+		ReplicationWindow:   window,
+		WatchLogSize:        watchLog,
+		AntiEntropyInterval: antiEntropy,
+		Store:               db,
+		Transport:           nodeClient,
+		CoordinatorClient:   cdrClient,
+		Log:                 log.Default(),
 	})
 
-	b := netrpc.NodeBinding{Svc: n}
-	if err := rpc.RegisterName("RPC", &b); err != nil {
+	// Listen before starting, so the coordinator can reach the node as soon as
+	// it announces itself.
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
 		log.Fatal(err)
 	}
-	rpc.HandleHTTP()
+
+	var serve func() error
+	if tport == "grpc" {
+		s := grpc.NewServer()
+		craqpb.RegisterNodeServiceServer(s, &grpc.NodeBinding{Svc: n})
+		serve = func() error { return s.Serve(lis) }
+	} else {
+		b := netrpc.NodeBinding{Svc: n}
+		if err := rpc.RegisterName("RPC", &b); err != nil {
+			log.Fatal(err)
+		}
+		rpc.HandleHTTP()
+		serve = func() error { return http.Serve(lis, nil) }
+	}
 
 	// Start the node
 	go n.Start()
 
 	// Start the rpc server
 	log.Println("Listening at " + addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(serve())
 }
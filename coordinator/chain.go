@@ -0,0 +1,74 @@
+package coordinator
+
+import "github.com/despreston/go-craq/transport"
+
+// chain is one of the chains managed by the Coordinator. Keys are sharded
+// across the chains using transport.ChainForKey.
+type chain struct {
+	id         int
+	head, tail *node
+	replicas   []*node
+}
+
+// setEnds points head and tail at the ends of the chain.
+func (ch *chain) setEnds() {
+	ch.head, ch.tail = nil, nil
+	if len(ch.replicas) > 0 {
+		ch.head = ch.replicas[0]
+		ch.tail = ch.replicas[len(ch.replicas)-1]
+	}
+}
+
+// metaFor builds the metadata for the node at index i of the chain.
+func (ch *chain) metaFor(i int) *transport.NodeMeta {
+	var args transport.NodeMeta
+	args.IsHead = i == 0
+	args.IsTail = len(ch.replicas) == i+1
+	args.Tail = ch.replicas[len(ch.replicas)-1].Address()
+
+	if len(ch.replicas) > 1 {
+		if i > 0 {
+			// Not the first node, so add address to previous.
+			args.Prev = ch.replicas[i-1].Address()
+		}
+		if i+1 != len(ch.replicas) {
+			// Not the last node, so add address to next.
+			args.Next = ch.replicas[i+1].Address()
+		}
+	}
+
+	return &args
+}
+
+// addresses of the nodes in the chain, from head to tail.
+func (ch *chain) addresses() []string {
+	addresses := make([]string, len(ch.replicas))
+	for i, n := range ch.replicas {
+		addresses[i] = n.Address()
+	}
+	return addresses
+}
+
+// learnerMeta builds the metadata for a learner that's going to be inserted at
+// index i of the chain. The learner catches up from the node that will be its
+// predecessor, or from the head if it's going to be the new head.
+func (ch *chain) learnerMeta(i int) *transport.NodeMeta {
+	source := ch.replicas[0]
+	if i > 0 {
+		source = ch.replicas[i-1]
+	}
+	return &transport.NodeMeta{
+		Learner: true,
+		Prev:    source.Address(),
+		Tail:    ch.replicas[len(ch.replicas)-1].Address(),
+	}
+}
+
+// insertIndex returns the index in a chain of count nodes that a node added at
+// position goes to.
+func insertIndex(position, count int) int {
+	if position < transport.HeadPosition || position > count {
+		return count
+	}
+	return position - 1
+}
@@ -0,0 +1,97 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Operations that change the membership of the chain.
+const (
+	opAdd    = "add"
+	opRemove = "remove"
+)
+
+// command is a change to the membership of a chain. When running in a
+// cluster, commands are replicated to every Coordinator through the Raft log
+// before being applied.
+type command struct {
+	Op      string
+	Address string
+	Chain   int
+	// Where the node is inserted, as in transport.AddNodeArgs. Zero, which is
+	// what older log entries have, appends the node.
+	Position int
+}
+
+// apply a command to the chains. If the Coordinator is part of a cluster, the
+// command is first committed to the Raft log.
+func (cdr *Coordinator) apply(c command) error {
+	if cdr.raft == nil {
+		cdr.applyCommand(c)
+		cdr.saveChains()
+		return nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return cdr.raft.Apply(b, applyTimeout)
+}
+
+// Apply is called by Raft once a command has been committed to the log. It
+// makes the Coordinator satisfy the raft.FSM interface.
+func (cdr *Coordinator) Apply(b []byte) error {
+	var c command
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	cdr.applyCommand(c)
+	return nil
+}
+
+func (cdr *Coordinator) applyCommand(c command) {
+	cdr.mu.Lock()
+	defer cdr.mu.Unlock()
+
+	// A node that's already in a chain is moved to its position in the chain
+	// it's being added to.
+	if ch, idx, found := cdr.findNode(c.Address); found {
+		ch.replicas = append(ch.replicas[:idx], ch.replicas[idx+1:]...)
+		ch.setEnds()
+	}
+
+	if c.Op == opAdd && c.Chain >= 0 && c.Chain < len(cdr.chains) {
+		ch := cdr.chains[c.Chain]
+		idx := insertIndex(c.Position, len(ch.replicas))
+		ch.replicas = append(ch.replicas, nil)
+		copy(ch.replicas[idx+1:], ch.replicas[idx:])
+		ch.replicas[idx] = &node{
+			last:    time.Now(),
+			address: c.Address,
+		}
+		ch.setEnds()
+	}
+}
+
+// saveChains persists the addresses of the nodes in each chain, in order. A
+// failure is logged but doesn't fail the change to the chain, which has
+// already been made.
+func (cdr *Coordinator) saveChains() {
+	if cdr.state == nil {
+		return
+	}
+
+	cdr.mu.Lock()
+	chains := make([][]string, len(cdr.chains))
+	for i, ch := range cdr.chains {
+		chains[i] = ch.addresses()
+	}
+	cdr.mu.Unlock()
+
+	if err := cdr.state.SaveChains(chains); err != nil {
+		log.Printf("Failed to save chain state: %v\n", err)
+	}
+}
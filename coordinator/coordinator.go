@@ -2,80 +2,457 @@
 // responsible for detecting and handling node failures, electing head and tail
 // nodes, and adding new nodes to the chain.
 //
+// A Coordinator can manage several independent chains. Keys are sharded across
+// the chains so that write throughput isn't limited by a single head node.
+//
 // If the Coordinator process fails but the chain is still intact, reads would
 // still be possible. Writes will not be possible because all writes are first
 // sent to the Coordinator. The coordinator forwards write requests to the head
 // node.
+//
+// To avoid a single point of failure, several Coordinators can be run as a
+// cluster. The members of the cluster replicate the membership of the chain
+// using Raft and elect a leader. Only the leader talks to the nodes. The other
+// members forward requests to the leader.
 
 package coordinator
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"github.com/despreston/go-craq/raft"
 	"github.com/despreston/go-craq/transport"
 )
 
 const (
-	pingTimeout  = 5 * time.Second
-	pingInterval = 1 * time.Second
+	defaultPingTimeout  = 5 * time.Second
+	defaultPingInterval = 1 * time.Second
+	applyTimeout        = 5 * time.Second
+)
+
+var errPingTimeout = errors.New("ping timed out")
+
+var (
+	ErrEmptyChain = errors.New("no nodes in the chain")
+
+	// ErrUnknownChain is returned by AddNode if the chain doesn't exist.
+	ErrUnknownChain = errors.New("unknown chain")
+
+	// ErrUnknownNode is returned by RemoveNode if the node isn't in any chain.
+	ErrUnknownNode = errors.New("unknown node")
+
+	// ErrLastNode is returned by Decommission if the node is the only one in
+	// it's chain, so there's nowhere for it's keys to go.
+	ErrLastNode = errors.New("node is the only one in it's chain")
+
+	// ErrNoLeader is returned when this Coordinator is not the leader of the
+	// cluster and doesn't know which Coordinator is.
+	ErrNoLeader = errors.New("no known coordinator leader")
+
+	// ErrCrossChainTransaction is returned by Transaction if the keys of the
+	// transaction belong to more than one chain.
+	ErrCrossChainTransaction = errors.New("transaction spans more than one chain")
+
+	// ErrNotClustered is returned by the Raft RPCs when the Coordinator is not
+	// part of a cluster.
+	ErrNotClustered = errors.New("coordinator is not running in a cluster")
 )
 
-var ErrEmptyChain = errors.New("no nodes in the chain")
+// Opts is for passing options to the Coordinator constructor.
+type Opts struct {
+	// Transport creates new clients for communication with nodes.
+	Transport transport.NodeClientFactory
+	// Number of chains to shard keys across. The number of chains must not
+	// change once the chains hold data, or keys will be looked up in the wrong
+	// chain. Default: 1
+	Chains int
+	// Address other Coordinators in the cluster use to reach this one. Only
+	// needed when Peers is not empty.
+	Address string
+	// Addresses of the other Coordinators in the cluster. If empty, the
+	// Coordinator runs by itself.
+	Peers []string
+	// RaftTransport creates new clients for communication with the other
+	// Coordinators in the cluster.
+	RaftTransport transport.RaftClientFactory
+	// CoordinatorTransport creates new clients for forwarding requests to the
+	// leader of the cluster.
+	CoordinatorTransport transport.CoordinatorClientFactory
+	// State persists the chains' membership, or the Raft log when running in a
+	// cluster, so it survives restarts. Optional.
+	State StateStore
+	// How often each node is pinged. Default: 1s
+	PingInterval time.Duration
+	// How long to wait for a node to answer a ping before counting it as
+	// missed. Default: 5s
+	PingTimeout time.Duration
+	// Decides when a node has failed from the pings. Failed nodes are removed
+	// from their chain. Default: a PhiDetector with the default PhiOpts
+	FailureDetector FailureDetector
+	// How long Decommission waits for a draining node's neighbors to catch up
+	// before giving up. Default: 1m
+	DrainTimeout time.Duration
+}
 
-// Coordinator is responsible for tracking the Nodes in the chain.
+// Coordinator is responsible for tracking the Nodes in each chain.
 type Coordinator struct {
-	tport      transport.NodeClientFactory
-	head, tail *node
-	mu         sync.Mutex
-	replicas   []*node
+	tport    transport.NodeClientFactory
+	cdrTport transport.CoordinatorClientFactory
+	mu       sync.Mutex // protects chains
+	opMu     sync.Mutex // serializes changes to the chains' membership
+	chains   []*chain
+	raft     *raft.Raft
+	state    StateStore
+	// Chain each learner is staged for, by address. Protected by mu.
+	learners map[string]int
+
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	detector     FailureDetector
+	// Health of each node last time it was checked, for logging changes, and
+	// the state of each node that isn't serving, as reported by the node.
+	// Protected by healthMu.
+	healthMu sync.Mutex
+	health   map[string]Health
+	states   map[string]transport.NodeState
+
+	drainTimeout time.Duration
+	// Progress of each decommission, by address. Protected by decomMu.
+	decomMu       sync.Mutex
+	decommissions map[string]*transport.DecommissionStatus
+
+	// Connection to the leader of the cluster, for forwarding requests.
+	fwdMu     sync.Mutex
+	fwd       transport.CoordinatorClient
+	fwdAddr   string
+	wasLeader bool
 
 	// For testing the AddNode method. This WaitGroup is done when updates have
 	// been sent to all nodes.
 	Updates *sync.WaitGroup
 }
 
-func New(t transport.NodeClientFactory) *Coordinator {
-	return &Coordinator{
-		Updates: &sync.WaitGroup{},
-		tport:   t,
+func New(opts Opts) *Coordinator {
+	count := opts.Chains
+	if count < 1 {
+		count = 1
+	}
+
+	pingInterval := opts.PingInterval
+	if pingInterval == 0 {
+		pingInterval = defaultPingInterval
+	}
+	pingTimeout := opts.PingTimeout
+	if pingTimeout == 0 {
+		pingTimeout = defaultPingTimeout
+	}
+	detector := opts.FailureDetector
+	if detector == nil {
+		detector = NewPhiDetector(PhiOpts{})
 	}
+	drainTimeout := opts.DrainTimeout
+	if drainTimeout == 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+
+	cdr := &Coordinator{
+		Updates:       &sync.WaitGroup{},
+		tport:         opts.Transport,
+		cdrTport:      opts.CoordinatorTransport,
+		state:         opts.State,
+		chains:        make([]*chain, count),
+		learners:      make(map[string]int),
+		pingInterval:  pingInterval,
+		pingTimeout:   pingTimeout,
+		detector:      detector,
+		health:        make(map[string]Health),
+		states:        make(map[string]transport.NodeState),
+		drainTimeout:  drainTimeout,
+		decommissions: make(map[string]*transport.DecommissionStatus),
+	}
+
+	for i := range cdr.chains {
+		cdr.chains[i] = &chain{id: i}
+	}
+
+	if len(opts.Peers) > 0 {
+		cdr.raft = raft.New(raft.Config{
+			ID:        opts.Address,
+			Peers:     opts.Peers,
+			Transport: opts.RaftTransport,
+			FSM:       cdr,
+			Persister: opts.State,
+		})
+	}
+
+	return cdr
 }
 
+// Start the Coordinator. If the Coordinator has a StateStore, the chains'
+// membership from the previous run is restored before pinging starts.
 func (cdr *Coordinator) Start() {
+	if cdr.raft != nil {
+		// The membership is restored by replaying the Raft log.
+		if err := cdr.raft.Start(); err != nil {
+			log.Fatalf("Failed to start raft.\n Error: %#v", err)
+		}
+	} else if cdr.state != nil {
+		if err := cdr.restore(); err != nil {
+			log.Fatalf("Failed to restore chain state.\n Error: %#v", err)
+		}
+	}
 	cdr.pingReplicas()
 }
 
-// Ping each node. If the response returns an error or the pingTimeout is
-// reached, remove the node from the list of replicas.
+// restore loads the membership of the chains saved by a previous run, then
+// reconnects to each node and sends it fresh metadata.
+func (cdr *Coordinator) restore() error {
+	saved, err := cdr.state.LoadChains()
+	if err != nil {
+		return err
+	}
+
+	if len(saved) == 0 {
+		return nil
+	}
+
+	if len(saved) != len(cdr.chains) {
+		return fmt.Errorf(
+			"saved state has %d chains but the coordinator is configured for %d",
+			len(saved),
+			len(cdr.chains),
+		)
+	}
+
+	cdr.mu.Lock()
+	for i, addresses := range saved {
+		log.Printf("restoring %d nodes in chain %d from saved state\n", len(addresses), i)
+		ch := cdr.chains[i]
+		for _, address := range addresses {
+			ch.replicas = append(ch.replicas, &node{
+				last:    time.Now(),
+				address: address,
+			})
+		}
+		ch.setEnds()
+	}
+	cdr.mu.Unlock()
+
+	cdr.takeover()
+	return nil
+}
+
+// isLeader reports whether this Coordinator is responsible for the chains. A
+// Coordinator that isn't part of a cluster is always the leader.
+func (cdr *Coordinator) isLeader() bool {
+	return cdr.raft == nil || cdr.raft.IsLeader()
+}
+
+// Ping each node every ping interval and tell the failure detector how it went.
+// Nodes the failure detector thinks have failed are removed from their chain.
+// Only the leader pings.
 func (cdr *Coordinator) pingReplicas() {
 	log.Println("starting pinging")
 	for {
-		for _, n := range cdr.replicas {
-			go func(n *node) {
-				resultCh := make(chan bool, 1)
-
-				go func() {
-					err := n.rpc.Ping()
-					resultCh <- err == nil
-				}()
-
-				select {
-				case ok := <-resultCh:
-					if !ok {
-						cdr.RemoveNode(n.Address())
-					}
-				case <-time.After(pingTimeout):
-					cdr.RemoveNode(n.Address())
-				}
-			}(n)
+		if !cdr.watchLeadership() {
+			time.Sleep(cdr.pingInterval)
+			continue
+		}
+
+		now := time.Now()
+		for _, n := range cdr.connectedReplicas() {
+			// Nodes that haven't answered yet become more suspicious over
+			// time, so check on them before their ping times out.
+			cdr.checkHealth(n.Address(), now)
+			go cdr.ping(n)
 		}
-		time.Sleep(pingInterval)
+		time.Sleep(cdr.pingInterval)
 	}
 }
 
+// ping sends a Ping to the node and records the result with the failure
+// detector.
+func (cdr *Coordinator) ping(n *node) {
+	result := make(chan error, 1)
+	go func() { result <- n.rpc.Ping() }()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(cdr.pingTimeout):
+		err = errPingTimeout
+	}
+
+	cdr.pinged(n.Address(), err, time.Now())
+}
+
+// pinged records the result of a ping with the failure detector and checks the
+// health of the node.
+func (cdr *Coordinator) pinged(address string, err error, now time.Time) {
+	if err == nil {
+		cdr.detector.Heartbeat(address, now)
+	} else {
+		cdr.detector.Miss(address, now)
+	}
+	cdr.checkHealth(address, now)
+}
+
+// checkHealth asks the failure detector for the health of the node, logs the
+// change if it's different from the last check, and removes the node from it's
+// chain if it has failed.
+func (cdr *Coordinator) checkHealth(address string, now time.Time) {
+	health := cdr.detector.Health(address, now)
+
+	cdr.healthMu.Lock()
+	last := cdr.health[address]
+	cdr.health[address] = health
+	cdr.healthMu.Unlock()
+
+	if health != last {
+		log.Printf("node %s went from %s to %s\n", address, last, health)
+	}
+
+	if health == Failed {
+		if err := cdr.RemoveNode(address); err != nil && err != ErrUnknownNode {
+			log.Printf("Failed to remove failed node %s: %v\n", address, err)
+		}
+	}
+}
+
+// forget drops the health and state of the node, so it starts out healthy if
+// it joins again.
+func (cdr *Coordinator) forget(address string) {
+	cdr.detector.Forget(address)
+	cdr.healthMu.Lock()
+	delete(cdr.health, address)
+	delete(cdr.states, address)
+	cdr.healthMu.Unlock()
+}
+
+// ReportState records the state of a node, as reported by the node. The states
+// of nodes that aren't serving are included in the routing table, so clients
+// send their reads to other nodes. Only the leader keeps track of the states; a
+// Coordinator that becomes the leader takes every node to be serving until it
+// reports otherwise.
+func (cdr *Coordinator) ReportState(address string, state transport.NodeState) error {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return err
+	} else if fwd != nil {
+		return fwd.ReportState(address, state)
+	}
+
+	cdr.setState(address, state)
+	log.Printf("node %s is %s\n", address, state)
+	return nil
+}
+
+func (cdr *Coordinator) setState(address string, state transport.NodeState) {
+	cdr.healthMu.Lock()
+	defer cdr.healthMu.Unlock()
+	if state == transport.NodeServing {
+		delete(cdr.states, address)
+	} else {
+		cdr.states[address] = state
+	}
+}
+
+// watchLeadership checks whether this Coordinator has just become the leader
+// of the cluster and, if so, takes over responsibility for the chains. Returns
+// whether this Coordinator is the leader and has taken over.
+func (cdr *Coordinator) watchLeadership() bool {
+	leader := cdr.isLeader()
+	if leader && !cdr.wasLeader && cdr.raft != nil {
+		// Commands committed by the previous leader may not have been applied
+		// yet, and the nodes they add would be left without a connection.
+		if !cdr.raft.Ready() {
+			return false
+		}
+		log.Println("became leader of the coordinator cluster")
+		cdr.takeover()
+	}
+	cdr.wasLeader = leader
+	return leader
+}
+
+// takeover connects to every node in every chain and sends each one fresh
+// metadata. Nodes that can't be reached are removed from their chain.
+func (cdr *Coordinator) takeover() {
+	// Connecting can take a while, so it's done without holding cdr.mu, and the
+	// connections are handed to the nodes afterwards.
+	rpcs := make(map[string]transport.NodeClient)
+	unreachable := []string{}
+	for _, address := range cdr.unconnectedReplicas() {
+		rpc := cdr.tport()
+		if err := rpc.Connect(address); err != nil {
+			log.Printf("failed to connect to node %s during takeover\n", address)
+			unreachable = append(unreachable, address)
+			continue
+		}
+		rpcs[address] = rpc
+	}
+
+	cdr.mu.Lock()
+	for _, ch := range cdr.chains {
+		for _, n := range ch.replicas {
+			if rpc, has := rpcs[n.address]; has && n.rpc == nil {
+				n.rpc = rpc
+				n.connected = true
+				delete(rpcs, n.address)
+			}
+		}
+	}
+	chains := append([]*chain{}, cdr.chains...)
+	cdr.mu.Unlock()
+
+	// Nodes that were removed, or connected by AddNode, in the meantime.
+	for _, rpc := range rpcs {
+		rpc.Close()
+	}
+
+	for _, address := range unreachable {
+		cdr.RemoveNode(address)
+	}
+
+	for _, ch := range chains {
+		cdr.updateAll(ch)
+	}
+}
+
+// unconnectedReplicas returns the addresses of the nodes the Coordinator
+// hasn't connected to yet.
+func (cdr *Coordinator) unconnectedReplicas() []string {
+	cdr.mu.Lock()
+	defer cdr.mu.Unlock()
+	addresses := []string{}
+	for _, ch := range cdr.chains {
+		for _, n := range ch.replicas {
+			if n.rpc == nil {
+				addresses = append(addresses, n.address)
+			}
+		}
+	}
+	return addresses
+}
+
+// connectedReplicas returns every node the Coordinator has a connection to.
+func (cdr *Coordinator) connectedReplicas() []*node {
+	cdr.mu.Lock()
+	defer cdr.mu.Unlock()
+	connected := []*node{}
+	for _, ch := range cdr.chains {
+		for _, n := range ch.replicas {
+			if n.rpc != nil && n.connected {
+				connected = append(connected, n)
+			}
+		}
+	}
+	return connected
+}
+
 func findReplicaIndex(address string, replicas []*node) (int, bool) {
 	for i, replica := range replicas {
 		if replica.Address() == address {
@@ -85,12 +462,40 @@ func findReplicaIndex(address string, replicas []*node) (int, bool) {
 	return 0, false
 }
 
-func (cdr *Coordinator) updateAll() {
+// findNode returns the chain the node is in and it's index in the chain.
+// Should be called with cdr.mu held.
+func (cdr *Coordinator) findNode(address string) (*chain, int, bool) {
+	for _, ch := range cdr.chains {
+		if idx, found := findReplicaIndex(address, ch.replicas); found {
+			return ch, idx, true
+		}
+	}
+	return nil, 0, false
+}
+
+// smallestChain returns the chain with the fewest nodes. Should be called with
+// cdr.mu held.
+func (cdr *Coordinator) smallestChain() *chain {
+	smallest := cdr.chains[0]
+	for _, ch := range cdr.chains[1:] {
+		if len(ch.replicas) < len(smallest.replicas) {
+			smallest = ch
+		}
+	}
+	return smallest
+}
+
+// updateAll sends the latest metadata to every node in the chain.
+func (cdr *Coordinator) updateAll(ch *chain) {
+	cdr.mu.Lock()
+	count := len(ch.replicas)
+	cdr.mu.Unlock()
+
 	wg := sync.WaitGroup{}
-	for i := 0; i < len(cdr.replicas); i++ {
+	for i := 0; i < count; i++ {
 		wg.Add(1)
 		go func(i int) {
-			cdr.updateNode(i)
+			cdr.updateNode(ch, i)
 			wg.Done()
 		}(i)
 	}
@@ -98,34 +503,46 @@ func (cdr *Coordinator) updateAll() {
 }
 
 func (cdr *Coordinator) RemoveNode(address string) error {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return err
+	} else if fwd != nil {
+		return fwd.RemoveNode(address)
+	}
+
+	cdr.opMu.Lock()
+	defer cdr.opMu.Unlock()
+
 	cdr.mu.Lock()
-	defer cdr.mu.Unlock()
+	ch, idx, found := cdr.findNode(address)
+	wasTail := found && idx == len(ch.replicas)-1
+	cdr.mu.Unlock()
 
-	idx, found := findReplicaIndex(address, cdr.replicas)
 	if !found {
-		return errors.New("unknown node")
+		return ErrUnknownNode
 	}
 
-	wasTail := idx == len(cdr.replicas)-1
-	cdr.replicas = append(cdr.replicas[:idx], cdr.replicas[idx+1:]...)
-	log.Printf("removed node %s", address)
+	if err := cdr.apply(command{Op: opRemove, Address: address}); err != nil {
+		log.Printf("Failed to remove node %s: %v\n", address, err)
+		return err
+	}
+	log.Printf("removed node %s from chain %d", address, ch.id)
+	cdr.forget(address)
 
 	if wasTail {
-		cdr.tail = nil
-		if idx > 0 {
-			cdr.tail = cdr.replicas[idx-1]
-		}
-
 		// Because the tail node changed, all the other nodes need to be updated to
 		// know where the tail is.
-		cdr.updateAll()
+		cdr.updateAll(ch)
 		return nil
 	}
 
 	// After removing the node, the successor, if there was one, now sits at idx
 	// in the chain. Send a message to that node to update it's metadata.
-	if len(cdr.replicas) > idx {
-		err := cdr.updateNode(idx)
+	cdr.mu.Lock()
+	count := len(ch.replicas)
+	cdr.mu.Unlock()
+
+	if count > idx {
+		err := cdr.updateNode(ch, idx)
 		if err != nil {
 			log.Printf("Failed to update successor: %s\n", err.Error())
 			return err
@@ -134,7 +551,7 @@ func (cdr *Coordinator) RemoveNode(address string) error {
 
 	// Send update to predecessor and update the tail
 	if idx > 0 {
-		err := cdr.updateNode(idx - 1)
+		err := cdr.updateNode(ch, idx-1)
 		if err != nil {
 			log.Printf("Failed to update predecessor: %v\n", err)
 			return err
@@ -146,29 +563,25 @@ func (cdr *Coordinator) RemoveNode(address string) error {
 
 // updateNode sends the latest metadata to a Node to tell it whether it's head
 // or tail and what it's neighbors' addresses are.
-func (cdr *Coordinator) updateNode(i int) error {
-	n := cdr.replicas[i]
-
-	log.Printf("Sending metadata to %s.\n", n.Address())
-
-	var args transport.NodeMeta
-	args.IsHead = i == 0
-	args.IsTail = len(cdr.replicas) == i+1
-	args.Tail = cdr.replicas[len(cdr.replicas)-1].Address()
+func (cdr *Coordinator) updateNode(ch *chain, i int) error {
+	cdr.mu.Lock()
+	if i >= len(ch.replicas) {
+		cdr.mu.Unlock()
+		return nil
+	}
+	n := ch.replicas[i]
+	rpc := n.rpc
+	args := ch.metaFor(i)
+	cdr.mu.Unlock()
 
-	if len(cdr.replicas) > 1 {
-		if i > 0 {
-			// Not the first node, so add address to previous.
-			args.Prev = cdr.replicas[i-1].Address()
-		}
-		if i+1 != len(cdr.replicas) {
-			// Not the last node, so add address to next.
-			args.Next = cdr.replicas[i+1].Address()
-		}
+	if rpc == nil {
+		return errors.New("not connected to node " + n.Address())
 	}
 
+	log.Printf("Sending metadata to %s.\n", n.Address())
+
 	// call Update method on node
-	if err := n.rpc.Update(&args); err != nil {
+	if err := rpc.Update(args); err != nil {
 		return err
 	}
 
@@ -176,43 +589,124 @@ func (cdr *Coordinator) updateNode(i int) error {
 }
 
 // AddNode should be called by Nodes to announce themselves to the Coordinator.
-// The coordinator then adds them to the end of the chain. The coordinator
-// replies with some flags to let the node know if they're head or tail, and
-// the address to the previous Node in the chain. The node is responsible for
-// announcing itself to the previous Node in the chain.
-func (cdr *Coordinator) AddNode(address string) (*transport.NodeMeta, error) {
+// The coordinator then adds them to the requested chain, or to the chain with
+// the fewest nodes if the node doesn't ask for a specific one, at the requested
+// position, which is the end of the chain by default. The coordinator replies
+// with some flags to let the node know if they're head or tail, and the address
+// to the previous Node in the chain. The node is responsible for announcing
+// itself to the previous Node in the chain.
+//
+// A node that asks to be a learner isn't added to the chain yet, so the chain
+// keeps serving from the nodes that have every key while it catches up. The
+// reply tells it which node to copy the keys from. Once it has, the node calls
+// AddNode again, without Learner, to join the chain at its position. A learner
+// of a chain without any nodes joins right away, since there's nothing to
+// catch up on.
+func (cdr *Coordinator) AddNode(args *transport.AddNodeArgs) (*transport.NodeMeta, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return nil, err
+	} else if fwd != nil {
+		return fwd.AddNode(args)
+	}
+
+	address := args.Address
 	log.Printf("received AddNode from %s\n", address)
 
-	n := &node{
-		last:    time.Now(),
-		address: address,
-		rpc:     cdr.tport(),
+	cdr.opMu.Lock()
+	defer cdr.opMu.Unlock()
+
+	cdr.mu.Lock()
+	var ch *chain
+	learnerChain, wasLearner := cdr.learners[address]
+	if args.Chain == transport.AnyChain && wasLearner {
+		// The chain was chosen when the node was staged.
+		ch = cdr.chains[learnerChain]
+	} else if args.Chain == transport.AnyChain {
+		ch = cdr.smallestChain()
+	} else if args.Chain >= 0 && args.Chain < len(cdr.chains) {
+		ch = cdr.chains[args.Chain]
+	}
+	var learnerMeta *transport.NodeMeta
+	if ch != nil && args.Learner && len(ch.replicas) > 0 {
+		learnerMeta = ch.learnerMeta(insertIndex(args.Position, len(ch.replicas)))
+		cdr.learners[address] = ch.id
+	} else {
+		delete(cdr.learners, address)
+	}
+	prevCh, _, wasMember := cdr.findNode(address)
+	cdr.mu.Unlock()
+
+	if ch == nil {
+		return nil, ErrUnknownChain
 	}
 
-	if err := n.Connect(); err != nil {
+	if learnerMeta != nil {
+		log.Printf("staged node %s as a learner of chain %d\n", address, ch.id)
+		return learnerMeta, nil
+	}
+
+	rpc := cdr.tport()
+	if err := rpc.Connect(address); err != nil {
 		log.Printf("failed to connect to node %s\n", address)
 		return nil, err
 	}
 
-	cdr.replicas = append(cdr.replicas, n)
-	cdr.tail = n
-	meta := &transport.NodeMeta{}
-	meta.IsTail = true
-	meta.Tail = address
+	cmd := command{Op: opAdd, Address: address, Chain: ch.id, Position: args.Position}
+	if err := cdr.apply(cmd); err != nil {
+		log.Printf("failed to add node %s: %v\n", address, err)
+		rpc.Close()
+		return nil, err
+	}
+	cdr.forget(address)
+	cdr.clearDecommission(address)
+	// The node still has to catch up from it's neighbors.
+	cdr.setState(address, transport.NodeSyncing)
 
-	if len(cdr.replicas) == 1 {
-		cdr.head = n
-		meta.IsHead = true
-	} else {
-		meta.Prev = cdr.replicas[len(cdr.replicas)-2].Address()
+	cdr.mu.Lock()
+	idx, _ := findReplicaIndex(address, ch.replicas)
+	n := ch.replicas[idx]
+	n.rpc = rpc
+	n.connected = true
+	meta := ch.metaFor(idx)
+	count := len(ch.replicas)
+	cdr.mu.Unlock()
+
+	log.Printf("added node %s to chain %d at position %d\n", address, ch.id, idx+1)
+
+	// A node that was in a different chain before leaves a gap there.
+	if wasMember && prevCh != ch {
+		go cdr.updateAll(prevCh)
+	}
+
+	if idx < count-1 && !(wasMember && prevCh == ch) {
+		// The tail didn't change, so only the new node's neighbors need to
+		// know about it. The predecessor goes first, so it's sending writes to
+		// the new node before the successor stops taking them from the
+		// predecessor.
+		cdr.Updates.Add(1)
+		go func() {
+			defer cdr.Updates.Done()
+			for _, i := range []int{idx - 1, idx + 1} {
+				if i < 0 {
+					continue
+				}
+				if err := cdr.updateNode(ch, i); err != nil {
+					log.Printf("Failed to update neighbor of node %s: %v\n", address, err)
+				}
+			}
+		}()
+		return meta, nil
 	}
 
-	// Because the tail node changed, all the other nodes need to be updated to
-	// know where the tail is.
-	for i := 0; i < len(cdr.replicas)-1; i++ {
+	// Because the tail node changed, or the node moved within the chain, all
+	// the other nodes need to be updated.
+	for i := 0; i < count; i++ {
+		if i == idx {
+			continue
+		}
 		cdr.Updates.Add(1)
 		go func(i int) {
-			cdr.updateNode(i)
+			cdr.updateNode(ch, i)
 			cdr.Updates.Done()
 		}(i)
 	}
@@ -220,13 +714,297 @@ func (cdr *Coordinator) AddNode(address string) (*transport.NodeMeta, error) {
 	return meta, nil
 }
 
-// Write a new object to the chain.
-func (cdr *Coordinator) Write(key string, value []byte) error {
-	if len(cdr.replicas) < 1 {
-		return ErrEmptyChain
+// Write a new object to the chain responsible for the key. Returns the
+// committed version. A write with a ttl is sent to the head as a batch of one,
+// since that's how items that expire are written.
+func (cdr *Coordinator) Write(
+	key string,
+	value []byte,
+	ttl time.Duration,
+) (uint64, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return 0, err
+	} else if fwd != nil {
+		return fwd.Write(key, value, ttl)
+	}
+
+	head, err := cdr.headFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if ttl > 0 {
+		item := transport.Item{Key: key, Value: value, TTL: ttl}
+		versions, err := head.rpc.ClientWriteBatch([]transport.Item{item})
+		if err != nil {
+			return 0, err
+		}
+		return versions[0], nil
 	}
 
 	// Forward the write to the head
-	head := cdr.replicas[0]
 	return head.rpc.ClientWrite(key, value)
 }
+
+// Delete a key from the chain responsible for the key. Returns the committed
+// version of the tombstone.
+func (cdr *Coordinator) Delete(key string) (uint64, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return 0, err
+	} else if fwd != nil {
+		return fwd.Delete(key)
+	}
+
+	head, err := cdr.headFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return head.rpc.ClientDelete(key)
+}
+
+// CompareAndSwap writes a new version of key if the newest version of the key
+// is expected. The check is done by the head of the chain responsible for the
+// key. Returns a *transport.ConflictError if the check fails.
+func (cdr *Coordinator) CompareAndSwap(
+	key string,
+	expected uint64,
+	value []byte,
+) (uint64, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return 0, err
+	} else if fwd != nil {
+		return fwd.CompareAndSwap(key, expected, value)
+	}
+
+	head, err := cdr.headFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return head.rpc.CompareAndSwap(key, expected, value)
+}
+
+// PutIfAbsent writes key if it doesn't exist. Returns a
+// *transport.ConflictError if it does.
+func (cdr *Coordinator) PutIfAbsent(key string, value []byte) (uint64, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return 0, err
+	} else if fwd != nil {
+		return fwd.PutIfAbsent(key, value)
+	}
+
+	head, err := cdr.headFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return head.rpc.PutIfAbsent(key, value)
+}
+
+// WriteBatch splits the items by the chain responsible for each key, and sends
+// each part to the head of it's chain as a single batch. The parts are written
+// in parallel. Each part is committed as a whole, but a batch that spans chains
+// isn't atomic: if one chain fails, the parts sent to the other chains may
+// still be committed.
+func (cdr *Coordinator) WriteBatch(items []transport.Item) ([]uint64, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return nil, err
+	} else if fwd != nil {
+		return fwd.WriteBatch(items)
+	}
+
+	// Position of each item in items, by chain.
+	cdr.mu.Lock()
+	byChain := make(map[int][]int)
+	for i, item := range items {
+		ch := transport.ChainForKey(item.Key, len(cdr.chains))
+		byChain[ch] = append(byChain[ch], i)
+	}
+	cdr.mu.Unlock()
+
+	versions := make([]uint64, len(items))
+	errs := make(chan error, len(byChain))
+
+	for ch, positions := range byChain {
+		go func(ch int, positions []int) {
+			part := make([]transport.Item, len(positions))
+			for i, pos := range positions {
+				part[i] = items[pos]
+			}
+
+			head, err := cdr.headOf(ch)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			written, err := head.rpc.ClientWriteBatch(part)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			for i, pos := range positions {
+				versions[pos] = written[i]
+			}
+			errs <- nil
+		}(ch, positions)
+	}
+
+	var err error
+	for range byChain {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Transaction sends a mini-transaction to the head of the chain responsible for
+// it's keys. Transactions are atomic within one chain only, so every key has to
+// belong to the same chain.
+func (cdr *Coordinator) Transaction(txn *transport.Transaction) ([]uint64, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return nil, err
+	} else if fwd != nil {
+		return fwd.Transaction(txn)
+	}
+
+	keys := txn.Keys()
+	if len(keys) == 0 {
+		return []uint64{}, nil
+	}
+
+	cdr.mu.Lock()
+	ch := transport.ChainForKey(keys[0], len(cdr.chains))
+	for _, key := range keys[1:] {
+		if transport.ChainForKey(key, len(cdr.chains)) != ch {
+			cdr.mu.Unlock()
+			return nil, ErrCrossChainTransaction
+		}
+	}
+	cdr.mu.Unlock()
+
+	head, err := cdr.headOf(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	return head.rpc.ClientTransaction(txn)
+}
+
+// headFor returns the head of the chain responsible for the key.
+func (cdr *Coordinator) headFor(key string) (*node, error) {
+	cdr.mu.Lock()
+	i := transport.ChainForKey(key, len(cdr.chains))
+	cdr.mu.Unlock()
+	return cdr.headOf(i)
+}
+
+// headOf returns the head of the i'th chain. A leader that hasn't taken over
+// yet may not be connected to the head.
+func (cdr *Coordinator) headOf(i int) (*node, error) {
+	cdr.mu.Lock()
+	defer cdr.mu.Unlock()
+
+	ch := cdr.chains[i]
+	if len(ch.replicas) < 1 {
+		return nil, ErrEmptyChain
+	}
+	head := ch.replicas[0]
+	if head.rpc == nil || !head.connected {
+		return nil, errors.New("not connected to node " + head.Address())
+	}
+	return head, nil
+}
+
+// Routes returns the addresses of the nodes in every chain. Clients use it to
+// find the chain responsible for a key. Every Coordinator in a cluster knows
+// the membership of the chains, so the request isn't forwarded to the leader.
+// Only the leader knows which nodes aren't serving yet, though.
+func (cdr *Coordinator) Routes() (*transport.RoutingTable, error) {
+	cdr.mu.Lock()
+	defer cdr.mu.Unlock()
+	cdr.healthMu.Lock()
+	defer cdr.healthMu.Unlock()
+
+	rt := &transport.RoutingTable{Chains: make([][]string, len(cdr.chains))}
+	for i, ch := range cdr.chains {
+		rt.Chains[i] = ch.addresses()
+		for _, address := range rt.Chains[i] {
+			state, notServing := cdr.states[address]
+			if !notServing {
+				continue
+			}
+			if rt.States == nil {
+				rt.States = make(map[string]transport.NodeState)
+			}
+			rt.States[address] = state
+		}
+	}
+	return rt, nil
+}
+
+// leaderClient returns a client connected to the leader of the cluster if this
+// Coordinator is not the leader. If this Coordinator is the leader, the
+// returned client is nil.
+func (cdr *Coordinator) leaderClient() (transport.CoordinatorClient, error) {
+	if cdr.isLeader() {
+		return nil, nil
+	}
+
+	addr := cdr.raft.Leader()
+	if addr == "" {
+		return nil, ErrNoLeader
+	}
+
+	cdr.fwdMu.Lock()
+	defer cdr.fwdMu.Unlock()
+
+	if cdr.fwd != nil && cdr.fwdAddr == addr {
+		return cdr.fwd, nil
+	}
+
+	if cdr.fwd != nil {
+		cdr.fwd.Close()
+		cdr.fwd = nil
+	}
+
+	c := cdr.cdrTport()
+	if err := c.Connect(addr); err != nil {
+		log.Printf("failed to connect to leader %s: %v\n", addr, err)
+		return nil, err
+	}
+
+	log.Printf("forwarding requests to leader %s\n", addr)
+	cdr.fwd = c
+	cdr.fwdAddr = addr
+	return c, nil
+}
+
+// RequestVote is for other Coordinators in the cluster to ask for a vote
+// during leader election.
+func (cdr *Coordinator) RequestVote(
+	args *transport.VoteRequest,
+) (*transport.VoteResponse, error) {
+	if cdr.raft == nil {
+		return nil, ErrNotClustered
+	}
+	return cdr.raft.RequestVote(args)
+}
+
+// AppendEntries is for the leader of the cluster to replicate changes to the
+// chain's membership.
+func (cdr *Coordinator) AppendEntries(
+	args *transport.AppendRequest,
+) (*transport.AppendResponse, error) {
+	if cdr.raft == nil {
+		return nil, ErrNotClustered
+	}
+	return cdr.raft.AppendEntries(args)
+}
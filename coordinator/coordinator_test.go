@@ -0,0 +1,454 @@
+package coordinator
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+// FakeNode records the metadata sent to it. Methods that aren't overridden
+// panic because the embedded NodeClient is nil.
+type FakeNode struct {
+	transport.NodeClient
+	mu       *sync.Mutex
+	address  string
+	metas    map[string]*transport.NodeMeta
+	writes   map[string][]string
+	batches  map[string][][]string
+	drains   map[string]int
+	undrains map[string]int
+}
+
+func (f *FakeNode) Connect(address string) error {
+	f.address = address
+	return nil
+}
+
+func (f *FakeNode) Close() error { return nil }
+
+func (f *FakeNode) Ping() error { return nil }
+
+func (f *FakeNode) Update(meta *transport.NodeMeta) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metas[f.address] = meta
+	return nil
+}
+
+// Drain reports an unacknowledged replication message the first two times it's
+// called, and then that the node has caught up.
+func (f *FakeNode) Drain() (*transport.DrainStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drains[f.address]++
+	if f.drains[f.address] <= 2 {
+		return &transport.DrainStatus{Dirty: 1, Replicating: 1}, nil
+	}
+	return &transport.DrainStatus{}, nil
+}
+
+func (f *FakeNode) Undrain() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.undrains[f.address]++
+	return nil
+}
+
+func (f *FakeNode) ClientWrite(key string, value []byte) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes[f.address] = append(f.writes[f.address], key)
+	return 0, nil
+}
+
+// ClientWriteBatch records the keys in the batch. The version of each item is
+// the length of it's value.
+func (f *FakeNode) ClientWriteBatch(items []transport.Item) ([]uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := []string{}
+	versions := []uint64{}
+	for _, item := range items {
+		keys = append(keys, item.Key)
+		versions = append(versions, uint64(len(item.Value)))
+	}
+	f.batches[f.address] = append(f.batches[f.address], keys)
+	return versions, nil
+}
+
+func TestMultipleChains(t *testing.T) {
+	mu := &sync.Mutex{}
+	writes := make(map[string][]string)
+	cdr := New(Opts{
+		Chains: 2,
+		Transport: func() transport.NodeClient {
+			return &FakeNode{
+				mu:     mu,
+				metas:  make(map[string]*transport.NodeMeta),
+				writes: writes,
+			}
+		},
+	})
+
+	joins := []*transport.AddNodeArgs{
+		{Address: "a", Chain: transport.AnyChain},
+		{Address: "b", Chain: transport.AnyChain},
+		{Address: "c", Chain: 1},
+	}
+	for _, args := range joins {
+		if _, err := cdr.AddNode(args); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", args.Address, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: "d", Chain: 2}); err != ErrUnknownChain {
+		t.Errorf("AddNode(d) unexpected error\n  want: %#v\n  got: %#v", ErrUnknownChain, err)
+	}
+
+	rt, err := cdr.Routes()
+	if err != nil {
+		t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+	}
+	want := [][]string{{"a"}, {"b", "c"}}
+	if diff := cmp.Diff(want, rt.Chains); diff != "" {
+		t.Fatalf("Routes() unexpected chains (-want +got):\n%s", diff)
+	}
+
+	// Writes go to the head of the chain responsible for the key.
+	keys := []string{"hello", "foo", "bar", "baz", "qux"}
+	wantWrites := make(map[string][]string)
+	for _, key := range keys {
+		if _, err := cdr.Write(key, []byte("value"), 0); err != nil {
+			t.Fatalf("Write(%s) unexpected error\n  got: %#v", key, err)
+		}
+		head := rt.Chains[rt.ChainFor(key)][0]
+		wantWrites[head] = append(wantWrites[head], key)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(wantWrites, writes); diff != "" {
+		t.Fatalf("Write() sent to unexpected heads (-want +got):\n%s", diff)
+	}
+}
+
+// A batch is split into one batch per chain, and the versions come back in the
+// order of the items.
+func TestWriteBatch(t *testing.T) {
+	mu := &sync.Mutex{}
+	batches := make(map[string][][]string)
+	cdr := New(Opts{
+		Chains: 2,
+		Transport: func() transport.NodeClient {
+			return &FakeNode{
+				mu:      mu,
+				metas:   make(map[string]*transport.NodeMeta),
+				batches: batches,
+			}
+		},
+	})
+
+	for _, addr := range []string{"a", "b"} {
+		args := &transport.AddNodeArgs{Address: addr, Chain: transport.AnyChain}
+		if _, err := cdr.AddNode(args); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", addr, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	rt, err := cdr.Routes()
+	if err != nil {
+		t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+	}
+
+	keys := []string{"hello", "foo", "bar", "baz", "qux"}
+	items := []transport.Item{}
+	wantBatches := make(map[string][][]string)
+	wantKeys := make(map[string][]string)
+	for i, key := range keys {
+		items = append(items, transport.Item{Key: key, Value: []byte(strings.Repeat("x", i))})
+		head := rt.Chains[rt.ChainFor(key)][0]
+		wantKeys[head] = append(wantKeys[head], key)
+	}
+	for head, keys := range wantKeys {
+		wantBatches[head] = [][]string{keys}
+	}
+
+	versions, err := cdr.WriteBatch(items)
+	if err != nil {
+		t.Fatalf("WriteBatch() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff([]uint64{0, 1, 2, 3, 4}, versions); diff != "" {
+		t.Fatalf("WriteBatch() unexpected versions (-want +got):\n%s", diff)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(wantBatches, batches); diff != "" {
+		t.Fatalf("WriteBatch() sent unexpected batches (-want +got):\n%s", diff)
+	}
+}
+
+// A write with a TTL goes to the head as a batch of one, so the head sets the
+// expiry.
+func TestWriteWithTTL(t *testing.T) {
+	mu := &sync.Mutex{}
+	writes := make(map[string][]string)
+	batches := make(map[string][][]string)
+	cdr := New(Opts{
+		Transport: func() transport.NodeClient {
+			return &FakeNode{
+				mu:      mu,
+				metas:   make(map[string]*transport.NodeMeta),
+				writes:  writes,
+				batches: batches,
+			}
+		},
+	})
+	if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: "a"}); err != nil {
+		t.Fatalf("AddNode(a) unexpected error\n  got: %#v", err)
+	}
+	cdr.Updates.Wait()
+
+	version, err := cdr.Write("session", []byte("abc"), time.Minute)
+	if err != nil {
+		t.Fatalf("Write(session) unexpected error\n  got: %#v", err)
+	}
+	if version != 3 {
+		t.Fatalf("Write(session) unexpected version\n  want: %d\n  got: %d", 3, version)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(map[string][][]string{"a": {{"session"}}}, batches); diff != "" {
+		t.Fatalf("Write(session) sent unexpected batches (-want +got):\n%s", diff)
+	}
+	if len(writes) != 0 {
+		t.Fatalf("Write(session) unexpected writes\n  got: %v", writes)
+	}
+}
+
+// Transactions are only atomic within one chain.
+func TestTransactionCrossChain(t *testing.T) {
+	cdr := New(Opts{
+		Chains: 2,
+		Transport: func() transport.NodeClient {
+			return &FakeNode{mu: &sync.Mutex{}, metas: make(map[string]*transport.NodeMeta)}
+		},
+	})
+
+	for _, addr := range []string{"a", "b"} {
+		args := &transport.AddNodeArgs{Address: addr, Chain: transport.AnyChain}
+		if _, err := cdr.AddNode(args); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", addr, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	// Find two keys in different chains.
+	keys := []string{"hello"}
+	for _, key := range []string{"foo", "bar", "baz", "qux"} {
+		if transport.ChainForKey(key, 2) != transport.ChainForKey(keys[0], 2) {
+			keys = append(keys, key)
+			break
+		}
+	}
+
+	txn := &transport.Transaction{
+		Preconditions: []transport.Precondition{{Key: keys[0], Absent: true}},
+		Writes:        []transport.Item{{Key: keys[1], Value: []byte("value")}},
+	}
+	if _, err := cdr.Transaction(txn); err != ErrCrossChainTransaction {
+		t.Fatalf("Transaction() unexpected error\n  want: %#v\n  got: %#v", ErrCrossChainTransaction, err)
+	}
+}
+
+// Nodes added by a previous leader have no connection until the new leader has
+// taken over, so writes fail instead of calling a nil client.
+func TestWriteNotConnected(t *testing.T) {
+	cdr := New(Opts{})
+	cdr.applyCommand(command{Op: opAdd, Address: "a"})
+
+	if _, err := cdr.Write("hello", []byte("world"), 0); err == nil {
+		t.Fatal("Write(hello) expected an error for a head that isn't connected")
+	}
+}
+
+func TestAddNodePosition(t *testing.T) {
+	mu := &sync.Mutex{}
+	metas := make(map[string]*transport.NodeMeta)
+	cdr := New(Opts{
+		Transport: func() transport.NodeClient {
+			return &FakeNode{mu: mu, metas: metas}
+		},
+	})
+
+	for _, addr := range []string{"a", "b", "c"} {
+		args := &transport.AddNodeArgs{Address: addr, Chain: transport.AnyChain}
+		if _, err := cdr.AddNode(args); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", addr, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	routes := func(want []string) {
+		t.Helper()
+		rt, err := cdr.Routes()
+		if err != nil {
+			t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+		}
+		if diff := cmp.Diff([][]string{want}, rt.Chains); diff != "" {
+			t.Fatalf("Routes() unexpected chains (-want +got):\n%s", diff)
+		}
+	}
+
+	// Only the neighbors of a node inserted before the tail are updated.
+	mu.Lock()
+	for addr := range metas {
+		delete(metas, addr)
+	}
+	mu.Unlock()
+
+	meta, err := cdr.AddNode(&transport.AddNodeArgs{Address: "d", Position: 2})
+	if err != nil {
+		t.Fatalf("AddNode(d) unexpected error\n  got: %#v", err)
+	}
+	cdr.Updates.Wait()
+
+	want := &transport.NodeMeta{Prev: "a", Next: "b", Tail: "c"}
+	if diff := cmp.Diff(want, meta); diff != "" {
+		t.Fatalf("AddNode(d) unexpected meta (-want +got):\n%s", diff)
+	}
+	routes([]string{"a", "d", "b", "c"})
+
+	mu.Lock()
+	wantMetas := map[string]*transport.NodeMeta{
+		"a": {IsHead: true, Next: "d", Tail: "c"},
+		"b": {Prev: "d", Next: "c", Tail: "c"},
+	}
+	if diff := cmp.Diff(wantMetas, metas); diff != "" {
+		mu.Unlock()
+		t.Fatalf("unexpected updates (-want +got):\n%s", diff)
+	}
+	mu.Unlock()
+
+	// A learner catches up from its future predecessor, or from the head if
+	// it's going to be the head, without joining the chain.
+	tests := []struct {
+		address  string
+		position int
+		want     *transport.NodeMeta
+	}{
+		{
+			address:  "e",
+			position: transport.TailPosition,
+			want:     &transport.NodeMeta{Learner: true, Prev: "c", Tail: "c"},
+		},
+		{
+			address:  "f",
+			position: transport.HeadPosition,
+			want:     &transport.NodeMeta{Learner: true, Prev: "a", Tail: "c"},
+		},
+	}
+	for _, tt := range tests {
+		args := &transport.AddNodeArgs{
+			Address:  tt.address,
+			Chain:    transport.AnyChain,
+			Position: tt.position,
+			Learner:  true,
+		}
+		meta, err := cdr.AddNode(args)
+		if err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", tt.address, err)
+		}
+		if diff := cmp.Diff(tt.want, meta); diff != "" {
+			t.Fatalf("AddNode(%s) unexpected meta (-want +got):\n%s", tt.address, diff)
+		}
+	}
+	routes([]string{"a", "d", "b", "c"})
+
+	// Once it's caught up, the learner joins at its position.
+	args := &transport.AddNodeArgs{
+		Address:  "f",
+		Chain:    transport.AnyChain,
+		Position: transport.HeadPosition,
+	}
+	meta, err = cdr.AddNode(args)
+	if err != nil {
+		t.Fatalf("AddNode(f) unexpected error\n  got: %#v", err)
+	}
+	cdr.Updates.Wait()
+
+	want = &transport.NodeMeta{IsHead: true, Next: "a", Tail: "c"}
+	if diff := cmp.Diff(want, meta); diff != "" {
+		t.Fatalf("AddNode(f) unexpected meta (-want +got):\n%s", diff)
+	}
+	routes([]string{"f", "a", "d", "b", "c"})
+}
+
+func TestReportState(t *testing.T) {
+	cdr := New(Opts{
+		Transport: func() transport.NodeClient {
+			return &FakeNode{mu: &sync.Mutex{}, metas: make(map[string]*transport.NodeMeta)}
+		},
+	})
+
+	for _, addr := range []string{"a", "b"} {
+		args := &transport.AddNodeArgs{Address: addr, Chain: transport.AnyChain}
+		if _, err := cdr.AddNode(args); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", addr, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	tests := []struct {
+		name    string
+		address string
+		state   transport.NodeState
+		want    map[string]transport.NodeState
+	}{
+		{
+			name:    "a caught up",
+			address: "a",
+			state:   transport.NodeServing,
+			want:    map[string]transport.NodeState{"b": transport.NodeSyncing},
+		},
+		{
+			name:    "b caught up",
+			address: "b",
+			state:   transport.NodeServing,
+		},
+		{
+			name:    "a draining",
+			address: "a",
+			state:   transport.NodeDraining,
+			want:    map[string]transport.NodeState{"a": transport.NodeDraining},
+		},
+		{
+			name:    "not in a chain",
+			address: "c",
+			state:   transport.NodeSyncing,
+			want:    map[string]transport.NodeState{"a": transport.NodeDraining},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdr.ReportState(tt.address, tt.state); err != nil {
+				t.Fatalf("ReportState(%s) unexpected error\n  got: %#v", tt.address, err)
+			}
+			rt, err := cdr.Routes()
+			if err != nil {
+				t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+			}
+			if diff := cmp.Diff(tt.want, rt.States); diff != "" {
+				t.Fatalf("Routes() unexpected states (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
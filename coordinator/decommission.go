@@ -0,0 +1,161 @@
+package coordinator
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+const (
+	defaultDrainTimeout = time.Minute
+	// How often a draining node is asked whether it's neighbors have caught
+	// up.
+	drainPollInterval = 250 * time.Millisecond
+)
+
+var errDrainTimeout = errors.New("timed out waiting for the node's neighbors to catch up")
+
+// Decommission takes a node out of it's chain without losing anything that's
+// on it's way through the node. The node is told to drain: it stops serving
+// client reads and writes, so clients move to the other nodes, while it keeps
+// passing writes down and commits up the chain. Once the node reports that
+// it's neighbors have caught up, it's spliced out of the chain like RemoveNode
+// does, by sending it's predecessor and successor new metadata. If they don't
+// catch up within the drain timeout, or the node stops answering, the
+// decommission fails and the node is told to undrain, so it stays in the chain
+// and serves clients again. That happens in the background; Decommission returns the progress so far, and is called
+// again to follow it. Calling it for a node that's already being
+// decommissioned doesn't start over, unless the last attempt failed.
+func (cdr *Coordinator) Decommission(address string) (*transport.DecommissionStatus, error) {
+	if fwd, err := cdr.leaderClient(); err != nil {
+		return nil, err
+	} else if fwd != nil {
+		return fwd.Decommission(address)
+	}
+
+	cdr.decomMu.Lock()
+	defer cdr.decomMu.Unlock()
+
+	if status, has := cdr.decommissions[address]; has {
+		if status.Stage != transport.DecommissionFailed {
+			copied := *status
+			return &copied, nil
+		}
+	}
+
+	cdr.mu.Lock()
+	ch, idx, found := cdr.findNode(address)
+	var n *node
+	if found {
+		n = ch.replicas[idx]
+	}
+	last := found && len(ch.replicas) == 1
+	cdr.mu.Unlock()
+
+	if !found {
+		return nil, ErrUnknownNode
+	}
+	if last {
+		return nil, ErrLastNode
+	}
+	if n.rpc == nil {
+		return nil, errors.New("not connected to node " + address)
+	}
+
+	log.Printf("decommissioning node %s\n", address)
+	status := &transport.DecommissionStatus{
+		Address: address,
+		Stage:   transport.DecommissionDraining,
+	}
+	cdr.decommissions[address] = status
+	go cdr.decommission(n)
+
+	copied := *status
+	return &copied, nil
+}
+
+// decommission drains the node, waits for it's neighbors to catch up, then
+// removes it from the chain, recording the progress as it goes.
+func (cdr *Coordinator) decommission(n *node) {
+	address := n.Address()
+
+	if err := cdr.drain(n); err != nil {
+		// The node stays in the chain, so it should serve clients again.
+		if err := n.rpc.Undrain(); err != nil {
+			log.Printf("Failed to undrain node %s: %v\n", address, err)
+		}
+		cdr.decommissionFailed(address, err)
+		return
+	}
+
+	log.Printf("node %s is drained, removing it from the chain\n", address)
+	cdr.updateDecommission(address, func(s *transport.DecommissionStatus) {
+		s.Stage = transport.DecommissionRemoving
+	})
+
+	if err := cdr.RemoveNode(address); err != nil {
+		cdr.decommissionFailed(address, err)
+		return
+	}
+
+	log.Printf("decommissioned node %s\n", address)
+	cdr.updateDecommission(address, func(s *transport.DecommissionStatus) {
+		s.Stage = transport.DecommissionDone
+	})
+}
+
+// drain tells the node to drain until it's neighbors have caught up. It returns
+// an error if the node doesn't answer or they don't catch up in time.
+func (cdr *Coordinator) drain(n *node) error {
+	deadline := time.Now().Add(cdr.drainTimeout)
+
+	for {
+		drain, err := n.rpc.Drain()
+		if err != nil {
+			return err
+		}
+
+		cdr.updateDecommission(n.Address(), func(s *transport.DecommissionStatus) {
+			s.Node = *drain
+		})
+
+		if drain.CaughtUp() {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errDrainTimeout
+		}
+
+		time.Sleep(drainPollInterval)
+	}
+}
+
+func (cdr *Coordinator) decommissionFailed(address string, err error) {
+	log.Printf("Failed to decommission node %s: %v\n", address, err)
+	cdr.updateDecommission(address, func(s *transport.DecommissionStatus) {
+		s.Stage = transport.DecommissionFailed
+		s.Error = err.Error()
+	})
+}
+
+func (cdr *Coordinator) updateDecommission(
+	address string,
+	fn func(*transport.DecommissionStatus),
+) {
+	cdr.decomMu.Lock()
+	defer cdr.decomMu.Unlock()
+	if status, has := cdr.decommissions[address]; has {
+		fn(status)
+	}
+}
+
+// clearDecommission forgets the decommission of a node that joined again, so
+// it can be decommissioned again later.
+func (cdr *Coordinator) clearDecommission(address string) {
+	cdr.decomMu.Lock()
+	defer cdr.decomMu.Unlock()
+	delete(cdr.decommissions, address)
+}
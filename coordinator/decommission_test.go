@@ -0,0 +1,169 @@
+package coordinator
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecommission(t *testing.T) {
+	mu := &sync.Mutex{}
+	metas := make(map[string]*transport.NodeMeta)
+	drains := make(map[string]int)
+	cdr := New(Opts{
+		Chains: 2,
+		Transport: func() transport.NodeClient {
+			return &FakeNode{mu: mu, metas: metas, drains: drains}
+		},
+	})
+
+	joins := []*transport.AddNodeArgs{
+		{Address: "a", Chain: 0},
+		{Address: "b", Chain: 0},
+		{Address: "c", Chain: 0},
+		{Address: "d", Chain: 1},
+	}
+	for _, args := range joins {
+		if _, err := cdr.AddNode(args); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", args.Address, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	tests := []struct {
+		address string
+		err     error
+	}{
+		{address: "e", err: ErrUnknownNode},
+		{address: "d", err: ErrLastNode},
+	}
+	for _, tt := range tests {
+		if _, err := cdr.Decommission(tt.address); err != tt.err {
+			t.Fatalf("Decommission(%s) unexpected error\n  want: %#v\n  got: %#v", tt.address, tt.err, err)
+		}
+	}
+
+	status, err := cdr.Decommission("b")
+	if err != nil {
+		t.Fatalf("Decommission(b) unexpected error\n  got: %#v", err)
+	}
+	if status.Stage != transport.DecommissionDraining {
+		t.Fatalf("unexpected stage\n  want: %s\n  got: %s", transport.DecommissionDraining, status.Stage)
+	}
+
+	// b stays in the chain until it's caught up.
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Stage != transport.DecommissionDone {
+		if status.Stage == transport.DecommissionFailed {
+			t.Fatalf("Decommission(b) failed: %s", status.Error)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Decommission(b) didn't finish\n  got: %#v", status)
+		}
+
+		rt, err := cdr.Routes()
+		if err != nil {
+			t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+		}
+		if status.Stage == transport.DecommissionDraining && len(rt.Chains[0]) != 3 {
+			t.Fatalf("b left the chain while draining\n  got: %v", rt.Chains[0])
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if status, err = cdr.Decommission("b"); err != nil {
+			t.Fatalf("Decommission(b) unexpected error\n  got: %#v", err)
+		}
+	}
+
+	rt, err := cdr.Routes()
+	if err != nil {
+		t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff([][]string{{"a", "c"}, {"d"}}, rt.Chains); diff != "" {
+		t.Fatalf("unexpected chains (-want +got):\n%s", diff)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if drains["b"] != 3 {
+		t.Fatalf("unexpected number of Drain calls\n  want: 3\n  got: %d", drains["b"])
+	}
+
+	// a and c are spliced together.
+	wantMetas := map[string]*transport.NodeMeta{
+		"a": {IsHead: true, Next: "c", Tail: "c"},
+		"c": {IsTail: true, Prev: "a", Tail: "c"},
+	}
+	for address, want := range wantMetas {
+		if diff := cmp.Diff(want, metas[address]); diff != "" {
+			t.Fatalf("unexpected metadata sent to %s (-want +got):\n%s", address, diff)
+		}
+	}
+}
+
+// A node whose neighbors don't catch up in time stays in the chain, and is
+// told to undrain.
+func TestDecommissionTimeout(t *testing.T) {
+	mu := &sync.Mutex{}
+	metas := make(map[string]*transport.NodeMeta)
+	drains := make(map[string]int)
+	undrains := make(map[string]int)
+	cdr := New(Opts{
+		DrainTimeout: time.Nanosecond,
+		Transport: func() transport.NodeClient {
+			return &FakeNode{mu: mu, metas: metas, drains: drains, undrains: undrains}
+		},
+	})
+
+	for _, address := range []string{"a", "b"} {
+		if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: address}); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", address, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	if _, err := cdr.Decommission("b"); err != nil {
+		t.Fatalf("Decommission(b) unexpected error\n  got: %#v", err)
+	}
+
+	// Calling Decommission again would retry, so check the status directly.
+	var status transport.DecommissionStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cdr.decomMu.Lock()
+		status = *cdr.decommissions["b"]
+		cdr.decomMu.Unlock()
+		if status.Stage == transport.DecommissionFailed {
+			break
+		}
+		if status.Stage == transport.DecommissionDone {
+			t.Fatal("Decommission(b) removed the node before it's neighbors caught up")
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Decommission(b) didn't finish\n  got: %#v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Error != errDrainTimeout.Error() {
+		t.Fatalf("unexpected error\n  want: %s\n  got: %s", errDrainTimeout, status.Error)
+	}
+
+	rt, err := cdr.Routes()
+	if err != nil {
+		t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff([][]string{{"a", "b"}}, rt.Chains); diff != "" {
+		t.Fatalf("unexpected chains (-want +got):\n%s", diff)
+	}
+
+	// The node is told to serve again.
+	mu.Lock()
+	defer mu.Unlock()
+	if undrains["b"] != 1 {
+		t.Fatalf("unexpected Undrain calls\n  want: 1\n  got: %d", undrains["b"])
+	}
+}
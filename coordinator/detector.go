@@ -0,0 +1,211 @@
+package coordinator
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Health is what a FailureDetector thinks of a node.
+type Health int
+
+const (
+	// Healthy nodes answer their pings.
+	Healthy Health = iota
+	// Suspected nodes have stopped answering their pings, but may just be
+	// slow. They're still in the chain.
+	Suspected
+	// Failed nodes are removed from the chain.
+	Failed
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Suspected:
+		return "suspected"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// FailureDetector decides when a node has failed from the pings the
+// Coordinator sends it. It must be safe for concurrent use.
+type FailureDetector interface {
+	// Heartbeat records a ping the node answered at now.
+	Heartbeat(address string, now time.Time)
+	// Miss records a ping that failed or timed out at now.
+	Miss(address string, now time.Time)
+	// Health returns the health of the node at now. Detectors may keep track
+	// of when they started suspecting the node, so Health is called with
+	// increasing times.
+	Health(address string, now time.Time) Health
+	// Forget drops everything known about the node. It's called when the node
+	// is added to or removed from a chain.
+	Forget(address string)
+}
+
+// PhiOpts is for passing options to the PhiDetector constructor.
+type PhiOpts struct {
+	// Consecutive failed or timed out pings before a node is suspected.
+	// Default: 3
+	MaxMisses int
+	// Suspicion level at which a node is suspected, even if none of its pings
+	// have failed yet, because it's been quiet for much longer than usual. A
+	// phi of 8 means there's about a 1 in 10^8 chance the node is still
+	// answering as usual. Default: 8
+	Threshold float64
+	// How long a node stays suspected before it has failed. If the node
+	// answers a ping in the meantime, it's healthy again. Default: 5s
+	GracePeriod time.Duration
+	// Number of intervals between answered pings the suspicion level is
+	// estimated from. Default: 100
+	WindowSize int
+	// Lower bound of the standard deviation of the intervals, so that nodes
+	// that always answer on time aren't suspected after the smallest delay.
+	// Default: 200ms
+	MinStdDev time.Duration
+}
+
+// PhiDetector is a FailureDetector based on the phi accrual failure detector.
+// Instead of a fixed timeout, it keeps the recent intervals between answered
+// pings of each node and works out how unlikely it is that the node is still
+// there given how long it's been quiet. That's the suspicion level, phi. A node
+// is suspected once phi reaches the threshold, or once it misses MaxMisses
+// pings in a row, and has failed once it's been suspected for the grace
+// period.
+type PhiDetector struct {
+	mu    sync.Mutex
+	opts  PhiOpts
+	nodes map[string]*phiState
+}
+
+type phiState struct {
+	last        time.Time // last answered ping
+	intervals   []time.Duration
+	misses      int       // in a row
+	suspectedAt time.Time // zero if not suspected
+}
+
+// NewPhiDetector creates a new PhiDetector.
+func NewPhiDetector(opts PhiOpts) *PhiDetector {
+	if opts.MaxMisses == 0 {
+		opts.MaxMisses = 3
+	}
+	if opts.Threshold == 0 {
+		opts.Threshold = 8
+	}
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = 5 * time.Second
+	}
+	if opts.WindowSize == 0 {
+		opts.WindowSize = 100
+	}
+	if opts.MinStdDev == 0 {
+		opts.MinStdDev = 200 * time.Millisecond
+	}
+	return &PhiDetector{opts: opts, nodes: make(map[string]*phiState)}
+}
+
+func (d *PhiDetector) state(address string) *phiState {
+	s, has := d.nodes[address]
+	if !has {
+		s = &phiState{}
+		d.nodes[address] = s
+	}
+	return s
+}
+
+func (d *PhiDetector) Heartbeat(address string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.state(address)
+	if !s.last.IsZero() && now.After(s.last) {
+		s.intervals = append(s.intervals, now.Sub(s.last))
+		if len(s.intervals) > d.opts.WindowSize {
+			s.intervals = s.intervals[1:]
+		}
+	}
+	s.last = now
+	s.misses = 0
+}
+
+func (d *PhiDetector) Miss(address string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state(address).misses++
+}
+
+func (d *PhiDetector) Health(address string, now time.Time) Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, has := d.nodes[address]
+	if !has {
+		return Healthy
+	}
+
+	if s.misses < d.opts.MaxMisses && s.phi(now, d.opts.MinStdDev) < d.opts.Threshold {
+		s.suspectedAt = time.Time{}
+		return Healthy
+	}
+
+	if s.suspectedAt.IsZero() {
+		s.suspectedAt = now
+	}
+	if now.Sub(s.suspectedAt) >= d.opts.GracePeriod {
+		return Failed
+	}
+	return Suspected
+}
+
+func (d *PhiDetector) Forget(address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.nodes, address)
+}
+
+// Phi returns the suspicion level of the node at now. It's 0 until the node
+// has answered at least two pings.
+func (d *PhiDetector) Phi(address string, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, has := d.nodes[address]
+	if !has {
+		return 0
+	}
+	return s.phi(now, d.opts.MinStdDev)
+}
+
+// phi is -log10 of the probability that an interval is longer than the time
+// since the last answered ping, with the intervals assumed to be normally
+// distributed. The normal distribution's CDF is approximated with a logistic
+// function.
+func (s *phiState) phi(now time.Time, minStdDev time.Duration) float64 {
+	if len(s.intervals) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, interval := range s.intervals {
+		sum += float64(interval)
+	}
+	mean := sum / float64(len(s.intervals))
+
+	var variance float64
+	for _, interval := range s.intervals {
+		variance += math.Pow(float64(interval)-mean, 2)
+	}
+	stdDev := math.Max(math.Sqrt(variance/float64(len(s.intervals))), float64(minStdDev))
+
+	elapsed := float64(now.Sub(s.last))
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
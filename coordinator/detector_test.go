@@ -0,0 +1,127 @@
+package coordinator
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPhiDetectorMisses(t *testing.T) {
+	d := NewPhiDetector(PhiOpts{MaxMisses: 3, GracePeriod: 5 * time.Second})
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	d.Heartbeat("a", at(0))
+
+	steps := []struct {
+		name string
+		step func()
+		at   int
+		want Health
+	}{
+		{name: "one miss", step: func() { d.Miss("a", at(1)) }, at: 1, want: Healthy},
+		{name: "two misses", step: func() { d.Miss("a", at(2)) }, at: 2, want: Healthy},
+		{name: "three misses", step: func() { d.Miss("a", at(3)) }, at: 3, want: Suspected},
+		{name: "within grace period", step: func() {}, at: 7, want: Suspected},
+		{name: "answered", step: func() { d.Heartbeat("a", at(8)) }, at: 8, want: Healthy},
+		{name: "suspected again", step: func() {
+			for i := 0; i < 3; i++ {
+				d.Miss("a", at(9))
+			}
+		}, at: 9, want: Suspected},
+		{name: "grace period over", step: func() {}, at: 14, want: Failed},
+		{name: "forgotten", step: func() { d.Forget("a") }, at: 15, want: Healthy},
+	}
+
+	for _, s := range steps {
+		s.step()
+		if got := d.Health("a", at(s.at)); got != s.want {
+			t.Fatalf("%s: unexpected health\n  want: %s\n  got: %s", s.name, s.want, got)
+		}
+	}
+}
+
+// A node that always answers once a second is suspected once it's been quiet
+// for a few seconds, before any of its pings fail.
+func TestPhiDetectorSuspicion(t *testing.T) {
+	d := NewPhiDetector(PhiOpts{GracePeriod: 5 * time.Second})
+	last := time.Now()
+	for i := 0; i < 10; i++ {
+		last = last.Add(time.Second)
+		d.Heartbeat("a", last)
+	}
+
+	tests := []struct {
+		quiet time.Duration
+		want  Health
+	}{
+		{quiet: 500 * time.Millisecond, want: Healthy},
+		{quiet: 1500 * time.Millisecond, want: Healthy},
+		{quiet: 3 * time.Second, want: Suspected},
+		{quiet: 8 * time.Second, want: Failed},
+	}
+
+	for _, tt := range tests {
+		now := last.Add(tt.quiet)
+		if got := d.Health("a", now); got != tt.want {
+			t.Fatalf("unexpected health after %s (phi %.2f)\n  want: %s\n  got: %s", tt.quiet, d.Phi("a", now), tt.want, got)
+		}
+	}
+}
+
+// A node is only removed from the chain once it's been suspected for the grace
+// period.
+func TestFailureDetection(t *testing.T) {
+	cdr := New(Opts{
+		Transport: func() transport.NodeClient {
+			return &FakeNode{mu: &sync.Mutex{}, metas: make(map[string]*transport.NodeMeta)}
+		},
+		FailureDetector: NewPhiDetector(PhiOpts{MaxMisses: 2, GracePeriod: 5 * time.Second}),
+	})
+
+	for _, address := range []string{"a", "b"} {
+		if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: address}); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", address, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	start := time.Now()
+	refused := errors.New("connection refused")
+	steps := []struct {
+		err  error
+		at   time.Duration
+		want []string
+	}{
+		{err: refused, at: 0, want: []string{"a", "b"}},
+		{err: refused, at: time.Second, want: []string{"a", "b"}},
+		{err: refused, at: 4 * time.Second, want: []string{"a", "b"}},
+		{err: refused, at: 6 * time.Second, want: []string{"a"}},
+	}
+
+	for _, s := range steps {
+		cdr.pinged("b", s.err, start.Add(s.at))
+		cdr.Updates.Wait()
+
+		rt, err := cdr.Routes()
+		if err != nil {
+			t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+		}
+		if diff := cmp.Diff([][]string{s.want}, rt.Chains); diff != "" {
+			t.Fatalf("unexpected chains after %s (-want +got):\n%s", s.at, diff)
+		}
+	}
+
+	// b starts out healthy if it joins again.
+	if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: "b"}); err != nil {
+		t.Fatalf("AddNode(b) unexpected error\n  got: %#v", err)
+	}
+	cdr.Updates.Wait()
+	if got := cdr.detector.Health("b", start.Add(7*time.Second)); got != Healthy {
+		t.Fatalf("unexpected health of b\n  want: %s\n  got: %s", Healthy, got)
+	}
+}
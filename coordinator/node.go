@@ -13,13 +13,4 @@ type node struct {
 	address   string    // host and port
 }
 
-func (n *node) Connect() error {
-	if err := n.rpc.Connect(n.address); err != nil {
-		n.connected = false
-		return err
-	}
-	n.connected = true
-	return nil
-}
-
 func (n node) Address() string { return n.address }
@@ -0,0 +1,110 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/despreston/go-craq/raft"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StateStore persists the membership and order of the chains so that a
+// restarted Coordinator knows about the nodes that were in each chain before it
+// stopped. A StateStore is also used to persist the Raft log when the
+// Coordinator is part of a cluster.
+type StateStore interface {
+	raft.Persister
+	SaveChains(chains [][]string) error
+	LoadChains() ([][]string, error)
+}
+
+var (
+	stateBucket = []byte("coordinator")
+	chainsKey   = []byte("chains")
+	raftKey     = []byte("raft")
+)
+
+// BoltState is a StateStore that keeps the state in a bbolt database file.
+type BoltState struct {
+	DB   *bolt.DB
+	file string
+}
+
+func NewBoltState(f string) *BoltState {
+	return &BoltState{file: f}
+}
+
+func (b *BoltState) Connect() error {
+	DB, err := bolt.Open(b.file, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(stateBucket); err != nil {
+			return fmt.Errorf("could not create coordinator bucket: %v", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	b.DB = DB
+	return nil
+}
+
+func (b *BoltState) put(key []byte, v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Put(key, encoded)
+	})
+}
+
+// get decodes the value for key into v. Returns false if there's no value.
+func (b *BoltState) get(key []byte, v interface{}) (bool, error) {
+	var result []byte
+
+	b.DB.View(func(tx *bolt.Tx) error {
+		// The slice returned by Get is only valid during the transaction.
+		result = append(result, tx.Bucket(stateBucket).Get(key)...)
+		return nil
+	})
+
+	if len(result) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(result, v)
+}
+
+// SaveChains saves the addresses of the nodes in each chain, in order.
+func (b *BoltState) SaveChains(chains [][]string) error {
+	return b.put(chainsKey, chains)
+}
+
+// LoadChains returns the addresses of the nodes in each chain, in order.
+func (b *BoltState) LoadChains() ([][]string, error) {
+	var chains [][]string
+	_, err := b.get(chainsKey, &chains)
+	return chains, err
+}
+
+// SaveRaft saves the term, vote and log of the Raft member.
+func (b *BoltState) SaveRaft(state *raft.State) error {
+	return b.put(raftKey, state)
+}
+
+// LoadRaft returns the saved state of the Raft member, or nil if nothing has
+// been saved.
+func (b *BoltState) LoadRaft() (*raft.State, error) {
+	state := &raft.State{}
+	found, err := b.get(raftKey, state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return state, nil
+}
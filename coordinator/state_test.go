@@ -0,0 +1,118 @@
+package coordinator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+// UnreachableNode is a FakeNode that can't be connected to at some addresses.
+type UnreachableNode struct {
+	*FakeNode
+	unreachable map[string]bool
+}
+
+func (u *UnreachableNode) Connect(address string) error {
+	if u.unreachable[address] {
+		return errors.New("connection refused")
+	}
+	return u.FakeNode.Connect(address)
+}
+
+func TestRestore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "coordinator.db")
+
+	state := NewBoltState(file)
+	if err := state.Connect(); err != nil {
+		t.Fatalf("Connect() unexpected error\n  got: %#v", err)
+	}
+
+	mu := &sync.Mutex{}
+	metas := make(map[string]*transport.NodeMeta)
+	tport := func() transport.NodeClient {
+		return &FakeNode{mu: mu, metas: metas}
+	}
+
+	cdr := New(Opts{Transport: tport, State: state})
+	for _, addr := range []string{"a", "b", "c"} {
+		if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: addr}); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", addr, err)
+		}
+	}
+	cdr.Updates.Wait()
+	state.DB.Close()
+
+	// Simulate a restart using the same state file.
+	state = NewBoltState(file)
+	if err := state.Connect(); err != nil {
+		t.Fatalf("Connect() unexpected error\n  got: %#v", err)
+	}
+	defer func() {
+		state.DB.Close()
+		os.Remove(file)
+	}()
+
+	metas = make(map[string]*transport.NodeMeta)
+	restarted := New(Opts{Transport: tport, State: state})
+	if err := restarted.restore(); err != nil {
+		t.Fatalf("restore() unexpected error\n  got: %#v", err)
+	}
+
+	want := map[string]*transport.NodeMeta{
+		"a": {IsHead: true, Next: "b", Tail: "c"},
+		"b": {Prev: "a", Next: "c", Tail: "c"},
+		"c": {IsTail: true, Prev: "b", Tail: "c"},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, metas); diff != "" {
+		t.Fatalf("restore() unexpected metadata (-want +got):\n%s", diff)
+	}
+}
+
+// Nodes that can't be reached after a restart are removed from their chain.
+func TestRestoreUnreachable(t *testing.T) {
+	state := NewBoltState(filepath.Join(t.TempDir(), "coordinator.db"))
+	if err := state.Connect(); err != nil {
+		t.Fatalf("Connect() unexpected error\n  got: %#v", err)
+	}
+	defer state.DB.Close()
+
+	mu := &sync.Mutex{}
+	metas := make(map[string]*transport.NodeMeta)
+	unreachable := make(map[string]bool)
+	tport := func() transport.NodeClient {
+		return &UnreachableNode{
+			FakeNode:    &FakeNode{mu: mu, metas: metas},
+			unreachable: unreachable,
+		}
+	}
+
+	cdr := New(Opts{Transport: tport, State: state})
+	for _, addr := range []string{"a", "b", "c"} {
+		if _, err := cdr.AddNode(&transport.AddNodeArgs{Address: addr}); err != nil {
+			t.Fatalf("AddNode(%s) unexpected error\n  got: %#v", addr, err)
+		}
+	}
+	cdr.Updates.Wait()
+
+	unreachable["b"] = true
+	restarted := New(Opts{Transport: tport, State: state})
+	if err := restarted.restore(); err != nil {
+		t.Fatalf("restore() unexpected error\n  got: %#v", err)
+	}
+
+	rt, err := restarted.Routes()
+	if err != nil {
+		t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff([][]string{{"a", "c"}}, rt.Chains); diff != "" {
+		t.Fatalf("unexpected chains (-want +got):\n%s", diff)
+	}
+}
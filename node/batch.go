@@ -0,0 +1,360 @@
+package node
+
+import (
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+// ClientWriteBatch adds a group of objects to the chain. The whole batch is
+// versioned at once, sent down the chain as a single WriteBatch message, and
+// committed together by the tail with a single CommitBatch message. It returns
+// the new versions, in the same order as items, once every item has been
+// committed and the commit has made it back to this node. A key that appears
+// more than once in the batch gets a new version for each item, so the last
+// item for the key wins.
+func (n *Node) ClientWriteBatch(items []transport.Item) ([]uint64, error) {
+	return n.clientWriteBatch(&transport.Transaction{Writes: items})
+}
+
+// ClientTransaction applies a mini-transaction. Every key the transaction
+// checks or writes is locked while the preconditions are checked against the
+// newest versions at the head, so nothing can change the keys in between. If
+// a precondition fails, a *transport.ConflictError is returned and nothing is
+// written. Otherwise the writes move down the chain as a single batch, are
+// stored by every node as one unit, and are committed as one unit, so reads
+// never see some of the writes without the others.
+func (n *Node) ClientTransaction(txn *transport.Transaction) ([]uint64, error) {
+	return n.clientWriteBatch(txn)
+}
+
+func (n *Node) clientWriteBatch(txn *transport.Transaction) ([]uint64, error) {
+	batch, done, err := n.startClientWriteBatch(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.After(n.writeTimeout)
+	for key, ch := range done {
+		select {
+		case <-ch:
+		case <-timeout:
+			n.stopWaitingAll(done)
+			n.log.Printf(
+				"Timed out waiting for commit of batch of %d items. Waiting on %s\n",
+				len(batch),
+				key,
+			)
+			return nil, transport.ErrCommitTimeout
+		}
+	}
+
+	versions := make([]uint64, len(batch))
+	for i, item := range batch {
+		versions[i] = item.Version
+	}
+	return versions, nil
+}
+
+// startClientWriteBatch checks the preconditions of txn, assigns versions to
+// every write, writes them to the store, and sends them down the chain as one
+// batch. Every key in txn is locked until the batch has been sent. The returned
+// channels, one for each key, are closed once the newest version of the key in
+// the batch is committed.
+func (n *Node) startClientWriteBatch(
+	txn *transport.Transaction,
+) ([]transport.VersionedItem, map[string]chan struct{}, error) {
+	if err := n.writable(); err != nil {
+		return nil, nil, err
+	}
+
+	unlock := n.seq.lockAll(txn.Keys())
+	defer unlock()
+
+	for _, p := range txn.Preconditions {
+		if err := n.checkPrecondition(p); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(txn.Writes) == 0 {
+		return []transport.VersionedItem{}, nil, nil
+	}
+
+	batch := make([]transport.VersionedItem, len(txn.Writes))
+	items := make([]*store.Item, len(txn.Writes))
+	// Newest version assigned in this batch, by key.
+	versions := make(map[string]uint64)
+
+	now := time.Now()
+
+	for i, write := range txn.Writes {
+		version := n.seq.next(write.Key)
+		if v, has := versions[write.Key]; has {
+			version = v + 1
+		}
+		versions[write.Key] = version
+
+		batch[i] = transport.VersionedItem{
+			Key:     write.Key,
+			Value:   write.Value,
+			Version: version,
+		}
+		if write.TTL > 0 {
+			batch[i].ExpiresAt = now.Add(write.TTL)
+		}
+		items[i] = batchItem(batch[i])
+	}
+
+	if err := n.storeItems(items); err != nil {
+		n.log.Printf("Failed to create during ClientWriteBatch. %v\n", err)
+		return nil, nil, err
+	}
+
+	// Start waiting before the batch goes anywhere so the commit can't be
+	// missed.
+	done := make(map[string]chan struct{}, len(versions))
+	for key, version := range versions {
+		done[key] = n.waitForCommit(key, version)
+	}
+
+	n.log.Printf("Node RPC ClientWriteBatch() created %d items\n", len(batch))
+
+	next := n.neighbors[transport.NeighborPosNext]
+
+	// No successor means this is the only node in the chain, so commit the
+	// batch right away.
+	if next.address == "" {
+		if err := n.commitBatch(versions); err != nil {
+			n.stopWaitingAll(done)
+			return nil, nil, err
+		}
+		return batch, done, nil
+	}
+
+	if err := n.forward(batch); err != nil {
+		n.log.Printf("Failed to send batch to successor. %v\n", err)
+		n.stopWaitingAll(done)
+		return nil, nil, err
+	}
+
+	return batch, done, nil
+}
+
+// checkPrecondition returns a *transport.ConflictError if the newest version of
+// the key doesn't match p. The caller must hold the lock for the key.
+func (n *Node) checkPrecondition(p transport.Precondition) error {
+	latest, has := n.seq.newestVersion(p.Key)
+	exists := has && !latest.deleted
+
+	if p.Absent && !exists || !p.Absent && exists && latest.version == p.Version {
+		return nil
+	}
+
+	return &transport.ConflictError{
+		Key:    p.Key,
+		Latest: latest.version,
+		Exists: exists,
+	}
+}
+
+// stopWaitingAll removes the waiters for every key in a batch.
+func (n *Node) stopWaitingAll(done map[string]chan struct{}) {
+	for key, ch := range done {
+		n.stopWaiting(key, ch)
+	}
+}
+
+// WriteBatch adds a batch of versioned objects to the chain. If the node is not
+// the tail, the batch is forwarded to the next node as is. If the node is the
+// tail, every item is committed and a single CommitBatch message is sent to the
+// predecessor. If any item isn't newer than what the node already has, the
+// whole batch is rejected with transport.ErrStaleVersion and nothing is
+// stored.
+func (n *Node) WriteBatch(batch []transport.VersionedItem) error {
+	n.log.Printf("Node RPC WriteBatch() %d items to store\n", len(batch))
+
+	if err := n.storeNewerBatch(batch); err != nil {
+		n.log.Printf("Failed to write batch. %v\n", err)
+		return err
+	}
+
+	if !n.IsTail {
+		if err := n.forward(batch); err != nil {
+			n.log.Printf("Failed to send batch to successor. %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	versions := newestInBatch(batch)
+	if err := n.commitBatch(versions); err != nil {
+		n.log.Printf("Failed to commit batch in WriteBatch. %v\n", err)
+		return err
+	}
+
+	n.sendCommits(versions)
+	return nil
+}
+
+// storeNewerBatch stores every item in the batch, as one unit, if they're all
+// newer than the versions the node has seen.
+func (n *Node) storeNewerBatch(batch []transport.VersionedItem) error {
+	keys := make([]string, len(batch))
+	for i, item := range batch {
+		keys[i] = item.Key
+	}
+
+	unlock := n.seq.lockAll(keys)
+	defer unlock()
+
+	// Versions of the same key inside the batch have to go up too.
+	newest := make(map[string]uint64, len(batch))
+	items := make([]*store.Item, len(batch))
+	for i, item := range batch {
+		if v, has := newest[item.Key]; has && item.Version <= v {
+			return transport.ErrStaleVersion
+		}
+		items[i] = batchItem(item)
+		if err := n.seq.check(items[i]); err != nil {
+			return err
+		}
+		newest[item.Key] = item.Version
+	}
+
+	return n.storeItems(items)
+}
+
+// storeNewerItems stores, as one unit, the items in the batch that are newer than
+// the versions the node has seen, and returns them. The other items are
+// skipped, since the node already has them or something newer.
+func (n *Node) storeNewerItems(batch []transport.VersionedItem) ([]transport.VersionedItem, error) {
+	keys := make([]string, len(batch))
+	for i, item := range batch {
+		keys[i] = item.Key
+	}
+
+	unlock := n.seq.lockAll(keys)
+	defer unlock()
+
+	newest := make(map[string]uint64, len(batch))
+	fresh := make([]transport.VersionedItem, 0, len(batch))
+	items := make([]*store.Item, 0, len(batch))
+	for _, item := range batch {
+		if v, has := newest[item.Key]; has && item.Version <= v {
+			continue
+		}
+		si := batchItem(item)
+		if n.seq.check(si) != nil {
+			continue
+		}
+		newest[item.Key] = item.Version
+		fresh = append(fresh, item)
+		items = append(items, si)
+	}
+
+	if len(items) == 0 {
+		return fresh, nil
+	}
+	return fresh, n.storeItems(items)
+}
+
+// storeItems writes the items to the store as one unit.
+func (n *Node) storeItems(items []*store.Item) error {
+	if err := n.store.WriteAll(items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		n.wrote(item)
+	}
+	return nil
+}
+
+// CommitBatch marks the newest version of each key in a batch as committed and
+// passes the commit on to the predecessor.
+func (n *Node) CommitBatch(versions map[string]uint64) error {
+	if err := n.commitBatch(versions); err != nil {
+		return err
+	}
+
+	if n.neighbors[transport.NeighborPosPrev].address != "" {
+		return n.sendCommits(versions)
+	}
+
+	return nil
+}
+
+// commitBatch commits the versions in the store as one unit and updates
+// n.latest for every key at once, so readers asking this node for the latest
+// versions never see part of a batch. The commits are added to the watch log
+// together too.
+func (n *Node) commitBatch(versions map[string]uint64) error {
+	items := make(map[string][]*store.Item, len(versions))
+	for key, version := range versions {
+		items[key] = n.watchItems(key, version)
+	}
+
+	if err := n.store.CommitAll(versions); err != nil {
+		n.log.Printf("Failed to commit batch. Versions: %v Error: %#v", versions, err)
+		return err
+	}
+
+	n.latestMu.Lock()
+	for key, version := range versions {
+		n.publish(items[key])
+		n.setLatest(key, version)
+	}
+	n.latestMu.Unlock()
+
+	for key, version := range versions {
+		n.notifyCommitted(key, version)
+		if n.committed != nil {
+			n.committed <- commitEvent{Key: key, Version: version}
+		}
+	}
+
+	return nil
+}
+
+func (n *Node) sendCommitBatchToPrev(versions map[string]uint64) error {
+	prev := n.neighbors[transport.NeighborPosPrev]
+	if err := prev.rpc.CommitBatch(versions); err != nil {
+		n.log.Printf("Failed to send CommitBatch to predecessor. %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// newestInBatch returns the newest version of each key in the batch. Committing
+// those versions commits the whole batch, because committing a version clears
+// every older version of the key.
+func newestInBatch(batch []transport.VersionedItem) map[string]uint64 {
+	versions := make(map[string]uint64)
+	for _, item := range batch {
+		if v, has := versions[item.Key]; !has || item.Version > v {
+			versions[item.Key] = item.Version
+		}
+	}
+	return versions
+}
+
+func batchItem(item transport.VersionedItem) *store.Item {
+	return &store.Item{
+		Key:       item.Key,
+		Value:     item.Value,
+		Version:   item.Version,
+		Deleted:   item.Deleted,
+		ExpiresAt: item.ExpiresAt,
+	}
+}
+
+func versionedItem(item *store.Item) transport.VersionedItem {
+	return transport.VersionedItem{
+		Key:       item.Key,
+		Value:     item.Value,
+		Version:   item.Version,
+		Deleted:   item.Deleted,
+		ExpiresAt: item.ExpiresAt,
+	}
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+// CountingNode counts the chain messages sent to the node.
+type CountingNode struct {
+	*Node
+	*FakeClient
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *CountingNode) count(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[method]++
+}
+
+func (c *CountingNode) Write(key string, val []byte, version uint64) error {
+	c.count("Write")
+	return c.Node.Write(key, val, version)
+}
+
+func (c *CountingNode) WriteBatch(items []transport.VersionedItem) error {
+	c.count("WriteBatch")
+	return c.Node.WriteBatch(items)
+}
+
+func (c *CountingNode) Commit(key string, version uint64) error {
+	c.count("Commit")
+	return c.Node.Commit(key, version)
+}
+
+func (c *CountingNode) CommitBatch(versions map[string]uint64) error {
+	c.count("CommitBatch")
+	return c.Node.CommitBatch(versions)
+}
+
+func TestWriteBatch(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	if _, err := c.Write("hello", []byte("before"), 0); err != nil {
+		t.Fatalf("Write(hello) unexpected error\n  got: %#v", err)
+	}
+
+	versions, err := c.WriteBatch([]transport.Item{
+		{Key: "hello", Value: []byte("a")},
+		{Key: "foo", Value: []byte("b")},
+		{Key: "hello", Value: []byte("c")},
+	})
+	if err != nil {
+		t.Fatalf("WriteBatch() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff([]uint64{1, 0, 2}, versions); diff != "" {
+		t.Fatalf("WriteBatch() unexpected versions (-want +got):\n%s", diff)
+	}
+
+	for _, node := range []*Node{n, n2} {
+		assertItem(t, node, "hello", []byte("c"))
+		assertItem(t, node, "foo", []byte("b"))
+	}
+}
+
+// The whole batch goes down the chain as one message and comes back as one
+// commit.
+func TestWriteBatchSingleMessage(t *testing.T) {
+	head := New(Opts{Store: kv.New()})
+	tail := New(Opts{Store: kv.New()})
+	tail.IsTail = true
+
+	toTail := &CountingNode{Node: tail, calls: make(map[string]int)}
+	toHead := &CountingNode{Node: head, calls: make(map[string]int)}
+	head.neighbors[transport.NeighborPosNext] = neighbor{rpc: toTail, address: "tail"}
+	tail.neighbors[transport.NeighborPosPrev] = neighbor{rpc: toHead, address: "head"}
+
+	items := []transport.Item{}
+	for i := 0; i < 100; i++ {
+		items = append(items, transport.Item{
+			Key:   fmt.Sprintf("key-%d", i),
+			Value: []byte("value"),
+		})
+	}
+
+	if _, err := head.ClientWriteBatch(items); err != nil {
+		t.Fatalf("ClientWriteBatch() unexpected error\n  got: %#v", err)
+	}
+
+	if diff := cmp.Diff(map[string]int{"WriteBatch": 1}, toTail.calls); diff != "" {
+		t.Fatalf("unexpected messages to the tail (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]int{"CommitBatch": 1}, toHead.calls); diff != "" {
+		t.Fatalf("unexpected messages to the head (-want +got):\n%s", diff)
+	}
+
+	committed, err := head.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() unexpected error\n  got: %#v", err)
+	}
+	if len(*committed) != len(items) {
+		t.Fatalf("unexpected number of committed items\n  want: %d\n  got: %d", len(items), len(*committed))
+	}
+}
+
+// A batch with any stale version is rejected as a whole.
+func TestWriteBatchRejectsStaleVersion(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{
+		rpc:     &StuckNode{},
+		address: "stuck",
+	}
+
+	if err := n.Write("hello", []byte("a"), 0); err != nil {
+		t.Fatalf("Write(hello, a, 0) unexpected error\n  got: %#v", err)
+	}
+
+	tests := []struct {
+		name  string
+		batch []transport.VersionedItem
+	}{
+		{
+			name: "already stored",
+			batch: []transport.VersionedItem{
+				{Key: "foo", Value: []byte("b"), Version: 0},
+				{Key: "hello", Value: []byte("b"), Version: 0},
+			},
+		},
+		{
+			name: "repeated in batch",
+			batch: []transport.VersionedItem{
+				{Key: "foo", Value: []byte("b"), Version: 0},
+				{Key: "foo", Value: []byte("c"), Version: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := n.WriteBatch(tt.batch); err != transport.ErrStaleVersion {
+				t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", transport.ErrStaleVersion, err)
+			}
+			if _, has := n.seq.newestVersion("foo"); has {
+				t.Fatal("unexpected write of foo from rejected batch")
+			}
+		})
+	}
+}
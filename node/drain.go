@@ -0,0 +1,61 @@
+package node
+
+import (
+	"github.com/despreston/go-craq/transport"
+)
+
+// Drain gets the node ready to leave the chain. From now on client reads are
+// refused with transport.ErrDraining, so clients go to other nodes. A draining
+// head still takes client writes, since the chain has no other head until this
+// one is spliced out. Writes from the predecessor and commits from the
+// successor are still passed along, so the node keeps the chain together until
+// the Coordinator splices it out. The returned status shows what the neighbors
+// are still waiting on; the Coordinator calls Drain until they've caught up, or
+// calls Undrain if they don't.
+func (n *Node) Drain() (*transport.DrainStatus, error) {
+	if n.currentState() != transport.NodeDraining {
+		n.setState(transport.NodeDraining)
+		n.log.Println("No longer serving client reads.")
+	}
+
+	return n.drainStatus(), nil
+}
+
+// Undrain puts a draining node back in the state it was in before it started
+// draining, or the one it reached since, so it serves client reads again. The
+// Coordinator calls it when the node's neighbors don't catch up in time.
+func (n *Node) Undrain() error {
+	n.stateMu.Lock()
+	if n.state != transport.NodeDraining {
+		n.stateMu.Unlock()
+		return nil
+	}
+	n.enterState(n.undrainState)
+	return nil
+}
+
+// drainStatus counts what the node still has to pass on to it's neighbors.
+func (n *Node) drainStatus() *transport.DrainStatus {
+	status := &transport.DrainStatus{}
+
+	n.latestMu.Lock()
+	status.Dirty = len(n.dirtySince)
+	n.latestMu.Unlock()
+
+	n.pipeMu.Lock()
+	if n.replicator != nil {
+		status.Replicating = n.replicator.backlog()
+	}
+	if n.committer != nil {
+		status.Committing = n.committer.backlog()
+	}
+	n.pipeMu.Unlock()
+
+	n.waitersMu.Lock()
+	for _, waiting := range n.waiters {
+		status.Writing += len(waiting)
+	}
+	n.waitersMu.Unlock()
+
+	return status
+}
@@ -0,0 +1,104 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+)
+
+// GateNode holds replication messages sent to the node until gate is closed.
+type GateNode struct {
+	*Node
+	*FakeClient
+	gate chan struct{}
+}
+
+func (g *GateNode) Replicate(msg *transport.Replication) error {
+	<-g.gate
+	return g.Node.Replicate(msg)
+}
+
+func TestDrain(t *testing.T) {
+	nodes := []*Node{
+		New(Opts{Store: kv.New()}),
+		New(Opts{Store: kv.New()}),
+	}
+	gate := make(chan struct{})
+	defer link(func(n *Node) transport.NodeClient {
+		return &GateNode{Node: n, gate: gate}
+	}, nodes...)()
+	head, tail := nodes[0], nodes[1]
+
+	written := make(chan error)
+	go func() {
+		_, err := head.ClientWrite("a", []byte("a"))
+		written <- err
+	}()
+
+	// Wait for the write to be on it's way to the tail.
+	var status *transport.DrainStatus
+	deadline := time.Now().Add(time.Second)
+	for {
+		status = head.drainStatus()
+		if status.Replicating == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("write isn't being replicated\n  got: %#v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status, err := head.Drain()
+	if err != nil {
+		t.Fatalf("Drain() unexpected error\n  got: %#v", err)
+	}
+	want := transport.DrainStatus{Dirty: 1, Replicating: 1, Writing: 1}
+	if *status != want || status.CaughtUp() {
+		t.Fatalf("unexpected drain status\n  want: %#v\n  got: %#v", want, *status)
+	}
+
+	// Client reads are refused, and go to other nodes instead. The head still
+	// takes writes until it's spliced out.
+	if _, _, _, err := head.Read("a", nil); err != transport.ErrDraining {
+		t.Fatalf("Read(a) unexpected error\n  want: %#v\n  got: %#v", transport.ErrDraining, err)
+	}
+	go func() {
+		_, err := head.ClientWrite("b", []byte("b"))
+		written <- err
+	}()
+
+	// Writes that were already in the chain still make it through, and so do
+	// the new ones.
+	close(gate)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-written:
+			if err != nil {
+				t.Fatalf("ClientWrite() unexpected error\n  got: %#v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("ClientWrite() wasn't committed")
+		}
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for {
+		status, err = head.Drain()
+		if err != nil {
+			t.Fatalf("Drain() unexpected error\n  got: %#v", err)
+		}
+		if status.CaughtUp() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("neighbors didn't catch up\n  got: %#v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertItem(t, tail, "a", []byte("a"))
+	assertItem(t, tail, "b", []byte("b"))
+}
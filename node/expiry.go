@@ -0,0 +1,84 @@
+package node
+
+import (
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+const defaultExpiryInterval = time.Second
+
+// expiry is a version of a key that expires.
+type expiry struct {
+	version uint64
+	at      time.Time
+}
+
+// trackExpiry records when the key expires if item is the newest version of
+// the key seen so far. Every node keeps track, so a node that becomes the head
+// knows which keys to expire.
+func (n *Node) trackExpiry(item *store.Item) {
+	n.expiriesMu.Lock()
+	defer n.expiriesMu.Unlock()
+
+	e, has := n.expiries[item.Key]
+	if has && e.version >= item.Version {
+		return
+	}
+
+	if item.Deleted || item.ExpiresAt.IsZero() {
+		delete(n.expiries, item.Key)
+		return
+	}
+
+	n.expiries[item.Key] = expiry{version: item.Version, at: item.ExpiresAt}
+}
+
+func (n *Node) expireLoop() {
+	for range time.Tick(n.expiryInterval) {
+		if n.IsHead && n.currentState() == transport.NodeServing {
+			n.expireKeys(time.Now())
+		}
+	}
+}
+
+// expireKeys deletes the keys that expired before now. Only the head expires
+// keys. The delete is a tombstone, versioned and replicated like any other
+// write, so every node agrees on the version the key disappears at. It's only
+// written if the expiring version is still the newest, so a key written again
+// in the meantime lives on.
+func (n *Node) expireKeys(now time.Time) {
+	expired := make(map[string]uint64)
+
+	n.expiriesMu.Lock()
+	for key, e := range n.expiries {
+		if !e.at.After(now) {
+			expired[key] = e.version
+		}
+	}
+	n.expiriesMu.Unlock()
+
+	for key, version := range expired {
+		version := version
+		item := &store.Item{Key: key, Deleted: true}
+
+		done, err := n.startClientWrite(item, func(latest written, has bool) bool {
+			return has && !latest.deleted && latest.version == version
+		})
+
+		if _, conflict := err.(*transport.ConflictError); conflict {
+			continue
+		}
+
+		if err != nil {
+			n.log.Printf("Failed to expire version %d of key %s. %v\n", version, key, err)
+			continue
+		}
+
+		// The tombstone commits like any other write, so there's no need to
+		// wait for it.
+		n.stopWaiting(key, done)
+		n.log.Printf("Expired version %d of key %s\n", version, key)
+	}
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+)
+
+func TestExpireKeys(t *testing.T) {
+	nodes := []*Node{
+		New(Opts{Store: kv.New()}),
+		New(Opts{Store: kv.New()}),
+	}
+	defer link(func(n *Node) transport.NodeClient {
+		return &DelayNode{Node: n}
+	}, nodes...)()
+	head, tail := nodes[0], nodes[1]
+
+	// a expires soon, b much later, and c is written again without a TTL
+	// before it expires.
+	_, err := head.ClientWriteBatch([]transport.Item{
+		{Key: "a", Value: []byte("a"), TTL: time.Minute},
+		{Key: "b", Value: []byte("b"), TTL: time.Hour},
+		{Key: "c", Value: []byte("c"), TTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("ClientWriteBatch() unexpected error\n  got: %#v", err)
+	}
+	if _, err := head.ClientWrite("c", []byte("c2")); err != nil {
+		t.Fatalf("ClientWrite(c) unexpected error\n  got: %#v", err)
+	}
+
+	// The expiry is replicated, so the tail can take over as head.
+	item, err := tail.store.Read("a")
+	if err != nil {
+		t.Fatalf("Read(a) unexpected error\n  got: %#v", err)
+	}
+	want, err := head.store.Read("a")
+	if err != nil {
+		t.Fatalf("Read(a) unexpected error\n  got: %#v", err)
+	}
+	if item.ExpiresAt.IsZero() || !item.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Fatalf("unexpected ExpiresAt on the tail\n  want: %v\n  got: %v", want.ExpiresAt, item.ExpiresAt)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, has := tail.expiries[key]; !has {
+			t.Fatalf("tail isn't tracking the expiry of %s", key)
+		}
+	}
+
+	head.expireKeys(time.Now().Add(2 * time.Minute))
+
+	// The tombstone is committed on the way back up the chain.
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := head.store.Read("a")
+		if err == store.ErrNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Read(a) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, n := range nodes {
+		if _, _, _, err := n.Read("a", nil); err != transport.ErrNotFound {
+			t.Fatalf("Read(a) unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+		}
+		assertItem(t, n, "b", []byte("b"))
+		assertItem(t, n, "c", []byte("c2"))
+		if _, has := n.expiries["a"]; has {
+			t.Fatal("the expiry of a is still tracked after it's deleted")
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+// maxExportChunk is the most keys a single Export chunk holds. It's also the
+// chunk size when the caller doesn't set a limit.
+const maxExportChunk = 1000
+
+// Export returns a chunk of up to limit items committed by this node, in key
+// order, starting after cursor. Like ReadAll, the latest version committed here
+// is returned even if a newer one is on it's way down the chain. Callers stream
+// the whole store by asking for the next chunk, with the returned cursor, once
+// they're done with the last one. Nothing is read from the store until the
+// caller asks for it, so a slow reader slows the export down instead of piling
+// up items in memory, and an export that failed can carry on from the last
+// cursor, on any node in the chain.
+func (n *Node) Export(cursor string, limit int) (*transport.ExportChunk, error) {
+	if err := n.readable(); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > maxExportChunk {
+		limit = maxExportChunk
+	}
+
+	chunk := &transport.ExportChunk{Items: []transport.VersionedItem{}}
+	scanned := 0
+
+	err := n.store.EachCommitted(cursor, func(item *store.Item) error {
+		if !item.Deleted {
+			chunk.Items = append(chunk.Items, versionedItem(item))
+		}
+
+		scanned++
+		if scanned == limit {
+			chunk.Next = item.Key
+			return store.ErrStop
+		}
+		return nil
+	})
+
+	if err != nil {
+		n.log.Printf("Failed to export after %s. %v\n", cursor, err)
+		return nil, err
+	}
+
+	return chunk, nil
+}
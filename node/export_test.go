@@ -0,0 +1,73 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExport(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+
+	// b has a dirty version 2, which isn't exported yet, and c is deleted.
+	for _, key := range []string{"a", "ab", "b", "c", "d"} {
+		if err := n.Write(key, []byte(key), 1); err != nil {
+			t.Fatalf("Write(%s) unexpected error\n  got: %#v", key, err)
+		}
+		if err := n.Commit(key, 1); err != nil {
+			t.Fatalf("Commit(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+	if err := n.Write("b", []byte("b2"), 2); err != nil {
+		t.Fatalf("Write(b) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Delete("c", 2); err != nil {
+		t.Fatalf("Delete(c) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Commit("c", 2); err != nil {
+		t.Fatalf("Commit(c) unexpected error\n  got: %#v", err)
+	}
+
+	item := func(key string) transport.VersionedItem {
+		return transport.VersionedItem{Key: key, Value: []byte(key), Version: 1}
+	}
+
+	tests := []struct {
+		cursor string
+		want   *transport.ExportChunk
+	}{
+		{
+			cursor: "",
+			want: &transport.ExportChunk{
+				Items: []transport.VersionedItem{item("a"), item("ab")},
+				Next:  "ab",
+			},
+		},
+		{
+			cursor: "ab",
+			want: &transport.ExportChunk{
+				Items: []transport.VersionedItem{item("b")},
+				Next:  "c",
+			},
+		},
+		{
+			cursor: "c",
+			want: &transport.ExportChunk{
+				Items: []transport.VersionedItem{item("d")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := n.Export(tt.cursor, 2)
+		if err != nil {
+			t.Fatalf("Export(%q, 2) unexpected error\n  got: %#v", tt.cursor, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("Export(%q, 2) unexpected chunk (-want +got):\n%s", tt.cursor, diff)
+		}
+	}
+}
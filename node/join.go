@@ -0,0 +1,48 @@
+package node
+
+import (
+	"github.com/despreston/go-craq/transport"
+)
+
+// join announces the node to the Coordinator. If the Coordinator stages the
+// node as a learner, the node copies every key from the node named in the
+// reply, outside the chain, and then announces itself again to join the chain
+// for real. Only the keys written in the meantime are left to catch up on
+// once it has joined.
+func (n *Node) join(args *transport.AddNodeArgs) (*transport.NodeMeta, error) {
+	reply, err := n.cdr.AddNode(args)
+	if err != nil {
+		return nil, err
+	}
+
+	n.setState(transport.NodeSyncing)
+	if !reply.Learner {
+		return reply, nil
+	}
+
+	n.log.Printf("Catching up from %s before joining the chain\n", reply.Prev)
+	if err := n.catchUpFrom(reply.Prev); err != nil {
+		return nil, err
+	}
+	n.log.Println("Caught up, joining the chain")
+
+	joinArgs := *args
+	joinArgs.Learner = false
+	return n.cdr.AddNode(&joinArgs)
+}
+
+// catchUpFrom copies the dirty and committed items the node doesn't have from
+// another node, without making it a neighbor.
+func (n *Node) catchUpFrom(address string) error {
+	from := n.transport()
+	if err := from.Connect(address); err != nil {
+		n.log.Printf("Failed to connect to %s to catch up. %v\n", address, err)
+		return err
+	}
+	defer from.Close()
+
+	if err := n.requestFwdPropagation(from); err != nil {
+		return err
+	}
+	return n.requestBackPropagation(from)
+}
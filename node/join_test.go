@@ -0,0 +1,84 @@
+package node
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/despreston/go-craq/coordinator"
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+)
+
+// NetNode connects to the node with the address it's given.
+type NetNode struct {
+	*Node
+	nodes map[string]*Node
+}
+
+func (f *NetNode) Connect(address string) error {
+	n, has := f.nodes[address]
+	if !has {
+		return errors.New("unknown node " + address)
+	}
+	f.Node = n
+	return nil
+}
+
+func (f *NetNode) Close() error { return nil }
+
+func TestJoinAtHead(t *testing.T) {
+	nodes := make(map[string]*Node)
+	tport := func() transport.NodeClient { return &NetNode{nodes: nodes} }
+	c := FakeCoordinator{Coordinator: coordinator.New(coordinator.Opts{Transport: tport})}
+
+	for _, addr := range []string{"a", "b"} {
+		nodes[addr] = New(Opts{
+			CdrAddress:        "coordinator",
+			PubAddress:        addr,
+			Store:             kv.New(),
+			CoordinatorClient: &c,
+			Transport:         tport,
+		})
+	}
+	nodes["b"].position = transport.HeadPosition
+
+	a, b := nodes["a"], nodes["b"]
+	if err := a.Start(); err != nil {
+		t.Fatalf("Start() unexpected error\n  got: %#v", err)
+	}
+	for _, key := range []string{"hello", "hello", "foo"} {
+		if _, err := a.ClientWrite(key, []byte(key)); err != nil {
+			t.Fatalf("ClientWrite(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+
+	if err := b.Start(); err != nil {
+		t.Fatalf("Start() unexpected error\n  got: %#v", err)
+	}
+	c.Updates.Wait()
+
+	if !b.IsHead || b.IsTail || a.IsHead || !a.IsTail {
+		t.Fatalf("unexpected ends\n  want: b head, a tail\n  got: a %v/%v, b %v/%v", a.IsHead, a.IsTail, b.IsHead, b.IsTail)
+	}
+	assertItem(t, b, "hello", []byte("hello"))
+	assertItem(t, b, "foo", []byte("foo"))
+
+	// hello is at version 1 on a, so the new head carries on from there.
+	version, err := b.ClientWrite("hello", []byte("world"))
+	if err != nil {
+		t.Fatalf("ClientWrite(hello) unexpected error\n  got: %#v", err)
+	}
+	if version != 2 {
+		t.Fatalf("unexpected version\n  want: %d\n  got: %d", 2, version)
+	}
+	assertItem(t, a, "hello", []byte("world"))
+
+	// Both nodes reported that they're serving.
+	rt, err := c.Routes()
+	if err != nil {
+		t.Fatalf("Routes() unexpected error\n  got: %#v", err)
+	}
+	if len(rt.States) != 0 {
+		t.Fatalf("unexpected states\n  want: none\n  got: %v", rt.States)
+	}
+}
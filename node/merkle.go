@@ -0,0 +1,270 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+const defaultAntiEntropyInterval = time.Minute
+
+var (
+	errUnknownTreeNode = errors.New("no such node in the merkle tree")
+	errTreeMismatch    = errors.New("merkle trees have different shapes")
+)
+
+// merkleTree keeps a hash of the latest committed version of every key the node
+// has, so two nodes can find the keys they disagree on without sending the
+// version of every key. Keys are spread across buckets by the hash of the key.
+// A bucket's hash is the XOR of the hashes of it's keys and their versions, and
+// every other tree node's hash is the XOR of it's children, so a commit only
+// changes the path from one bucket up to the root. Trees are compared from the
+// root down, following only the subtrees that differ. The keys in each bucket
+// are kept too, so the keys of the buckets that differ are found without
+// going through the store.
+type merkleTree struct {
+	mu sync.Mutex
+	// levels[0] is the root, levels[transport.MerkleDepth] are the buckets.
+	levels [][]uint64
+	// Version of every key in the tree, by bucket.
+	keys []map[string]uint64
+}
+
+func newMerkleTree() *merkleTree {
+	levels := make([][]uint64, transport.MerkleDepth+1)
+	width := 1
+	for i := range levels {
+		levels[i] = make([]uint64, width)
+		width *= transport.MerkleFanout
+	}
+	keys := make([]map[string]uint64, len(levels[transport.MerkleDepth]))
+	for i := range keys {
+		keys[i] = make(map[string]uint64)
+	}
+	return &merkleTree{levels: levels, keys: keys}
+}
+
+// flip adds the hash of version of key to the tree, or removes it if it's
+// already in the tree. The caller must hold t.mu.
+func (t *merkleTree) flip(bucket int, key string, version uint64) {
+	h := sha256.New()
+	h.Write([]byte(key))
+	binary.Write(h, binary.BigEndian, version)
+	hash := binary.BigEndian.Uint64(h.Sum(nil))
+
+	i := bucket
+	for level := transport.MerkleDepth; level >= 0; level-- {
+		t.levels[level][i] ^= hash
+		i /= transport.MerkleFanout
+	}
+}
+
+// set replaces the version of key in the tree. had is false if the key wasn't
+// in the tree.
+func (t *merkleTree) set(key string, old uint64, had bool, version uint64) {
+	if had && old == version {
+		return
+	}
+
+	bucket := t.bucketFor(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if had {
+		t.flip(bucket, key, old)
+	}
+	t.flip(bucket, key, version)
+	t.keys[bucket][key] = version
+}
+
+// remove takes version of key out of the tree.
+func (t *merkleTree) remove(key string, version uint64) {
+	bucket := t.bucketFor(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.flip(bucket, key, version)
+	delete(t.keys[bucket], key)
+}
+
+// versions returns the version of every key in buckets.
+func (t *merkleTree) versions(buckets []int) (map[string]uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	versions := make(map[string]uint64)
+	for _, bucket := range buckets {
+		if bucket < 0 || bucket >= len(t.keys) {
+			return nil, errUnknownTreeNode
+		}
+		for key, version := range t.keys[bucket] {
+			versions[key] = version
+		}
+	}
+	return versions, nil
+}
+
+func (t *merkleTree) bucketFor(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	buckets := uint64(len(t.levels[transport.MerkleDepth]))
+	return int(binary.BigEndian.Uint64(sum[:]) % buckets)
+}
+
+// hashes returns the hashes of the tree nodes at level, in the same order as
+// indexes.
+func (t *merkleTree) hashes(level int, indexes []int) ([]uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if level < 0 || level >= len(t.levels) {
+		return nil, errUnknownTreeNode
+	}
+
+	hashes := make([]uint64, len(indexes))
+	for i, index := range indexes {
+		if index < 0 || index >= len(t.levels[level]) {
+			return nil, errUnknownTreeNode
+		}
+		hashes[i] = t.levels[level][index]
+	}
+	return hashes, nil
+}
+
+// MerkleHashes returns the hashes of the nodes of the Merkle tree over the
+// node's committed versions at level, in the same order as indexes.
+func (n *Node) MerkleHashes(level int, indexes []int) ([]uint64, error) {
+	return n.tree.hashes(level, indexes)
+}
+
+// BackPropagateBuckets is BackPropagate for the keys in the given buckets of
+// the Merkle tree. The node responds with the latest committed item of every
+// key in the buckets that has a newer version than the one in the request, or
+// isn't in the request. Only those items are read from the store.
+func (n *Node) BackPropagateBuckets(
+	buckets []int,
+	verByKey *transport.PropagateRequest,
+) (*transport.PropagateResponse, error) {
+	versions, err := n.tree.versions(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	unseen := []*store.Item{}
+	for key, latest := range versions {
+		if version, has := (*verByKey)[key]; has && version >= latest {
+			continue
+		}
+		item, err := n.store.ReadVersion(key, latest)
+		if err == store.ErrNotFound {
+			// Replaced by a newer commit, or purged, since the tree was read.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		unseen = append(unseen, item)
+	}
+
+	return makePropagateResponse(unseen), nil
+}
+
+// reconcile compares the node's Merkle tree with the tree of another node, one
+// level at a time, and asks it for the committed versions the node is missing
+// from the buckets that differ. Only the hashes of the subtrees that differ,
+// and the versions of the keys in the buckets that differ, go over the wire.
+// It returns the number of buckets that differed.
+func (n *Node) reconcile(client transport.NodeClient) (int, error) {
+	indexes := []int{0}
+
+	for level := 0; ; level++ {
+		theirs, err := client.MerkleHashes(level, indexes)
+		if err != nil {
+			return 0, err
+		}
+		ours, err := n.tree.hashes(level, indexes)
+		if err != nil {
+			return 0, err
+		}
+		if len(theirs) != len(ours) {
+			return 0, errTreeMismatch
+		}
+
+		differ := []int{}
+		for i, index := range indexes {
+			if theirs[i] != ours[i] {
+				differ = append(differ, index)
+			}
+		}
+
+		if len(differ) == 0 {
+			return 0, nil
+		}
+		if level == transport.MerkleDepth {
+			indexes = differ
+			break
+		}
+
+		indexes = make([]int, 0, len(differ)*transport.MerkleFanout)
+		for _, index := range differ {
+			for child := 0; child < transport.MerkleFanout; child++ {
+				indexes = append(indexes, index*transport.MerkleFanout+child)
+			}
+		}
+	}
+
+	versions, err := n.tree.versions(indexes)
+	if err != nil {
+		return 0, err
+	}
+	req := transport.PropagateRequest(versions)
+
+	reply, err := client.BackPropagateBuckets(indexes, &req)
+	if err != nil {
+		n.log.Printf("Failed during reconciliation: %#v\n", err)
+		return 0, err
+	}
+
+	return len(indexes), n.commitPropagated(reply)
+}
+
+func (n *Node) antiEntropyLoop() {
+	for range time.Tick(n.reconcileEvery) {
+		if n.currentState() == transport.NodeServing {
+			n.antiEntropy()
+		}
+	}
+}
+
+// antiEntropy reconciles the node with it's successor, or with it's
+// predecessor if it's the tail. Propagation only runs when the node's neighbors
+// change, so this heals keys that diverged without anyone noticing, like a
+// commit that was lost on the way up the chain. Committed versions are safe to
+// take from either neighbor, since a version is only committed once the tail
+// has it, except for the ones of keys whose tombstone this node has already
+// purged; see collectTombstones.
+func (n *Node) antiEntropy() {
+	n.mu.Lock()
+	nbr := n.neighbors[transport.NeighborPosNext]
+	if nbr.address == "" {
+		nbr = n.neighbors[transport.NeighborPosPrev]
+	}
+	n.mu.Unlock()
+
+	if nbr.address == "" {
+		return
+	}
+
+	differed, err := n.reconcile(nbr.rpc)
+	if err != nil {
+		n.log.Printf("Failed to reconcile with %s. %v\n", nbr.address, err)
+		return
+	}
+	if differed > 0 {
+		n.log.Printf("Reconciled %d buckets with %s\n", differed, nbr.address)
+	}
+}
@@ -0,0 +1,164 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+)
+
+// ReconcilingNode records the keys sent to it to reconcile.
+type ReconcilingNode struct {
+	*Node
+	*FakeClient
+	sent int
+}
+
+func (r *ReconcilingNode) BackPropagateBuckets(
+	buckets []int,
+	verByKey *transport.PropagateRequest,
+) (*transport.PropagateResponse, error) {
+	r.sent += len(*verByKey)
+	return r.Node.BackPropagateBuckets(buckets, verByKey)
+}
+
+// The hashes only depend on the latest committed version of each key, not on
+// the order of the commits.
+func TestMerkleTree(t *testing.T) {
+	a, b := newMerkleTree(), newMerkleTree()
+
+	a.set("hello", 0, false, 1)
+	a.set("foo", 0, false, 1)
+	a.set("hello", 1, true, 2)
+
+	b.set("foo", 0, false, 1)
+	b.set("hello", 0, false, 2)
+
+	aRoot, _ := a.hashes(0, []int{0})
+	bRoot, _ := b.hashes(0, []int{0})
+	if aRoot[0] != bRoot[0] || aRoot[0] == 0 {
+		t.Fatalf("unexpected roots\n  want: equal and not 0\n  got: %d, %d", aRoot[0], bRoot[0])
+	}
+
+	a.remove("hello", 2)
+	a.remove("foo", 1)
+	if root, _ := a.hashes(0, []int{0}); root[0] != 0 {
+		t.Fatalf("unexpected root of an empty tree\n  want: 0\n  got: %d", root[0])
+	}
+
+	if _, err := a.hashes(transport.MerkleDepth+1, []int{0}); err != errUnknownTreeNode {
+		t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", errUnknownTreeNode, err)
+	}
+}
+
+func TestAntiEntropy(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	next := New(Opts{Store: kv.New()})
+
+	commit := func(n *Node, key string, value []byte, version uint64) {
+		t.Helper()
+		if err := n.store.Write(key, value, version); err != nil {
+			t.Fatalf("Write(%s) unexpected error\n  got: %#v", key, err)
+		}
+		if err := n.commit(key, version); err != nil {
+			t.Fatalf("commit(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		commit(n, key, []byte(key), 1)
+		commit(next, key, []byte(key), 1)
+	}
+
+	// The successor committed a newer version, and a key, that never made it
+	// back up the chain.
+	commit(next, "key-7", []byte("newer"), 2)
+	commit(next, "lost", []byte("lost"), 1)
+
+	client := &ReconcilingNode{Node: next}
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: client, address: "next"}
+	n.antiEntropy()
+
+	assertItem(t, n, "key-7", []byte("newer"))
+	assertItem(t, n, "lost", []byte("lost"))
+
+	// Only the keys in the buckets that differ were sent.
+	if client.sent == 0 || client.sent > 10 {
+		t.Fatalf("unexpected number of keys sent\n  want: a few\n  got: %d", client.sent)
+	}
+
+	ours, _ := n.MerkleHashes(0, []int{0})
+	theirs, _ := next.MerkleHashes(0, []int{0})
+	if ours[0] != theirs[0] {
+		t.Fatalf("trees still differ after anti-entropy\n  want: %d\n  got: %d", theirs[0], ours[0])
+	}
+
+	// Nothing is sent once the trees match.
+	client.sent = 0
+	n.antiEntropy()
+	if client.sent != 0 {
+		t.Fatalf("unexpected number of keys sent\n  want: 0\n  got: %d", client.sent)
+	}
+}
+
+// A tombstone purged by the node isn't brought back by a neighbor that hasn't
+// purged it yet, and neither is an older version from a neighbor that never
+// got the delete.
+func TestAntiEntropyPurgedTombstone(t *testing.T) {
+	n := New(Opts{Store: kv.New(), TombstoneGracePeriod: time.Minute})
+	next := New(Opts{Store: kv.New(), TombstoneGracePeriod: time.Minute})
+	prev := New(Opts{Store: kv.New(), TombstoneGracePeriod: time.Minute})
+
+	for _, node := range []*Node{n, next, prev} {
+		node.store.Write("hello", []byte("world"), 0)
+		if err := node.commit("hello", 0); err != nil {
+			t.Fatalf("commit(hello, 0) unexpected error\n  got: %#v", err)
+		}
+	}
+	for _, node := range []*Node{n, next} {
+		node.store.Delete("hello", 1)
+		if err := node.commit("hello", 1); err != nil {
+			t.Fatalf("commit(hello, 1) unexpected error\n  got: %#v", err)
+		}
+	}
+
+	now := time.Now()
+	for _, now := range []time.Time{now, now.Add(time.Minute)} {
+		if err := n.collectTombstones(now); err != nil {
+			t.Fatalf("collectTombstones() unexpected error\n  got: %#v", err)
+		}
+	}
+	if _, err := n.store.ReadVersion("hello", 1); err != store.ErrNotFound {
+		t.Fatalf("tombstone not purged\n  got: %#v", err)
+	}
+
+	for _, nbr := range []*Node{next, prev} {
+		n.neighbors[transport.NeighborPosNext] = neighbor{
+			rpc:     &ReconcilingNode{Node: nbr},
+			address: "neighbor",
+		}
+		n.antiEntropy()
+
+		for _, version := range []uint64{0, 1} {
+			if _, err := n.store.ReadVersion("hello", version); err != store.ErrNotFound {
+				t.Fatalf("version %d of hello came back\n  got: %#v", version, err)
+			}
+		}
+	}
+
+	// Once the successor purges it too, their trees match again.
+	for _, now := range []time.Time{now, now.Add(time.Minute)} {
+		if err := next.collectTombstones(now); err != nil {
+			t.Fatalf("collectTombstones() unexpected error\n  got: %#v", err)
+		}
+	}
+	ours, _ := n.MerkleHashes(0, []int{0})
+	theirs, _ := next.MerkleHashes(0, []int{0})
+	if ours[0] != theirs[0] {
+		t.Fatalf("trees differ after both purged\n  want: %d\n  got: %d", theirs[0], ours[0])
+	}
+}
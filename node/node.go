@@ -3,14 +3,28 @@
 package node
 
 import (
-	"errors"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/despreston/go-craq/store"
 	"github.com/despreston/go-craq/transport"
 )
 
+const (
+	defaultTombstoneGracePeriod = 10 * time.Minute
+	defaultWriteTimeout         = 10 * time.Second
+	// How many times a read starts over when the version it's after is gone
+	// from the store. It starts over right away, since the version was most
+	// likely committed and replaced by a newer one in the meantime.
+	maxReadAttempts = 5
+	// How many times a read asks the tail for the latest version of a dirty key
+	// when the tail doesn't answer, and how long it waits before the first
+	// retry. The wait doubles after every retry, so a read waits at most 15ms.
+	maxTailAttempts   = 3
+	tailRetryInterval = 5 * time.Millisecond
+)
+
 // neighbor is another node in the chain
 type neighbor struct {
 	rpc     transport.NodeClient
@@ -27,10 +41,42 @@ type Opts struct {
 	PubAddress string
 	// Address of coordinator
 	CdrAddress string
+	// Chain to join when the coordinator manages more than one chain. Use
+	// transport.AnyChain to let the coordinator choose.
+	Chain int
+	// Where in the chain to join, counting from transport.HeadPosition at 1.
+	// Default: transport.TailPosition
+	Position int
+	// Catch up outside the chain, as a learner, before joining it. Nodes that
+	// join at the head always do, since the head hands out versions.
+	Learner bool
 	// Transport creates new clients for communication with other nodes
 	Transport transport.NodeClientFactory
 	// For communication with the Coordinator
 	CoordinatorClient transport.CoordinatorClient
+	// How long the head waits for a client write to be committed by the chain
+	// before giving up with transport.ErrCommitTimeout. Default: 10s
+	WriteTimeout time.Duration
+	// Send writes down the chain with nested, synchronous RPCs instead of
+	// pipelining them to the successor.
+	SyncReplication bool
+	// Max number of replication messages in flight to the successor when
+	// replication is pipelined. Default: 64
+	ReplicationWindow int
+	// How long to keep committed tombstones before purging the deleted keys from
+	// the store. Nodes that rejoin the chain after being gone for longer than
+	// this may bring deleted keys back. Default: 10m
+	TombstoneGracePeriod time.Duration
+	// Number of commits kept for watchers to resume from. Watchers that fall
+	// further behind get transport.ErrWatchCompacted. Default: 10000
+	WatchLogSize int
+	// How often the head deletes keys that have expired. Keys can be read for
+	// up to this long after they expire. Default: 1s
+	ExpiryInterval time.Duration
+	// How often the node compares it's committed versions with a neighbor's
+	// and catches up on the ones it's missing. A negative interval turns it
+	// off. Default: 1m
+	AntiEntropyInterval time.Duration
 	// Log
 	Log *log.Logger
 }
@@ -40,6 +86,18 @@ type commitEvent struct {
 	Version uint64
 }
 
+// waiter is a client write waiting for it's version to be committed.
+type waiter struct {
+	version uint64
+	done    chan struct{}
+}
+
+// tombstone is a committed tombstone waiting to be purged.
+type tombstone struct {
+	version uint64
+	seen    time.Time
+}
+
 // Node is what the white paper refers to as a node. This is the client that is
 // responsible for storing data and handling reads/writes.
 type Node struct {
@@ -49,9 +107,55 @@ type Node struct {
 	store store.Storer
 	// Latest version of a given key
 	latest map[string]uint64
+	// Hashes of the latest committed versions, and how often they're compared
+	// with a neighbor's, for anti-entropy. The tree only changes along with
+	// latest, while holding latestMu.
+	tree           *merkleTree
+	reconcileEvery time.Duration
+	// When each dirty key was first written after it's latest commit. Used to
+	// bound the staleness of ReadBounded reads. Protected by latestMu.
+	dirtySince map[string]time.Time
+	// Tombstones purged by collectTombstones, and when, by key. Protected by
+	// latestMu.
+	purged   map[string]tombstone
+	latestMu sync.Mutex
+	// Assigns versions at the head and rejects stale versions downstream.
+	seq *sequencer
+	// Client writes waiting for a commit, by key.
+	waiters      map[string][]waiter
+	waitersMu    sync.Mutex
+	writeTimeout time.Duration
 	// For listening to commit's. For testing.
-	committed                    chan commitEvent
+	committed chan commitEvent
+	// Replication stream to the successor and commits to the predecessor. Both
+	// are nil when replication is synchronous.
+	pipeMu          sync.Mutex
+	replicator      *replicator
+	committer       *committer
+	prevAddr        string
+	in              *inbound
+	syncReplication bool
+	window          int
+	// Recent commits, for Watch.
+	watches      *watchLog
+	watchTimeout time.Duration
+	// Version of each key that expires, and when. Protected by expiriesMu.
+	expiries       map[string]expiry
+	expiriesMu     sync.Mutex
+	expiryInterval time.Duration
+	// Where the node is in joining, serving and leaving the chain, where it
+	// goes back to if it stops draining, and a channel that's closed once it's
+	// serving. Protected by stateMu.
+	state        transport.NodeState
+	undrainState transport.NodeState
+	caughtUp     chan struct{}
+	stateMu      sync.Mutex
+	// Committed tombstones by key. Only used by collectTombstones.
+	tombstones                   map[string]tombstone
+	gracePeriod                  time.Duration
 	cdrAddress, address, pubAddr string
+	chain, position              int
+	learner                      bool
 	cdr                          transport.CoordinatorClient
 	IsHead, IsTail               bool
 	mu                           sync.Mutex
@@ -65,16 +169,70 @@ func New(opts Opts) *Node {
 	if opts.Log == nil {
 		logger = log.Default()
 	}
+	gracePeriod := opts.TombstoneGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultTombstoneGracePeriod
+	}
+	writeTimeout := opts.WriteTimeout
+	if writeTimeout == 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+	window := opts.ReplicationWindow
+	if window == 0 {
+		window = defaultReplicationWindow
+	}
+	watchLogSize := opts.WatchLogSize
+	if watchLogSize == 0 {
+		watchLogSize = defaultWatchLogSize
+	}
+	expiryInterval := opts.ExpiryInterval
+	if expiryInterval == 0 {
+		expiryInterval = defaultExpiryInterval
+	}
+	reconcileEvery := opts.AntiEntropyInterval
+	if reconcileEvery == 0 {
+		reconcileEvery = defaultAntiEntropyInterval
+	}
+	// A node without a Coordinator isn't part of a chain, so it serves what's
+	// in it's store right away.
+	state := transport.NodeServing
+	caughtUp := make(chan struct{})
+	if opts.CdrAddress != "" {
+		state = transport.NodeJoining
+	} else {
+		close(caughtUp)
+	}
 	return &Node{
-		latest:     make(map[string]uint64),
-		neighbors:  make(map[transport.NeighborPos]neighbor, 3),
-		cdrAddress: opts.CdrAddress,
-		address:    opts.Address,
-		store:      opts.Store,
-		transport:  opts.Transport,
-		pubAddr:    opts.PubAddress,
-		cdr:        opts.CoordinatorClient,
-		log:        logger,
+		latest:          make(map[string]uint64),
+		tree:            newMerkleTree(),
+		dirtySince:      make(map[string]time.Time),
+		purged:          make(map[string]tombstone),
+		seq:             newSequencer(),
+		waiters:         make(map[string][]waiter),
+		writeTimeout:    writeTimeout,
+		in:              newInbound(),
+		syncReplication: opts.SyncReplication,
+		window:          window,
+		watches:         newWatchLog(watchLogSize),
+		watchTimeout:    defaultWatchTimeout,
+		neighbors:       make(map[transport.NeighborPos]neighbor, 3),
+		expiries:        make(map[string]expiry),
+		expiryInterval:  expiryInterval,
+		reconcileEvery:  reconcileEvery,
+		state:           state,
+		caughtUp:        caughtUp,
+		tombstones:      make(map[string]tombstone),
+		gracePeriod:     gracePeriod,
+		cdrAddress:      opts.CdrAddress,
+		address:         opts.Address,
+		store:           opts.Store,
+		transport:       opts.Transport,
+		pubAddr:         opts.PubAddress,
+		chain:           opts.Chain,
+		position:        opts.Position,
+		learner:         opts.Learner,
+		cdr:             opts.CoordinatorClient,
+		log:             logger,
 	}
 }
 
@@ -86,18 +244,107 @@ func (n *Node) Start() error {
 	if err := n.connectToCoordinator(); err != nil {
 		log.Fatalf("Failed to connect to the chain.\n Error: %#v", err)
 	}
+	go n.collectTombstonesLoop()
+	go n.expireLoop()
+	if n.reconcileEvery > 0 {
+		go n.antiEntropyLoop()
+	}
+	return nil
+}
+
+func (n *Node) collectTombstonesLoop() {
+	for range time.Tick(n.gracePeriod / 2) {
+		if err := n.collectTombstones(time.Now()); err != nil {
+			n.log.Printf("Failed to collect tombstones: %v\n", err)
+		}
+	}
+}
+
+// collectTombstones purges deleted keys from the store once their tombstones
+// have been committed for longer than the grace period. Tombstones are kept
+// around for a while so that nodes that briefly left the chain still learn
+// about the delete during back propagation.
+//
+// Every node purges a tombstone, and takes it out of it's Merkle tree, on it's
+// own schedule, so a neighbor may still have the tombstone, or even an older
+// version if it's behind. The purged version is remembered for another grace
+// period, by which time the neighbors have purged it too, and propagated
+// versions that aren't newer are ignored, so the key doesn't come back.
+func (n *Node) collectTombstones(now time.Time) error {
+	waiting := make(map[string]tombstone)
+
+	n.latestMu.Lock()
+	for key, ts := range n.purged {
+		if now.Sub(ts.seen) >= n.gracePeriod {
+			delete(n.purged, key)
+		}
+	}
+	n.latestMu.Unlock()
+
+	err := n.store.EachCommitted("", func(item *store.Item) error {
+		if !item.Deleted {
+			return nil
+		}
+
+		ts, has := n.tombstones[item.Key]
+		if !has || ts.version != item.Version {
+			ts = tombstone{version: item.Version, seen: now}
+		}
+
+		if now.Sub(ts.seen) < n.gracePeriod {
+			waiting[item.Key] = ts
+			return nil
+		}
+
+		if err := n.store.Purge(item.Key, item.Version); err != nil {
+			return err
+		}
+
+		// The key is gone from the store, so it's gone from the tree too, like
+		// it would be after a restart.
+		n.latestMu.Lock()
+		if latest, has := n.latest[item.Key]; has && latest == item.Version {
+			delete(n.latest, item.Key)
+			n.tree.remove(item.Key, item.Version)
+		}
+		n.purged[item.Key] = tombstone{version: item.Version, seen: now}
+		n.latestMu.Unlock()
+
+		n.log.Printf("Purged version %d of deleted key %s\n", item.Version, item.Key)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	n.tombstones = waiting
 	return nil
 }
 
 // backfillLatest queries the store for the latest committed version of
-// everything it has in order to fill n.latest.
+// everything it has in order to fill n.latest. The sequencer and the expiring
+// keys are filled from both the committed and the dirty items.
 func (n *Node) backfillLatest() error {
-	c, err := n.store.AllCommitted()
+	err := n.store.EachCommitted("", func(item *store.Item) error {
+		n.latestMu.Lock()
+		n.setLatest(item.Key, item.Version)
+		n.latestMu.Unlock()
+		n.seq.wrote(item)
+		n.trackExpiry(item)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	for _, item := range c {
-		n.latest[item.Key] = item.Version
+
+	dirty, err := n.store.AllDirty()
+	if err != nil {
+		return err
+	}
+	for _, item := range dirty {
+		n.seq.wrote(item)
+		n.trackExpiry(item)
 	}
 	return nil
 }
@@ -117,7 +364,12 @@ func (n *Node) connectToCoordinator() error {
 	n.log.Printf("Connected to coordinator at %s\n", n.cdrAddress)
 
 	// Announce self to the Coordinator
-	reply, err := n.cdr.AddNode(n.pubAddr)
+	reply, err := n.join(&transport.AddNodeArgs{
+		Address:  n.pubAddr,
+		Chain:    n.chain,
+		Position: n.position,
+		Learner:  n.learner || n.position == transport.HeadPosition,
+	})
 	if err != nil {
 		n.log.Println(err.Error())
 		return err
@@ -141,6 +393,32 @@ func (n *Node) connectToCoordinator() error {
 		n.neighbors[transport.NeighborPosPrev].rpc.Close()
 	}
 
+	// A node that joined before the tail has a successor to replicate to, and
+	// asks the tail about dirty keys.
+	if reply.Next != "" {
+		if err := n.connectToNode(reply.Tail, transport.NeighborPosTail); err != nil {
+			n.log.Printf("Failed to connect to the tail in ConnectToCoordinator. %v\n", err)
+			return err
+		}
+		if err := n.connectToNode(reply.Next, transport.NeighborPosNext); err != nil {
+			n.log.Printf("Failed to connect to the successor in ConnectToCoordinator. %v\n", err)
+			return err
+		}
+		next := n.neighbors[transport.NeighborPosNext].rpc
+		if reply.Prev == "" {
+			// The new head hands out versions, so it needs the newest version
+			// of every key, including the ones the old head wrote after the
+			// node caught up as a learner.
+			if err := n.requestFwdPropagation(next); err != nil {
+				return err
+			}
+		}
+		if err := n.requestBackPropagation(next); err != nil {
+			return err
+		}
+	}
+
+	n.setState(transport.NodeServing)
 	return nil
 }
 
@@ -173,6 +451,7 @@ func (n *Node) connectToNode(address string, pos transport.NeighborPos) error {
 		rpc:     newNbr,
 		address: address,
 	}
+	n.linkChanged(pos, address, newNbr)
 
 	return nil
 }
@@ -181,7 +460,7 @@ func (n *Node) writePropagated(reply *transport.PropagateResponse) error {
 	// Save items from reply to store.
 	for key, forKey := range *reply {
 		for _, item := range forKey {
-			if err := n.store.Write(key, item.Value, item.Version); err != nil {
+			if err := n.storeItem(propagatedItem(key, item)); err != nil {
 				n.log.Printf("Failed to write item %+v to store: %#v\n", item, err)
 				return err
 			}
@@ -191,15 +470,22 @@ func (n *Node) writePropagated(reply *transport.PropagateResponse) error {
 	return nil
 }
 
-// Commit the version to the store, update n.latest for this key, and announce
-// the commit to the n.committed channel if there is one.
+// Commit the version to the store, update n.latest for this key, add the
+// commit to the watch log, and announce the commit to the n.committed channel if
+// there is one.
 func (n *Node) commit(key string, version uint64) error {
+	items := n.watchItems(key, version)
+
 	if err := n.store.Commit(key, version); err != nil {
 		n.log.Printf("Failed to commit. Key: %s Version: %d Error: %#v", key, version, err)
 		return err
 	}
 
-	n.latest[key] = version
+	n.latestMu.Lock()
+	n.publish(items)
+	n.setLatest(key, version)
+	n.latestMu.Unlock()
+	n.notifyCommitted(key, version)
 
 	if n.committed != nil {
 		n.committed <- commitEvent{Key: key, Version: version}
@@ -208,17 +494,21 @@ func (n *Node) commit(key string, version uint64) error {
 	return nil
 }
 
+// commitPropagated commits the items from reply to the store, except for the
+// ones of keys whose tombstone has been purged since; see collectTombstones.
 func (n *Node) commitPropagated(reply *transport.PropagateResponse) error {
-	// Commit items from reply to store.
 	for key, forKey := range *reply {
 		for _, item := range forKey {
+			if n.wasPurged(key, item.Version) {
+				continue
+			}
 			// It's possible the item doesn't exist. In that case, add it first.
 			// This sort of a poor man's upsert, but it saves from having to
 			// deal w/ it in the storage layer, which should make it easier to
 			// write new storers.
 			if err := n.commit(key, item.Version); err != nil {
 				if err == store.ErrNotFound {
-					if err := n.store.Write(key, item.Value, item.Version); err != nil {
+					if err := n.storeItem(propagatedItem(key, item)); err != nil {
 						return err
 					}
 					if err := n.commit(key, item.Version); err != nil {
@@ -233,6 +523,25 @@ func (n *Node) commitPropagated(reply *transport.PropagateResponse) error {
 	return nil
 }
 
+// wasPurged reports whether version of key isn't newer than a tombstone of the
+// key that was recently purged.
+func (n *Node) wasPurged(key string, version uint64) bool {
+	n.latestMu.Lock()
+	defer n.latestMu.Unlock()
+	ts, has := n.purged[key]
+	return has && version <= ts.version
+}
+
+func propagatedItem(key string, vv transport.ValueVersion) *store.Item {
+	return &store.Item{
+		Key:       key,
+		Value:     vv.Value,
+		Version:   vv.Version,
+		Deleted:   vv.Deleted,
+		ExpiresAt: vv.ExpiresAt,
+	}
+}
+
 // Create a map of the highest versions for each key.
 func propagateRequestFromItems(items []*store.Item) *transport.PropagateRequest {
 	req := transport.PropagateRequest{}
@@ -264,27 +573,23 @@ func (n *Node) requestFwdPropagation(client transport.NodeClient) error {
 }
 
 // requestBackPropagation asks client to respond with all committed items that
-// this node either does not have or are newer than what this node has.
+// this node either does not have or are newer than what this node has. The
+// Merkle trees of the nodes are compared first, so only the versions of keys in
+// buckets that differ are sent.
 func (n *Node) requestBackPropagation(client transport.NodeClient) error {
-	committed, err := n.store.AllCommitted()
-	if err != nil {
-		n.log.Printf("Failed to get all committed items: %#v\n", err)
-		return err
-	}
-
-	reply, err := client.BackPropagate(propagateRequestFromItems(committed))
+	differed, err := n.reconcile(client)
 	if err != nil {
-		n.log.Printf("Failed during back propagation: %#v\n", err)
 		return err
 	}
-
-	return n.commitPropagated(reply)
+	n.log.Printf("Back propagated %d buckets\n", differed)
+	return nil
 }
 
 // resetNeighbor closes any open connection and resets the neighbor.
 func (n *Node) resetNeighbor(pos transport.NeighborPos) {
 	n.neighbors[pos].rpc.Close()
 	n.neighbors[pos] = neighbor{}
+	n.linkChanged(pos, "", nil)
 }
 
 // Ping responds to ping messages.
@@ -388,21 +693,109 @@ func (n *Node) Update(meta *transport.NodeMeta) error {
 }
 
 // ClientWrite adds a new object to the chain and starts the process of
-// replication.
-func (n *Node) ClientWrite(key string, val []byte) error {
-	// Increment version based off any existing objects for this key.
-	var version uint64
-	old, err := n.store.Read(key)
-	if err == nil {
-		version = old.Version + 1
+// replication. It returns the new version once it's been committed by the
+// tail and the commit has made it back to this node.
+func (n *Node) ClientWrite(key string, val []byte) (uint64, error) {
+	return n.clientWrite(&store.Item{Key: key, Value: val}, nil)
+}
+
+// ClientDelete deletes a key from the chain. A tombstone is added as the next
+// version of the key and replicated like any other write. Reads return
+// not-found once the tombstone is committed.
+func (n *Node) ClientDelete(key string) (uint64, error) {
+	return n.clientWrite(&store.Item{Key: key, Deleted: true}, nil)
+}
+
+// CompareAndSwap writes a new version of key only if the newest version at the
+// head is expected. Otherwise a *transport.ConflictError is returned. Both
+// committed and uncommitted versions count, so two writers racing from the same
+// version can't both succeed.
+func (n *Node) CompareAndSwap(key string, expected uint64, val []byte) (uint64, error) {
+	item := &store.Item{Key: key, Value: val}
+	return n.clientWrite(item, func(latest written, has bool) bool {
+		return has && !latest.deleted && latest.version == expected
+	})
+}
+
+// PutIfAbsent writes key only if it doesn't exist or is deleted. Otherwise a
+// *transport.ConflictError is returned.
+func (n *Node) PutIfAbsent(key string, val []byte) (uint64, error) {
+	item := &store.Item{Key: key, Value: val}
+	return n.clientWrite(item, func(latest written, has bool) bool {
+		return !has || latest.deleted
+	})
+}
+
+// clientWrite sends the item down the chain and waits for the commit to come
+// back. Returns transport.ErrCommitTimeout if that takes longer than the write
+// timeout.
+func (n *Node) clientWrite(
+	item *store.Item,
+	cond func(latest written, has bool) bool,
+) (uint64, error) {
+	done, err := n.startClientWrite(item, cond)
+	if err != nil {
+		return 0, err
 	}
 
-	if err := n.store.Write(key, val, version); err != nil {
+	select {
+	case <-done:
+		return item.Version, nil
+	case <-time.After(n.writeTimeout):
+		n.stopWaiting(item.Key, done)
+		n.log.Printf(
+			"Timed out waiting for commit of version %d of key %s\n",
+			item.Version,
+			item.Key,
+		)
+		return 0, transport.ErrCommitTimeout
+	}
+}
+
+// startClientWrite assigns the next version to item, writes it to the store,
+// and sends it down the chain. If cond is given, the write is only done if cond
+// returns true for the newest version of the key. Client writes to the same key
+// are serialized so that the version check and the version assignment are
+// atomic, and so that versions are sent down the chain in order. The returned
+// channel is closed once the version is committed.
+func (n *Node) startClientWrite(
+	item *store.Item,
+	cond func(latest written, has bool) bool,
+) (chan struct{}, error) {
+	if err := n.writable(); err != nil {
+		return nil, err
+	}
+
+	unlock := n.seq.lock(item.Key)
+	defer unlock()
+
+	latest, has := n.seq.newestVersion(item.Key)
+
+	if cond != nil && !cond(latest, has) {
+		return nil, &transport.ConflictError{
+			Key:    item.Key,
+			Latest: latest.version,
+			Exists: has && !latest.deleted,
+		}
+	}
+
+	item.Version = n.seq.next(item.Key)
+
+	// Start waiting before the write goes anywhere so the commit can't be
+	// missed.
+	done := n.waitForCommit(item.Key, item.Version)
+
+	if err := n.storeItem(item); err != nil {
 		n.log.Printf("Failed to create during ClientWrite. %v\n", err)
-		return err
+		n.stopWaiting(item.Key, done)
+		return nil, err
 	}
 
-	n.log.Printf("Node RPC ClientWrite() created version %d of key %s\n", version, key)
+	n.log.Printf(
+		"Node RPC ClientWrite() created version %d of key %s\n",
+		item.Version,
+		item.Key,
+	)
 
 	// Forward the new object to the successor node.
 
@@ -412,18 +805,138 @@ func (n *Node) ClientWrite(key string, val []byte) error {
 	// mark the item as committed and return early.
 	if next.address == "" {
 		n.log.Println("No successor")
-		if err := n.commit(key, version); err != nil {
-			return err
+		if err := n.commit(item.Key, item.Version); err != nil {
+			n.stopWaiting(item.Key, done)
+			return nil, err
 		}
-		return nil
+		return done, nil
 	}
 
-	if err := next.rpc.Write(key, val, version); err != nil {
+	if err := n.forwardItem(item); err != nil {
 		n.log.Printf("Failed to send to successor during ClientWrite. %v\n", err)
+		n.stopWaiting(item.Key, done)
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// waitForCommit returns a channel that's closed once version, or a newer
+// version, of key is committed.
+func (n *Node) waitForCommit(key string, version uint64) chan struct{} {
+	n.waitersMu.Lock()
+	defer n.waitersMu.Unlock()
+	done := make(chan struct{})
+	n.waiters[key] = append(n.waiters[key], waiter{version: version, done: done})
+	return done
+}
+
+// stopWaiting removes a waiter that's no longer interested in the commit.
+func (n *Node) stopWaiting(key string, done chan struct{}) {
+	n.waitersMu.Lock()
+	defer n.waitersMu.Unlock()
+
+	waiting := n.waiters[key][:0]
+	for _, w := range n.waiters[key] {
+		if w.done != done {
+			waiting = append(waiting, w)
+		}
+	}
+
+	if len(waiting) == 0 {
+		delete(n.waiters, key)
+	} else {
+		n.waiters[key] = waiting
+	}
+}
+
+// notifyCommitted wakes up the client writes waiting for version, or an older
+// version, of key. Committing a version replaces every older version, so older
+// writes are done too.
+func (n *Node) notifyCommitted(key string, version uint64) {
+	n.waitersMu.Lock()
+	defer n.waitersMu.Unlock()
+
+	waiting := n.waiters[key][:0]
+	for _, w := range n.waiters[key] {
+		if w.version <= version {
+			close(w.done)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+
+	if len(waiting) == 0 {
+		delete(n.waiters, key)
+	} else {
+		n.waiters[key] = waiting
+	}
+}
+
+// storeItem writes the item, or the tombstone if the item is deleted, to the
+// store. Only WriteAll stores an expiry, so it's used for items that expire.
+func (n *Node) storeItem(item *store.Item) error {
+	var err error
+	if !item.ExpiresAt.IsZero() {
+		err = n.store.WriteAll([]*store.Item{item})
+	} else if item.Deleted {
+		err = n.store.Delete(item.Key, item.Version)
+	} else {
+		err = n.store.Write(item.Key, item.Value, item.Version)
+	}
+	if err == nil {
+		n.wrote(item)
+	}
+	return err
+}
+
+// wrote records item as written to the store.
+func (n *Node) wrote(item *store.Item) {
+	n.seq.wrote(item)
+	n.trackExpiry(item)
+
+	n.latestMu.Lock()
+	defer n.latestMu.Unlock()
+	if _, dirty := n.dirtySince[item.Key]; dirty {
+		return
+	}
+	if latest, has := n.latest[item.Key]; !has || item.Version > latest {
+		n.dirtySince[item.Key] = time.Now()
+	}
+}
+
+// setLatest records version as the latest committed version of key. The caller
+// must hold latestMu.
+func (n *Node) setLatest(key string, version uint64) {
+	old, had := n.latest[key]
+	n.tree.set(key, old, had, version)
+	n.latest[key] = version
+	if newest, _ := n.seq.newestVersion(key); version >= newest.version {
+		delete(n.dirtySince, key)
+	}
+}
+
+// storeNewer stores the item if it's newer than every version of the key the
+// node has seen.
+func (n *Node) storeNewer(item *store.Item) error {
+	unlock := n.seq.lock(item.Key)
+	defer unlock()
+	if err := n.seq.check(item); err != nil {
 		return err
 	}
+	return n.storeItem(item)
+}
 
-	return nil
+// sendItem forwards a write or a delete to another node. Write and Delete don't
+// carry an expiry, so items that expire are sent as a batch of one.
+func sendItem(to transport.NodeClient, item *store.Item) error {
+	if !item.ExpiresAt.IsZero() {
+		return to.WriteBatch([]transport.VersionedItem{versionedItem(item)})
+	}
+	if item.Deleted {
+		return to.Delete(item.Key, item.Version)
+	}
+	return to.Write(item.Key, item.Value, item.Version)
 }
 
 // Write adds an object to the chain. If the node is not the tail, the Write is
@@ -431,9 +944,21 @@ func (n *Node) ClientWrite(key string, val []byte) error {
 // marked committed and a Commit message is sent to the predecessor in the
 // chain.
 func (n *Node) Write(key string, val []byte, version uint64) error {
-	n.log.Printf("Node RPC Write() %s version %d to store\n", key, version)
+	return n.write(&store.Item{Key: key, Value: val, Version: version})
+}
 
-	if err := n.store.Write(key, val, version); err != nil {
+// Delete adds a tombstone to the chain. It's replicated the same way as Write.
+func (n *Node) Delete(key string, version uint64) error {
+	return n.write(&store.Item{Key: key, Version: version, Deleted: true})
+}
+
+// write stores the item and passes it along the chain. Versions that aren't
+// newer than what the node already has are rejected with
+// transport.ErrStaleVersion so the store never gets the same version twice.
+func (n *Node) write(item *store.Item) error {
+	n.log.Printf("Node RPC Write() %s version %d to store\n", item.Key, item.Version)
+
+	if err := n.storeNewer(item); err != nil {
 		n.log.Printf("Failed to write. %v\n", err)
 		return err
 	}
@@ -441,8 +966,7 @@ func (n *Node) Write(key string, val []byte, version uint64) error {
 	// If this isn't the tail node, the write needs to be forwarded along the
 	// chain to the next node.
 	if !n.IsTail {
-		next := n.neighbors[transport.NeighborPosNext]
-		if err := next.rpc.Write(key, val, version); err != nil {
+		if err := n.forwardItem(item); err != nil {
 			n.log.Printf("Failed to send to successor during Write. %v\n", err)
 			return err
 		}
@@ -451,13 +975,13 @@ func (n *Node) Write(key string, val []byte, version uint64) error {
 
 	// At this point it's assumed this node is the tail.
 
-	if err := n.commit(key, version); err != nil {
+	if err := n.commit(item.Key, item.Version); err != nil {
 		n.log.Printf("Failed to mark as committed in Write. %v\n", err)
 		return err
 	}
 
 	// Start telling predecessors to mark this version committed.
-	n.sendCommitToPrev(key, version)
+	n.sendCommit(item.Key, item.Version)
 	return nil
 }
 
@@ -470,7 +994,7 @@ func (n *Node) commitAndSend(key string, version uint64) error {
 
 	// if this node has a predecessor, send commit to previous node
 	if n.neighbors[transport.NeighborPosPrev].address != "" {
-		return n.sendCommitToPrev(key, version)
+		return n.sendCommit(key, version)
 	}
 
 	return nil
@@ -490,16 +1014,90 @@ func (n *Node) Commit(key string, version uint64) error {
 	return n.commitAndSend(key, version)
 }
 
-// Read returns values from the store. If the store returns ErrDirtyItem, ask
-// the tail for the latest committed version for this key. That ensures that
-// every node in the chain returns the same version.
-func (n *Node) Read(key string) (string, []byte, error) {
-	item, err := n.store.Read(key)
+// Read returns values, and their version, from the store. If the store returns
+// ErrDirtyItem, the version that's read depends on opts:
+//
+// ReadStrong asks the tail for the latest committed version for this key. That
+// ensures that every node in the chain returns the same version.
+//
+// ReadEventual reads the newest version in the store, committed or not.
+//
+// ReadBounded reads the latest version committed by this node if it's within
+// the bounds in opts, and otherwise asks the tail like ReadStrong.
+//
+// If the version is committed here, and replaced by a newer one, before it can
+// be read, the read starts over, up to maxReadAttempts times, and then fails
+// with transport.ErrNotFound. So does a read of a key the tail reports a
+// version of that this node never had. If the tail doesn't answer, it's asked
+// again, up to maxTailAttempts times.
+func (n *Node) Read(
+	key string,
+	opts *transport.ReadOpts,
+) (string, []byte, uint64, error) {
+	if err := n.readable(); err != nil {
+		return "", nil, 0, err
+	}
+	item, err := n.readItem(key, opts)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return key, item.Value, item.Version, nil
+}
+
+// readItem is Read, returning the item from the store.
+func (n *Node) readItem(key string, opts *transport.ReadOpts) (*store.Item, error) {
+	for attempt := 1; ; attempt++ {
+		item, err := n.store.Read(key)
+
+		switch err {
+		case store.ErrNotFound:
+			return nil, transport.ErrNotFound
+		case store.ErrDirtyItem:
+			v, err := n.dirtyVersion(key, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			item, err = n.store.ReadVersion(key, v)
+			if err == store.ErrNotFound {
+				if attempt == maxReadAttempts {
+					return nil, transport.ErrNotFound
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if item.Deleted {
+				return nil, transport.ErrNotFound
+			}
+
+			return item, nil
+		}
+
+		return item, err
+	}
+}
+
+// ReadAtVersion returns the value of a committed version of key. Older versions
+// can only be read while the store's retention keeps them. A version that's
+// dirty on this node is only returned if the tail has committed it, or a newer
+// version. If the version is a tombstone, transport.ErrNotFound is returned.
+func (n *Node) ReadAtVersion(key string, version uint64) (string, []byte, error) {
+	if err := n.readable(); err != nil {
+		return "", nil, err
+	}
+
+	item, err := n.store.ReadVersion(key, version)
+	if err == store.ErrNotFound {
+		return "", nil, transport.ErrVersionNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
 
-	switch err {
-	case store.ErrNotFound:
-		return "", nil, errors.New("key doesn't exist")
-	case store.ErrDirtyItem:
+	if !item.Committed {
 		_, v, err := n.neighbors[transport.NeighborPosTail].rpc.LatestVersion(key)
 		if err != nil {
 			n.log.Printf(
@@ -509,37 +1107,169 @@ func (n *Node) Read(key string) (string, []byte, error) {
 			)
 			return "", nil, err
 		}
-
-		item, err = n.store.ReadVersion(key, v)
-		if err != nil {
-			return "", nil, err
+		if v < version {
+			return "", nil, transport.ErrVersionNotFound
 		}
 	}
 
+	if item.Deleted {
+		return "", nil, transport.ErrNotFound
+	}
+
 	return key, item.Value, nil
 }
 
-// ReadAll returns all committed key/value pairs in the store.
-func (n *Node) ReadAll() (*[]transport.Item, error) {
-	fullItems, err := n.store.AllCommitted()
+// History returns the committed versions of key this node keeps, oldest first,
+// including tombstones. If the newest versions of key are dirty on this node,
+// the tail is asked for the latest committed version, and the dirty versions up
+// to it are included too, so every node returns the same latest version.
+func (n *Node) History(key string) ([]transport.HistoryItem, error) {
+	if err := n.readable(); err != nil {
+		return nil, err
+	}
+
+	items, err := n.store.History(key)
+	if err == store.ErrNotFound {
+		return nil, transport.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	items := []transport.Item{}
-	for _, itm := range fullItems {
-		items = append(items, transport.Item{
-			Key:   itm.Key,
-			Value: itm.Value,
+	history := []transport.HistoryItem{}
+	var committed uint64
+	askedTail := false
+
+	for _, item := range items {
+		if !item.Committed {
+			if !askedTail {
+				_, v, err := n.neighbors[transport.NeighborPosTail].rpc.LatestVersion(key)
+				if err != nil {
+					n.log.Printf(
+						"Failed to get latest version of %s from the tail. %v\n",
+						key,
+						err,
+					)
+					return nil, err
+				}
+				committed, askedTail = v, true
+			}
+			if item.Version > committed {
+				break
+			}
+		}
+
+		history = append(history, transport.HistoryItem{
+			Value:       item.Value,
+			Version:     item.Version,
+			Deleted:     item.Deleted,
+			CommittedAt: item.CommittedAt,
 		})
 	}
 
+	if len(history) == 0 {
+		return nil, transport.ErrNotFound
+	}
+
+	return history, nil
+}
+
+// dirtyVersion picks the version of a dirty key to read.
+func (n *Node) dirtyVersion(key string, opts *transport.ReadOpts) (uint64, error) {
+	if opts != nil {
+		switch opts.Mode {
+		case transport.ReadEventual:
+			newest, _ := n.seq.newestVersion(key)
+			return newest.version, nil
+		case transport.ReadBounded:
+			if v, ok := n.boundedVersion(key, opts); ok {
+				return v, nil
+			}
+		}
+	}
+
+	tail := n.neighbors[transport.NeighborPosTail].rpc
+	wait := tailRetryInterval
+	for attempt := 1; ; attempt++ {
+		_, v, err := tail.LatestVersion(key)
+		if err == nil {
+			return v, nil
+		}
+		n.log.Printf(
+			"Failed to get latest version of %s from the tail. %v\n",
+			key,
+			err,
+		)
+		if attempt == maxTailAttempts {
+			return 0, err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// boundedVersion returns the latest version of key committed by this node if
+// it's no more than opts.MaxVersionGap versions behind the newest version this
+// node has, or if it's been out of date for no longer than opts.MaxStaleness.
+// The tail only commits versions this node already has, so the committed
+// version here was the latest one at the tail at least until the key became
+// dirty here, and it's no further behind the tail than it is behind the newest
+// version here.
+func (n *Node) boundedVersion(key string, opts *transport.ReadOpts) (uint64, bool) {
+	newest, _ := n.seq.newestVersion(key)
+
+	n.latestMu.Lock()
+	defer n.latestMu.Unlock()
+
+	committed, has := n.latest[key]
+	if !has {
+		return 0, false
+	}
+
+	if committed >= newest.version || newest.version-committed <= opts.MaxVersionGap {
+		return committed, true
+	}
+
+	// Keys that were dirty when the node started have no time.
+	since, has := n.dirtySince[key]
+	if has && time.Since(since) <= opts.MaxStaleness {
+		return committed, true
+	}
+
+	return 0, false
+}
+
+// ReadAll returns all committed key/value pairs in the store. Deleted keys are
+// left out. The whole reply is built in memory, so Export should be used for
+// large stores.
+func (n *Node) ReadAll() (*[]transport.Item, error) {
+	if err := n.readable(); err != nil {
+		return nil, err
+	}
+
+	items := []transport.Item{}
+
+	err := n.store.EachCommitted("", func(itm *store.Item) error {
+		if !itm.Deleted {
+			items = append(items, transport.Item{Key: itm.Key, Value: itm.Value})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &items, nil
 }
 
 // LatestVersion provides the latest committed version for a given key in the
-// store.
+// store. It's only asked by the rest of the chain, and the versions the tail
+// has committed are the latest even while it's syncing or draining, so unlike
+// client reads it's answered in every state.
 func (n *Node) LatestVersion(key string) (string, uint64, error) {
+	n.latestMu.Lock()
+	defer n.latestMu.Unlock()
 	return key, n.latest[key], nil
 }
 
@@ -578,8 +1308,10 @@ func makePropagateResponse(items []*store.Item) *transport.PropagateResponse {
 
 	for _, item := range items {
 		response[item.Key] = append(response[item.Key], transport.ValueVersion{
-			Value:   item.Value,
-			Version: item.Version,
+			Value:     item.Value,
+			Version:   item.Version,
+			Deleted:   item.Deleted,
+			ExpiresAt: item.ExpiresAt,
 		})
 	}
 
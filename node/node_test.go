@@ -2,10 +2,12 @@ package node
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/despreston/go-craq/coordinator"
+	"github.com/despreston/go-craq/store"
 	"github.com/despreston/go-craq/store/kv"
 	"github.com/despreston/go-craq/transport"
 	"github.com/google/go-cmp/cmp"
@@ -34,7 +36,7 @@ type FakeCoordinator struct {
 
 func assertItem(t *testing.T, n *Node, kWant string, vWant []byte) {
 	t.Helper()
-	k, v, err := n.Read(kWant)
+	k, v, _, err := n.Read(kWant, nil)
 	if err != nil {
 		t.Errorf("Read(%s) unexpected error\n  got: %#v", kWant, err)
 	}
@@ -81,7 +83,7 @@ func setupTwoNodeChain() (*Node, *Node, *FakeCoordinator) {
 		return &FakeNode{Node: n2}
 	}
 
-	c = FakeCoordinator{Coordinator: coordinator.New(tport)}
+	c = FakeCoordinator{Coordinator: coordinator.New(coordinator.Opts{Transport: tport})}
 	return n, n2, &c
 }
 
@@ -98,8 +100,10 @@ func TestStart(t *testing.T) {
 	})
 
 	c = FakeCoordinator{
-		Coordinator: coordinator.New(func() transport.NodeClient {
-			return &FakeNode{Node: n}
+		Coordinator: coordinator.New(coordinator.Opts{
+			Transport: func() transport.NodeClient {
+				return &FakeNode{Node: n}
+			},
 		}),
 	}
 
@@ -151,7 +155,7 @@ func TestWriteRead(t *testing.T) {
 	n2.Start()
 	c.Updates.Wait()
 	n.committed = make(chan commitEvent, 1)
-	c.Write("hello", []byte("world"))
+	c.Write("hello", []byte("world"), 0)
 
 	select {
 	case got := <-n.committed:
@@ -172,7 +176,8 @@ func TestWriteRead(t *testing.T) {
 
 func TestReadUnknownKey(t *testing.T) {
 	n, _, _ := setupTwoNodeChain()
-	_, _, err := n.Read("whatever")
+	n.Start()
+	_, _, _, err := n.Read("whatever", nil)
 	want := "key doesn't exist"
 	if err == nil || err.Error() != want {
 		t.Errorf("Read(whatever) unexpected error\n  want: %s\n  got:%s", want, err)
@@ -201,7 +206,7 @@ func TestFullPropagation(t *testing.T) {
 	n.committed = make(chan commitEvent, 1)
 	n2.committed = make(chan commitEvent, 1)
 	n.Start()
-	c.Write("hello", []byte("world"))
+	c.Write("hello", []byte("world"), 0)
 	n2.Start()
 
 	select {
@@ -246,8 +251,10 @@ func TestBackfill(t *testing.T) {
 	})
 
 	c = FakeCoordinator{
-		Coordinator: coordinator.New(func() transport.NodeClient {
-			return &FakeNode{Node: n}
+		Coordinator: coordinator.New(coordinator.Opts{
+			Transport: func() transport.NodeClient {
+				return &FakeNode{Node: n}
+			},
 		}),
 	}
 
@@ -264,3 +271,213 @@ func TestBackfill(t *testing.T) {
 		t.Errorf("LatestVersion(hello) = %s, %d, nil. Want hello, 1, nil", k, ver)
 	}
 }
+
+// The tombstone is replicated down the chain and the key can't be read from
+// any node once the tombstone is committed.
+func TestDelete(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+	n.committed = make(chan commitEvent, 1)
+
+	c.Write("hello", []byte("world"), 0)
+	<-n.committed
+	c.Delete("hello")
+
+	select {
+	case got := <-n.committed:
+		want := commitEvent{Key: "hello", Version: 1}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("unexpected commit\n  want: %#v\n  got: %#v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for commit")
+	}
+
+	for _, node := range []*Node{n, n2} {
+		if _, _, _, err := node.Read("hello", nil); !transport.IsNotFound(err) {
+			t.Errorf("Read(hello) unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+		}
+		if items, _ := node.ReadAll(); len(*items) != 0 {
+			t.Errorf("ReadAll() unexpected items\n  got: %#v", *items)
+		}
+	}
+
+	// Writing again continues from the tombstone's version.
+	c.Write("hello", []byte("again"), 0)
+	if got := <-n.committed; got.Version != 2 {
+		t.Fatalf("unexpected version after delete\n  want: %d\n  got: %d", 2, got.Version)
+	}
+	assertItem(t, n2, "hello", []byte("again"))
+}
+
+// A new node learns about deletes through back propagation.
+func TestDeletePropagation(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	c.Write("hello", []byte("world"), 0)
+	c.Delete("hello")
+
+	n2.store.Write("hello", []byte("world"), 0)
+	n2.store.Commit("hello", 0)
+	n2.committed = make(chan commitEvent, 1)
+	n2.Start()
+
+	select {
+	case <-n2.committed:
+		if _, _, _, err := n2.Read("hello", nil); !transport.IsNotFound(err) {
+			t.Errorf("Read(hello) unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for propagation")
+	}
+}
+
+func TestCollectTombstones(t *testing.T) {
+	n := New(Opts{Store: kv.New(), TombstoneGracePeriod: time.Minute})
+	n.store.Write("hello", []byte("world"), 0)
+	n.store.Commit("hello", 0)
+	n.store.Delete("hello", 1)
+	n.store.Commit("hello", 1)
+
+	now := time.Now()
+
+	// First time the tombstone is seen. It's too new to be purged.
+	if err := n.collectTombstones(now); err != nil {
+		t.Fatalf("collectTombstones() unexpected error\n  got: %#v", err)
+	}
+	if _, err := n.store.ReadVersion("hello", 1); err != nil {
+		t.Fatalf("tombstone purged before the grace period\n  got: %#v", err)
+	}
+
+	if err := n.collectTombstones(now.Add(time.Minute)); err != nil {
+		t.Fatalf("collectTombstones() unexpected error\n  got: %#v", err)
+	}
+	if _, err := n.store.ReadVersion("hello", 1); err != store.ErrNotFound {
+		t.Fatalf("tombstone not purged after the grace period\n  got: %#v", err)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	_, err := c.CompareAndSwap("hello", 0, []byte("world"))
+	want := &transport.ConflictError{Key: "hello"}
+	if diff := cmp.Diff(want, err); diff != "" {
+		t.Fatalf("CompareAndSwap(hello, 0) unknown key (-want +got):\n%s", diff)
+	}
+
+	if _, err := c.PutIfAbsent("hello", []byte("world")); err != nil {
+		t.Fatalf("PutIfAbsent(hello) unexpected error\n  got: %#v", err)
+	}
+
+	_, err = c.PutIfAbsent("hello", []byte("again"))
+	want = &transport.ConflictError{Key: "hello", Latest: 0, Exists: true}
+	if diff := cmp.Diff(want, err); diff != "" {
+		t.Fatalf("PutIfAbsent(hello) existing key (-want +got):\n%s", diff)
+	}
+
+	if v, err := c.CompareAndSwap("hello", 0, []byte("foo")); err != nil || v != 1 {
+		t.Fatalf("CompareAndSwap(hello, 0) = %d, %#v. Want 1, nil", v, err)
+	}
+
+	_, err = c.CompareAndSwap("hello", 0, []byte("bar"))
+	want = &transport.ConflictError{Key: "hello", Latest: 1, Exists: true}
+	if diff := cmp.Diff(want, err); diff != "" {
+		t.Fatalf("CompareAndSwap(hello, 0) stale version (-want +got):\n%s", diff)
+	}
+
+	assertItem(t, n2, "hello", []byte("foo"))
+
+	// Deleted keys are absent.
+	c.Delete("hello")
+	if _, err := c.PutIfAbsent("hello", []byte("back")); err != nil {
+		t.Fatalf("PutIfAbsent(hello) after delete unexpected error\n  got: %#v", err)
+	}
+	assertItem(t, n2, "hello", []byte("back"))
+}
+
+// Only one of many writers racing from the same version wins.
+func TestCompareAndSwapRace(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+	c.Write("counter", []byte("0"), 0)
+
+	const writers = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.CompareAndSwap("counter", 0, []byte("1")); err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Fatalf("unexpected number of successful writers\n  want: %d\n  got: %d", 1, wins)
+	}
+}
+
+// Writes return once the version is committed on every node, so reads right
+// after the write see the new value.
+func TestWriteWaitsForCommit(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	for i, value := range []string{"a", "b", "c"} {
+		version, err := c.Write("hello", []byte(value), 0)
+		if err != nil {
+			t.Fatalf("Write(hello) unexpected error\n  got: %#v", err)
+		}
+		if version != uint64(i) {
+			t.Fatalf("Write(hello) unexpected version\n  want: %d\n  got: %d", i, version)
+		}
+		assertItem(t, n, "hello", []byte(value))
+		assertItem(t, n2, "hello", []byte(value))
+	}
+}
+
+// StuckNode accepts writes but never commits them.
+type StuckNode struct {
+	transport.NodeClient
+}
+
+func (s *StuckNode) Write(string, []byte, uint64) error { return nil }
+
+func (s *StuckNode) Delete(string, uint64) error { return nil }
+
+func (s *StuckNode) WriteBatch([]transport.VersionedItem) error { return nil }
+
+func TestWriteCommitTimeout(t *testing.T) {
+	n := New(Opts{Store: kv.New(), WriteTimeout: 10 * time.Millisecond})
+	n.neighbors[transport.NeighborPosNext] = neighbor{
+		rpc:     &StuckNode{},
+		address: "stuck",
+	}
+
+	if _, err := n.ClientWrite("hello", []byte("world")); err != transport.ErrCommitTimeout {
+		t.Fatalf("ClientWrite(hello) unexpected error\n  want: %#v\n  got: %#v", transport.ErrCommitTimeout, err)
+	}
+
+	n.waitersMu.Lock()
+	defer n.waitersMu.Unlock()
+	if len(n.waiters) != 0 {
+		t.Fatalf("unexpected waiters after timeout\n  got: %#v", n.waiters)
+	}
+}
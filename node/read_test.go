@@ -0,0 +1,308 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+)
+
+// TailNode answers LatestVersion with a fixed version and counts the calls. The
+// first fails calls return an error, like a tail that doesn't answer.
+type TailNode struct {
+	transport.NodeClient
+	version uint64
+	calls   int
+	fails   int
+}
+
+func (t *TailNode) LatestVersion(key string) (string, uint64, error) {
+	t.calls++
+	if t.calls <= t.fails {
+		return "", 0, errors.New("connection refused")
+	}
+	return key, t.version, nil
+}
+
+func TestReadModes(t *testing.T) {
+	tail := &TailNode{version: 1}
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+
+	// Version 0 is committed here, the tail has committed version 1, and
+	// version 2 is on it's way down the chain.
+	for version, value := range []string{"a", "b", "c"} {
+		if err := n.Write("hello", []byte(value), uint64(version)); err != nil {
+			t.Fatalf("Write(hello, %s) unexpected error\n  got: %#v", value, err)
+		}
+		if version == 0 {
+			if err := n.Commit("hello", 0); err != nil {
+				t.Fatalf("Commit(hello, 0) unexpected error\n  got: %#v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		opts      *transport.ReadOpts
+		want      string
+		version   uint64
+		askedTail bool
+	}{
+		{
+			name:      "default",
+			want:      "b",
+			version:   1,
+			askedTail: true,
+		},
+		{
+			name:      "strong",
+			opts:      &transport.ReadOpts{Mode: transport.ReadStrong},
+			want:      "b",
+			version:   1,
+			askedTail: true,
+		},
+		{
+			name:    "eventual",
+			opts:    &transport.ReadOpts{Mode: transport.ReadEventual},
+			want:    "c",
+			version: 2,
+		},
+		{
+			name: "bounded within version gap",
+			opts: &transport.ReadOpts{Mode: transport.ReadBounded, MaxVersionGap: 2},
+			want: "a",
+		},
+		{
+			name: "bounded within staleness",
+			opts: &transport.ReadOpts{Mode: transport.ReadBounded, MaxStaleness: time.Hour},
+			want: "a",
+		},
+		{
+			name: "bounded out of bounds",
+			opts: &transport.ReadOpts{
+				Mode:          transport.ReadBounded,
+				MaxVersionGap: 1,
+				MaxStaleness:  time.Nanosecond,
+			},
+			want:      "b",
+			version:   1,
+			askedTail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tail.calls = 0
+
+			_, v, version, err := n.Read("hello", tt.opts)
+			if err != nil {
+				t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+			}
+			if !bytes.Equal([]byte(tt.want), v) {
+				t.Fatalf("Read(hello) unexpected value\n  want: %s\n  got: %s", tt.want, v)
+			}
+			if version != tt.version {
+				t.Fatalf("Read(hello) unexpected version\n  want: %d\n  got: %d", tt.version, version)
+			}
+			if askedTail := tail.calls > 0; askedTail != tt.askedTail {
+				t.Fatalf("unexpected LatestVersion calls to the tail\n  want: %t\n  got: %t", tt.askedTail, askedTail)
+			}
+		})
+	}
+}
+
+// Once the key is clean again, the staleness clock starts over with the next
+// write.
+func TestReadBoundedStaleness(t *testing.T) {
+	tail := &TailNode{version: 0}
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+	opts := &transport.ReadOpts{Mode: transport.ReadBounded, MaxStaleness: 20 * time.Millisecond}
+
+	for version := uint64(0); version < 2; version++ {
+		if err := n.Write("hello", []byte("a"), version*2); err != nil {
+			t.Fatalf("Write(hello) unexpected error\n  got: %#v", err)
+		}
+		if err := n.Commit("hello", version*2); err != nil {
+			t.Fatalf("Commit(hello) unexpected error\n  got: %#v", err)
+		}
+		if err := n.Write("hello", []byte("b"), version*2+1); err != nil {
+			t.Fatalf("Write(hello) unexpected error\n  got: %#v", err)
+		}
+		tail.version = version * 2
+
+		if _, _, _, err := n.Read("hello", opts); err != nil {
+			t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+		}
+		if tail.calls != 0 {
+			t.Fatalf("unexpected LatestVersion calls to the tail\n  want: %d\n  got: %d", 0, tail.calls)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+
+		if _, _, _, err := n.Read("hello", opts); err != nil {
+			t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+		}
+		if tail.calls != 1 {
+			t.Fatalf("unexpected LatestVersion calls to the tail\n  want: %d\n  got: %d", 1, tail.calls)
+		}
+		tail.calls = 0
+	}
+}
+
+// A read gives up once it's asked the tail maxReadAttempts times for a version
+// this node doesn't have.
+func TestReadMissingVersion(t *testing.T) {
+	tail := &TailNode{version: 5}
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+
+	if err := n.Write("hello", []byte("world"), 0); err != nil {
+		t.Fatalf("Write(hello, world) unexpected error\n  got: %#v", err)
+	}
+
+	if _, _, _, err := n.Read("hello", nil); err != transport.ErrNotFound {
+		t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+	}
+	if tail.calls != maxReadAttempts {
+		t.Fatalf("unexpected calls to the tail\n  want: %d\n  got: %d", maxReadAttempts, tail.calls)
+	}
+}
+
+// A read asks the tail again when it doesn't answer, up to maxTailAttempts
+// times.
+func TestReadTailRetry(t *testing.T) {
+	tail := &TailNode{version: 0, fails: maxTailAttempts - 1}
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+
+	if err := n.Write("hello", []byte("world"), 0); err != nil {
+		t.Fatalf("Write(hello, world) unexpected error\n  got: %#v", err)
+	}
+
+	_, value, _, err := n.Read("hello", nil)
+	if err != nil {
+		t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+	}
+	if !bytes.Equal(value, []byte("world")) {
+		t.Fatalf("unexpected value\n  want: %q\n  got: %q", "world", value)
+	}
+
+	tail.calls = 0
+	tail.fails = maxTailAttempts
+	if _, _, _, err := n.Read("hello", nil); err == nil {
+		t.Fatal("Read(hello) succeeded without an answer from the tail")
+	}
+	if tail.calls != maxTailAttempts {
+		t.Fatalf("unexpected calls to the tail\n  want: %d\n  got: %d", maxTailAttempts, tail.calls)
+	}
+}
+
+func TestReadAtVersion(t *testing.T) {
+	tail := &TailNode{version: 2}
+	s := kv.New()
+	s.SetRetention(store.Retention{Versions: 2})
+	n := New(Opts{Store: s})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+
+	// Versions 0 to 2 are committed, but only 1 and 2 are kept. The tail has
+	// committed version 3, but this node hasn't heard about it yet. Version 4
+	// is a tombstone that isn't committed.
+	for version, value := range []string{"a", "b", "c", "d"} {
+		if err := n.Write("hello", []byte(value), uint64(version)); err != nil {
+			t.Fatalf("Write(hello, %s) unexpected error\n  got: %#v", value, err)
+		}
+	}
+	if err := n.Commit("hello", 2); err != nil {
+		t.Fatalf("Commit(hello, 2) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Delete("hello", 4); err != nil {
+		t.Fatalf("Delete(hello, 4) unexpected error\n  got: %#v", err)
+	}
+	tail.version = 3
+
+	tests := []struct {
+		version uint64
+		want    string
+		err     error
+	}{
+		{version: 0, err: transport.ErrVersionNotFound},
+		{version: 1, want: "b"},
+		{version: 2, want: "c"},
+		{version: 3, want: "d"},
+		{version: 4, err: transport.ErrVersionNotFound},
+		{version: 5, err: transport.ErrVersionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.version), func(t *testing.T) {
+			_, v, err := n.ReadAtVersion("hello", tt.version)
+			if err != tt.err {
+				t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", tt.err, err)
+			}
+			if tt.err == nil && !bytes.Equal([]byte(tt.want), v) {
+				t.Fatalf("unexpected value\n  want: %s\n  got: %s", tt.want, v)
+			}
+		})
+	}
+
+	// Once the tombstone is committed, reading it's version returns not found.
+	tail.version = 4
+	if _, _, err := n.ReadAtVersion("hello", 4); err != transport.ErrNotFound {
+		t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tail := &TailNode{version: 2}
+	s := kv.New()
+	s.SetRetention(store.Retention{Versions: 2})
+	n := New(Opts{Store: s})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+
+	// Versions 0 and 1 are committed here. The tail has committed version 2,
+	// but version 3 is still on it's way down the chain.
+	for version, value := range []string{"a", "b", "c", "d"} {
+		if err := n.Write("hello", []byte(value), uint64(version)); err != nil {
+			t.Fatalf("Write(hello, %s) unexpected error\n  got: %#v", value, err)
+		}
+	}
+	if err := n.Commit("hello", 1); err != nil {
+		t.Fatalf("Commit(hello, 1) unexpected error\n  got: %#v", err)
+	}
+
+	got, err := n.History("hello")
+	if err != nil {
+		t.Fatalf("History(hello) unexpected error\n  got: %#v", err)
+	}
+
+	want := []string{"a", "b", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("History(hello) unexpected number of versions\n  want: %d\n  got: %d", len(want), len(got))
+	}
+	for i, item := range got {
+		if item.Version != uint64(i) || !bytes.Equal([]byte(want[i]), item.Value) {
+			t.Fatalf("History(hello) unexpected item %d\n  want: %d %s\n  got: %d %s", i, i, want[i], item.Version, item.Value)
+		}
+		// Only the versions committed by this node have a commit time.
+		if committed := !item.CommittedAt.IsZero(); committed != (i < 2) {
+			t.Fatalf("History(hello) unexpected CommittedAt for version %d\n  got: %v", i, item.CommittedAt)
+		}
+	}
+
+	if _, err := n.History("unknown"); err != transport.ErrNotFound {
+		t.Fatalf("History(unknown) unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+	}
+}
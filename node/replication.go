@@ -0,0 +1,437 @@
+package node
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+const (
+	defaultReplicationWindow = 64
+	// Size of the queue of messages waiting for a slot in the window.
+	replicationQueueSize = 1024
+	// How long to wait before sending a message again after it failed.
+	replicationRetryInterval = 100 * time.Millisecond
+)
+
+// replicator pipelines writes to the successor as an ordered stream of
+// transport.Replication messages. Every message gets the next sequence number
+// in the stream. Up to window messages are in flight at once; a slot in the
+// window is freed once the successor acknowledges the message by returning
+// from Replicate. Messages that fail are sent again until they're acknowledged
+// or the replicator is closed, so the successor never sees a gap in the
+// stream.
+type replicator struct {
+	to     transport.NodeClient
+	from   string
+	stream uint64
+	mu     sync.Mutex // makes the order of the queue match the sequence numbers
+	seq    uint64
+	// Messages sent that haven't been acknowledged. Updated atomically, since
+	// send holds mu while it waits for room in the queue.
+	pending int64
+	queue   chan *transport.Replication
+	window  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	log     *log.Logger
+}
+
+func newReplicator(
+	to transport.NodeClient,
+	from string,
+	window int,
+	logger *log.Logger,
+) *replicator {
+	r := &replicator{
+		to:     to,
+		from:   from,
+		stream: uint64(time.Now().UnixNano()),
+		queue:  make(chan *transport.Replication, replicationQueueSize),
+		window: make(chan struct{}, window),
+		done:   make(chan struct{}),
+		log:    logger,
+	}
+	go r.run()
+	return r
+}
+
+// send adds items to the end of the stream. It only blocks if the queue is
+// full.
+func (r *replicator) send(items []transport.VersionedItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	atomic.AddInt64(&r.pending, 1)
+	msg := &transport.Replication{
+		From:   r.from,
+		Stream: r.stream,
+		Seq:    r.seq,
+		Items:  items,
+	}
+
+	select {
+	case r.queue <- msg:
+	case <-r.done:
+	}
+}
+
+func (r *replicator) run() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case msg := <-r.queue:
+			select {
+			case r.window <- struct{}{}:
+			case <-r.done:
+				return
+			}
+			go r.deliver(msg)
+		}
+	}
+}
+
+// deliver sends msg until it's acknowledged, then frees it's slot in the
+// window.
+func (r *replicator) deliver(msg *transport.Replication) {
+	defer func() { <-r.window }()
+
+	for {
+		err := r.to.Replicate(msg)
+		if err == nil {
+			atomic.AddInt64(&r.pending, -1)
+			return
+		}
+
+		if transport.IsStaleStream(err) {
+			r.log.Printf("Successor stopped accepting stream %d\n", r.stream)
+			r.close()
+			return
+		}
+
+		r.log.Printf("Failed to replicate message %d, retrying. %v\n", msg.Seq, err)
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(replicationRetryInterval):
+		}
+	}
+}
+
+// backlog returns the number of messages the successor hasn't acknowledged.
+func (r *replicator) backlog() int {
+	return int(atomic.LoadInt64(&r.pending))
+}
+
+// close stops sending. Messages that haven't been acknowledged are dropped;
+// the next successor catches up through propagation instead.
+func (r *replicator) close() {
+	r.once.Do(func() { close(r.done) })
+}
+
+// committer sends commits to the predecessor from a single goroutine, so they
+// arrive in the order they were committed. Commits that pile up while a
+// CommitBatch is in flight are merged into the next one, keeping the newest
+// version of each key, so the commit path keeps up with the pipeline. A
+// CommitBatch that fails is merged back in and sent again until it succeeds or
+// the committer is closed.
+type committer struct {
+	to      transport.NodeClient
+	mu      sync.Mutex
+	pending map[string]uint64
+	// Number of commits in the CommitBatch in flight. Protected by mu.
+	sending int
+	wake    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	log     *log.Logger
+}
+
+func newCommitter(to transport.NodeClient, logger *log.Logger) *committer {
+	c := &committer{
+		to:      to,
+		pending: make(map[string]uint64),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		log:     logger,
+	}
+	go c.run()
+	return c
+}
+
+// add queues versions to be committed by the predecessor.
+func (c *committer) add(versions map[string]uint64) {
+	c.mu.Lock()
+	c.merge(versions)
+	c.mu.Unlock()
+	c.signal()
+}
+
+// merge adds versions to pending, keeping the newest version of each key. The
+// caller must hold c.mu.
+func (c *committer) merge(versions map[string]uint64) {
+	for key, version := range versions {
+		if v, has := c.pending[key]; !has || version > v {
+			c.pending[key] = version
+		}
+	}
+}
+
+// signal wakes up run if it isn't already awake.
+func (c *committer) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *committer) run() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+
+		c.mu.Lock()
+		versions := c.pending
+		c.pending = make(map[string]uint64)
+		c.sending = len(versions)
+		c.mu.Unlock()
+
+		if len(versions) == 0 {
+			continue
+		}
+
+		err := c.to.CommitBatch(versions)
+
+		c.mu.Lock()
+		if err != nil {
+			c.merge(versions)
+		}
+		c.sending = 0
+		c.mu.Unlock()
+
+		if err == nil {
+			continue
+		}
+
+		c.log.Printf("Failed to send CommitBatch to predecessor, retrying. %v\n", err)
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(replicationRetryInterval):
+		}
+		c.signal()
+	}
+}
+
+// backlog returns the number of commits that are waiting to be sent, or are
+// being sent, to the predecessor.
+func (c *committer) backlog() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) + c.sending
+}
+
+func (c *committer) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// inbound puts the replication messages from the predecessor back in order.
+// Replicate calls wait for their turn, so the messages are applied one at a
+// time in sequence order.
+type inbound struct {
+	mu     sync.Mutex
+	turn   *sync.Cond
+	from   string
+	stream uint64
+	next   uint64
+}
+
+func newInbound() *inbound {
+	in := &inbound{}
+	in.turn = sync.NewCond(&in.mu)
+	return in
+}
+
+// wait blocks until it's msg's turn to be applied. It returns false if msg has
+// already been applied. A message from the predecessor with a newer stream
+// starts the sequence over. The caller must hold in.mu.
+func (in *inbound) wait(msg *transport.Replication, prev func() string) (bool, error) {
+	for {
+		if msg.From != prev() {
+			return false, transport.ErrStaleStream
+		}
+
+		if msg.From != in.from || msg.Stream > in.stream {
+			in.from, in.stream, in.next = msg.From, msg.Stream, 1
+			in.turn.Broadcast()
+		}
+
+		switch {
+		case msg.Stream < in.stream:
+			return false, transport.ErrStaleStream
+		case msg.Seq < in.next:
+			return false, nil
+		case msg.Seq == in.next:
+			return true, nil
+		}
+
+		in.turn.Wait()
+	}
+}
+
+// done moves on to the next message in the stream. The caller must hold
+// in.mu.
+func (in *inbound) done() {
+	in.next++
+	in.turn.Broadcast()
+}
+
+// Replicate applies a message from the predecessor's replication stream.
+// Messages are applied in sequence order and each is only applied once, so the
+// predecessor can have many messages in flight and can send them again after
+// a failure. The items are stored, then queued for the successor, or committed
+// if this node is the tail. Items the node already has a newer version of are
+// skipped. Returning nil means the message is acknowledged; it doesn't wait for
+// the successor. If the items can't be stored, the error is returned and the
+// message isn't acknowledged, so the predecessor sends it again.
+func (n *Node) Replicate(msg *transport.Replication) error {
+	n.in.mu.Lock()
+	defer n.in.mu.Unlock()
+
+	apply, err := n.in.wait(msg, n.prevAddress)
+	if err != nil || !apply {
+		return err
+	}
+
+	items, err := n.storeNewerItems(msg.Items)
+	if err != nil {
+		n.log.Printf("Failed to apply replication message %d. %v\n", msg.Seq, err)
+		return err
+	}
+	defer n.in.done()
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	if !n.IsTail {
+		if err := n.forward(items); err != nil {
+			n.log.Printf("Failed to forward replication message %d. %v\n", msg.Seq, err)
+		}
+		return nil
+	}
+
+	versions := newestInBatch(items)
+	if err := n.commitBatch(versions); err != nil {
+		n.log.Printf("Failed to commit replication message %d. %v\n", msg.Seq, err)
+		return nil
+	}
+
+	n.sendCommits(versions)
+	return nil
+}
+
+func (n *Node) prevAddress() string {
+	n.pipeMu.Lock()
+	defer n.pipeMu.Unlock()
+	return n.prevAddr
+}
+
+// pipeline returns the replication stream to the successor, or nil if
+// replication is synchronous.
+func (n *Node) pipeline() *replicator {
+	n.pipeMu.Lock()
+	defer n.pipeMu.Unlock()
+	return n.replicator
+}
+
+// forward sends items to the successor. When replication is pipelined the
+// items are queued on the stream to the successor and forward returns right
+// away. Otherwise they're sent with a synchronous WriteBatch, which returns
+// once every node down the chain has them.
+func (n *Node) forward(items []transport.VersionedItem) error {
+	if r := n.pipeline(); r != nil {
+		r.send(items)
+		return nil
+	}
+	return n.neighbors[transport.NeighborPosNext].rpc.WriteBatch(items)
+}
+
+// forwardItem is forward for a single write or delete. Without pipelining it's
+// sent with the Write or Delete RPC.
+func (n *Node) forwardItem(item *store.Item) error {
+	if r := n.pipeline(); r != nil {
+		r.send([]transport.VersionedItem{versionedItem(item)})
+		return nil
+	}
+	return sendItem(n.neighbors[transport.NeighborPosNext].rpc, item)
+}
+
+// sendCommit tells the predecessor to commit a version, through the committer
+// when replication is pipelined.
+func (n *Node) sendCommit(key string, version uint64) error {
+	n.pipeMu.Lock()
+	c := n.committer
+	n.pipeMu.Unlock()
+
+	if c != nil {
+		c.add(map[string]uint64{key: version})
+		return nil
+	}
+
+	return n.sendCommitToPrev(key, version)
+}
+
+// sendCommits tells the predecessor to commit versions, through the committer
+// when replication is pipelined.
+func (n *Node) sendCommits(versions map[string]uint64) error {
+	n.pipeMu.Lock()
+	c := n.committer
+	n.pipeMu.Unlock()
+
+	if c != nil {
+		c.add(versions)
+		return nil
+	}
+
+	return n.sendCommitBatchToPrev(versions)
+}
+
+// linkChanged starts and stops the replication stream and the committer when
+// the node connects to a new neighbor or drops one. rpc is nil if the neighbor
+// was dropped.
+func (n *Node) linkChanged(pos transport.NeighborPos, address string, rpc transport.NodeClient) {
+	n.pipeMu.Lock()
+	defer n.pipeMu.Unlock()
+
+	switch pos {
+	case transport.NeighborPosNext:
+		if n.replicator != nil {
+			n.replicator.close()
+			n.replicator = nil
+		}
+		if rpc != nil && !n.syncReplication {
+			n.replicator = newReplicator(rpc, n.pubAddr, n.window, n.log)
+		}
+	case transport.NeighborPosPrev:
+		n.prevAddr = address
+		if n.committer != nil {
+			n.committer.close()
+			n.committer = nil
+		}
+		if rpc != nil && !n.syncReplication {
+			n.committer = newCommitter(rpc, n.log)
+		}
+	}
+}
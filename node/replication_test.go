@@ -0,0 +1,358 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+// DelayNode adds latency to the chain messages sent to the node. If jitter is
+// set, the delay is random up to latency, so replication messages arrive out of
+// order.
+type DelayNode struct {
+	*Node
+	*FakeClient
+	latency time.Duration
+	jitter  bool
+}
+
+func (d *DelayNode) delay() {
+	if d.jitter {
+		time.Sleep(time.Duration(rand.Int63n(int64(d.latency))))
+		return
+	}
+	time.Sleep(d.latency)
+}
+
+func (d *DelayNode) Write(key string, val []byte, version uint64) error {
+	d.delay()
+	return d.Node.Write(key, val, version)
+}
+
+func (d *DelayNode) Replicate(msg *transport.Replication) error {
+	d.delay()
+	return d.Node.Replicate(msg)
+}
+
+func (d *DelayNode) Commit(key string, version uint64) error {
+	d.delay()
+	return d.Node.Commit(key, version)
+}
+
+func (d *DelayNode) CommitBatch(versions map[string]uint64) error {
+	d.delay()
+	return d.Node.CommitBatch(versions)
+}
+
+// link connects nodes into a chain, in order, the same way connectToNode does.
+// wrap is how each node is reached by it's neighbors. The returned func
+// disconnects the nodes.
+func link(wrap func(*Node) transport.NodeClient, nodes ...*Node) func() {
+	for i, n := range nodes {
+		n.pubAddr = fmt.Sprintf("node-%d", i)
+	}
+
+	connect := func(n *Node, pos transport.NeighborPos, to *Node) {
+		rpc := wrap(to)
+		n.neighbors[pos] = neighbor{rpc: rpc, address: to.pubAddr}
+		n.linkChanged(pos, to.pubAddr, rpc)
+	}
+
+	for i, n := range nodes {
+		if i > 0 {
+			connect(n, transport.NeighborPosPrev, nodes[i-1])
+		}
+		if i < len(nodes)-1 {
+			connect(n, transport.NeighborPosNext, nodes[i+1])
+		}
+	}
+	nodes[len(nodes)-1].IsTail = true
+
+	return func() {
+		for _, n := range nodes {
+			n.linkChanged(transport.NeighborPosPrev, "", nil)
+			n.linkChanged(transport.NeighborPosNext, "", nil)
+		}
+	}
+}
+
+// Concurrent writes to the same key are pipelined and arrive out of order, but
+// every node applies them in version order and ends up with the newest value.
+func TestPipelinedReplication(t *testing.T) {
+	nodes := []*Node{
+		New(Opts{Store: kv.New()}),
+		New(Opts{Store: kv.New()}),
+		New(Opts{Store: kv.New()}),
+	}
+	defer link(func(n *Node) transport.NodeClient {
+		return &DelayNode{Node: n, latency: 5 * time.Millisecond, jitter: true}
+	}, nodes...)()
+
+	const writers = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	values := make(map[uint64]string)
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("value-%d", i)
+			version, err := nodes[0].ClientWrite("hello", []byte(value))
+			if err != nil {
+				t.Errorf("ClientWrite(hello) unexpected error\n  got: %#v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, has := values[version]; has {
+				t.Errorf("ClientWrite(hello) duplicate version %d", version)
+			}
+			values[version] = value
+		}(i)
+	}
+	wg.Wait()
+
+	if len(values) != writers {
+		t.Fatalf("unexpected number of versions\n  want: %d\n  got: %d", writers, len(values))
+	}
+
+	// The write with the newest version returns once it's committed
+	// everywhere, but commits for older versions can still be on the way, so
+	// wait for every node to be clean.
+	deadline := time.Now().Add(time.Second)
+	for _, n := range nodes {
+		for {
+			dirty, err := n.store.AllDirty()
+			if err != nil {
+				t.Fatalf("AllDirty() unexpected error\n  got: %#v", err)
+			}
+			if len(dirty) == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected dirty items\n  got: %#v", dirty)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assertItem(t, n, "hello", []byte(values[writers-1]))
+	}
+}
+
+func TestReplicateStream(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{
+		rpc:     &StuckNode{},
+		address: "stuck",
+	}
+	n.linkChanged(transport.NeighborPosPrev, "prev", nil)
+
+	msg := func(from string, stream, seq, version uint64) *transport.Replication {
+		return &transport.Replication{
+			From:   from,
+			Stream: stream,
+			Seq:    seq,
+			Items: []transport.VersionedItem{
+				{Key: "hello", Value: []byte("world"), Version: version},
+			},
+		}
+	}
+
+	// The second message arrives first and waits for the first.
+	errs := make(chan error)
+	go func() { errs <- n.Replicate(msg("prev", 1, 2, 1)) }()
+	if err := n.Replicate(msg("prev", 1, 1, 0)); err != nil {
+		t.Fatalf("Replicate(seq 1) unexpected error\n  got: %#v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Replicate(seq 2) unexpected error\n  got: %#v", err)
+	}
+	if w, _ := n.seq.newestVersion("hello"); w.version != 1 {
+		t.Fatalf("unexpected newest version\n  want: %d\n  got: %d", 1, w.version)
+	}
+
+	tests := []struct {
+		name string
+		msg  *transport.Replication
+		want error
+	}{
+		{name: "duplicate", msg: msg("prev", 1, 1, 0), want: nil},
+		{name: "not predecessor", msg: msg("other", 1, 3, 2), want: transport.ErrStaleStream},
+		{name: "new stream", msg: msg("prev", 2, 1, 2), want: nil},
+		{name: "old stream", msg: msg("prev", 1, 3, 3), want: transport.ErrStaleStream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := n.Replicate(tt.msg); err != tt.want {
+				t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", tt.want, err)
+			}
+		})
+	}
+
+	if w, _ := n.seq.newestVersion("hello"); w.version != 2 {
+		t.Fatalf("unexpected newest version\n  want: %d\n  got: %d", 2, w.version)
+	}
+}
+
+// FailingStore fails the next fails calls to WriteAll.
+type FailingStore struct {
+	store.Storer
+	fails int
+}
+
+func (f *FailingStore) WriteAll(items []*store.Item) error {
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("disk full")
+	}
+	return f.Storer.WriteAll(items)
+}
+
+// A message that can't be stored isn't acknowledged, and is applied when the
+// predecessor sends it again. Items the node already has are skipped without
+// rejecting the rest of the message.
+func TestReplicateRetry(t *testing.T) {
+	s := &FailingStore{Storer: kv.New(), fails: 1}
+	n := New(Opts{Store: s})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.linkChanged(transport.NeighborPosPrev, "prev", nil)
+
+	msg := &transport.Replication{
+		From:   "prev",
+		Stream: 1,
+		Seq:    1,
+		Items:  []transport.VersionedItem{{Key: "hello", Value: []byte("world"), Version: 0}},
+	}
+	if err := n.Replicate(msg); err == nil {
+		t.Fatal("expected an error when the store fails")
+	}
+	if _, has := n.seq.newestVersion("hello"); has {
+		t.Fatal("unexpected version of hello after the store failed")
+	}
+	if err := n.Replicate(msg); err != nil {
+		t.Fatalf("Replicate(retry) unexpected error\n  got: %#v", err)
+	}
+
+	// hello at version 0 is stale now.
+	msg = &transport.Replication{
+		From:   "prev",
+		Stream: 1,
+		Seq:    2,
+		Items: []transport.VersionedItem{
+			{Key: "hello", Value: []byte("stale"), Version: 0},
+			{Key: "other", Value: []byte("thing"), Version: 0},
+		},
+	}
+	if err := n.Replicate(msg); err != nil {
+		t.Fatalf("Replicate(stale) unexpected error\n  got: %#v", err)
+	}
+
+	for key, want := range map[string]string{"hello": "world", "other": "thing"} {
+		item, err := s.ReadVersion(key, 0)
+		if err != nil {
+			t.Fatalf("ReadVersion(%s, 0) unexpected error\n  got: %#v", key, err)
+		}
+		if string(item.Value) != want {
+			t.Fatalf("unexpected value of %s\n  want: %s\n  got: %s", key, want, item.Value)
+		}
+	}
+}
+
+// FlakyNode fails the first fails calls to CommitBatch and sends the versions
+// of the ones that succeed to committed.
+type FlakyNode struct {
+	transport.NodeClient
+	mu        sync.Mutex
+	fails     int
+	committed chan map[string]uint64
+}
+
+func (f *FlakyNode) CommitBatch(versions map[string]uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("connection refused")
+	}
+	f.committed <- versions
+	return nil
+}
+
+// A CommitBatch that fails is sent again.
+func TestCommitterRetry(t *testing.T) {
+	to := &FlakyNode{fails: 2, committed: make(chan map[string]uint64, 1)}
+	c := newCommitter(to, log.New(io.Discard, "", 0))
+	defer c.close()
+
+	want := map[string]uint64{"hello": 3}
+	c.add(want)
+
+	select {
+	case got := <-to.committed:
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("unexpected commits (-want +got):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for CommitBatch to be sent again")
+	}
+}
+
+// BenchmarkReplication compares synchronous and pipelined replication on a
+// three node chain with a little latency between the nodes.
+func BenchmarkReplication(b *testing.B) {
+	modes := []struct {
+		name string
+		sync bool
+	}{
+		{name: "sync", sync: true},
+		{name: "pipelined", sync: false},
+	}
+
+	workloads := []struct {
+		name string
+		key  func(i int64) string
+	}{
+		{name: "hot-key", key: func(int64) string { return "hello" }},
+		{name: "many-keys", key: func(i int64) string { return fmt.Sprintf("key-%d", i) }},
+	}
+
+	for _, mode := range modes {
+		for _, workload := range workloads {
+			b.Run(mode.name+"/"+workload.name, func(b *testing.B) {
+				nodes := make([]*Node, 3)
+				for i := range nodes {
+					nodes[i] = New(Opts{Store: kv.New(), SyncReplication: mode.sync})
+				}
+				defer link(func(n *Node) transport.NodeClient {
+					return &DelayNode{Node: n, latency: time.Millisecond}
+				}, nodes...)()
+
+				var count int64
+				value := []byte("value")
+
+				b.SetParallelism(16)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						key := workload.key(atomic.AddInt64(&count, 1))
+						if _, err := nodes[0].ClientWrite(key, value); err != nil {
+							b.Errorf("ClientWrite(%s) unexpected error\n  got: %#v", key, err)
+						}
+					}
+				})
+			})
+		}
+	}
+}
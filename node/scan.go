@@ -0,0 +1,99 @@
+package node
+
+import (
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+// maxScanLimit is the most keys a single Scan or ScanPrefix page returns. It's
+// also the page size when the caller doesn't set a limit, so a scan never
+// loads the whole store into memory.
+const maxScanLimit = 1000
+
+// Scan returns a page of up to limit keys from start to end, in key order. Each
+// key is read the same way Read reads it: if the newest version of a key is
+// dirty, the tail is asked for the latest committed version. Deleted keys are
+// left out.
+func (n *Node) Scan(start, end string, limit int) (*transport.ScanResult, error) {
+	if err := n.readable(); err != nil {
+		return nil, err
+	}
+
+	limit = scanLimit(limit)
+
+	items, err := n.store.Scan(start, end, limit)
+	if err != nil {
+		n.log.Printf("Failed to scan from %s to %s. %v\n", start, end, err)
+		return nil, err
+	}
+
+	result, err := n.scanResult(items, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scan's start is included, so the next page starts right after the last
+	// key.
+	if result.Next != "" {
+		result.Next += "\x00"
+	}
+
+	return result, nil
+}
+
+// ScanPrefix returns a page of up to limit keys that start with prefix and come
+// after cursor, read the same way as Scan.
+func (n *Node) ScanPrefix(prefix, cursor string, limit int) (*transport.ScanResult, error) {
+	if err := n.readable(); err != nil {
+		return nil, err
+	}
+
+	limit = scanLimit(limit)
+
+	items, err := n.store.ScanPrefix(prefix, cursor, limit)
+	if err != nil {
+		n.log.Printf("Failed to scan prefix %s. %v\n", prefix, err)
+		return nil, err
+	}
+
+	return n.scanResult(items, limit)
+}
+
+// scanResult reads the latest committed version of every item the store
+// scanned. If the store returned a full page, Next is set to the last key
+// scanned.
+func (n *Node) scanResult(items []*store.Item, limit int) (*transport.ScanResult, error) {
+	result := &transport.ScanResult{Items: []transport.VersionedItem{}}
+
+	for _, item := range items {
+		if !item.Committed {
+			var err error
+			item, err = n.readItem(item.Key, nil)
+			if err == transport.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if item.Deleted {
+			continue
+		}
+
+		result.Items = append(result.Items, versionedItem(item))
+	}
+
+	if len(items) == limit {
+		result.Next = items[len(items)-1].Key
+	}
+
+	return result, nil
+}
+
+func scanLimit(limit int) int {
+	if limit <= 0 || limit > maxScanLimit {
+		return maxScanLimit
+	}
+	return limit
+}
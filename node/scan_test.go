@@ -0,0 +1,105 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScan(t *testing.T) {
+	tail := &TailNode{version: 1}
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.neighbors[transport.NeighborPosTail] = neighbor{rpc: tail, address: "tail"}
+
+	// Every key is committed at version 1. b has a dirty version 2, so it's
+	// read at the version the tail has committed, and c is deleted.
+	for _, key := range []string{"a", "ab", "b", "c", "d"} {
+		if err := n.Write(key, []byte(key), 1); err != nil {
+			t.Fatalf("Write(%s) unexpected error\n  got: %#v", key, err)
+		}
+		if err := n.Commit(key, 1); err != nil {
+			t.Fatalf("Commit(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+	if err := n.Write("b", []byte("b2"), 2); err != nil {
+		t.Fatalf("Write(b) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Delete("c", 2); err != nil {
+		t.Fatalf("Delete(c) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Commit("c", 2); err != nil {
+		t.Fatalf("Commit(c) unexpected error\n  got: %#v", err)
+	}
+
+	item := func(key string) transport.VersionedItem {
+		return transport.VersionedItem{Key: key, Value: []byte(key), Version: 1}
+	}
+
+	tests := []struct {
+		name string
+		scan func() (*transport.ScanResult, error)
+		want *transport.ScanResult
+	}{
+		{
+			name: "everything",
+			scan: func() (*transport.ScanResult, error) { return n.Scan("", "", 0) },
+			want: &transport.ScanResult{
+				Items: []transport.VersionedItem{item("a"), item("ab"), item("b"), item("d")},
+			},
+		},
+		{
+			name: "first page",
+			scan: func() (*transport.ScanResult, error) { return n.Scan("", "", 2) },
+			want: &transport.ScanResult{
+				Items: []transport.VersionedItem{item("a"), item("ab")},
+				Next:  "ab\x00",
+			},
+		},
+		{
+			name: "page without deleted key",
+			scan: func() (*transport.ScanResult, error) { return n.Scan("ab\x00", "", 2) },
+			want: &transport.ScanResult{
+				Items: []transport.VersionedItem{item("b")},
+				Next:  "c\x00",
+			},
+		},
+		{
+			name: "last page",
+			scan: func() (*transport.ScanResult, error) { return n.Scan("c\x00", "", 2) },
+			want: &transport.ScanResult{
+				Items: []transport.VersionedItem{item("d")},
+			},
+		},
+		{
+			name: "prefix",
+			scan: func() (*transport.ScanResult, error) { return n.ScanPrefix("a", "", 1) },
+			want: &transport.ScanResult{
+				Items: []transport.VersionedItem{item("a")},
+				Next:  "a",
+			},
+		},
+		{
+			name: "prefix after cursor",
+			scan: func() (*transport.ScanResult, error) { return n.ScanPrefix("a", "a", 1) },
+			want: &transport.ScanResult{
+				Items: []transport.VersionedItem{item("ab")},
+				Next:  "ab",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.scan()
+			if err != nil {
+				t.Fatalf("unexpected error\n  got: %#v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
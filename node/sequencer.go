@@ -0,0 +1,132 @@
+package node
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+// written is the newest version of a key in the store, committed or not.
+type written struct {
+	version uint64
+	deleted bool
+}
+
+// keyLock is a mutex for one key. refs counts the writers holding or waiting
+// for the lock so that it can be dropped once nobody needs it.
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// sequencer assigns versions to the writes for each key. Writes to the same key
+// are serialized with a per-key lock, so the version check and the version
+// assignment are atomic, while writes to different keys don't wait on each
+// other.
+//
+// The newest versions are only recorded after the item has been written to the
+// store, and they're filled from the store when the node starts. A node that
+// crashes, or a successor that becomes the head, keeps counting from the newest
+// version it has stored, committed or not, so a version is never handed out
+// twice.
+type sequencer struct {
+	mu     sync.Mutex
+	newest map[string]written
+	locks  map[string]*keyLock
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		newest: make(map[string]written),
+		locks:  make(map[string]*keyLock),
+	}
+}
+
+// lock serializes writes to key. The returned func releases the lock.
+func (s *sequencer) lock(key string) func() {
+	s.mu.Lock()
+	l, has := s.locks[key]
+	if !has {
+		l = &keyLock{}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, key)
+		}
+	}
+}
+
+// lockAll locks every key in keys. Keys are locked in sorted order so that
+// batches with overlapping keys can't deadlock. The returned func releases every
+// lock.
+func (s *sequencer) lockAll(keys []string) func() {
+	distinct := make(map[string]bool, len(keys))
+	sorted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !distinct[key] {
+			distinct[key] = true
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+
+	unlocks := make([]func(), len(sorted))
+	for i, key := range sorted {
+		unlocks[i] = s.lock(key)
+	}
+
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// newestVersion returns the newest version of key in the store, committed or
+// not.
+func (s *sequencer) newestVersion(key string) (written, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, has := s.newest[key]
+	return w, has
+}
+
+// next returns the version for the next write to key. Deleted keys keep
+// counting from the version of the tombstone. The caller must hold the lock for
+// key.
+func (s *sequencer) next(key string) uint64 {
+	if w, has := s.newestVersion(key); has {
+		return w.version + 1
+	}
+	return 0
+}
+
+// check returns transport.ErrStaleVersion if item isn't newer than every
+// version of it's key seen so far. The caller must hold the lock for the key.
+func (s *sequencer) check(item *store.Item) error {
+	if w, has := s.newestVersion(item.Key); has && item.Version <= w.version {
+		return transport.ErrStaleVersion
+	}
+	return nil
+}
+
+// wrote records item as the newest version of it's key if it's newer than what
+// was seen before.
+func (s *sequencer) wrote(item *store.Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, has := s.newest[item.Key]; !has || item.Version >= w.version {
+		s.newest[item.Key] = written{version: item.Version, deleted: item.Deleted}
+	}
+}
@@ -0,0 +1,203 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeConcurrently starts writers goroutines that each write the key count
+// times, and returns every version that was handed out.
+func writeConcurrently(
+	t *testing.T,
+	write func(key string, val []byte, ttl time.Duration) (uint64, error),
+	key string,
+	writers, count int,
+) []uint64 {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	versions := []uint64{}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				v, err := write(key, []byte(fmt.Sprintf("%d-%d", i, j)), 0)
+				if err != nil {
+					t.Errorf("Write(%s) unexpected error\n  got: %#v", key, err)
+					return
+				}
+				mu.Lock()
+				versions = append(versions, v)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// sequence returns the versions 0 to count-1.
+func sequence(count int) []uint64 {
+	versions := make([]uint64, count)
+	for i := range versions {
+		versions[i] = uint64(i)
+	}
+	return versions
+}
+
+// Every parallel writer to the same key gets it's own version and no version is
+// skipped.
+func TestConcurrentWritesUniqueVersions(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	const writers, count = 10, 20
+	got := writeConcurrently(t, c.Write, "hello", writers, count)
+
+	if diff := cmp.Diff(sequence(writers*count), got); diff != "" {
+		t.Fatalf("unexpected versions (-want +got):\n%s", diff)
+	}
+
+	for _, node := range []*Node{n, n2} {
+		item, err := node.store.Read("hello")
+		if err != nil {
+			t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+		}
+		if item.Version != writers*count-1 {
+			t.Fatalf("unexpected latest version\n  want: %d\n  got: %d", writers*count-1, item.Version)
+		}
+	}
+}
+
+// Writes to different keys are versioned independently.
+func TestConcurrentWritesManyKeys(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	const writers, count = 5, 10
+	keys := []string{"a", "b", "c", "d"}
+	got := make([][]uint64, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			got[i] = writeConcurrently(t, c.Write, key, writers, count)
+		}(i, key)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if diff := cmp.Diff(sequence(writers*count), got[i]); diff != "" {
+			t.Fatalf("unexpected versions for %s (-want +got):\n%s", key, diff)
+		}
+	}
+}
+
+// Versions the node already has are rejected instead of being stored twice.
+func TestWriteRejectsStaleVersion(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{
+		rpc:     &StuckNode{},
+		address: "stuck",
+	}
+
+	if err := n.Write("hello", []byte("a"), 1); err != nil {
+		t.Fatalf("Write(hello, a, 1) unexpected error\n  got: %#v", err)
+	}
+
+	tests := []struct {
+		name    string
+		version uint64
+	}{
+		{name: "duplicate", version: 1},
+		{name: "older", version: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := n.Write("hello", []byte("b"), tt.version)
+			if err != transport.ErrStaleVersion {
+				t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", transport.ErrStaleVersion, err)
+			}
+		})
+	}
+
+	items, err := n.store.AllDirty()
+	if err != nil {
+		t.Fatalf("AllDirty() unexpected error\n  got: %#v", err)
+	}
+	if len(items) != 1 || string(items[0].Value) != "a" {
+		t.Fatalf("unexpected items in store\n  got: %#v", items)
+	}
+}
+
+// Parallel deliveries of the same version only store it once.
+func TestConcurrentDuplicateWrites(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{
+		rpc:     &StuckNode{},
+		address: "stuck",
+	}
+
+	const senders = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.Write("hello", []byte("world"), 0); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Fatalf("unexpected number of accepted writes\n  want: %d\n  got: %d", 1, accepted)
+	}
+}
+
+// A head that restarts keeps counting from the newest version in it's store,
+// including uncommitted versions.
+func TestSequencerRecoversFromStore(t *testing.T) {
+	s := kv.New()
+	s.Write("hello", []byte("a"), 3)
+	s.Commit("hello", 3)
+	s.Write("hello", []byte("b"), 4)
+
+	n := New(Opts{Store: s})
+	if err := n.backfillLatest(); err != nil {
+		t.Fatalf("backfillLatest() unexpected error\n  got: %#v", err)
+	}
+
+	version, err := n.ClientWrite("hello", []byte("c"))
+	if err != nil {
+		t.Fatalf("ClientWrite(hello) unexpected error\n  got: %#v", err)
+	}
+	if version != 5 {
+		t.Fatalf("ClientWrite(hello) unexpected version\n  want: %d\n  got: %d", 5, version)
+	}
+}
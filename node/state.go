@@ -0,0 +1,109 @@
+package node
+
+import (
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+// Status returns the state of the node and where it sits in the chain.
+func (n *Node) Status() (*transport.NodeStatus, error) {
+	n.mu.Lock()
+	status := &transport.NodeStatus{
+		Address: n.pubAddr,
+		IsHead:  n.IsHead,
+		IsTail:  n.IsTail,
+		Prev:    n.neighbors[transport.NeighborPosPrev].address,
+		Next:    n.neighbors[transport.NeighborPosNext].address,
+		Tail:    n.neighbors[transport.NeighborPosTail].address,
+	}
+	n.mu.Unlock()
+
+	status.State = n.currentState()
+	return status, nil
+}
+
+func (n *Node) currentState() transport.NodeState {
+	n.stateMu.Lock()
+	defer n.stateMu.Unlock()
+	return n.state
+}
+
+// setState moves the node to state and reports it to the Coordinator. A
+// draining node stays draining until Undrain, which moves it to the last state
+// it was given.
+func (n *Node) setState(state transport.NodeState) {
+	n.stateMu.Lock()
+	if n.state == transport.NodeDraining {
+		if state != transport.NodeDraining {
+			n.undrainState = state
+		}
+		n.stateMu.Unlock()
+		return
+	}
+	n.enterState(state)
+}
+
+// enterState is setState without keeping draining nodes draining. stateMu must
+// be held, and is unlocked before the state is reported.
+func (n *Node) enterState(state transport.NodeState) {
+	if n.state == state {
+		n.stateMu.Unlock()
+		return
+	}
+	if state == transport.NodeDraining {
+		n.undrainState = n.state
+	}
+	n.state = state
+	if state == transport.NodeServing {
+		// A node that was serving before it drained is already caught up.
+		select {
+		case <-n.caughtUp:
+		default:
+			close(n.caughtUp)
+		}
+	}
+	n.stateMu.Unlock()
+
+	n.log.Printf("Node is %s\n", state)
+
+	// A node without a Coordinator has no one to report to.
+	if n.cdrAddress == "" {
+		return
+	}
+	if err := n.cdr.ReportState(n.pubAddr, state); err != nil {
+		n.log.Printf("Failed to report state %s to the coordinator. %v\n", state, err)
+	}
+}
+
+// readable returns nil if the node can serve client reads. Until it's caught
+// up, it may be missing keys or have old versions of them, so reads are
+// refused with transport.ErrNotServing and clients go to another node.
+// Draining nodes refuse them with transport.ErrDraining.
+func (n *Node) readable() error {
+	switch n.currentState() {
+	case transport.NodeServing:
+		return nil
+	case transport.NodeDraining:
+		return transport.ErrDraining
+	}
+	return transport.ErrNotServing
+}
+
+// writable returns nil if the node can take client writes. Writes can only go
+// to the head, so writes that arrive while the node is joining the chain wait
+// for it to catch up, up to the write timeout, before they're refused with
+// transport.ErrNotServing. A draining head keeps taking writes until it's
+// spliced out of the chain, since there's no other node they can go to.
+func (n *Node) writable() error {
+	n.stateMu.Lock()
+	caughtUp := n.caughtUp
+	n.stateMu.Unlock()
+
+	select {
+	case <-caughtUp:
+		return nil
+	case <-time.After(n.writeTimeout):
+		return transport.ErrNotServing
+	}
+}
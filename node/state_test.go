@@ -0,0 +1,116 @@
+package node
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+// StateCoordinator records the states reported to it. Methods that aren't
+// overridden panic because the embedded CoordinatorClient is nil.
+type StateCoordinator struct {
+	transport.CoordinatorClient
+	mu     sync.Mutex
+	states []transport.NodeState
+}
+
+func (c *StateCoordinator) ReportState(address string, state transport.NodeState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states = append(c.states, state)
+	return nil
+}
+
+func TestNodeState(t *testing.T) {
+	var c StateCoordinator
+	n := New(Opts{
+		CdrAddress:        "coordinator",
+		PubAddress:        "node",
+		Store:             kv.New(),
+		CoordinatorClient: &c,
+		WriteTimeout:      50 * time.Millisecond,
+	})
+
+	assertState := func(want transport.NodeState) {
+		t.Helper()
+		status, err := n.Status()
+		if err != nil {
+			t.Fatalf("Status() unexpected error\n  got: %#v", err)
+		}
+		if status.State != want {
+			t.Fatalf("unexpected state\n  want: %s\n  got: %s", want, status.State)
+		}
+	}
+
+	// Reads are refused until the node is serving, and writes wait for it. The
+	// rest of the chain can still ask for the latest versions.
+	assertState(transport.NodeJoining)
+	if _, _, _, err := n.Read("hello", nil); err != transport.ErrNotServing {
+		t.Fatalf("Read(hello) unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotServing, err)
+	}
+	if _, _, err := n.LatestVersion("hello"); err != nil {
+		t.Fatalf("LatestVersion(hello) unexpected error\n  got: %#v", err)
+	}
+	if _, err := n.ClientWrite("hello", []byte("world")); err != transport.ErrNotServing {
+		t.Fatalf("ClientWrite(hello) unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotServing, err)
+	}
+
+	n.writeTimeout = time.Minute
+	written := make(chan error)
+	go func() {
+		_, err := n.ClientWrite("hello", []byte("world"))
+		written <- err
+	}()
+
+	n.setState(transport.NodeSyncing)
+	assertState(transport.NodeSyncing)
+	select {
+	case err := <-written:
+		t.Fatalf("ClientWrite(hello) returned while syncing\n  got: %#v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	n.setState(transport.NodeServing)
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("ClientWrite(hello) unexpected error\n  got: %#v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ClientWrite(hello) didn't return once the node was serving")
+	}
+	assertItem(t, n, "hello", []byte("world"))
+
+	// A draining node stays draining.
+	if _, err := n.Drain(); err != nil {
+		t.Fatalf("Drain() unexpected error\n  got: %#v", err)
+	}
+	n.setState(transport.NodeServing)
+	assertState(transport.NodeDraining)
+	if _, _, _, err := n.Read("hello", nil); err != transport.ErrDraining {
+		t.Fatalf("Read(hello) unexpected error\n  want: %#v\n  got: %#v", transport.ErrDraining, err)
+	}
+
+	// Undrain puts it back to serving.
+	if err := n.Undrain(); err != nil {
+		t.Fatalf("Undrain() unexpected error\n  got: %#v", err)
+	}
+	assertState(transport.NodeServing)
+	assertItem(t, n, "hello", []byte("world"))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	want := []transport.NodeState{
+		transport.NodeSyncing,
+		transport.NodeServing,
+		transport.NodeDraining,
+		transport.NodeServing,
+	}
+	if diff := cmp.Diff(want, c.states); diff != "" {
+		t.Fatalf("unexpected reported states (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,160 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTransaction(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	c.Write("a", []byte("100"), 0)
+	c.Write("a", []byte("90"), 0)
+	c.Write("b", []byte("100"), 0)
+
+	tests := []struct {
+		name     string
+		txn      *transport.Transaction
+		versions []uint64
+		conflict *transport.ConflictError
+		a, b     string
+	}{
+		{
+			name: "stale version",
+			txn: &transport.Transaction{
+				Preconditions: []transport.Precondition{{Key: "a", Version: 0}},
+				Writes:        []transport.Item{{Key: "a", Value: []byte("80")}},
+			},
+			conflict: &transport.ConflictError{Key: "a", Latest: 1, Exists: true},
+			a:        "90",
+			b:        "100",
+		},
+		{
+			name: "key exists",
+			txn: &transport.Transaction{
+				Preconditions: []transport.Precondition{
+					{Key: "a", Version: 1},
+					{Key: "b", Absent: true},
+				},
+				Writes: []transport.Item{{Key: "a", Value: []byte("80")}},
+			},
+			conflict: &transport.ConflictError{Key: "b", Latest: 0, Exists: true},
+			a:        "90",
+			b:        "100",
+		},
+		{
+			name: "transfer",
+			txn: &transport.Transaction{
+				Preconditions: []transport.Precondition{
+					{Key: "a", Version: 1},
+					{Key: "b", Version: 0},
+					{Key: "c", Absent: true},
+				},
+				Writes: []transport.Item{
+					{Key: "a", Value: []byte("80")},
+					{Key: "b", Value: []byte("110")},
+				},
+			},
+			versions: []uint64{2, 1},
+			a:        "80",
+			b:        "110",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versions, err := c.Transaction(tt.txn)
+
+			var conflict *transport.ConflictError
+			if errors.As(err, &conflict) {
+				if diff := cmp.Diff(tt.conflict, conflict); diff != "" {
+					t.Fatalf("unexpected conflict (-want +got):\n%s", diff)
+				}
+			} else if err != nil || tt.conflict != nil {
+				t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", tt.conflict, err)
+			}
+
+			if diff := cmp.Diff(tt.versions, versions); diff != "" {
+				t.Fatalf("unexpected versions (-want +got):\n%s", diff)
+			}
+
+			for _, node := range []*Node{n, n2} {
+				assertItem(t, node, "a", []byte(tt.a))
+				assertItem(t, node, "b", []byte(tt.b))
+			}
+		})
+	}
+}
+
+// readBalance reads the latest committed balance and version of an account.
+func readBalance(t *testing.T, n *Node, key string) (int, uint64) {
+	t.Helper()
+	_, value, version, err := n.Read(key, nil)
+	if err != nil {
+		t.Fatalf("Read(%s) unexpected error\n  got: %#v", key, err)
+	}
+	balance, err := strconv.Atoi(string(value))
+	if err != nil {
+		t.Fatalf("unexpected balance %s", value)
+	}
+	return balance, version
+}
+
+// Concurrent transfers between two accounts never lose or create money.
+func TestTransactionTransfers(t *testing.T) {
+	n, n2, c := setupTwoNodeChain()
+	n.Start()
+	n2.Start()
+	c.Updates.Wait()
+
+	c.Write("a", []byte("100"), 0)
+	c.Write("b", []byte("100"), 0)
+
+	const workers, transfers = 5, 10
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for done := 0; done < transfers; {
+				a, va := readBalance(t, n, "a")
+				b, vb := readBalance(t, n, "b")
+
+				_, err := c.Transaction(&transport.Transaction{
+					Preconditions: []transport.Precondition{
+						{Key: "a", Version: va},
+						{Key: "b", Version: vb},
+					},
+					Writes: []transport.Item{
+						{Key: "a", Value: []byte(strconv.Itoa(a - 1))},
+						{Key: "b", Value: []byte(strconv.Itoa(b + 1))},
+					},
+				})
+
+				var conflict *transport.ConflictError
+				if errors.As(err, &conflict) {
+					continue
+				} else if err != nil {
+					t.Errorf("Transaction() unexpected error\n  got: %#v", err)
+					return
+				}
+				done++
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, node := range []*Node{n, n2} {
+		assertItem(t, node, "a", []byte(strconv.Itoa(100-workers*transfers)))
+		assertItem(t, node, "b", []byte(strconv.Itoa(100+workers*transfers)))
+	}
+}
@@ -0,0 +1,247 @@
+package node
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/despreston/go-craq/store"
+	"github.com/despreston/go-craq/transport"
+)
+
+const (
+	defaultWatchLogSize = 10000
+	// How long Watch waits for a commit before returning no events.
+	defaultWatchTimeout = 30 * time.Second
+	// maxWatchEvents is the most events a single Watch reply holds.
+	maxWatchEvents = 1000
+	// How long after the last Watch call returns commits are still added to
+	// the watch log, so watchers that call again right away don't miss any.
+	watchIdleTimeout = time.Minute
+)
+
+// watchLog keeps the most recent commits made by the node, in the order they
+// were made, so watchers can pick up where they left off. Each commit gets the
+// next revision. Revisions start from the time the log was created, so a
+// revision from before the node restarted is never mistaken for one after.
+type watchLog struct {
+	mu sync.Mutex
+	// Oldest first. At most size events are kept.
+	events []transport.WatchEvent
+	size   int
+	// Revision of the newest commit.
+	revision uint64
+	// Closed, and replaced, every time a commit is added.
+	changed chan struct{}
+	// Watch calls in progress, and when the last one returned.
+	watchers  int
+	lastWatch time.Time
+}
+
+func newWatchLog(size int) *watchLog {
+	return &watchLog{
+		size:     size,
+		revision: uint64(time.Now().UnixNano()),
+		changed:  make(chan struct{}),
+	}
+}
+
+// add appends a commit of item to the log and wakes up the watchers.
+func (w *watchLog) add(item *store.Item) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.revision++
+	w.events = append(w.events, transport.WatchEvent{
+		Key:      item.Key,
+		Value:    item.Value,
+		Version:  item.Version,
+		Deleted:  item.Deleted,
+		Revision: w.revision,
+	})
+	if len(w.events) > w.size {
+		w.events = w.events[1:]
+	}
+
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// watching reports whether there are Watch calls in progress, or there were
+// recently enough that the caller is likely to call again.
+func (w *watchLog) watching() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.watchers > 0 || time.Since(w.lastWatch) < watchIdleTimeout
+}
+
+// startWatch records a Watch call until the returned func is called.
+func (w *watchLog) startWatch() func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watchers++
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.watchers--
+		w.lastWatch = time.Now()
+	}
+}
+
+// skip counts a commit made while no one was watching, without keeping it.
+// The older commits are dropped too, so a watcher that comes back gets
+// transport.ErrWatchCompacted instead of silently missing the commit.
+func (w *watchLog) skip() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.revision++
+	w.events = nil
+}
+
+// current returns the revision of the newest commit.
+func (w *watchLog) current() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.revision
+}
+
+// since returns up to limit commits after revision from of keys that start with
+// prefix, and the revision to continue from. The returned channel is closed
+// once another commit is added. If the log doesn't have every commit after
+// from, transport.ErrWatchCompacted is returned.
+func (w *watchLog) since(
+	prefix string,
+	from uint64,
+	limit int,
+) ([]transport.WatchEvent, uint64, <-chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	oldest := w.revision - uint64(len(w.events))
+	if from < oldest || from > w.revision {
+		return nil, 0, nil, transport.ErrWatchCompacted
+	}
+
+	events := []transport.WatchEvent{}
+	next := w.revision
+
+	for _, event := range w.events[len(w.events)-int(w.revision-from):] {
+		if !strings.HasPrefix(event.Key, prefix) {
+			continue
+		}
+		events = append(events, event)
+		if len(events) == limit {
+			next = event.Revision
+			break
+		}
+	}
+
+	return events, next, w.changed, nil
+}
+
+// Watch returns the commits made by this node, after revision from, of keys
+// that start with prefix. If there aren't any, it waits for one until the watch
+// timeout and then returns no events. Callers keep watching by passing the
+// returned revision to the next call, so no commit is missed in between, even
+// if the caller reconnects. A from of 0 returns the current revision right
+// away, to start watching from now. Commits older than the watch log, and
+// commits from before the node restarted, can't be watched; asking for them
+// returns transport.ErrWatchCompacted. The node only keeps commits while
+// someone is watching, so commits made more than a minute after the last Watch
+// call returned are compacted right away.
+func (n *Node) Watch(prefix string, from uint64) (*transport.WatchResult, error) {
+	defer n.watches.startWatch()()
+
+	if from == 0 {
+		return &transport.WatchResult{
+			Events:   []transport.WatchEvent{},
+			Revision: n.watches.current(),
+		}, nil
+	}
+
+	timeout := time.NewTimer(n.watchTimeout)
+	defer timeout.Stop()
+
+	for {
+		events, next, changed, err := n.watches.since(prefix, from, maxWatchEvents)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(events) > 0 {
+			return &transport.WatchResult{Events: events, Revision: next}, nil
+		}
+
+		// Nothing matched, so skip ahead to avoid looking at the same commits
+		// again.
+		from = next
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return &transport.WatchResult{Events: events, Revision: from}, nil
+		}
+	}
+}
+
+// watchItems reads the versions of key that committing version commits, oldest
+// first, for the watch log. Committing a version commits every older dirty
+// version of the key too, and commits merged by the successor's committer skip
+// straight to the newest version, so watchers only see every version if
+// they're all published. The older versions are cleared by the commit, so
+// watchItems has to be called before committing, and the items published
+// after the commit succeeds. Versions that can't be read are left out, and
+// watchers don't see those commits. If no one is watching, nothing is read and
+// nil is returned.
+func (n *Node) watchItems(key string, version uint64) []*store.Item {
+	if !n.watches.watching() {
+		return nil
+	}
+
+	n.latestMu.Lock()
+	latest, has := n.latest[key]
+	n.latestMu.Unlock()
+
+	items := []*store.Item{}
+	for v := version; !has || v > latest; v-- {
+		item, err := n.store.ReadVersion(key, v)
+		if err == store.ErrNotFound {
+			break
+		}
+		if err != nil {
+			n.log.Printf("Failed to read commit of %s version %d for watchers. %v\n", key, v, err)
+			break
+		}
+		if item.Committed && v < version {
+			break
+		}
+		items = append(items, item)
+		if v == 0 {
+			break
+		}
+	}
+
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+// publish adds the commits of items, which are versions of the same key in
+// order, to the watch log if they're newer than the latest version of the key.
+// Nil items, from watchItems when no one was watching, are skipped in the log.
+// The caller must hold latestMu, and call publish once the commit has
+// succeeded, before setLatest.
+func (n *Node) publish(items []*store.Item) {
+	if items == nil {
+		n.watches.skip()
+		return
+	}
+	for _, item := range items {
+		if latest, has := n.latest[item.Key]; has && item.Version <= latest {
+			continue
+		}
+		n.watches.add(item)
+	}
+}
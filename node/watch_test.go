@@ -0,0 +1,225 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/store/kv"
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatch(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.watchTimeout = 10 * time.Millisecond
+
+	start, err := n.Watch("user/", 0)
+	if err != nil {
+		t.Fatalf("Watch(user/, 0) unexpected error\n  got: %#v", err)
+	}
+
+	// A write, a key without the prefix, a delete, and a batch.
+	if err := n.Write("user/a", []byte("a"), 1); err != nil {
+		t.Fatalf("Write(user/a) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Write("other", []byte("other"), 1); err != nil {
+		t.Fatalf("Write(other) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Delete("user/a", 2); err != nil {
+		t.Fatalf("Delete(user/a) unexpected error\n  got: %#v", err)
+	}
+	for _, key := range []string{"user/a", "other"} {
+		if err := n.Commit(key, 1); err != nil {
+			t.Fatalf("Commit(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+	if err := n.Commit("user/a", 2); err != nil {
+		t.Fatalf("Commit(user/a) unexpected error\n  got: %#v", err)
+	}
+	err = n.WriteBatch([]transport.VersionedItem{
+		{Key: "user/b", Value: []byte("b"), Version: 1},
+		{Key: "user/c", Value: []byte("c"), Version: 1},
+	})
+	if err != nil {
+		t.Fatalf("WriteBatch() unexpected error\n  got: %#v", err)
+	}
+	if err := n.CommitBatch(map[string]uint64{"user/b": 1, "user/c": 1}); err != nil {
+		t.Fatalf("CommitBatch() unexpected error\n  got: %#v", err)
+	}
+
+	got, err := n.Watch("user/", start.Revision)
+	if err != nil {
+		t.Fatalf("Watch(user/) unexpected error\n  got: %#v", err)
+	}
+
+	want := []transport.WatchEvent{
+		{Key: "user/a", Value: []byte("a"), Version: 1},
+		{Key: "user/a", Version: 2, Deleted: true},
+		{Key: "user/b", Value: []byte("b"), Version: 1},
+		{Key: "user/c", Value: []byte("c"), Version: 1},
+	}
+	ignoreRevision := cmp.FilterPath(func(p cmp.Path) bool {
+		return p.Last().String() == ".Revision"
+	}, cmp.Ignore())
+
+	// The batch can be added in any order.
+	if len(got.Events) == len(want) && got.Events[2].Key == "user/c" {
+		got.Events[2], got.Events[3] = got.Events[3], got.Events[2]
+	}
+	if diff := cmp.Diff(want, got.Events, ignoreRevision); diff != "" {
+		t.Fatalf("Watch(user/) unexpected events (-want +got):\n%s", diff)
+	}
+	if got.Revision != start.Revision+5 {
+		t.Fatalf("unexpected revision\n  want: %d\n  got: %d", start.Revision+5, got.Revision)
+	}
+
+	// Nothing new, so the watch times out.
+	empty, err := n.Watch("user/", got.Revision)
+	if err != nil {
+		t.Fatalf("Watch(user/) unexpected error\n  got: %#v", err)
+	}
+	if len(empty.Events) != 0 || empty.Revision != got.Revision {
+		t.Fatalf("unexpected watch result\n  want: no events at %d\n  got: %#v", got.Revision, empty)
+	}
+
+	// A waiting watch returns as soon as a matching key is committed.
+	n.watchTimeout = time.Minute
+	results := make(chan *transport.WatchResult)
+	go func() {
+		result, err := n.Watch("user/", got.Revision)
+		if err != nil {
+			t.Errorf("Watch(user/) unexpected error\n  got: %#v", err)
+		}
+		results <- result
+	}()
+
+	for _, key := range []string{"other", "user/d"} {
+		if err := n.Write(key, []byte(key), 3); err != nil {
+			t.Fatalf("Write(%s) unexpected error\n  got: %#v", key, err)
+		}
+		if err := n.Commit(key, 3); err != nil {
+			t.Fatalf("Commit(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+
+	select {
+	case result := <-results:
+		if len(result.Events) != 1 || result.Events[0].Key != "user/d" {
+			t.Fatalf("unexpected events\n  want: user/d\n  got: %#v", result.Events)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch(user/) didn't return after a commit")
+	}
+}
+
+// Commits merged by the successor skip versions, but watchers still see every
+// version they commit.
+func TestWatchMergedCommits(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+
+	start, err := n.Watch("", 0)
+	if err != nil {
+		t.Fatalf("Watch(, 0) unexpected error\n  got: %#v", err)
+	}
+
+	for version, value := range []string{"a", "b", "c"} {
+		if err := n.Write("hello", []byte(value), uint64(version)); err != nil {
+			t.Fatalf("Write(hello, %s) unexpected error\n  got: %#v", value, err)
+		}
+	}
+	if err := n.Commit("hello", 0); err != nil {
+		t.Fatalf("Commit(hello, 0) unexpected error\n  got: %#v", err)
+	}
+	if err := n.CommitBatch(map[string]uint64{"hello": 2}); err != nil {
+		t.Fatalf("CommitBatch() unexpected error\n  got: %#v", err)
+	}
+
+	got, err := n.Watch("", start.Revision)
+	if err != nil {
+		t.Fatalf("Watch() unexpected error\n  got: %#v", err)
+	}
+
+	want := []transport.WatchEvent{
+		{Key: "hello", Value: []byte("a"), Version: 0},
+		{Key: "hello", Value: []byte("b"), Version: 1},
+		{Key: "hello", Value: []byte("c"), Version: 2},
+	}
+	ignoreRevision := cmp.FilterPath(func(p cmp.Path) bool {
+		return p.Last().String() == ".Revision"
+	}, cmp.Ignore())
+	if diff := cmp.Diff(want, got.Events, ignoreRevision); diff != "" {
+		t.Fatalf("Watch() unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchCompacted(t *testing.T) {
+	n := New(Opts{Store: kv.New(), WatchLogSize: 2})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+	n.watchTimeout = 10 * time.Millisecond
+
+	start, err := n.Watch("", 0)
+	if err != nil {
+		t.Fatalf("Watch(\"\", 0) unexpected error\n  got: %#v", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := n.Write(key, []byte(key), 1); err != nil {
+			t.Fatalf("Write(%s) unexpected error\n  got: %#v", key, err)
+		}
+		if err := n.Commit(key, 1); err != nil {
+			t.Fatalf("Commit(%s) unexpected error\n  got: %#v", key, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		from uint64
+		err  error
+	}{
+		{name: "oldest kept", from: start.Revision + 1},
+		{name: "compacted", from: start.Revision, err: transport.ErrWatchCompacted},
+		{name: "ahead of the node", from: start.Revision + 4, err: transport.ErrWatchCompacted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := n.Watch("", tt.from); err != tt.err {
+				t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestWatchUnwatched(t *testing.T) {
+	n := New(Opts{Store: kv.New()})
+	n.neighbors[transport.NeighborPosNext] = neighbor{rpc: &StuckNode{}, address: "stuck"}
+
+	start, err := n.Watch("", 0)
+	if err != nil {
+		t.Fatalf("Watch(\"\", 0) unexpected error\n  got: %#v", err)
+	}
+
+	// The watcher went away long enough ago that commits aren't kept anymore.
+	n.watches.mu.Lock()
+	n.watches.lastWatch = time.Now().Add(-watchIdleTimeout)
+	n.watches.mu.Unlock()
+
+	if err := n.Write("hello", []byte("world"), 1); err != nil {
+		t.Fatalf("Write(hello) unexpected error\n  got: %#v", err)
+	}
+	if err := n.Commit("hello", 1); err != nil {
+		t.Fatalf("Commit(hello) unexpected error\n  got: %#v", err)
+	}
+
+	n.watches.mu.Lock()
+	events := len(n.watches.events)
+	n.watches.mu.Unlock()
+	if events != 0 {
+		t.Fatalf("unexpected events in the watch log\n  want: 0\n  got: %d", events)
+	}
+	if _, err := n.Watch("", start.Revision); err != transport.ErrWatchCompacted {
+		t.Fatalf("unexpected error\n  want: %#v\n  got: %#v", transport.ErrWatchCompacted, err)
+	}
+}
@@ -0,0 +1,707 @@
+// raft package is a small implementation of the Raft consensus algorithm as
+// described in https://raft.github.io/raft.pdf. It's used to replicate the
+// state of the Coordinator across a cluster of Coordinators so that the chain
+// survives the failure of any single Coordinator process.
+//
+// Only leader election and log replication are implemented. Cluster membership
+// is static and the log is never compacted. The log is expected to stay small
+// because it's only used for changes to the chain's membership.
+
+package raft
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+const (
+	defaultHeartbeatInterval = 100 * time.Millisecond
+	defaultElectionTimeout   = 1 * time.Second
+)
+
+var (
+	// ErrNotLeader is returned by Apply if this member is not the leader.
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrTimeout is returned by Apply if the command was not applied in time.
+	ErrTimeout = errors.New("timed out waiting for command to be applied")
+
+	// ErrLeadershipLost is returned by Apply if leadership was lost before the
+	// command was committed.
+	ErrLeadershipLost = errors.New("leadership lost before command was applied")
+)
+
+// FSM is the state machine that commands in the log are applied to. Apply is
+// called on every member of the cluster, in log order, once a command is
+// committed.
+type FSM interface {
+	Apply(cmd []byte) error
+}
+
+// State is the part of a member's state that must survive restarts.
+type State struct {
+	Term     uint64
+	VotedFor string
+	Entries  []transport.RaftEntry
+}
+
+// Persister saves and loads the State of a member. Save is called every time
+// the term, vote or log changes, before responding to any RPC.
+type Persister interface {
+	SaveRaft(state *State) error
+	LoadRaft() (*State, error)
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case candidate:
+		return "candidate"
+	case leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// Config is for passing options to the Raft constructor.
+type Config struct {
+	// Address of this member. Other members use this address to reach it.
+	ID string
+	// Addresses of the other members in the cluster.
+	Peers []string
+	// Transport creates new clients for communication with the other members.
+	Transport transport.RaftClientFactory
+	// State machine to apply commands to.
+	FSM FSM
+	// Persister for the term, vote and log. If nil, the state is only kept in
+	// memory and a restarted member rejoins the cluster with an empty log.
+	Persister Persister
+	// How often the leader sends heartbeats. Default: 100ms
+	HeartbeatInterval time.Duration
+	// Minimum amount of time without hearing from a leader before starting an
+	// election. The actual timeout is randomized between ElectionTimeout and
+	// 2*ElectionTimeout. Default: 1s
+	ElectionTimeout time.Duration
+	// Log
+	Log *log.Logger
+}
+
+type peer struct {
+	mu      sync.Mutex
+	address string
+	rpc     transport.RaftClient
+}
+
+type pending struct {
+	term uint64
+	done chan error
+}
+
+// Raft is a single member of a Raft cluster.
+type Raft struct {
+	mu        sync.Mutex
+	id        string
+	peers     []*peer
+	transport transport.RaftClientFactory
+	fsm       FSM
+	persister Persister
+	log       *log.Logger
+	role      role
+	leader    string
+
+	// Persistent state. entries[0] is a sentinel so that the first real entry
+	// is at index 1.
+	currentTerm uint64
+	votedFor    string
+	entries     []transport.RaftEntry
+
+	// Volatile state
+	commitIndex, lastApplied uint64
+	nextIndex, matchIndex    map[string]uint64
+	lastContact              time.Time
+	electionTimeout          time.Duration
+	heartbeat                time.Duration
+
+	// Index of the no-op entry appended when this member was last elected.
+	noopIndex uint64
+
+	// Apply calls waiting for their entry to be applied, by log index.
+	waiting  map[uint64]pending
+	applyCh  chan struct{}
+	shutdown chan struct{}
+}
+
+// New creates a new Raft member. Start must be called before it takes part in
+// the cluster.
+func New(cfg Config) *Raft {
+	logger := cfg.Log
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	hb := cfg.HeartbeatInterval
+	if hb == 0 {
+		hb = defaultHeartbeatInterval
+	}
+
+	et := cfg.ElectionTimeout
+	if et == 0 {
+		et = defaultElectionTimeout
+	}
+
+	peers := make([]*peer, 0, len(cfg.Peers))
+	for _, addr := range cfg.Peers {
+		if addr != cfg.ID {
+			peers = append(peers, &peer{address: addr})
+		}
+	}
+
+	return &Raft{
+		id:              cfg.ID,
+		peers:           peers,
+		transport:       cfg.Transport,
+		fsm:             cfg.FSM,
+		persister:       cfg.Persister,
+		log:             logger,
+		entries:         []transport.RaftEntry{{}},
+		nextIndex:       make(map[string]uint64),
+		matchIndex:      make(map[string]uint64),
+		heartbeat:       hb,
+		electionTimeout: et,
+		waiting:         make(map[uint64]pending),
+		applyCh:         make(chan struct{}, 1),
+		shutdown:        make(chan struct{}),
+	}
+}
+
+// Start the election timer and the loop that applies committed entries. If
+// there is a Persister, the state saved by a previous run is loaded first.
+// Committed entries from the loaded log are applied again once the leader
+// tells this member what has been committed.
+func (r *Raft) Start() error {
+	r.mu.Lock()
+	if r.persister != nil {
+		state, err := r.persister.LoadRaft()
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		if state != nil {
+			r.currentTerm = state.Term
+			r.votedFor = state.VotedFor
+			if len(state.Entries) > 0 {
+				r.entries = state.Entries
+			}
+			r.log.Printf(
+				"raft: %s loaded term %d and %d log entries\n",
+				r.id,
+				r.currentTerm,
+				r.lastIndex(),
+			)
+		}
+	}
+	r.lastContact = time.Now()
+	r.mu.Unlock()
+
+	go r.run()
+	go r.applyCommitted()
+	return nil
+}
+
+// persist saves the term, vote and log. Should be called with r.mu held.
+func (r *Raft) persist() {
+	if r.persister == nil {
+		return
+	}
+	err := r.persister.SaveRaft(&State{
+		Term:     r.currentTerm,
+		VotedFor: r.votedFor,
+		Entries:  r.entries,
+	})
+	if err != nil {
+		r.log.Printf("raft: %s failed to persist state: %v\n", r.id, err)
+	}
+}
+
+// Shutdown stops the member. It stops sending and responding to heartbeats.
+func (r *Raft) Shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.shutdown:
+	default:
+		close(r.shutdown)
+		r.role = follower
+	}
+}
+
+// IsLeader reports whether this member currently believes it's the leader.
+func (r *Raft) IsLeader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == leader
+}
+
+// Ready reports whether this member is the leader and has applied the no-op
+// entry it appended when it was elected. By then the FSM has every command
+// committed by previous leaders, which IsLeader alone doesn't promise.
+func (r *Raft) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == leader && r.lastApplied >= r.noopIndex
+}
+
+// Leader returns the address of the current leader, if known.
+func (r *Raft) Leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+// Apply appends a command to the log and waits until it has been committed
+// and applied to the FSM of this member. The error returned by FSM.Apply is
+// returned. Only the leader can apply commands.
+func (r *Raft) Apply(cmd []byte, timeout time.Duration) error {
+	r.mu.Lock()
+	if r.role != leader {
+		r.mu.Unlock()
+		return ErrNotLeader
+	}
+
+	r.entries = append(r.entries, transport.RaftEntry{
+		Term:    r.currentTerm,
+		Command: cmd,
+	})
+	r.persist()
+	idx := r.lastIndex()
+	done := make(chan error, 1)
+	r.waiting[idx] = pending{term: r.currentTerm, done: done}
+	r.advanceCommit()
+	r.mu.Unlock()
+
+	r.broadcast()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		r.mu.Lock()
+		delete(r.waiting, idx)
+		r.mu.Unlock()
+		return ErrTimeout
+	}
+}
+
+func (r *Raft) lastIndex() uint64 {
+	return uint64(len(r.entries) - 1)
+}
+
+func (r *Raft) lastTerm() uint64 {
+	return r.entries[len(r.entries)-1].Term
+}
+
+func (r *Raft) randomTimeout() time.Duration {
+	return r.electionTimeout + time.Duration(rand.Int63n(int64(r.electionTimeout)))
+}
+
+// run is the main loop. Leaders send heartbeats, everyone else waits for the
+// election timeout to pass without hearing from a leader.
+func (r *Raft) run() {
+	timeout := r.randomTimeout()
+	for {
+		select {
+		case <-r.shutdown:
+			return
+		case <-time.After(r.heartbeat):
+		}
+
+		r.mu.Lock()
+		role := r.role
+		expired := time.Since(r.lastContact) > timeout
+		r.mu.Unlock()
+
+		if role == leader {
+			r.broadcast()
+			continue
+		}
+
+		if expired {
+			timeout = r.randomTimeout()
+			r.startElection()
+		}
+	}
+}
+
+// becomeFollower should be called with r.mu held.
+func (r *Raft) becomeFollower(term uint64) {
+	if r.role != follower {
+		r.log.Printf("raft: %s stepping down from %s in term %d\n", r.id, r.role, term)
+	}
+	if term > r.currentTerm {
+		r.currentTerm = term
+		r.votedFor = ""
+		r.persist()
+	}
+	r.role = follower
+}
+
+// becomeLeader should be called with r.mu held. A no-op entry is appended so
+// that entries from previous terms are committed as soon as possible.
+func (r *Raft) becomeLeader() {
+	r.log.Printf("raft: %s elected leader for term %d\n", r.id, r.currentTerm)
+	r.role = leader
+	r.leader = r.id
+	r.entries = append(r.entries, transport.RaftEntry{Term: r.currentTerm})
+	r.noopIndex = r.lastIndex()
+	r.persist()
+	for _, p := range r.peers {
+		r.nextIndex[p.address] = r.lastIndex()
+		r.matchIndex[p.address] = 0
+	}
+	r.advanceCommit()
+}
+
+func (r *Raft) startElection() {
+	r.mu.Lock()
+	r.role = candidate
+	r.currentTerm++
+	r.votedFor = r.id
+	r.leader = ""
+	r.lastContact = time.Now()
+	r.persist()
+	term := r.currentTerm
+	args := &transport.VoteRequest{
+		Term:         term,
+		Candidate:    r.id,
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.lastTerm(),
+	}
+	r.log.Printf("raft: %s starting election for term %d\n", r.id, term)
+
+	votes := 1
+	if votes > (len(r.peers)+1)/2 {
+		r.becomeLeader()
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+
+	for _, p := range r.peers {
+		go func(p *peer) {
+			reply, err := r.requestVote(p, args)
+			if err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if reply.Term > r.currentTerm {
+				r.becomeFollower(reply.Term)
+				return
+			}
+
+			if r.role != candidate || r.currentTerm != term || !reply.Granted {
+				return
+			}
+
+			votes++
+			if votes > (len(r.peers)+1)/2 {
+				r.becomeLeader()
+				go r.broadcast()
+			}
+		}(p)
+	}
+}
+
+// broadcast sends AppendEntries to every peer. Peers that are caught up
+// receive a heartbeat.
+func (r *Raft) broadcast() {
+	for _, p := range r.peers {
+		go r.replicate(p)
+	}
+}
+
+func (r *Raft) replicate(p *peer) {
+	r.mu.Lock()
+	if r.role != leader {
+		r.mu.Unlock()
+		return
+	}
+
+	next := r.nextIndex[p.address]
+	if next < 1 {
+		next = 1
+	}
+	prev := next - 1
+	entries := make([]transport.RaftEntry, len(r.entries[next:]))
+	copy(entries, r.entries[next:])
+	term := r.currentTerm
+	args := &transport.AppendRequest{
+		Term:         term,
+		Leader:       r.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  r.entries[prev].Term,
+		Entries:      entries,
+		LeaderCommit: r.commitIndex,
+	}
+	r.mu.Unlock()
+
+	reply, err := r.appendEntries(p, args)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if reply.Term > r.currentTerm {
+		r.becomeFollower(reply.Term)
+		return
+	}
+
+	if r.role != leader || r.currentTerm != term {
+		return
+	}
+
+	if reply.Success {
+		match := prev + uint64(len(entries))
+		if match > r.matchIndex[p.address] {
+			r.matchIndex[p.address] = match
+			r.nextIndex[p.address] = match + 1
+		}
+		r.advanceCommit()
+		return
+	}
+
+	// Back up to where the follower's log diverges and try again on the next
+	// heartbeat.
+	next = reply.ConflictIndex
+	if next < 1 {
+		next = 1
+	}
+	r.nextIndex[p.address] = next
+}
+
+// advanceCommit moves commitIndex forward to the highest index from the
+// current term that's stored on a majority of the cluster. Should be called
+// with r.mu held.
+func (r *Raft) advanceCommit() {
+	for idx := r.lastIndex(); idx > r.commitIndex; idx-- {
+		if r.entries[idx].Term != r.currentTerm {
+			break
+		}
+
+		count := 1
+		for _, p := range r.peers {
+			if r.matchIndex[p.address] >= idx {
+				count++
+			}
+		}
+
+		if count > (len(r.peers)+1)/2 {
+			r.commitIndex = idx
+			r.signalApply()
+			return
+		}
+	}
+}
+
+func (r *Raft) signalApply() {
+	select {
+	case r.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// applyCommitted applies committed entries to the FSM in order.
+func (r *Raft) applyCommitted() {
+	for {
+		select {
+		case <-r.shutdown:
+			return
+		case <-r.applyCh:
+		}
+
+		for {
+			r.mu.Lock()
+			if r.lastApplied >= r.commitIndex {
+				r.mu.Unlock()
+				break
+			}
+			r.lastApplied++
+			idx := r.lastApplied
+			entry := r.entries[idx]
+			w, waiting := r.waiting[idx]
+			delete(r.waiting, idx)
+			r.mu.Unlock()
+
+			// Entries without a command are the no-op entries leaders append when
+			// they're elected.
+			var err error
+			if entry.Command != nil {
+				err = r.fsm.Apply(entry.Command)
+			}
+
+			if waiting {
+				if w.term != entry.Term {
+					err = ErrLeadershipLost
+				}
+				w.done <- err
+			}
+		}
+	}
+}
+
+// RequestVote is invoked by candidates to gather votes.
+func (r *Raft) RequestVote(args *transport.VoteRequest) (*transport.VoteResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if args.Term > r.currentTerm {
+		r.becomeFollower(args.Term)
+	}
+
+	reply := &transport.VoteResponse{Term: r.currentTerm}
+	if args.Term < r.currentTerm {
+		return reply, nil
+	}
+
+	upToDate := args.LastLogTerm > r.lastTerm() ||
+		(args.LastLogTerm == r.lastTerm() && args.LastLogIndex >= r.lastIndex())
+
+	if (r.votedFor == "" || r.votedFor == args.Candidate) && upToDate {
+		r.votedFor = args.Candidate
+		r.lastContact = time.Now()
+		r.persist()
+		reply.Granted = true
+	}
+
+	return reply, nil
+}
+
+// AppendEntries is invoked by the leader to replicate log entries and as a
+// heartbeat.
+func (r *Raft) AppendEntries(args *transport.AppendRequest) (*transport.AppendResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reply := &transport.AppendResponse{Term: r.currentTerm}
+	if args.Term < r.currentTerm {
+		return reply, nil
+	}
+
+	r.becomeFollower(args.Term)
+	r.leader = args.Leader
+	r.lastContact = time.Now()
+	reply.Term = r.currentTerm
+
+	// Log doesn't contain an entry at PrevLogIndex, or the terms don't match.
+	if args.PrevLogIndex > r.lastIndex() {
+		reply.ConflictIndex = r.lastIndex() + 1
+		return reply, nil
+	}
+	if r.entries[args.PrevLogIndex].Term != args.PrevLogTerm {
+		reply.ConflictIndex = args.PrevLogIndex
+		return reply, nil
+	}
+
+	// Append new entries, truncating the log if an existing entry conflicts
+	// with a new one.
+	for i, entry := range args.Entries {
+		idx := args.PrevLogIndex + uint64(i) + 1
+		if idx <= r.lastIndex() {
+			if r.entries[idx].Term == entry.Term {
+				continue
+			}
+			r.entries = r.entries[:idx]
+		}
+		r.entries = append(r.entries, args.Entries[i:]...)
+		r.persist()
+		break
+	}
+
+	if args.LeaderCommit > r.commitIndex {
+		last := args.PrevLogIndex + uint64(len(args.Entries))
+		r.commitIndex = args.LeaderCommit
+		if last < r.commitIndex {
+			r.commitIndex = last
+		}
+		r.signalApply()
+	}
+
+	reply.Success = true
+	return reply, nil
+}
+
+func (r *Raft) connect(p *peer) (transport.RaftClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rpc != nil {
+		return p.rpc, nil
+	}
+
+	c := r.transport()
+	if err := c.Connect(p.address); err != nil {
+		return nil, err
+	}
+	p.rpc = c
+	return c, nil
+}
+
+// disconnect closes the connection to the peer so that the next RPC
+// reconnects.
+func (r *Raft) disconnect(p *peer, c transport.RaftClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rpc == c {
+		c.Close()
+		p.rpc = nil
+	}
+}
+
+func (r *Raft) requestVote(
+	p *peer,
+	args *transport.VoteRequest,
+) (*transport.VoteResponse, error) {
+	c, err := r.connect(p)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.RequestVote(args)
+	if err != nil {
+		r.disconnect(p, c)
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (r *Raft) appendEntries(
+	p *peer,
+	args *transport.AppendRequest,
+) (*transport.AppendResponse, error) {
+	c, err := r.connect(p)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.AppendEntries(args)
+	if err != nil {
+		r.disconnect(p, c)
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// cluster is a set of Raft members that talk to each other in-memory.
+type cluster struct {
+	mu      sync.Mutex
+	members map[string]*Raft
+	fsms    map[string]*FakeFSM
+	down    map[string]bool
+}
+
+type FakeFSM struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (f *FakeFSM) Apply(cmd []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cmds = append(f.cmds, string(cmd))
+	return nil
+}
+
+func (f *FakeFSM) applied() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.cmds...)
+}
+
+type FakeClient struct {
+	c    *cluster
+	addr string
+}
+
+func (f *FakeClient) Connect(addr string) error {
+	f.addr = addr
+	return nil
+}
+
+func (f *FakeClient) Close() error {
+	return nil
+}
+
+func (f *FakeClient) member() (*Raft, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	if f.c.down[f.addr] {
+		return nil, errUnreachable
+	}
+	return f.c.members[f.addr], nil
+}
+
+func (f *FakeClient) RequestVote(args *transport.VoteRequest) (*transport.VoteResponse, error) {
+	m, err := f.member()
+	if err != nil {
+		return nil, err
+	}
+	return m.RequestVote(args)
+}
+
+func (f *FakeClient) AppendEntries(args *transport.AppendRequest) (*transport.AppendResponse, error) {
+	m, err := f.member()
+	if err != nil {
+		return nil, err
+	}
+	return m.AppendEntries(args)
+}
+
+func newCluster(addrs ...string) *cluster {
+	c := &cluster{
+		members: make(map[string]*Raft),
+		fsms:    make(map[string]*FakeFSM),
+		down:    make(map[string]bool),
+	}
+
+	for _, addr := range addrs {
+		fsm := &FakeFSM{}
+		c.fsms[addr] = fsm
+		c.members[addr] = New(Config{
+			ID:                addr,
+			Peers:             addrs,
+			FSM:               fsm,
+			HeartbeatInterval: 10 * time.Millisecond,
+			ElectionTimeout:   50 * time.Millisecond,
+			Transport: func() transport.RaftClient {
+				return &FakeClient{c: c}
+			},
+		})
+	}
+
+	for _, m := range c.members {
+		m.Start()
+	}
+
+	return c
+}
+
+func (c *cluster) shutdown() {
+	for _, m := range c.members {
+		m.Shutdown()
+	}
+}
+
+// stop makes a member unreachable and stops it.
+func (c *cluster) stop(addr string) {
+	c.mu.Lock()
+	c.down[addr] = true
+	c.mu.Unlock()
+	c.members[addr].Shutdown()
+}
+
+// waitForLeader waits until exactly one reachable member is the leader.
+func (c *cluster) waitForLeader(t *testing.T) *Raft {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*Raft
+		for addr, m := range c.members {
+			if !c.down[addr] && m.IsLeader() {
+				leaders = append(leaders, m)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timeout waiting for a leader to be elected")
+	return nil
+}
+
+func (c *cluster) waitForApplied(t *testing.T, addr string, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	var got []string
+	for time.Now().Before(deadline) {
+		if got = c.fsms[addr].applied(); cmp.Equal(want, got) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("unexpected commands applied on %s\n  want: %#v\n  got: %#v", addr, want, got)
+}
+
+func TestSingleMember(t *testing.T) {
+	c := newCluster("a")
+	defer c.shutdown()
+
+	leader := c.waitForLeader(t)
+	if err := leader.Apply([]byte("hello"), time.Second); err != nil {
+		t.Fatalf("Apply(hello) unexpected error\n  got: %#v", err)
+	}
+	c.waitForApplied(t, "a", []string{"hello"})
+
+	// The no-op entry is applied before hello.
+	if !leader.Ready() {
+		t.Fatal("leader isn't ready after applying a command")
+	}
+}
+
+// A member of a two member cluster can't be elected without the other's vote.
+func TestTwoMembers(t *testing.T) {
+	c := newCluster("a", "b")
+	defer c.shutdown()
+	c.stop("b")
+
+	time.Sleep(300 * time.Millisecond)
+	if c.members["a"].IsLeader() {
+		t.Fatal("a was elected leader without a majority")
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	c := newCluster("a", "b", "c")
+	defer c.shutdown()
+
+	leader := c.waitForLeader(t)
+	for _, cmd := range []string{"one", "two"} {
+		if err := leader.Apply([]byte(cmd), time.Second); err != nil {
+			t.Fatalf("Apply(%s) unexpected error\n  got: %#v", cmd, err)
+		}
+	}
+
+	for addr := range c.members {
+		c.waitForApplied(t, addr, []string{"one", "two"})
+	}
+}
+
+func TestApplyNotLeader(t *testing.T) {
+	c := newCluster("a", "b", "c")
+	defer c.shutdown()
+
+	leader := c.waitForLeader(t)
+	for _, m := range c.members {
+		if m == leader {
+			continue
+		}
+		if err := m.Apply([]byte("hello"), time.Second); err != ErrNotLeader {
+			t.Errorf("Apply(hello) unexpected error\n  want: %#v\n  got: %#v", ErrNotLeader, err)
+		}
+
+		// Followers learn about the leader from the first heartbeat.
+		deadline := time.Now().Add(time.Second)
+		for m.Leader() != leader.id && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := m.Leader(); got != leader.id {
+			t.Errorf("Leader() unexpected address\n  want: %s\n  got: %s", leader.id, got)
+		}
+	}
+}
+
+// When the leader fails, a new leader is elected that has every committed
+// command.
+func TestLeaderFailure(t *testing.T) {
+	c := newCluster("a", "b", "c")
+	defer c.shutdown()
+
+	leader := c.waitForLeader(t)
+	if err := leader.Apply([]byte("one"), time.Second); err != nil {
+		t.Fatalf("Apply(one) unexpected error\n  got: %#v", err)
+	}
+
+	c.stop(leader.id)
+	newLeader := c.waitForLeader(t)
+	if newLeader == leader {
+		t.Fatal("expected a new leader to be elected")
+	}
+
+	if err := newLeader.Apply([]byte("two"), time.Second); err != nil {
+		t.Fatalf("Apply(two) unexpected error\n  got: %#v", err)
+	}
+
+	for addr := range c.members {
+		if addr != leader.id {
+			c.waitForApplied(t, addr, []string{"one", "two"})
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package boltdb
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/despreston/go-craq/store"
 	"github.com/despreston/go-craq/store/storetest"
 )
 
@@ -33,3 +36,43 @@ func TestStorer(t *testing.T) {
 	})
 
 }
+
+// EachCommitted reads the store in chunks, and carries on from the last key of
+// each chunk.
+func TestEachCommittedChunks(t *testing.T) {
+	db := New(filepath.Join(t.TempDir(), "test.db"), "test-bucket")
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Unexpected error connecting to test database\n  %#v", err.Error())
+	}
+	defer db.DB.Close()
+
+	const keys = eachChunkSize*2 + 1
+	items := make([]*store.Item, keys)
+	versions := make(map[string]uint64, keys)
+	for i := range items {
+		key := fmt.Sprintf("key-%05d", i)
+		items[i] = &store.Item{Key: key, Version: 1}
+		versions[key] = 1
+	}
+	if err := db.WriteAll(items); err != nil {
+		t.Fatalf("WriteAll() unexpected error\n  got: %#v", err)
+	}
+	if err := db.CommitAll(versions); err != nil {
+		t.Fatalf("CommitAll() unexpected error\n  got: %#v", err)
+	}
+
+	i := 0
+	err := db.EachCommitted("", func(item *store.Item) error {
+		if item.Key != items[i].Key {
+			return fmt.Errorf("unexpected key %s, want %s", item.Key, items[i].Key)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachCommitted() unexpected error\n  got: %#v", err)
+	}
+	if i != keys {
+		t.Fatalf("EachCommitted() unexpected number of items\n  want: %d\n  got: %d", keys, i)
+	}
+}
@@ -6,15 +6,20 @@ package boltdb
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/despreston/go-craq/store"
 	bolt "go.etcd.io/bbolt"
 )
 
+// eachChunkSize is how many keys EachCommitted reads in one transaction.
+const eachChunkSize = 1000
+
 type Bolt struct {
-	DB     *bolt.DB
-	file   string
-	bucket []byte
+	DB        *bolt.DB
+	file      string
+	bucket    []byte
+	retention store.Retention
 }
 
 func New(f, b string) *Bolt {
@@ -24,6 +29,13 @@ func New(f, b string) *Bolt {
 	}
 }
 
+// SetRetention changes which committed versions of each key are kept. It takes
+// effect on the next commit of each key. It's not safe to call while the
+// store is in use.
+func (b *Bolt) SetRetention(r store.Retention) {
+	b.retention = r
+}
+
 func (b *Bolt) Connect() error {
 	DB, err := bolt.Open(b.file, 0600, nil)
 	if err != nil {
@@ -69,46 +81,123 @@ func (b *Bolt) Read(key string) (*store.Item, error) {
 		return nil, store.ErrDirtyItem
 	}
 
+	if items[len(items)-1].Deleted {
+		return nil, store.ErrNotFound
+	}
+
 	return items[len(items)-1], nil
 }
 
 func (b *Bolt) Write(key string, val []byte, version uint64) error {
-	var v []*store.Item
-	k := []byte(key)
+	return b.write(&store.Item{Value: val, Version: version, Key: key})
+}
 
-	item := &store.Item{
-		Committed: false,
-		Value:     val,
-		Version:   version,
-		Key:       key,
-	}
+func (b *Bolt) Delete(key string, version uint64) error {
+	return b.write(&store.Item{Version: version, Key: key, Deleted: true})
+}
+
+func (b *Bolt) write(item *store.Item) error {
+	return b.WriteAll([]*store.Item{item})
+}
 
+// WriteAll writes every item in a single transaction.
+func (b *Bolt) WriteAll(items []*store.Item) error {
 	return b.DB.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(b.bucket)
-		existing := bucket.Get(k)
-
-		if existing == nil {
-			v = append(v, item)
-		} else {
-			items, err := store.DecodeMany(existing)
-			if err != nil {
-				log.Printf("Write() decoding error\n  %#v", err)
+		for _, item := range items {
+			if err := writeItem(bucket, item); err != nil {
 				return err
 			}
-			v = append(items, item)
 		}
+		return nil
+	})
+}
+
+func writeItem(bucket *bolt.Bucket, item *store.Item) error {
+	var v []*store.Item
+	k := []byte(item.Key)
+	existing := bucket.Get(k)
 
-		encoded, err := store.Encode(v)
+	if existing == nil {
+		v = append(v, item)
+	} else {
+		items, err := store.DecodeMany(existing)
 		if err != nil {
-			log.Printf("Write() encode error\n  %#v", err)
+			log.Printf("Write() decoding error\n  %#v", err)
 			return err
 		}
+		v = append(items, item)
+	}
 
-		return bucket.Put(k, encoded)
-	})
+	encoded, err := store.Encode(v)
+	if err != nil {
+		log.Printf("Write() encode error\n  %#v", err)
+		return err
+	}
+
+	return bucket.Put(k, encoded)
 }
 
 func (b *Bolt) Commit(key string, version uint64) error {
+	return b.CommitAll(map[string]uint64{key: version})
+}
+
+// CommitAll commits every version in a single transaction. If any version is
+// missing, the transaction is rolled back.
+func (b *Bolt) CommitAll(versions map[string]uint64) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		for key, version := range versions {
+			if err := commitItem(bucket, key, version, b.retention); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func commitItem(
+	bucket *bolt.Bucket,
+	key string,
+	version uint64,
+	retention store.Retention,
+) error {
+	k := []byte(key)
+	result := bucket.Get(k)
+
+	if result == nil {
+		return store.ErrNotFound
+	}
+
+	items, err := store.DecodeMany(result)
+	if err != nil {
+		return err
+	}
+
+	// Find the index of the version being committed.
+	found := -1
+	for i, itm := range items {
+		if itm.Version == version {
+			found = i
+			break
+		}
+	}
+
+	if found < 0 {
+		return store.ErrNotFound
+	}
+
+	items = retention.CommitAt(items, found)
+
+	encoded, err := store.Encode(items)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(k, encoded)
+}
+
+func (b *Bolt) Purge(key string, version uint64) error {
 	k := []byte(key)
 
 	return b.DB.Update(func(tx *bolt.Tx) error {
@@ -116,7 +205,7 @@ func (b *Bolt) Commit(key string, version uint64) error {
 		result := bucket.Get(k)
 
 		if result == nil {
-			return store.ErrNotFound
+			return nil
 		}
 
 		items, err := store.DecodeMany(result)
@@ -124,28 +213,12 @@ func (b *Bolt) Commit(key string, version uint64) error {
 			return err
 		}
 
-		// Find index of older versions and mark the item that matches the version
-		// being committed as committed.
-		var older int
-		for i, itm := range items {
-			if itm.Version == version {
-				itm.Committed = true
-				older = i - 1
-				break
-			}
-		}
-
-		// Remove older items
-		if older > -1 {
-			items = items[older+1:]
+		latest := items[len(items)-1]
+		if !latest.Deleted || !latest.Committed || latest.Version != version {
+			return nil
 		}
 
-		encoded, err := store.Encode(items)
-		if err != nil {
-			return err
-		}
-
-		return bucket.Put(k, encoded)
+		return bucket.Delete(k)
 	})
 }
 
@@ -176,6 +249,29 @@ func (b *Bolt) ReadVersion(key string, version uint64) (*store.Item, error) {
 	return nil, store.ErrNotFound
 }
 
+// History returns every version of key, oldest first, that the retention
+// keeps.
+func (b *Bolt) History(key string) ([]*store.Item, error) {
+	var result []byte
+
+	b.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(b.bucket)
+		result = b.Get([]byte(key))
+		return nil
+	})
+
+	if result == nil {
+		return nil, store.ErrNotFound
+	}
+
+	items, err := store.DecodeMany(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.retention.Trim(items, time.Now()), nil
+}
+
 func (b *Bolt) AllNewerCommitted(verByKey map[string]uint64) ([]*store.Item, error) {
 	newer := []*store.Item{}
 
@@ -266,25 +362,88 @@ func (b *Bolt) AllDirty() ([]*store.Item, error) {
 	return dirty, nil
 }
 
-func (b *Bolt) AllCommitted() ([]*store.Item, error) {
-	committed := []*store.Item{}
+// EachCommitted reads the keys in chunks of eachChunkSize, each in it's own
+// read transaction, and calls fn between transactions. That way fn can write to
+// the store, and a slow fn doesn't keep a transaction open.
+func (b *Bolt) EachCommitted(after string, fn func(*store.Item) error) error {
+	for {
+		chunk, err := b.committedChunk(after)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range chunk {
+			if err := fn(item); err != nil {
+				if err == store.ErrStop {
+					return nil
+				}
+				return err
+			}
+		}
+
+		if len(chunk) < eachChunkSize {
+			return nil
+		}
+		after = chunk[len(chunk)-1].Key
+	}
+}
+
+// committedChunk returns the latest committed item of up to eachChunkSize keys
+// after after.
+func (b *Bolt) committedChunk(after string) ([]*store.Item, error) {
+	chunk := []*store.Item{}
 
 	err := b.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(b.bucket)
-		c := b.Cursor()
+		c := tx.Bucket(b.bucket).Cursor()
+
+		for k, v := c.Seek([]byte(after)); k != nil; k, v = c.Next() {
+			if string(k) <= after {
+				continue
+			}
+			if len(chunk) == eachChunkSize {
+				break
+			}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
 			items, err := store.DecodeMany(v)
 			if err != nil {
-				log.Printf("Error decoding items for key %s in AllCommitted\n", k)
+				log.Printf("Error decoding items for key %s in EachCommitted\n", k)
 				return err
 			}
 
-			for _, item := range items {
-				if item.Committed {
-					committed = append(committed, item)
-				}
+			if item := store.LatestCommitted(items); item != nil {
+				chunk = append(chunk, item)
+			}
+		}
+
+		return nil
+	})
+
+	return chunk, err
+}
+
+// Scan seeks to start and walks the keys in order, so only the keys that are
+// returned are decoded.
+func (b *Bolt) Scan(start, end string, limit int) ([]*store.Item, error) {
+	items := []*store.Item{}
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(b.bucket).Cursor()
+
+		for k, v := c.Seek([]byte(start)); k != nil; k, v = c.Next() {
+			if !store.InRange(string(k), start, end) {
+				break
+			}
+			if limit > 0 && len(items) == limit {
+				break
+			}
+
+			forKey, err := store.DecodeMany(v)
+			if err != nil {
+				log.Printf("Error decoding items for key %s in Scan\n", k)
+				return err
 			}
+
+			items = append(items, forKey[len(forKey)-1])
 		}
 
 		return nil
@@ -293,5 +452,10 @@ func (b *Bolt) AllCommitted() ([]*store.Item, error) {
 	if err != nil {
 		return nil, err
 	}
-	return committed, nil
+	return items, nil
+}
+
+func (b *Bolt) ScanPrefix(prefix, cursor string, limit int) ([]*store.Item, error) {
+	start, end := store.PrefixRange(prefix, cursor)
+	return b.Scan(start, end, limit)
 }
@@ -4,15 +4,18 @@ package kv
 
 import (
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/despreston/go-craq/store"
 )
 
 // KV is an in-memory key/value storage.
 type KV struct {
-	items map[string][]*store.Item
-	mu    sync.Mutex
+	items     map[string][]*store.Item
+	retention store.Retention
+	mu        sync.Mutex
 }
 
 // New store
@@ -22,6 +25,14 @@ func New() *KV {
 	}
 }
 
+// SetRetention changes which committed versions of each key are kept. It takes
+// effect on the next commit of each key.
+func (s *KV) SetRetention(r store.Retention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = r
+}
+
 func (s *KV) lookup(key string) ([]*store.Item, bool) {
 	items := s.items[key]
 	if len(items) == 0 {
@@ -30,9 +41,10 @@ func (s *KV) lookup(key string) ([]*store.Item, bool) {
 	return items, true
 }
 
-// Read an item from the store by key. If there is an uncommitted (dirty)
-// version of the item in the store, it returns a ErrDirtystore.Item error. If
-// no item exists for that key it returns a ErrNotFound error.
+// Read the latest committed item from the store by key. If there is an
+// uncommitted (dirty) version of the item in the store, it returns a
+// ErrDirtyItem error. If no item exists for that key, or the key was deleted,
+// it returns a ErrNotFound error.
 func (s *KV) Read(key string) (*store.Item, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -42,11 +54,17 @@ func (s *KV) Read(key string) (*store.Item, error) {
 		return nil, store.ErrNotFound
 	}
 
-	if !items[len(items)-1].Committed {
+	latest := items[len(items)-1]
+
+	if !latest.Committed {
 		return nil, store.ErrDirtyItem
 	}
 
-	return items[0], nil
+	if latest.Deleted {
+		return nil, store.ErrNotFound
+	}
+
+	return latest, nil
 }
 
 // ReadVersion finds an item for the given key with the matching version. If no
@@ -69,51 +87,107 @@ func (s *KV) ReadVersion(key string, version uint64) (*store.Item, error) {
 	return nil, store.ErrNotFound
 }
 
+// History returns every version of key, oldest first, that the retention
+// keeps.
+func (s *KV) History(key string) ([]*store.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items, has := s.lookup(key)
+	if !has {
+		return nil, store.ErrNotFound
+	}
+
+	kept := s.retention.Trim(items, time.Now())
+	return append([]*store.Item{}, kept...), nil
+}
+
 // Write a new item to the store.
 func (s *KV) Write(key string, val []byte, version uint64) error {
+	return s.write(&store.Item{Value: val, Version: version, Key: key})
+}
+
+// Delete writes a new tombstone for the key.
+func (s *KV) Delete(key string, version uint64) error {
+	return s.write(&store.Item{Version: version, Key: key, Deleted: true})
+}
+
+func (s *KV) write(item *store.Item) error {
+	return s.WriteAll([]*store.Item{item})
+}
+
+// WriteAll writes every item while holding the lock, so readers never see
+// some of the items without the others.
+func (s *KV) WriteAll(items []*store.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		s.items[item.Key] = append(s.items[item.Key], item)
+	}
+	return nil
+}
+
+// Purge removes the key if the latest item is a committed tombstone with the
+// given version.
+func (s *KV) Purge(key string, version uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	item := store.Item{
-		Committed: false,
-		Value:     val,
-		Version:   version,
-		Key:       key,
+	items, has := s.lookup(key)
+	if !has {
+		return nil
+	}
+
+	latest := items[len(items)-1]
+	if latest.Deleted && latest.Committed && latest.Version == version {
+		delete(s.items, key)
 	}
 
-	s.items[key] = append(s.items[key], &item)
 	return nil
 }
 
 // Commit a version for the given key.
 func (s *KV) Commit(key string, version uint64) error {
+	return s.CommitAll(map[string]uint64{key: version})
+}
+
+// CommitAll commits every version while holding the lock. Every version is
+// looked up before anything is changed, so either all of them are committed or
+// none are.
+func (s *KV) CommitAll(versions map[string]uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Index of the version being committed, by key.
+	found := make(map[string]int, len(versions))
+	for key, version := range versions {
+		i, ok := s.indexOf(key, version)
+		if !ok {
+			return store.ErrNotFound
+		}
+		found[key] = i
+	}
+
+	for key, i := range found {
+		s.items[key] = s.retention.CommitAt(s.items[key], i)
+		log.Printf("Marked version %d of key %s committed.\n", versions[key], key)
+	}
+
+	return nil
+}
+
+// indexOf finds the index of the version in the items of key.
+func (s *KV) indexOf(key string, version uint64) (int, bool) {
 	items, has := s.lookup(key)
 	if !has {
-		return store.ErrNotFound
+		return 0, false
 	}
-
-	// Update the committed flag and find index in items where version is older
-	// than item.version. If this version is the oldest for this key, the index
-	// will be -1.
-	var older int
 	for i, itm := range items {
 		if itm.Version == version {
-			itm.Committed = true
-			older = i - 1
-			break
+			return i, true
 		}
 	}
-
-	// Remove the older items if there are any.
-	if older > -1 {
-		s.items[key] = s.items[key][older+1:]
-	}
-
-	log.Printf("Marked version %d of key %s committed.\n", version, key)
-	return nil
+	return 0, false
 }
 
 // AllNewerCommitted returns all committed items who's key is not in keyVersions
@@ -176,20 +250,69 @@ func (s *KV) AllDirty() ([]*store.Item, error) {
 	return dirty, nil
 }
 
-// AllCommitted returns all committed items.
-func (s *KV) AllCommitted() ([]*store.Item, error) {
+// EachCommitted calls fn with the latest committed item of every key after
+// after. The items are already in memory, so the matching ones are collected
+// and sorted while holding the lock, and fn is called once it's released.
+func (s *KV) EachCommitted(after string, fn func(*store.Item) error) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	committed := []*store.Item{}
+	for key, forKey := range s.items {
+		if key <= after {
+			continue
+		}
+		if item := store.LatestCommitted(forKey); item != nil {
+			committed = append(committed, item)
+		}
+	}
+	s.mu.Unlock()
 
-	for _, forKey := range s.items {
-		for _, item := range forKey {
-			if item.Committed {
-				committed = append(committed, item)
+	sort.Slice(committed, func(i, j int) bool {
+		return committed[i].Key < committed[j].Key
+	})
+
+	for _, item := range committed {
+		if err := fn(item); err != nil {
+			if err == store.ErrStop {
+				return nil
 			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Scan returns the newest item of up to limit keys from start to end. The keys
+// aren't kept in order, so every key is looked at and the ones in range are
+// sorted.
+func (s *KV) Scan(start, end string, limit int) ([]*store.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.items {
+		if store.InRange(key, start, end) {
+			keys = append(keys, key)
 		}
 	}
+	sort.Strings(keys)
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	items := make([]*store.Item, len(keys))
+	for i, key := range keys {
+		forKey := s.items[key]
+		items[i] = forKey[len(forKey)-1]
+	}
+
+	return items, nil
+}
 
-	return committed, nil
+// ScanPrefix returns the newest item of up to limit keys that start with
+// prefix and come after cursor.
+func (s *KV) ScanPrefix(prefix, cursor string, limit int) ([]*store.Item, error) {
+	start, end := store.PrefixRange(prefix, cursor)
+	return s.Scan(start, end, limit)
 }
@@ -3,7 +3,9 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/despreston/go-craq/store"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,17 +15,28 @@ import (
 
 const collName = "items"
 
+// ErrStandalone is returned by Connect when the server isn't part of a replica
+// set or a sharded cluster. WriteAll and CommitAll need transactions, which
+// standalone servers don't have.
+var ErrStandalone = errors.New("MongoDB server is standalone; transactions need a replica set or a sharded cluster")
+
 type item struct {
 	Version   uint64 `bson:"version"`
 	Committed bool   `bson:"committed"`
 	Value     []byte `bson:"value"`
 	Key       string `bson:"key"`
+	Deleted   bool   `bson:"deleted"`
+	// CommittedAt is stored as a BSON date, so it's only precise to the
+	// millisecond.
+	CommittedAt time.Time `bson:"committedAt"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
 }
 
 type MongoDB struct {
-	client *mongo.Client
-	db     *mongo.Database
-	coll   *mongo.Collection
+	client    *mongo.Client
+	db        *mongo.Database
+	coll      *mongo.Collection
+	retention store.Retention
 }
 
 func New(db string, opts ...*options.ClientOptions) (*MongoDB, error) {
@@ -44,8 +57,35 @@ func New(db string, opts ...*options.ClientOptions) (*MongoDB, error) {
 	return m, nil
 }
 
+// SetRetention changes which committed versions of each key are kept. It takes
+// effect on the next commit of each key. It's not safe to call while the
+// store is in use.
+func (m *MongoDB) SetRetention(r store.Retention) {
+	m.retention = r
+}
+
+// Connect connects to the server and checks that it supports transactions. It
+// returns ErrStandalone if it doesn't.
 func (m *MongoDB) Connect(ctx context.Context) error {
-	return m.client.Connect(ctx)
+	if err := m.client.Connect(ctx); err != nil {
+		return err
+	}
+
+	var res struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	cmd := bson.D{{Key: "isMaster", Value: 1}}
+	if err := m.client.Database("admin").RunCommand(ctx, cmd).Decode(&res); err != nil {
+		m.client.Disconnect(ctx)
+		return err
+	}
+	// mongos replies with msg "isdbgrid"
+	if res.SetName == "" && res.Msg != "isdbgrid" {
+		m.client.Disconnect(ctx)
+		return ErrStandalone
+	}
+	return nil
 }
 
 func (m *MongoDB) Disconnect(ctx context.Context) error {
@@ -62,7 +102,7 @@ func (m *MongoDB) Read(key string) (*store.Item, error) {
 	limit := int64(1)
 
 	opts := options.FindOptions{
-		Sort:  bson.M{"version": 1},
+		Sort:  bson.M{"version": -1},
 		Limit: &limit,
 	}
 
@@ -86,6 +126,10 @@ func (m *MongoDB) Read(key string) (*store.Item, error) {
 		return nil, store.ErrDirtyItem
 	}
 
+	if items[0].Deleted {
+		return nil, store.ErrNotFound
+	}
+
 	si := store.Item(items[0])
 	return &si, nil
 }
@@ -102,21 +146,137 @@ func (m *MongoDB) Write(key string, val []byte, version uint64) error {
 	return err
 }
 
+func (m *MongoDB) Delete(key string, version uint64) error {
+	itm := item{
+		Version:   version,
+		Committed: false,
+		Key:       key,
+		Deleted:   true,
+	}
+
+	_, err := m.coll.InsertOne(context.TODO(), itm)
+	return err
+}
+
+func (m *MongoDB) Purge(key string, version uint64) error {
+	// Only purge if there's nothing newer than the committed tombstone.
+	newer := bson.M{"key": key, "version": bson.M{"$gt": version}}
+	n, err := m.coll.CountDocuments(context.TODO(), newer)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	tombstone := bson.M{
+		"key":       key,
+		"version":   version,
+		"committed": true,
+		"deleted":   true,
+	}
+	n, err = m.coll.CountDocuments(context.TODO(), tombstone)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	_, err = m.coll.DeleteMany(context.TODO(), bson.M{"key": key})
+	return err
+}
+
 func (m *MongoDB) Commit(key string, version uint64) error {
-	// mark version committed
+	return m.commit(context.TODO(), key, version)
+}
+
+func (m *MongoDB) commit(ctx context.Context, key string, version uint64) error {
 	filter := bson.M{"key": key, "version": version}
-	update := bson.M{"$set": bson.M{"committed": true}}
-	updateRes := m.coll.FindOneAndUpdate(context.TODO(), filter, update)
-	if err := updateRes.Err(); err != nil {
+	if err := m.coll.FindOne(ctx, filter).Err(); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return store.ErrNotFound
 		}
+		return err
+	}
+
+	// mark version, and every older version that isn't yet, committed
+	now := time.Now()
+	dirty := bson.M{
+		"key":       key,
+		"version":   bson.M{"$lte": version},
+		"committed": false,
+	}
+	update := bson.M{"$set": bson.M{"committed": true, "committedAt": now}}
+	if _, err := m.coll.UpdateMany(ctx, dirty, update); err != nil {
+		return err
+	}
+
+	// find the newest version the retention doesn't keep, and delete it along
+	// with everything older
+	var oldest item
+	skip := int64(m.retention.Kept())
+	opts := options.FindOne().SetSort(bson.M{"version": -1}).SetSkip(skip)
+	kept := bson.M{"key": key, "version": bson.M{"$lte": version}}
+	if err := m.coll.FindOne(ctx, kept, opts).Decode(&oldest); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	cleared := bson.M{"key": key, "version": bson.M{"$lte": oldest.Version}}
+	if m.retention.MaxAge > 0 {
+		cleared["committedAt"] = bson.M{"$lte": now.Add(-m.retention.MaxAge)}
+	}
+	_, err := m.coll.DeleteMany(ctx, cleared)
+
+	return err
+}
+
+// WriteAll inserts every item in a MongoDB transaction.
+func (m *MongoDB) WriteAll(items []*store.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(items))
+	for i, itm := range items {
+		docs[i] = item{
+			Version:   itm.Version,
+			Value:     itm.Value,
+			Key:       itm.Key,
+			Deleted:   itm.Deleted,
+			ExpiresAt: itm.ExpiresAt,
+		}
 	}
 
-	// delete older versions
-	older := bson.M{"key": key, "version": bson.M{"$lt": version}}
-	_, err := m.coll.DeleteMany(context.TODO(), older)
+	return m.inTransaction(func(ctx context.Context) error {
+		_, err := m.coll.InsertMany(ctx, docs)
+		return err
+	})
+}
+
+// CommitAll commits every version in a MongoDB transaction.
+func (m *MongoDB) CommitAll(versions map[string]uint64) error {
+	return m.inTransaction(func(ctx context.Context) error {
+		for key, version := range versions {
+			if err := m.commit(ctx, key, version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
+// inTransaction runs fn in a transaction.
+func (m *MongoDB) inTransaction(fn func(context.Context) error) error {
+	sess, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.TODO())
+
+	_, err = sess.WithTransaction(
+		context.TODO(),
+		func(ctx mongo.SessionContext) (interface{}, error) {
+			return nil, fn(ctx)
+		},
+	)
 	return err
 }
 
@@ -136,6 +296,33 @@ func (m *MongoDB) ReadVersion(key string, version uint64) (*store.Item, error) {
 	return &si, nil
 }
 
+// History returns every version of key, oldest first, that the retention
+// keeps.
+func (m *MongoDB) History(key string) ([]*store.Item, error) {
+	opts := options.Find().SetSort(bson.M{"version": 1})
+	res, err := m.coll.Find(context.TODO(), bson.M{"key": key}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []item
+
+	if err := res.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+
+	if len(items) < 1 {
+		return nil, store.ErrNotFound
+	}
+
+	si := make([]*store.Item, len(items))
+	for i := range items {
+		si[i] = (*store.Item)(&items[i])
+	}
+
+	return m.retention.Trim(si, time.Now()), nil
+}
+
 func (m *MongoDB) AllNewerCommitted(verBykey map[string]uint64) ([]*store.Item, error) {
 	filter := bson.M{"committed": true}
 	or := bson.A{}
@@ -150,23 +337,7 @@ func (m *MongoDB) AllNewerCommitted(verBykey map[string]uint64) ([]*store.Item,
 	or = append(or, bson.M{"key": bson.M{"$nin": keys}})
 	filter["$or"] = or
 
-	var items []item
-
-	res, err := m.coll.Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := res.All(context.TODO(), &items); err != nil {
-		return nil, err
-	}
-
-	si := make([]*store.Item, len(items))
-	for i, _ := range items {
-		si[i] = (*store.Item)(&items[i])
-	}
-
-	return si, nil
+	return m.latestCommitted(filter)
 }
 
 func (m *MongoDB) AllNewerDirty(verBykey map[string]uint64) ([]*store.Item, error) {
@@ -222,8 +393,52 @@ func (m *MongoDB) AllDirty() ([]*store.Item, error) {
 	return si, nil
 }
 
-func (m *MongoDB) AllCommitted() ([]*store.Item, error) {
-	res, err := m.coll.Find(context.TODO(), bson.M{"committed": true})
+// EachCommitted walks a cursor over the committed items sorted by key, newest
+// version first, and calls fn with the first item of each key. The driver
+// fetches the items from the server a batch at a time as the cursor moves.
+func (m *MongoDB) EachCommitted(after string, fn func(*store.Item) error) error {
+	ctx := context.TODO()
+	filter := bson.M{"committed": true, "key": bson.M{"$gt": after}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "key", Value: 1}, {Key: "version", Value: -1}}).
+		SetBatchSize(1000).
+		SetAllowDiskUse(true)
+
+	res, err := m.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer res.Close(ctx)
+
+	var last string
+	for res.Next(ctx) {
+		var itm item
+		if err := res.Decode(&itm); err != nil {
+			return err
+		}
+
+		// Older versions of a key come right after the newest one.
+		if itm.Key == last {
+			continue
+		}
+		last = itm.Key
+
+		if err := fn((*store.Item)(&itm)); err != nil {
+			if err == store.ErrStop {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return res.Err()
+}
+
+// latestCommitted returns the newest item of each key that matches filter.
+// Older committed versions kept by the retention are left out.
+func (m *MongoDB) latestCommitted(filter bson.M) ([]*store.Item, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "key", Value: 1}, {Key: "version", Value: 1}})
+	res, err := m.coll.Find(context.TODO(), filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -234,10 +449,56 @@ func (m *MongoDB) AllCommitted() ([]*store.Item, error) {
 		return nil, err
 	}
 
-	si := make([]*store.Item, len(items))
-	for i, _ := range items {
-		si[i] = (*store.Item)(&items[i])
+	si := []*store.Item{}
+	for i := range items {
+		if i+1 < len(items) && items[i+1].Key == items[i].Key {
+			continue
+		}
+		si = append(si, (*store.Item)(&items[i]))
+	}
+
+	return si, nil
+}
+
+// Scan groups the versions of each key in range and keeps the newest one.
+func (m *MongoDB) Scan(start, end string, limit int) ([]*store.Item, error) {
+	keyRange := bson.M{"$gte": start}
+	if end != "" {
+		keyRange["$lt"] = end
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"key": keyRange}}},
+		{{Key: "$sort", Value: bson.D{{Key: "key", Value: 1}, {Key: "version", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$key", "newest": bson.M{"$last": "$$ROOT"}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+
+	res, err := m.coll.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Newest item `bson:"newest"`
+	}
+
+	if err := res.All(context.TODO(), &groups); err != nil {
+		return nil, err
+	}
+
+	si := make([]*store.Item, len(groups))
+	for i := range groups {
+		si[i] = (*store.Item)(&groups[i].Newest)
 	}
 
 	return si, nil
 }
+
+func (m *MongoDB) ScanPrefix(prefix, cursor string, limit int) ([]*store.Item, error) {
+	start, end := store.PrefixRange(prefix, cursor)
+	return m.Scan(start, end, limit)
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
+	"time"
 )
 
 var (
@@ -16,40 +17,189 @@ var (
 	// ErrDirtyItem should be returned by storage if the latest version for the
 	// key has not been committed yet.
 	ErrDirtyItem = errors.New("key has an uncommitted version")
+
+	// ErrStop can be returned by the function passed to EachCommitted to stop
+	// early. EachCommitted returns nil when it does.
+	ErrStop = errors.New("stop iterating")
 )
 
 type Storer interface {
-	// Read an item from the store by key. If there is an uncommitted (dirty)
-	// version of the item in the store, it returns a ErrDirtyItem error. If
-	// no item exists for that key it returns a ErrNotFound error.
+	// Read the latest committed item from the store by key. If there is an
+	// uncommitted (dirty) version of the item in the store, it returns a
+	// ErrDirtyItem error. If no item exists for that key it returns a
+	// ErrNotFound error.
 	Read(key string) (*Item, error)
 
 	// Write a new item to the store.
 	Write(key string, val []byte, version uint64) error
 
-	// Commit a version for the given key. All items with matching key and older
-	// than version are cleared.
+	// Delete writes a new, uncommitted tombstone for the key. Tombstones are
+	// versioned and committed like any other item. Once the tombstone is the
+	// latest committed version of the key, Read returns ErrNotFound.
+	Delete(key string, version uint64) error
+
+	// Purge removes every item for the key, but only if the latest item is a
+	// committed tombstone with the given version. It's used to garbage-collect
+	// tombstones once every node in the chain has seen them.
+	Purge(key string, version uint64) error
+
+	// Commit a version for the given key. Older versions of the key are
+	// committed too, then cleared, except for the committed versions kept by
+	// the store's Retention. If the version doesn't exist, ErrNotFound is
+	// returned.
 	Commit(key string, version uint64) error
 
-	// ReadVersion finds an item for the given key with the matching version. If
-	// no item is found for that version of key, ErrNotFound is returned
+	// WriteAll writes several new, uncommitted items as one unit: either every
+	// item is written or none are. Items with Deleted set are written as
+	// tombstones. Unlike Write, WriteAll keeps the items' ExpiresAt.
+	WriteAll(items []*Item) error
+
+	// CommitAll commits a version of several keys as one unit, the same way
+	// Commit does for a single key. Readers see either every version committed
+	// or none of them. If any of the versions doesn't exist, nothing is
+	// committed and ErrNotFound is returned.
+	CommitAll(versions map[string]uint64) error
+
+	// ReadVersion finds an item for the given key with the matching version,
+	// committed or not. If no item is found for that version of key,
+	// ErrNotFound is returned
 	ReadVersion(key string, version uint64) (*Item, error)
 
 	// AllNewerCommitted returns all committed items who's key is not in
 	// versionsByKey or who's version is higher than the versions in
-	// versionsByKey.
+	// versionsByKey. Tombstones are included.
 	AllNewerCommitted(versionsByKey map[string]uint64) ([]*Item, error)
 
 	// AllNewerDirty returns all uncommitted items who's key is not in
 	// versionsByKey or who's version is higher than the versions in
-	// versionsByKey.
+	// versionsByKey. Tombstones are included.
 	AllNewerDirty(versionsByKey map[string]uint64) ([]*Item, error)
 
 	// AllDirty returns all uncommitted items.
 	AllDirty() ([]*Item, error)
 
-	// AllCommitted returns all committed items.
-	AllCommitted() ([]*Item, error)
+	// EachCommitted calls fn with the latest committed item of every key that
+	// comes after the key after, in key order, including tombstones. An empty
+	// after starts from the first key. Items are read from the store a chunk at
+	// a time as fn consumes them, so the store is never read into memory all at
+	// once, and fn may call the store's other methods. If fn returns an error,
+	// EachCommitted stops and returns it, unless it's ErrStop.
+	EachCommitted(after string, fn func(*Item) error) error
+
+	// History returns every version of key the store has, oldest first,
+	// including uncommitted versions and tombstones. Committed versions the
+	// store's Retention no longer keeps are left out, even if they haven't been
+	// cleared yet. If no item exists for the key, ErrNotFound is returned.
+	History(key string) ([]*Item, error)
+
+	// Scan returns the newest item, committed or not, of up to limit keys from
+	// start to end, in key order. start is included and end isn't; an empty end
+	// means there's no upper bound. Tombstones are included. A limit of 0 means
+	// there's no limit.
+	Scan(start, end string, limit int) ([]*Item, error)
+
+	// ScanPrefix is Scan for the keys that start with prefix and come after
+	// cursor. An empty cursor starts from the first key with the prefix.
+	ScanPrefix(prefix, cursor string, limit int) ([]*Item, error)
+}
+
+// PrefixRange returns the range of keys for ScanPrefix, in the form Scan takes:
+// every key that starts with prefix and comes after cursor.
+func PrefixRange(prefix, cursor string) (start, end string) {
+	start = prefix
+	if cursor >= prefix {
+		start = cursor + "\x00"
+	}
+
+	// The first key after every key with the prefix is the prefix with it's
+	// last byte incremented, ignoring trailing 0xff bytes. A prefix of only
+	// 0xff bytes has no upper bound.
+	end = ""
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			end = prefix[:i] + string([]byte{prefix[i] + 1})
+			break
+		}
+	}
+
+	return start, end
+}
+
+// InRange reports whether key is in the range Scan returns for start and end.
+func InRange(key, start, end string) bool {
+	return key >= start && (end == "" || key < end)
+}
+
+// Retention is which committed versions of each key a store keeps. A version
+// is kept if either rule keeps it. Older versions can be read with ReadVersion
+// until they're cleared, which happens the next time the key is committed. The
+// zero value keeps only the latest committed version.
+type Retention struct {
+	// Number of committed versions to keep for each key, including the latest
+	// one.
+	Versions int
+	// Committed versions younger than MaxAge, by the time they were committed,
+	// are kept however many there are.
+	MaxAge time.Duration
+}
+
+// Kept returns the number of committed versions kept for each key.
+func (r Retention) Kept() int {
+	if r.Versions < 1 {
+		return 1
+	}
+	return r.Versions
+}
+
+// CommitAt marks items[i], and every item before it, committed. It returns the
+// items that are left once the older versions the retention doesn't keep are
+// cleared. items have to be sorted by version.
+func (r Retention) CommitAt(items []*Item, i int) []*Item {
+	now := time.Now()
+	for _, item := range items[:i+1] {
+		if !item.Committed {
+			item.Committed = true
+			item.CommittedAt = now
+		}
+	}
+	return r.Trim(items, now)
+}
+
+// Trim returns the items that are left once the committed versions the
+// retention doesn't keep at time now are cleared. The latest committed version
+// and every version after it are always kept. items have to be sorted by
+// version.
+func (r Retention) Trim(items []*Item, now time.Time) []*Item {
+	latest := -1
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Committed {
+			latest = i
+			break
+		}
+	}
+
+	oldest := latest + 1 - r.Kept()
+	if oldest < 0 {
+		oldest = 0
+	}
+
+	// Versions are committed in order, so everything before the first version
+	// that's too old is too old as well.
+	for oldest > 0 && r.young(items[oldest-1], now) {
+		oldest--
+	}
+
+	return items[oldest:]
+}
+
+// young reports whether item is kept because of MaxAge.
+func (r Retention) young(item *Item, now time.Time) bool {
+	return r.MaxAge > 0 && now.Sub(item.CommittedAt) < r.MaxAge
+}
+
+// Retainer is implemented by stores whose Retention can be changed.
+type Retainer interface {
+	SetRetention(r Retention)
 }
 
 // Item is an object in the Store. A key inside the store might have multiple
@@ -59,6 +209,26 @@ type Item struct {
 	Committed bool
 	Value     []byte
 	Key       string
+	// Deleted marks the item as a tombstone. Tombstones have no value.
+	Deleted bool
+	// CommittedAt is when the item was committed by this store. It's the zero
+	// time for items that aren't committed.
+	CommittedAt time.Time
+	// ExpiresAt is when the head of the chain deletes the key, unless a newer
+	// version is written first. It's the zero time for items that don't
+	// expire. Only WriteAll stores it.
+	ExpiresAt time.Time
+}
+
+// LatestCommitted returns the newest committed item, or nil if none of the
+// items are committed. items have to be sorted by version.
+func LatestCommitted(items []*Item) *Item {
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Committed {
+			return items[i]
+		}
+	}
+	return nil
 }
 
 // Encode returns the byte representation of the interface given, using
@@ -4,13 +4,22 @@
 package storetest
 
 import (
+	"errors"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/despreston/go-craq/store"
 	"github.com/google/go-cmp/cmp"
 )
 
+// ignoreCommitTime leaves CommittedAt out of comparisons, since it's set by the
+// store when the item is committed.
+var ignoreCommitTime = cmp.FilterPath(func(p cmp.Path) bool {
+	return p.String() == "CommittedAt"
+}, cmp.Ignore())
+
 // Test function
 type Test func(*testing.T, store.Storer)
 
@@ -20,10 +29,25 @@ var tests = map[string]Test{
 	"ReadDirty":             testReadDirty,
 	"ReadVersion":           testReadVersion,
 	"ReadVersionUnknownKey": testReadVersionUnknownKey,
+	"CommitUnknownVersion":  testCommitUnknownVersion,
 	"AllNewerCommitted":     testAllNewerCommitted,
 	"AllNewerDirty":         testAllNewerDirty,
 	"AllDirty":              testAllDirty,
 	"AllCommitted":          testAllCommitted,
+	"EachCommitted":         testEachCommitted,
+	"Delete":                testDelete,
+	"DeletePropagation":     testDeletePropagation,
+	"Purge":                 testPurge,
+	"WriteAll":              testWriteAll,
+	"ExpiresAt":             testExpiresAt,
+	"CommitAll":             testCommitAll,
+	"CommitAllUnknown":      testCommitAllUnknown,
+	"Retention":             testRetention,
+	"RetentionMaxAge":       testRetentionMaxAge,
+	"History":               testHistory,
+	"HistoryUnknownKey":     testHistoryUnknownKey,
+	"Scan":                  testScan,
+	"ScanPrefix":            testScanPrefix,
 }
 
 // Run will invoke all tests.
@@ -53,7 +77,7 @@ func testBasicWriteCommitRead(t *testing.T, s store.Storer) {
 	if err != nil {
 		t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
 	}
-	if !reflect.DeepEqual(got, &itm) {
+	if diff := cmp.Diff(&itm, got, ignoreCommitTime); diff != "" {
 		t.Fatalf("Read(hello) unexpected item\n  want: %#v\n  got: %#v", itm, got)
 	}
 }
@@ -107,6 +131,18 @@ func testReadVersionUnknownKey(t *testing.T, s store.Storer) {
 	}
 }
 
+func testCommitUnknownVersion(t *testing.T, s store.Storer) {
+	s.Write("hello", []byte("world"), 1)
+
+	if err := s.Commit("hello", 2); err != store.ErrNotFound {
+		t.Fatalf("Commit(hello, 2) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+
+	if _, err := s.ReadVersion("hello", 1); err != nil {
+		t.Fatalf("ReadVersion(hello, 1) unexpected error\n  got: %#v", err)
+	}
+}
+
 func testAllNewerCommitted(t *testing.T, s store.Storer) {
 	items := []*store.Item{
 		{
@@ -142,7 +178,7 @@ func testAllNewerCommitted(t *testing.T, s store.Storer) {
 			err,
 		)
 	}
-	if diff := cmp.Diff(items[1:2], got); diff != "" {
+	if diff := cmp.Diff(items[1:2], got, ignoreCommitTime); diff != "" {
 		t.Fatalf("AllNewerCommitted response mismatch (-want +got):\n%s", diff)
 	}
 
@@ -158,7 +194,7 @@ func testAllNewerCommitted(t *testing.T, s store.Storer) {
 			err,
 		)
 	}
-	if diff := cmp.Diff(items[2:], got); diff != "" {
+	if diff := cmp.Diff(items[2:], got, ignoreCommitTime); diff != "" {
 		t.Errorf("AllNewerCommitted response mismatch (-want +got):\n%s", diff)
 	}
 }
@@ -206,7 +242,7 @@ func testAllNewerDirty(t *testing.T, s store.Storer) {
 	for _, wantItem := range items[2:] {
 		var found bool
 		for _, gotItem := range got {
-			if diff := cmp.Diff(wantItem, gotItem); diff == "" {
+			if diff := cmp.Diff(wantItem, gotItem, ignoreCommitTime); diff == "" {
 				found = true
 				break
 			}
@@ -249,7 +285,7 @@ func testAllDirty(t *testing.T, s store.Storer) {
 	var found bool
 	want := items[1]
 	for _, gotItem := range got {
-		if diff := cmp.Diff(want, gotItem); diff == "" {
+		if diff := cmp.Diff(want, gotItem, ignoreCommitTime); diff == "" {
 			found = true
 			break
 		}
@@ -280,23 +316,505 @@ func testAllCommitted(t *testing.T, s store.Storer) {
 
 	s.Commit("hello", items[0].Version)
 
-	got, err := s.AllCommitted()
+	got, err := allCommitted(s)
 	if err != nil {
-		t.Fatalf("AllCommitted() unexpected error\n  got: %#v", err)
+		t.Fatalf("EachCommitted() unexpected error\n  got: %#v", err)
 	}
-	if want, got := items[0], got[0]; !reflect.DeepEqual(want, got) {
-		t.Fatalf("AllCommitted() unexpected response\n  want: %#v\n  got: %#v", want, got)
+	if want, got := items[0], got[0]; cmp.Diff(want, got, ignoreCommitTime) != "" {
+		t.Fatalf("EachCommitted() unexpected response\n  want: %#v\n  got: %#v", want, got)
 	}
 
 	var found bool
 	want := items[0]
 	for _, gotItem := range got {
-		if diff := cmp.Diff(want, gotItem); diff == "" {
+		if diff := cmp.Diff(want, gotItem, ignoreCommitTime); diff == "" {
 			found = true
 			break
 		}
 	}
 	if !found {
-		t.Fatalf("AllCommitted() response missing item:\n%#v", want)
+		t.Fatalf("EachCommitted() response missing item:\n%#v", want)
+	}
+}
+
+func testDelete(t *testing.T, s store.Storer) {
+	s.Write("hello", []byte("world"), 1)
+	s.Commit("hello", 1)
+
+	if err := s.Delete("hello", 2); err != nil {
+		t.Fatalf("Delete(hello, 2) unexpected error\n  got: %#v", err)
+	}
+
+	if _, err := s.Read("hello"); err != store.ErrDirtyItem {
+		t.Fatalf("Read(hello) unexpected error\n  want: %#v\n  got: %#v", store.ErrDirtyItem, err)
+	}
+
+	if err := s.Commit("hello", 2); err != nil {
+		t.Fatalf("Commit(hello, 2) unexpected error\n  got: %#v", err)
+	}
+
+	if _, err := s.Read("hello"); err != store.ErrNotFound {
+		t.Fatalf("Read(hello) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+
+	want := &store.Item{Key: "hello", Version: 2, Committed: true, Deleted: true}
+	got, err := s.ReadVersion("hello", 2)
+	if err != nil {
+		t.Fatalf("ReadVersion(hello, 2) unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("ReadVersion(hello, 2) unexpected item (-want +got):\n%s", diff)
+	}
+}
+
+// Tombstones are propagated to nodes rejoining the chain just like any other
+// item.
+func testDeletePropagation(t *testing.T, s store.Storer) {
+	s.Write("hello", []byte("world"), 1)
+	s.Commit("hello", 1)
+	s.Delete("hello", 2)
+
+	want := []*store.Item{{Key: "hello", Version: 2, Deleted: true}}
+	got, err := s.AllNewerDirty(map[string]uint64{"hello": 1})
+	if err != nil {
+		t.Fatalf("AllNewerDirty() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("AllNewerDirty() response mismatch (-want +got):\n%s", diff)
+	}
+
+	s.Commit("hello", 2)
+	want[0].Committed = true
+
+	got, err = s.AllNewerCommitted(map[string]uint64{"hello": 1})
+	if err != nil {
+		t.Fatalf("AllNewerCommitted() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("AllNewerCommitted() response mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func testPurge(t *testing.T, s store.Storer) {
+	s.Write("hello", []byte("world"), 1)
+	s.Commit("hello", 1)
+	s.Delete("hello", 2)
+
+	// Uncommitted tombstones are kept.
+	if err := s.Purge("hello", 2); err != nil {
+		t.Fatalf("Purge(hello, 2) unexpected error\n  got: %#v", err)
+	}
+	if _, err := s.ReadVersion("hello", 2); err != nil {
+		t.Fatalf("ReadVersion(hello, 2) unexpected error\n  got: %#v", err)
+	}
+
+	s.Commit("hello", 2)
+
+	// Wrong version is ignored.
+	s.Purge("hello", 1)
+	if _, err := s.ReadVersion("hello", 2); err != nil {
+		t.Fatalf("ReadVersion(hello, 2) unexpected error\n  got: %#v", err)
+	}
+
+	if err := s.Purge("hello", 2); err != nil {
+		t.Fatalf("Purge(hello, 2) unexpected error\n  got: %#v", err)
+	}
+
+	if _, err := s.ReadVersion("hello", 2); err != store.ErrNotFound {
+		t.Fatalf("ReadVersion(hello, 2) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+
+	committed, err := allCommitted(s)
+	if err != nil {
+		t.Fatalf("EachCommitted() unexpected error\n  got: %#v", err)
+	}
+	if len(committed) != 0 {
+		t.Fatalf("EachCommitted() unexpected items after Purge\n  got: %#v", committed)
+	}
+}
+
+func testWriteAll(t *testing.T, s store.Storer) {
+	items := []*store.Item{
+		{Key: "hello", Value: []byte("world"), Version: 1},
+		{Key: "foo", Version: 3, Deleted: true},
+		{Key: "hello", Value: []byte("again"), Version: 2},
+	}
+
+	if err := s.WriteAll(items); err != nil {
+		t.Fatalf("WriteAll() unexpected error\n  got: %#v", err)
+	}
+
+	for _, want := range items {
+		got, err := s.ReadVersion(want.Key, want.Version)
+		if err != nil {
+			t.Fatalf("ReadVersion(%s, %d) unexpected error\n  got: %#v", want.Key, want.Version, err)
+		}
+		if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+			t.Fatalf("ReadVersion(%s, %d) unexpected item (-want +got):\n%s", want.Key, want.Version, diff)
+		}
+	}
+}
+
+func testExpiresAt(t *testing.T, s store.Storer) {
+	// Some stores only keep the time to the millisecond.
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+	err := s.WriteAll([]*store.Item{
+		{Key: "hello", Value: []byte("world"), Version: 1, ExpiresAt: expiresAt},
+	})
+	if err != nil {
+		t.Fatalf("WriteAll() unexpected error\n  got: %#v", err)
+	}
+	if err := s.Commit("hello", 1); err != nil {
+		t.Fatalf("Commit(hello, 1) unexpected error\n  got: %#v", err)
+	}
+
+	got, err := s.Read("hello")
+	if err != nil {
+		t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+	}
+	if !got.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Read(hello) unexpected ExpiresAt\n  want: %v\n  got: %v", expiresAt, got.ExpiresAt)
+	}
+}
+
+func testCommitAll(t *testing.T, s store.Storer) {
+	s.Write("hello", []byte("world"), 1)
+	s.Write("hello", []byte("again"), 2)
+	s.Write("foo", []byte("bar"), 1)
+
+	if err := s.CommitAll(map[string]uint64{"hello": 2, "foo": 1}); err != nil {
+		t.Fatalf("CommitAll() unexpected error\n  got: %#v", err)
+	}
+
+	want := []*store.Item{
+		{Key: "foo", Value: []byte("bar"), Version: 1, Committed: true},
+		{Key: "hello", Value: []byte("again"), Version: 2, Committed: true},
+	}
+	got, err := allCommitted(s)
+	if err != nil {
+		t.Fatalf("EachCommitted() unexpected error\n  got: %#v", err)
+	}
+	sortItems(got)
+	if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("EachCommitted() unexpected items (-want +got):\n%s", diff)
+	}
+
+	if _, err := s.ReadVersion("hello", 1); err != store.ErrNotFound {
+		t.Fatalf("ReadVersion(hello, 1) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+}
+
+// Nothing is committed if one of the versions doesn't exist.
+func testCommitAllUnknown(t *testing.T, s store.Storer) {
+	s.Write("hello", []byte("world"), 1)
+	s.Write("foo", []byte("bar"), 1)
+
+	err := s.CommitAll(map[string]uint64{"hello": 1, "foo": 2})
+	if err != store.ErrNotFound {
+		t.Fatalf("CommitAll() unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+
+	committed, err := allCommitted(s)
+	if err != nil {
+		t.Fatalf("EachCommitted() unexpected error\n  got: %#v", err)
+	}
+	if len(committed) != 0 {
+		t.Fatalf("unexpected committed items\n  got: %#v", committed)
+	}
+}
+
+// Older committed versions are kept, up to the retention, and can still be read
+// with ReadVersion. Read and AllCommitted only see the latest one.
+func testRetention(t *testing.T, s store.Storer) {
+	r, ok := s.(store.Retainer)
+	if !ok {
+		t.Skip("store doesn't implement store.Retainer")
+	}
+	r.SetRetention(store.Retention{Versions: 2})
+
+	for version, value := range []string{"a", "b", "c", "d"} {
+		s.Write("hello", []byte(value), uint64(version))
+	}
+
+	// Committing version 2 commits 0 and 1 too, then clears version 0.
+	if err := s.Commit("hello", 2); err != nil {
+		t.Fatalf("Commit(hello, 2) unexpected error\n  got: %#v", err)
 	}
+
+	tests := []struct {
+		version uint64
+		want    *store.Item
+		err     error
+	}{
+		{version: 0, err: store.ErrNotFound},
+		{version: 1, want: &store.Item{Key: "hello", Value: []byte("b"), Version: 1, Committed: true}},
+		{version: 2, want: &store.Item{Key: "hello", Value: []byte("c"), Version: 2, Committed: true}},
+		{version: 3, want: &store.Item{Key: "hello", Value: []byte("d"), Version: 3}},
+	}
+
+	for _, tt := range tests {
+		got, err := s.ReadVersion("hello", tt.version)
+		if err != tt.err {
+			t.Fatalf("ReadVersion(hello, %d) unexpected error\n  want: %#v\n  got: %#v", tt.version, tt.err, err)
+		}
+		if diff := cmp.Diff(tt.want, got, ignoreCommitTime); diff != "" {
+			t.Fatalf("ReadVersion(hello, %d) unexpected item (-want +got):\n%s", tt.version, diff)
+		}
+	}
+
+	if err := s.Commit("hello", 3); err != nil {
+		t.Fatalf("Commit(hello, 3) unexpected error\n  got: %#v", err)
+	}
+
+	latest := &store.Item{Key: "hello", Value: []byte("d"), Version: 3, Committed: true}
+
+	got, err := s.Read("hello")
+	if err != nil {
+		t.Fatalf("Read(hello) unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff(latest, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("Read(hello) unexpected item (-want +got):\n%s", diff)
+	}
+
+	committed, err := allCommitted(s)
+	if err != nil {
+		t.Fatalf("EachCommitted() unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff([]*store.Item{latest}, committed, ignoreCommitTime); diff != "" {
+		t.Fatalf("EachCommitted() unexpected items (-want +got):\n%s", diff)
+	}
+
+	if _, err := s.ReadVersion("hello", 1); err != store.ErrNotFound {
+		t.Fatalf("ReadVersion(hello, 1) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+}
+
+// Versions younger than MaxAge are kept, and left out of History once they're
+// too old even if they haven't been cleared yet.
+func testRetentionMaxAge(t *testing.T, s store.Storer) {
+	r, ok := s.(store.Retainer)
+	if !ok {
+		t.Skip("store doesn't implement store.Retainer")
+	}
+	r.SetRetention(store.Retention{MaxAge: 100 * time.Millisecond})
+
+	s.Write("hello", []byte("a"), 0)
+	s.Commit("hello", 0)
+	time.Sleep(150 * time.Millisecond)
+
+	for version, value := range []string{"b", "c"} {
+		s.Write("hello", []byte(value), uint64(version+1))
+		if err := s.Commit("hello", uint64(version+1)); err != nil {
+			t.Fatalf("Commit(hello, %d) unexpected error\n  got: %#v", version+1, err)
+		}
+	}
+
+	// Version 0 was too old when version 2 was committed, so it's cleared.
+	if _, err := s.ReadVersion("hello", 0); err != store.ErrNotFound {
+		t.Fatalf("ReadVersion(hello, 0) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+	assertHistory(t, s, "hello", 1, 2)
+
+	time.Sleep(150 * time.Millisecond)
+	assertHistory(t, s, "hello", 2)
+
+	if _, err := s.ReadVersion("hello", 1); err != nil {
+		t.Fatalf("ReadVersion(hello, 1) unexpected error\n  got: %#v", err)
+	}
+}
+
+func testHistory(t *testing.T, s store.Storer) {
+	for version, value := range []string{"a", "b", "c"} {
+		s.Write("hello", []byte(value), uint64(version))
+	}
+	s.Commit("hello", 1)
+
+	want := []*store.Item{
+		{Key: "hello", Value: []byte("b"), Version: 1, Committed: true},
+		{Key: "hello", Value: []byte("c"), Version: 2},
+	}
+
+	got, err := s.History("hello")
+	if err != nil {
+		t.Fatalf("History(hello) unexpected error\n  got: %#v", err)
+	}
+	if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("History(hello) unexpected items (-want +got):\n%s", diff)
+	}
+	if got[0].CommittedAt.IsZero() {
+		t.Fatalf("History(hello) committed item without CommittedAt\n  got: %#v", got[0])
+	}
+	if !got[1].CommittedAt.IsZero() {
+		t.Fatalf("History(hello) uncommitted item with CommittedAt\n  got: %#v", got[1])
+	}
+}
+
+func testHistoryUnknownKey(t *testing.T, s store.Storer) {
+	if _, err := s.History("unknown"); err != store.ErrNotFound {
+		t.Fatalf("History(unknown) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+}
+
+// EachCommitted visits keys in order, starts after the given key, stops on
+// ErrStop, and lets fn use the store.
+func testEachCommitted(t *testing.T, s store.Storer) {
+	writeScanKeys(s)
+
+	var visited []string
+	err := s.EachCommitted("a", func(item *store.Item) error {
+		visited = append(visited, item.Key)
+		if item.Deleted {
+			return s.Purge(item.Key, item.Version)
+		}
+		if item.Key == "d" {
+			return store.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachCommitted(a) unexpected error\n  got: %#v", err)
+	}
+
+	// b's newest version is dirty, so the committed version 1 is visited.
+	if diff := cmp.Diff([]string{"ab", "b", "c", "d"}, visited); diff != "" {
+		t.Fatalf("EachCommitted(a) unexpected keys (-want +got):\n%s", diff)
+	}
+	if _, err := s.ReadVersion("c", 2); err != store.ErrNotFound {
+		t.Fatalf("ReadVersion(c, 2) unexpected error\n  want: %#v\n  got: %#v", store.ErrNotFound, err)
+	}
+
+	want := errors.New("failed")
+	calls := 0
+	err = s.EachCommitted("", func(*store.Item) error {
+		calls++
+		return want
+	})
+	if err != want || calls != 1 {
+		t.Fatalf("EachCommitted() unexpected result\n  want: %#v after 1 call\n  got: %#v after %d calls", want, err, calls)
+	}
+}
+
+// allCommitted collects every item EachCommitted visits.
+func allCommitted(s store.Storer) ([]*store.Item, error) {
+	items := []*store.Item{}
+	err := s.EachCommitted("", func(item *store.Item) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// writeScanKeys writes the keys the scan tests use. b is dirty and c is
+// deleted.
+func writeScanKeys(s store.Storer) {
+	for _, key := range []string{"a", "ab", "b", "c", "d"} {
+		s.Write(key, []byte(key), 1)
+		s.Commit(key, 1)
+	}
+	s.Write("b", []byte("b2"), 2)
+	s.Delete("c", 2)
+	s.Commit("c", 2)
+}
+
+func testScan(t *testing.T, s store.Storer) {
+	writeScanKeys(s)
+
+	tests := []struct {
+		start, end string
+		limit      int
+		want       []string
+	}{
+		{start: "", end: "", want: []string{"a", "ab", "b", "c", "d"}},
+		{start: "ab", end: "d", want: []string{"ab", "b", "c"}},
+		{start: "aa", end: "", limit: 2, want: []string{"ab", "b"}},
+		{start: "e", end: "", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		got, err := s.Scan(tt.start, tt.end, tt.limit)
+		if err != nil {
+			t.Fatalf("Scan(%s, %s, %d) unexpected error\n  got: %#v", tt.start, tt.end, tt.limit, err)
+		}
+		if diff := cmp.Diff(tt.want, scannedKeys(got)); diff != "" {
+			t.Fatalf("Scan(%s, %s, %d) unexpected keys (-want +got):\n%s", tt.start, tt.end, tt.limit, diff)
+		}
+	}
+
+	// The newest item of each key is returned, committed or not.
+	got, err := s.Scan("b", "d", 0)
+	if err != nil {
+		t.Fatalf("Scan(b, d, 0) unexpected error\n  got: %#v", err)
+	}
+	want := []*store.Item{
+		{Key: "b", Value: []byte("b2"), Version: 2},
+		{Key: "c", Version: 2, Committed: true, Deleted: true},
+	}
+	if diff := cmp.Diff(want, got, ignoreCommitTime); diff != "" {
+		t.Fatalf("Scan(b, d, 0) unexpected items (-want +got):\n%s", diff)
+	}
+}
+
+func testScanPrefix(t *testing.T, s store.Storer) {
+	writeScanKeys(s)
+	s.Write("a\xff", []byte("a\xff"), 1)
+
+	tests := []struct {
+		prefix, cursor string
+		limit          int
+		want           []string
+	}{
+		{prefix: "a", want: []string{"a", "ab", "a\xff"}},
+		{prefix: "a", limit: 1, want: []string{"a"}},
+		{prefix: "a", cursor: "a", want: []string{"ab", "a\xff"}},
+		{prefix: "a", cursor: "ab", limit: 1, want: []string{"a\xff"}},
+		{prefix: "", cursor: "c", want: []string{"d"}},
+		{prefix: "x", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		got, err := s.ScanPrefix(tt.prefix, tt.cursor, tt.limit)
+		if err != nil {
+			t.Fatalf("ScanPrefix(%q, %q, %d) unexpected error\n  got: %#v", tt.prefix, tt.cursor, tt.limit, err)
+		}
+		if diff := cmp.Diff(tt.want, scannedKeys(got)); diff != "" {
+			t.Fatalf("ScanPrefix(%q, %q, %d) unexpected keys (-want +got):\n%s", tt.prefix, tt.cursor, tt.limit, diff)
+		}
+	}
+}
+
+func scannedKeys(items []*store.Item) []string {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	return keys
+}
+
+// assertHistory checks the versions History returns for key.
+func assertHistory(t *testing.T, s store.Storer, key string, versions ...uint64) {
+	t.Helper()
+
+	items, err := s.History(key)
+	if err != nil {
+		t.Fatalf("History(%s) unexpected error\n  got: %#v", key, err)
+	}
+
+	got := make([]uint64, len(items))
+	for i, item := range items {
+		got[i] = item.Version
+	}
+	if diff := cmp.Diff(versions, got); diff != "" {
+		t.Fatalf("History(%s) unexpected versions (-want +got):\n%s", key, diff)
+	}
+}
+
+// sortItems sorts items by key, then by version.
+func sortItems(items []*store.Item) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Key != items[j].Key {
+			return items[i].Key < items[j].Key
+		}
+		return items[i].Version < items[j].Version
+	})
 }
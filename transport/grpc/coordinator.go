@@ -0,0 +1,249 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
+)
+
+func NewCoordinatorClient() transport.CoordinatorClient {
+	return &CoordinatorClient{Client: &Client{}}
+}
+
+// CoordinatorBinding provides a layer of translation between the
+// CoordinatorService which is transport agnostic and gRPC. It implements
+// craqpb.CoordinatorServiceServer, so it can be registered with
+// craqpb.RegisterCoordinatorServiceServer.
+type CoordinatorBinding struct {
+	craqpb.UnimplementedCoordinatorServiceServer
+	Svc transport.CoordinatorService
+}
+
+func (c *CoordinatorBinding) AddNode(
+	_ context.Context,
+	args *craqpb.AddNodeRequest,
+) (*craqpb.NodeMeta, error) {
+	meta, err := c.Svc.AddNode(&transport.AddNodeArgs{
+		Address:  args.Address,
+		Chain:    int(args.Chain),
+		Position: int(args.Position),
+		Learner:  args.Learner,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return metaToProto(meta), nil
+}
+
+func (c *CoordinatorBinding) RemoveNode(
+	_ context.Context,
+	args *craqpb.RemoveNodeRequest,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(c.Svc.RemoveNode(args.Address))
+}
+
+func (c *CoordinatorBinding) ReportState(
+	_ context.Context,
+	args *craqpb.ReportStateRequest,
+) (*craqpb.Empty, error) {
+	err := c.Svc.ReportState(args.Address, transport.NodeState(args.State))
+	return &craqpb.Empty{}, toStatus(err)
+}
+
+func (c *CoordinatorBinding) Decommission(
+	_ context.Context,
+	args *craqpb.DecommissionRequest,
+) (*craqpb.DecommissionStatus, error) {
+	status, err := c.Svc.Decommission(args.Address)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.DecommissionStatus{
+		Address: status.Address,
+		Stage:   craqpb.DecommissionStatus_Stage(status.Stage),
+		Node:    drainStatusToProto(&status.Node),
+		Error:   status.Error,
+	}, nil
+}
+
+func (c *CoordinatorBinding) Write(
+	_ context.Context,
+	args *craqpb.ClientWriteRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(c.Svc.Write(args.Key, args.Value, time.Duration(args.Ttl)))
+}
+
+func (c *CoordinatorBinding) Delete(
+	_ context.Context,
+	args *craqpb.KeyRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(c.Svc.Delete(args.Key))
+}
+
+func (c *CoordinatorBinding) CompareAndSwap(
+	_ context.Context,
+	args *craqpb.CompareAndSwapRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(c.Svc.CompareAndSwap(args.Key, args.Expected, args.Value))
+}
+
+func (c *CoordinatorBinding) PutIfAbsent(
+	_ context.Context,
+	args *craqpb.ClientWriteRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(c.Svc.PutIfAbsent(args.Key, args.Value))
+}
+
+func (c *CoordinatorBinding) WriteBatch(
+	_ context.Context,
+	args *craqpb.WriteBatchRequest,
+) (*craqpb.WriteBatchResponse, error) {
+	return batchReply(c.Svc.WriteBatch(itemsFromProto(args.Items)))
+}
+
+func (c *CoordinatorBinding) Transaction(
+	_ context.Context,
+	txn *craqpb.TransactionRequest,
+) (*craqpb.WriteBatchResponse, error) {
+	return batchReply(c.Svc.Transaction(txnFromProto(txn)))
+}
+
+func (c *CoordinatorBinding) Routes(
+	_ context.Context,
+	_ *craqpb.Empty,
+) (*craqpb.RoutingTable, error) {
+	rt, err := c.Svc.Routes()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &craqpb.RoutingTable{}
+	for _, nodes := range rt.Chains {
+		reply.Chains = append(reply.Chains, &craqpb.Chain{Nodes: nodes})
+	}
+	for address, state := range rt.States {
+		if reply.States == nil {
+			reply.States = make(map[string]craqpb.NodeState)
+		}
+		reply.States[address] = craqpb.NodeState(state)
+	}
+	return reply, nil
+}
+
+// CoordinatorClient is for invoking gRPC methods on a Coordinator.
+type CoordinatorClient struct {
+	*Client
+	rpc craqpb.CoordinatorServiceClient
+}
+
+func (cc *CoordinatorClient) Connect(addr string) error {
+	if err := cc.Client.Connect(addr); err != nil {
+		return err
+	}
+	cc.rpc = craqpb.NewCoordinatorServiceClient(cc.conn)
+	return nil
+}
+
+func (cc *CoordinatorClient) AddNode(
+	args *transport.AddNodeArgs,
+) (*transport.NodeMeta, error) {
+	reply, err := cc.rpc.AddNode(context.Background(), &craqpb.AddNodeRequest{
+		Address:  args.Address,
+		Chain:    int32(args.Chain),
+		Position: int32(args.Position),
+		Learner:  args.Learner,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return metaFromProto(reply), nil
+}
+
+func (cc *CoordinatorClient) RemoveNode(addr string) error {
+	args := &craqpb.RemoveNodeRequest{Address: addr}
+	_, err := cc.rpc.RemoveNode(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (cc *CoordinatorClient) ReportState(
+	addr string,
+	state transport.NodeState,
+) error {
+	args := &craqpb.ReportStateRequest{Address: addr, State: craqpb.NodeState(state)}
+	_, err := cc.rpc.ReportState(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (cc *CoordinatorClient) Decommission(
+	addr string,
+) (*transport.DecommissionStatus, error) {
+	args := &craqpb.DecommissionRequest{Address: addr}
+	reply, err := cc.rpc.Decommission(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.DecommissionStatus{
+		Address: reply.Address,
+		Stage:   transport.DecommissionStage(reply.Stage),
+		Node:    *drainStatusFromProto(reply.Node),
+		Error:   reply.Error,
+	}, nil
+}
+
+func (cc *CoordinatorClient) Write(
+	k string,
+	v []byte,
+	ttl time.Duration,
+) (uint64, error) {
+	args := &craqpb.ClientWriteRequest{Key: k, Value: v, Ttl: int64(ttl)}
+	return fromWriteReply(cc.rpc.Write(context.Background(), args))
+}
+
+func (cc *CoordinatorClient) Delete(k string) (uint64, error) {
+	args := &craqpb.KeyRequest{Key: k}
+	return fromWriteReply(cc.rpc.Delete(context.Background(), args))
+}
+
+func (cc *CoordinatorClient) CompareAndSwap(
+	k string,
+	expected uint64,
+	v []byte,
+) (uint64, error) {
+	args := &craqpb.CompareAndSwapRequest{Key: k, Expected: expected, Value: v}
+	return fromWriteReply(cc.rpc.CompareAndSwap(context.Background(), args))
+}
+
+func (cc *CoordinatorClient) PutIfAbsent(k string, v []byte) (uint64, error) {
+	args := &craqpb.ClientWriteRequest{Key: k, Value: v}
+	return fromWriteReply(cc.rpc.PutIfAbsent(context.Background(), args))
+}
+
+func (cc *CoordinatorClient) WriteBatch(items []transport.Item) ([]uint64, error) {
+	args := &craqpb.WriteBatchRequest{Items: itemsToProto(items)}
+	return fromBatchReply(cc.rpc.WriteBatch(context.Background(), args))
+}
+
+func (cc *CoordinatorClient) Transaction(
+	txn *transport.Transaction,
+) ([]uint64, error) {
+	return fromBatchReply(cc.rpc.Transaction(context.Background(), txnToProto(txn)))
+}
+
+func (cc *CoordinatorClient) Routes() (*transport.RoutingTable, error) {
+	reply, err := cc.rpc.Routes(context.Background(), &craqpb.Empty{})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	rt := &transport.RoutingTable{Chains: make([][]string, len(reply.Chains))}
+	for i, ch := range reply.Chains {
+		rt.Chains[i] = ch.Nodes
+	}
+	for address, state := range reply.States {
+		if rt.States == nil {
+			rt.States = make(map[string]transport.NodeState)
+		}
+		rt.States[address] = transport.NodeState(state)
+	}
+	return rt, nil
+}
@@ -0,0 +1,1774 @@
+// Protocol buffer definitions for the go-craq services. These mirror the
+// interfaces in transport/transport.go so that a gRPC transport can be swapped
+// in for net/rpc, and so that services not written in Go can talk to the
+// chain.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: craq.proto
+
+package craqpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	NodeService_Ping_FullMethodName                 = "/craq.NodeService/Ping"
+	NodeService_Update_FullMethodName               = "/craq.NodeService/Update"
+	NodeService_ClientWrite_FullMethodName          = "/craq.NodeService/ClientWrite"
+	NodeService_Write_FullMethodName                = "/craq.NodeService/Write"
+	NodeService_ClientDelete_FullMethodName         = "/craq.NodeService/ClientDelete"
+	NodeService_Delete_FullMethodName               = "/craq.NodeService/Delete"
+	NodeService_CompareAndSwap_FullMethodName       = "/craq.NodeService/CompareAndSwap"
+	NodeService_PutIfAbsent_FullMethodName          = "/craq.NodeService/PutIfAbsent"
+	NodeService_ClientWriteBatch_FullMethodName     = "/craq.NodeService/ClientWriteBatch"
+	NodeService_ClientTransaction_FullMethodName    = "/craq.NodeService/ClientTransaction"
+	NodeService_WriteBatch_FullMethodName           = "/craq.NodeService/WriteBatch"
+	NodeService_Replicate_FullMethodName            = "/craq.NodeService/Replicate"
+	NodeService_LatestVersion_FullMethodName        = "/craq.NodeService/LatestVersion"
+	NodeService_FwdPropagate_FullMethodName         = "/craq.NodeService/FwdPropagate"
+	NodeService_BackPropagate_FullMethodName        = "/craq.NodeService/BackPropagate"
+	NodeService_MerkleHashes_FullMethodName         = "/craq.NodeService/MerkleHashes"
+	NodeService_BackPropagateBuckets_FullMethodName = "/craq.NodeService/BackPropagateBuckets"
+	NodeService_Commit_FullMethodName               = "/craq.NodeService/Commit"
+	NodeService_CommitBatch_FullMethodName          = "/craq.NodeService/CommitBatch"
+	NodeService_Read_FullMethodName                 = "/craq.NodeService/Read"
+	NodeService_ReadAtVersion_FullMethodName        = "/craq.NodeService/ReadAtVersion"
+	NodeService_History_FullMethodName              = "/craq.NodeService/History"
+	NodeService_Scan_FullMethodName                 = "/craq.NodeService/Scan"
+	NodeService_ScanPrefix_FullMethodName           = "/craq.NodeService/ScanPrefix"
+	NodeService_ReadAll_FullMethodName              = "/craq.NodeService/ReadAll"
+	NodeService_Export_FullMethodName               = "/craq.NodeService/Export"
+	NodeService_Watch_FullMethodName                = "/craq.NodeService/Watch"
+	NodeService_Drain_FullMethodName                = "/craq.NodeService/Drain"
+	NodeService_Undrain_FullMethodName              = "/craq.NodeService/Undrain"
+	NodeService_Status_FullMethodName               = "/craq.NodeService/Status"
+)
+
+// NodeServiceClient is the client API for NodeService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NodeServiceClient interface {
+	Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	Update(ctx context.Context, in *NodeMeta, opts ...grpc.CallOption) (*Empty, error)
+	ClientWrite(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	Write(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*Empty, error)
+	ClientDelete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*Empty, error)
+	CompareAndSwap(ctx context.Context, in *CompareAndSwapRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	PutIfAbsent(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	ClientWriteBatch(ctx context.Context, in *WriteBatchRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error)
+	ClientTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error)
+	WriteBatch(ctx context.Context, in *VersionedItems, opts ...grpc.CallOption) (*Empty, error)
+	Replicate(ctx context.Context, in *Replication, opts ...grpc.CallOption) (*Empty, error)
+	LatestVersion(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*VersionResponse, error)
+	FwdPropagate(ctx context.Context, in *PropagateRequest, opts ...grpc.CallOption) (*PropagateResponse, error)
+	BackPropagate(ctx context.Context, in *PropagateRequest, opts ...grpc.CallOption) (*PropagateResponse, error)
+	MerkleHashes(ctx context.Context, in *MerkleHashesRequest, opts ...grpc.CallOption) (*MerkleHashesResponse, error)
+	BackPropagateBuckets(ctx context.Context, in *BackPropagateBucketsRequest, opts ...grpc.CallOption) (*PropagateResponse, error)
+	Commit(ctx context.Context, in *CommitRequest, opts ...grpc.CallOption) (*Empty, error)
+	CommitBatch(ctx context.Context, in *CommitBatchRequest, opts ...grpc.CallOption) (*Empty, error)
+	Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (*VersionedItem, error)
+	ReadAtVersion(ctx context.Context, in *ReadAtVersionRequest, opts ...grpc.CallOption) (*Item, error)
+	History(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*HistoryResponse, error)
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error)
+	ScanPrefix(ctx context.Context, in *ScanPrefixRequest, opts ...grpc.CallOption) (*ScanResponse, error)
+	ReadAll(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Items, error)
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (*ExportResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (*WatchResponse, error)
+	Drain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*DrainStatus, error)
+	Undrain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	Status(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*NodeStatus, error)
+}
+
+type nodeServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNodeServiceClient(cc grpc.ClientConnInterface) NodeServiceClient {
+	return &nodeServiceClient{cc}
+}
+
+func (c *nodeServiceClient) Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Ping_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Update(ctx context.Context, in *NodeMeta, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Update_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ClientWrite(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, NodeService_ClientWrite_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Write(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Write_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ClientDelete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, NodeService_ClientDelete_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Delete_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) CompareAndSwap(ctx context.Context, in *CompareAndSwapRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, NodeService_CompareAndSwap_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) PutIfAbsent(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, NodeService_PutIfAbsent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ClientWriteBatch(ctx context.Context, in *WriteBatchRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error) {
+	out := new(WriteBatchResponse)
+	err := c.cc.Invoke(ctx, NodeService_ClientWriteBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ClientTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error) {
+	out := new(WriteBatchResponse)
+	err := c.cc.Invoke(ctx, NodeService_ClientTransaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) WriteBatch(ctx context.Context, in *VersionedItems, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_WriteBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Replicate(ctx context.Context, in *Replication, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Replicate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) LatestVersion(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*VersionResponse, error) {
+	out := new(VersionResponse)
+	err := c.cc.Invoke(ctx, NodeService_LatestVersion_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) FwdPropagate(ctx context.Context, in *PropagateRequest, opts ...grpc.CallOption) (*PropagateResponse, error) {
+	out := new(PropagateResponse)
+	err := c.cc.Invoke(ctx, NodeService_FwdPropagate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) BackPropagate(ctx context.Context, in *PropagateRequest, opts ...grpc.CallOption) (*PropagateResponse, error) {
+	out := new(PropagateResponse)
+	err := c.cc.Invoke(ctx, NodeService_BackPropagate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) MerkleHashes(ctx context.Context, in *MerkleHashesRequest, opts ...grpc.CallOption) (*MerkleHashesResponse, error) {
+	out := new(MerkleHashesResponse)
+	err := c.cc.Invoke(ctx, NodeService_MerkleHashes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) BackPropagateBuckets(ctx context.Context, in *BackPropagateBucketsRequest, opts ...grpc.CallOption) (*PropagateResponse, error) {
+	out := new(PropagateResponse)
+	err := c.cc.Invoke(ctx, NodeService_BackPropagateBuckets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Commit(ctx context.Context, in *CommitRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Commit_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) CommitBatch(ctx context.Context, in *CommitBatchRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_CommitBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (*VersionedItem, error) {
+	out := new(VersionedItem)
+	err := c.cc.Invoke(ctx, NodeService_Read_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ReadAtVersion(ctx context.Context, in *ReadAtVersionRequest, opts ...grpc.CallOption) (*Item, error) {
+	out := new(Item)
+	err := c.cc.Invoke(ctx, NodeService_ReadAtVersion_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) History(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*HistoryResponse, error) {
+	out := new(HistoryResponse)
+	err := c.cc.Invoke(ctx, NodeService_History_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error) {
+	out := new(ScanResponse)
+	err := c.cc.Invoke(ctx, NodeService_Scan_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ScanPrefix(ctx context.Context, in *ScanPrefixRequest, opts ...grpc.CallOption) (*ScanResponse, error) {
+	out := new(ScanResponse)
+	err := c.cc.Invoke(ctx, NodeService_ScanPrefix_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) ReadAll(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Items, error) {
+	out := new(Items)
+	err := c.cc.Invoke(ctx, NodeService_ReadAll_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (*ExportResponse, error) {
+	out := new(ExportResponse)
+	err := c.cc.Invoke(ctx, NodeService_Export_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (*WatchResponse, error) {
+	out := new(WatchResponse)
+	err := c.cc.Invoke(ctx, NodeService_Watch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Drain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*DrainStatus, error) {
+	out := new(DrainStatus)
+	err := c.cc.Invoke(ctx, NodeService_Drain_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Undrain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, NodeService_Undrain_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeServiceClient) Status(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*NodeStatus, error) {
+	out := new(NodeStatus)
+	err := c.cc.Invoke(ctx, NodeService_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NodeServiceServer is the server API for NodeService service.
+// All implementations must embed UnimplementedNodeServiceServer
+// for forward compatibility
+type NodeServiceServer interface {
+	Ping(context.Context, *Empty) (*Empty, error)
+	Update(context.Context, *NodeMeta) (*Empty, error)
+	ClientWrite(context.Context, *ClientWriteRequest) (*WriteResponse, error)
+	Write(context.Context, *WriteRequest) (*Empty, error)
+	ClientDelete(context.Context, *KeyRequest) (*WriteResponse, error)
+	Delete(context.Context, *DeleteRequest) (*Empty, error)
+	CompareAndSwap(context.Context, *CompareAndSwapRequest) (*WriteResponse, error)
+	PutIfAbsent(context.Context, *ClientWriteRequest) (*WriteResponse, error)
+	ClientWriteBatch(context.Context, *WriteBatchRequest) (*WriteBatchResponse, error)
+	ClientTransaction(context.Context, *TransactionRequest) (*WriteBatchResponse, error)
+	WriteBatch(context.Context, *VersionedItems) (*Empty, error)
+	Replicate(context.Context, *Replication) (*Empty, error)
+	LatestVersion(context.Context, *KeyRequest) (*VersionResponse, error)
+	FwdPropagate(context.Context, *PropagateRequest) (*PropagateResponse, error)
+	BackPropagate(context.Context, *PropagateRequest) (*PropagateResponse, error)
+	MerkleHashes(context.Context, *MerkleHashesRequest) (*MerkleHashesResponse, error)
+	BackPropagateBuckets(context.Context, *BackPropagateBucketsRequest) (*PropagateResponse, error)
+	Commit(context.Context, *CommitRequest) (*Empty, error)
+	CommitBatch(context.Context, *CommitBatchRequest) (*Empty, error)
+	Read(context.Context, *ReadRequest) (*VersionedItem, error)
+	ReadAtVersion(context.Context, *ReadAtVersionRequest) (*Item, error)
+	History(context.Context, *KeyRequest) (*HistoryResponse, error)
+	Scan(context.Context, *ScanRequest) (*ScanResponse, error)
+	ScanPrefix(context.Context, *ScanPrefixRequest) (*ScanResponse, error)
+	ReadAll(context.Context, *Empty) (*Items, error)
+	Export(context.Context, *ExportRequest) (*ExportResponse, error)
+	Watch(context.Context, *WatchRequest) (*WatchResponse, error)
+	Drain(context.Context, *Empty) (*DrainStatus, error)
+	Undrain(context.Context, *Empty) (*Empty, error)
+	Status(context.Context, *Empty) (*NodeStatus, error)
+	mustEmbedUnimplementedNodeServiceServer()
+}
+
+// UnimplementedNodeServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedNodeServiceServer struct {
+}
+
+func (UnimplementedNodeServiceServer) Ping(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedNodeServiceServer) Update(context.Context, *NodeMeta) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedNodeServiceServer) ClientWrite(context.Context, *ClientWriteRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClientWrite not implemented")
+}
+func (UnimplementedNodeServiceServer) Write(context.Context, *WriteRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Write not implemented")
+}
+func (UnimplementedNodeServiceServer) ClientDelete(context.Context, *KeyRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClientDelete not implemented")
+}
+func (UnimplementedNodeServiceServer) Delete(context.Context, *DeleteRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedNodeServiceServer) CompareAndSwap(context.Context, *CompareAndSwapRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareAndSwap not implemented")
+}
+func (UnimplementedNodeServiceServer) PutIfAbsent(context.Context, *ClientWriteRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutIfAbsent not implemented")
+}
+func (UnimplementedNodeServiceServer) ClientWriteBatch(context.Context, *WriteBatchRequest) (*WriteBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClientWriteBatch not implemented")
+}
+func (UnimplementedNodeServiceServer) ClientTransaction(context.Context, *TransactionRequest) (*WriteBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClientTransaction not implemented")
+}
+func (UnimplementedNodeServiceServer) WriteBatch(context.Context, *VersionedItems) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteBatch not implemented")
+}
+func (UnimplementedNodeServiceServer) Replicate(context.Context, *Replication) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedNodeServiceServer) LatestVersion(context.Context, *KeyRequest) (*VersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LatestVersion not implemented")
+}
+func (UnimplementedNodeServiceServer) FwdPropagate(context.Context, *PropagateRequest) (*PropagateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FwdPropagate not implemented")
+}
+func (UnimplementedNodeServiceServer) BackPropagate(context.Context, *PropagateRequest) (*PropagateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BackPropagate not implemented")
+}
+func (UnimplementedNodeServiceServer) MerkleHashes(context.Context, *MerkleHashesRequest) (*MerkleHashesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleHashes not implemented")
+}
+func (UnimplementedNodeServiceServer) BackPropagateBuckets(context.Context, *BackPropagateBucketsRequest) (*PropagateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BackPropagateBuckets not implemented")
+}
+func (UnimplementedNodeServiceServer) Commit(context.Context, *CommitRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Commit not implemented")
+}
+func (UnimplementedNodeServiceServer) CommitBatch(context.Context, *CommitBatchRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitBatch not implemented")
+}
+func (UnimplementedNodeServiceServer) Read(context.Context, *ReadRequest) (*VersionedItem, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Read not implemented")
+}
+func (UnimplementedNodeServiceServer) ReadAtVersion(context.Context, *ReadAtVersionRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadAtVersion not implemented")
+}
+func (UnimplementedNodeServiceServer) History(context.Context, *KeyRequest) (*HistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
+}
+func (UnimplementedNodeServiceServer) Scan(context.Context, *ScanRequest) (*ScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedNodeServiceServer) ScanPrefix(context.Context, *ScanPrefixRequest) (*ScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ScanPrefix not implemented")
+}
+func (UnimplementedNodeServiceServer) ReadAll(context.Context, *Empty) (*Items, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadAll not implemented")
+}
+func (UnimplementedNodeServiceServer) Export(context.Context, *ExportRequest) (*ExportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (UnimplementedNodeServiceServer) Watch(context.Context, *WatchRequest) (*WatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedNodeServiceServer) Drain(context.Context, *Empty) (*DrainStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedNodeServiceServer) Undrain(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Undrain not implemented")
+}
+func (UnimplementedNodeServiceServer) Status(context.Context, *Empty) (*NodeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedNodeServiceServer) mustEmbedUnimplementedNodeServiceServer() {}
+
+// UnsafeNodeServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NodeServiceServer will
+// result in compilation errors.
+type UnsafeNodeServiceServer interface {
+	mustEmbedUnimplementedNodeServiceServer()
+}
+
+func RegisterNodeServiceServer(s grpc.ServiceRegistrar, srv NodeServiceServer) {
+	s.RegisterService(&NodeService_ServiceDesc, srv)
+}
+
+func _NodeService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Ping(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeMeta)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Update_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Update(ctx, req.(*NodeMeta))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ClientWrite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ClientWrite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ClientWrite_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ClientWrite(ctx, req.(*ClientWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Write_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Write(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Write_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Write(ctx, req.(*WriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ClientDelete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ClientDelete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ClientDelete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ClientDelete(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_CompareAndSwap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareAndSwapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).CompareAndSwap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_CompareAndSwap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).CompareAndSwap(ctx, req.(*CompareAndSwapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_PutIfAbsent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).PutIfAbsent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_PutIfAbsent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).PutIfAbsent(ctx, req.(*ClientWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ClientWriteBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ClientWriteBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ClientWriteBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ClientWriteBatch(ctx, req.(*WriteBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ClientTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ClientTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ClientTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ClientTransaction(ctx, req.(*TransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_WriteBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VersionedItems)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).WriteBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_WriteBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).WriteBatch(ctx, req.(*VersionedItems))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Replicate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Replication)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Replicate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Replicate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Replicate(ctx, req.(*Replication))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_LatestVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).LatestVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_LatestVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).LatestVersion(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_FwdPropagate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PropagateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).FwdPropagate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_FwdPropagate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).FwdPropagate(ctx, req.(*PropagateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_BackPropagate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PropagateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).BackPropagate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_BackPropagate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).BackPropagate(ctx, req.(*PropagateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_MerkleHashes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleHashesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).MerkleHashes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_MerkleHashes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).MerkleHashes(ctx, req.(*MerkleHashesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_BackPropagateBuckets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackPropagateBucketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).BackPropagateBuckets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_BackPropagateBuckets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).BackPropagateBuckets(ctx, req.(*BackPropagateBucketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Commit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Commit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Commit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Commit(ctx, req.(*CommitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_CommitBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).CommitBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_CommitBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).CommitBatch(ctx, req.(*CommitBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Read_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Read(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Read_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Read(ctx, req.(*ReadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ReadAtVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadAtVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ReadAtVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ReadAtVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ReadAtVersion(ctx, req.(*ReadAtVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).History(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_History_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).History(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Scan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ScanPrefix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanPrefixRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ScanPrefix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ScanPrefix_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ScanPrefix(ctx, req.(*ScanPrefixRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_ReadAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).ReadAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_ReadAll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).ReadAll(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Export_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Export(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Export_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Export(ctx, req.(*ExportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Watch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Watch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Watch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Watch(ctx, req.(*WatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Drain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Drain(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Undrain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Undrain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Undrain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Undrain(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeService_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServiceServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeService_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServiceServer).Status(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NodeService_ServiceDesc is the grpc.ServiceDesc for NodeService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var NodeService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "craq.NodeService",
+	HandlerType: (*NodeServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ping",
+			Handler:    _NodeService_Ping_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _NodeService_Update_Handler,
+		},
+		{
+			MethodName: "ClientWrite",
+			Handler:    _NodeService_ClientWrite_Handler,
+		},
+		{
+			MethodName: "Write",
+			Handler:    _NodeService_Write_Handler,
+		},
+		{
+			MethodName: "ClientDelete",
+			Handler:    _NodeService_ClientDelete_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _NodeService_Delete_Handler,
+		},
+		{
+			MethodName: "CompareAndSwap",
+			Handler:    _NodeService_CompareAndSwap_Handler,
+		},
+		{
+			MethodName: "PutIfAbsent",
+			Handler:    _NodeService_PutIfAbsent_Handler,
+		},
+		{
+			MethodName: "ClientWriteBatch",
+			Handler:    _NodeService_ClientWriteBatch_Handler,
+		},
+		{
+			MethodName: "ClientTransaction",
+			Handler:    _NodeService_ClientTransaction_Handler,
+		},
+		{
+			MethodName: "WriteBatch",
+			Handler:    _NodeService_WriteBatch_Handler,
+		},
+		{
+			MethodName: "Replicate",
+			Handler:    _NodeService_Replicate_Handler,
+		},
+		{
+			MethodName: "LatestVersion",
+			Handler:    _NodeService_LatestVersion_Handler,
+		},
+		{
+			MethodName: "FwdPropagate",
+			Handler:    _NodeService_FwdPropagate_Handler,
+		},
+		{
+			MethodName: "BackPropagate",
+			Handler:    _NodeService_BackPropagate_Handler,
+		},
+		{
+			MethodName: "MerkleHashes",
+			Handler:    _NodeService_MerkleHashes_Handler,
+		},
+		{
+			MethodName: "BackPropagateBuckets",
+			Handler:    _NodeService_BackPropagateBuckets_Handler,
+		},
+		{
+			MethodName: "Commit",
+			Handler:    _NodeService_Commit_Handler,
+		},
+		{
+			MethodName: "CommitBatch",
+			Handler:    _NodeService_CommitBatch_Handler,
+		},
+		{
+			MethodName: "Read",
+			Handler:    _NodeService_Read_Handler,
+		},
+		{
+			MethodName: "ReadAtVersion",
+			Handler:    _NodeService_ReadAtVersion_Handler,
+		},
+		{
+			MethodName: "History",
+			Handler:    _NodeService_History_Handler,
+		},
+		{
+			MethodName: "Scan",
+			Handler:    _NodeService_Scan_Handler,
+		},
+		{
+			MethodName: "ScanPrefix",
+			Handler:    _NodeService_ScanPrefix_Handler,
+		},
+		{
+			MethodName: "ReadAll",
+			Handler:    _NodeService_ReadAll_Handler,
+		},
+		{
+			MethodName: "Export",
+			Handler:    _NodeService_Export_Handler,
+		},
+		{
+			MethodName: "Watch",
+			Handler:    _NodeService_Watch_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _NodeService_Drain_Handler,
+		},
+		{
+			MethodName: "Undrain",
+			Handler:    _NodeService_Undrain_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _NodeService_Status_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "craq.proto",
+}
+
+const (
+	CoordinatorService_AddNode_FullMethodName        = "/craq.CoordinatorService/AddNode"
+	CoordinatorService_Write_FullMethodName          = "/craq.CoordinatorService/Write"
+	CoordinatorService_Delete_FullMethodName         = "/craq.CoordinatorService/Delete"
+	CoordinatorService_CompareAndSwap_FullMethodName = "/craq.CoordinatorService/CompareAndSwap"
+	CoordinatorService_PutIfAbsent_FullMethodName    = "/craq.CoordinatorService/PutIfAbsent"
+	CoordinatorService_WriteBatch_FullMethodName     = "/craq.CoordinatorService/WriteBatch"
+	CoordinatorService_Transaction_FullMethodName    = "/craq.CoordinatorService/Transaction"
+	CoordinatorService_RemoveNode_FullMethodName     = "/craq.CoordinatorService/RemoveNode"
+	CoordinatorService_ReportState_FullMethodName    = "/craq.CoordinatorService/ReportState"
+	CoordinatorService_Decommission_FullMethodName   = "/craq.CoordinatorService/Decommission"
+	CoordinatorService_Routes_FullMethodName         = "/craq.CoordinatorService/Routes"
+)
+
+// CoordinatorServiceClient is the client API for CoordinatorService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CoordinatorServiceClient interface {
+	AddNode(ctx context.Context, in *AddNodeRequest, opts ...grpc.CallOption) (*NodeMeta, error)
+	Write(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	Delete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	CompareAndSwap(ctx context.Context, in *CompareAndSwapRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	PutIfAbsent(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	WriteBatch(ctx context.Context, in *WriteBatchRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error)
+	Transaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error)
+	RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	ReportState(ctx context.Context, in *ReportStateRequest, opts ...grpc.CallOption) (*Empty, error)
+	Decommission(ctx context.Context, in *DecommissionRequest, opts ...grpc.CallOption) (*DecommissionStatus, error)
+	Routes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*RoutingTable, error)
+}
+
+type coordinatorServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCoordinatorServiceClient(cc grpc.ClientConnInterface) CoordinatorServiceClient {
+	return &coordinatorServiceClient{cc}
+}
+
+func (c *coordinatorServiceClient) AddNode(ctx context.Context, in *AddNodeRequest, opts ...grpc.CallOption) (*NodeMeta, error) {
+	out := new(NodeMeta)
+	err := c.cc.Invoke(ctx, CoordinatorService_AddNode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Write(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_Write_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Delete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_Delete_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) CompareAndSwap(ctx context.Context, in *CompareAndSwapRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_CompareAndSwap_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) PutIfAbsent(ctx context.Context, in *ClientWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_PutIfAbsent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) WriteBatch(ctx context.Context, in *WriteBatchRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error) {
+	out := new(WriteBatchResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_WriteBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Transaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*WriteBatchResponse, error) {
+	out := new(WriteBatchResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_Transaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, CoordinatorService_RemoveNode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) ReportState(ctx context.Context, in *ReportStateRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, CoordinatorService_ReportState_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Decommission(ctx context.Context, in *DecommissionRequest, opts ...grpc.CallOption) (*DecommissionStatus, error) {
+	out := new(DecommissionStatus)
+	err := c.cc.Invoke(ctx, CoordinatorService_Decommission_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Routes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*RoutingTable, error) {
+	out := new(RoutingTable)
+	err := c.cc.Invoke(ctx, CoordinatorService_Routes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CoordinatorServiceServer is the server API for CoordinatorService service.
+// All implementations must embed UnimplementedCoordinatorServiceServer
+// for forward compatibility
+type CoordinatorServiceServer interface {
+	AddNode(context.Context, *AddNodeRequest) (*NodeMeta, error)
+	Write(context.Context, *ClientWriteRequest) (*WriteResponse, error)
+	Delete(context.Context, *KeyRequest) (*WriteResponse, error)
+	CompareAndSwap(context.Context, *CompareAndSwapRequest) (*WriteResponse, error)
+	PutIfAbsent(context.Context, *ClientWriteRequest) (*WriteResponse, error)
+	WriteBatch(context.Context, *WriteBatchRequest) (*WriteBatchResponse, error)
+	Transaction(context.Context, *TransactionRequest) (*WriteBatchResponse, error)
+	RemoveNode(context.Context, *RemoveNodeRequest) (*Empty, error)
+	ReportState(context.Context, *ReportStateRequest) (*Empty, error)
+	Decommission(context.Context, *DecommissionRequest) (*DecommissionStatus, error)
+	Routes(context.Context, *Empty) (*RoutingTable, error)
+	mustEmbedUnimplementedCoordinatorServiceServer()
+}
+
+// UnimplementedCoordinatorServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCoordinatorServiceServer struct {
+}
+
+func (UnimplementedCoordinatorServiceServer) AddNode(context.Context, *AddNodeRequest) (*NodeMeta, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddNode not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Write(context.Context, *ClientWriteRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Write not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Delete(context.Context, *KeyRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) CompareAndSwap(context.Context, *CompareAndSwapRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareAndSwap not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) PutIfAbsent(context.Context, *ClientWriteRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutIfAbsent not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) WriteBatch(context.Context, *WriteBatchRequest) (*WriteBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteBatch not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Transaction(context.Context, *TransactionRequest) (*WriteBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transaction not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) RemoveNode(context.Context, *RemoveNodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveNode not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) ReportState(context.Context, *ReportStateRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportState not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Decommission(context.Context, *DecommissionRequest) (*DecommissionStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decommission not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Routes(context.Context, *Empty) (*RoutingTable, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Routes not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) mustEmbedUnimplementedCoordinatorServiceServer() {}
+
+// UnsafeCoordinatorServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CoordinatorServiceServer will
+// result in compilation errors.
+type UnsafeCoordinatorServiceServer interface {
+	mustEmbedUnimplementedCoordinatorServiceServer()
+}
+
+func RegisterCoordinatorServiceServer(s grpc.ServiceRegistrar, srv CoordinatorServiceServer) {
+	s.RegisterService(&CoordinatorService_ServiceDesc, srv)
+}
+
+func _CoordinatorService_AddNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).AddNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_AddNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).AddNode(ctx, req.(*AddNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Write_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Write(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Write_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Write(ctx, req.(*ClientWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Delete(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_CompareAndSwap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareAndSwapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).CompareAndSwap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_CompareAndSwap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).CompareAndSwap(ctx, req.(*CompareAndSwapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_PutIfAbsent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).PutIfAbsent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_PutIfAbsent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).PutIfAbsent(ctx, req.(*ClientWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_WriteBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).WriteBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_WriteBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).WriteBatch(ctx, req.(*WriteBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Transaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Transaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Transaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Transaction(ctx, req.(*TransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_RemoveNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).RemoveNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_RemoveNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).RemoveNode(ctx, req.(*RemoveNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_ReportState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).ReportState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_ReportState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).ReportState(ctx, req.(*ReportStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Decommission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecommissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Decommission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Decommission_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Decommission(ctx, req.(*DecommissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Routes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Routes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Routes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Routes(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CoordinatorService_ServiceDesc is the grpc.ServiceDesc for CoordinatorService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CoordinatorService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "craq.CoordinatorService",
+	HandlerType: (*CoordinatorServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddNode",
+			Handler:    _CoordinatorService_AddNode_Handler,
+		},
+		{
+			MethodName: "Write",
+			Handler:    _CoordinatorService_Write_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _CoordinatorService_Delete_Handler,
+		},
+		{
+			MethodName: "CompareAndSwap",
+			Handler:    _CoordinatorService_CompareAndSwap_Handler,
+		},
+		{
+			MethodName: "PutIfAbsent",
+			Handler:    _CoordinatorService_PutIfAbsent_Handler,
+		},
+		{
+			MethodName: "WriteBatch",
+			Handler:    _CoordinatorService_WriteBatch_Handler,
+		},
+		{
+			MethodName: "Transaction",
+			Handler:    _CoordinatorService_Transaction_Handler,
+		},
+		{
+			MethodName: "RemoveNode",
+			Handler:    _CoordinatorService_RemoveNode_Handler,
+		},
+		{
+			MethodName: "ReportState",
+			Handler:    _CoordinatorService_ReportState_Handler,
+		},
+		{
+			MethodName: "Decommission",
+			Handler:    _CoordinatorService_Decommission_Handler,
+		},
+		{
+			MethodName: "Routes",
+			Handler:    _CoordinatorService_Routes_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "craq.proto",
+}
+
+const (
+	RaftService_RequestVote_FullMethodName   = "/craq.RaftService/RequestVote"
+	RaftService_AppendEntries_FullMethodName = "/craq.RaftService/AppendEntries"
+)
+
+// RaftServiceClient is the client API for RaftService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RaftServiceClient interface {
+	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error)
+}
+
+type raftServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRaftServiceClient(cc grpc.ClientConnInterface) RaftServiceClient {
+	return &raftServiceClient{cc}
+}
+
+func (c *raftServiceClient) RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error) {
+	out := new(VoteResponse)
+	err := c.cc.Invoke(ctx, RaftService_RequestVote_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) AppendEntries(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error) {
+	out := new(AppendResponse)
+	err := c.cc.Invoke(ctx, RaftService_AppendEntries_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RaftServiceServer is the server API for RaftService service.
+// All implementations must embed UnimplementedRaftServiceServer
+// for forward compatibility
+type RaftServiceServer interface {
+	RequestVote(context.Context, *VoteRequest) (*VoteResponse, error)
+	AppendEntries(context.Context, *AppendRequest) (*AppendResponse, error)
+	mustEmbedUnimplementedRaftServiceServer()
+}
+
+// UnimplementedRaftServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRaftServiceServer struct {
+}
+
+func (UnimplementedRaftServiceServer) RequestVote(context.Context, *VoteRequest) (*VoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+func (UnimplementedRaftServiceServer) AppendEntries(context.Context, *AppendRequest) (*AppendResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendEntries not implemented")
+}
+func (UnimplementedRaftServiceServer) mustEmbedUnimplementedRaftServiceServer() {}
+
+// UnsafeRaftServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RaftServiceServer will
+// result in compilation errors.
+type UnsafeRaftServiceServer interface {
+	mustEmbedUnimplementedRaftServiceServer()
+}
+
+func RegisterRaftServiceServer(s grpc.ServiceRegistrar, srv RaftServiceServer) {
+	s.RegisterService(&RaftService_ServiceDesc, srv)
+}
+
+func _RaftService_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RaftService_RequestVote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).RequestVote(ctx, req.(*VoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_AppendEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).AppendEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RaftService_AppendEntries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).AppendEntries(ctx, req.(*AppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RaftService_ServiceDesc is the grpc.ServiceDesc for RaftService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RaftService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "craq.RaftService",
+	HandlerType: (*RaftServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RequestVote",
+			Handler:    _RaftService_RequestVote_Handler,
+		},
+		{
+			MethodName: "AppendEntries",
+			Handler:    _RaftService_AppendEntries_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "craq.proto",
+}
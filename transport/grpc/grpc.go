@@ -0,0 +1,346 @@
+// grpc package is a transporter client that uses gRPC for communication. The
+// services are described in craq.proto, so clients written in other languages
+// can generate their own stubs and talk to the chain. The Go stubs generated
+// from it live in the craqpb package.
+//
+// Errors returned by the services are sent as gRPC statuses with the error's
+// message, and with a code that matches the error where there is one, e.g.
+// NotFound for transport.ErrNotFound. The clients turn the status back into an
+// error with the same message, so the transport.IsX helpers work on it. Like
+// in transport/netrpc, a *transport.ConflictError is sent in the reply.
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/despreston/go-craq/transport/grpc --go-grpc_out=. --go-grpc_opt=module=github.com/despreston/go-craq/transport/grpc craq.proto
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// How long Connect waits for the connection to be established.
+	dialTimeout = 5 * time.Second
+	// net/rpc doesn't limit the size of messages, so neither does this
+	// transport. Reads of every key can be large.
+	maxMessageSize = math.MaxInt32
+)
+
+// NewServer creates a gRPC server for registering bindings with, e.g. with
+// craqpb.RegisterNodeServiceServer.
+func NewServer() *grpc.Server {
+	return grpc.NewServer(grpc.MaxRecvMsgSize(maxMessageSize))
+}
+
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Connect dials the address and waits for the connection to be established, so
+// that unreachable addresses fail here like they do with net/rpc.
+func (c *Client) Connect(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(maxMessageSize),
+			grpc.MaxCallSendMsgSize(maxMessageSize),
+		),
+	)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// toStatus turns an error returned by a service into a gRPC status with the
+// same message.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := codes.Unknown
+	switch {
+	case transport.IsNotFound(err), transport.IsVersionNotFound(err):
+		code = codes.NotFound
+	case transport.IsNotServing(err), transport.IsDraining(err):
+		code = codes.Unavailable
+	case transport.IsCommitTimeout(err):
+		code = codes.DeadlineExceeded
+	case transport.IsStaleStream(err), err.Error() == transport.ErrStaleVersion.Error():
+		code = codes.FailedPrecondition
+	case transport.IsWatchCompacted(err):
+		code = codes.OutOfRange
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// fromStatus turns the status returned by a call back into an error with the
+// message of the service's error.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return errors.New(s.Message())
+	}
+	return err
+}
+
+// writeReply is the reply to a client write. A *transport.ConflictError is
+// moved into the reply so that the caller can get it back with fromWriteReply.
+func writeReply(version uint64, err error) (*craqpb.WriteResponse, error) {
+	if conflict, ok := err.(*transport.ConflictError); ok {
+		return &craqpb.WriteResponse{Conflict: conflictToProto(conflict)}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.WriteResponse{Version: version}, nil
+}
+
+func fromWriteReply(reply *craqpb.WriteResponse, err error) (uint64, error) {
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	if reply.Conflict != nil {
+		return 0, conflictFromProto(reply.Conflict)
+	}
+	return reply.Version, nil
+}
+
+// batchReply is writeReply for writes that return several versions.
+func batchReply(versions []uint64, err error) (*craqpb.WriteBatchResponse, error) {
+	if conflict, ok := err.(*transport.ConflictError); ok {
+		return &craqpb.WriteBatchResponse{Conflict: conflictToProto(conflict)}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.WriteBatchResponse{Versions: versions}, nil
+}
+
+func fromBatchReply(reply *craqpb.WriteBatchResponse, err error) ([]uint64, error) {
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if reply.Conflict != nil {
+		return nil, conflictFromProto(reply.Conflict)
+	}
+	return reply.Versions, nil
+}
+
+// ----------------------------------------------------------------------------
+// Conversions between the transport types and the protocol buffer messages.
+// The enums in craq.proto have the same values as the ones in the transport
+// package, and times are sent as Unix nanoseconds, with 0 for the zero time.
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func conflictToProto(e *transport.ConflictError) *craqpb.ConflictError {
+	return &craqpb.ConflictError{Key: e.Key, Latest: e.Latest, Exists: e.Exists}
+}
+
+func conflictFromProto(e *craqpb.ConflictError) *transport.ConflictError {
+	return &transport.ConflictError{Key: e.Key, Latest: e.Latest, Exists: e.Exists}
+}
+
+func metaToProto(m *transport.NodeMeta) *craqpb.NodeMeta {
+	return &craqpb.NodeMeta{
+		IsHead:  m.IsHead,
+		IsTail:  m.IsTail,
+		Prev:    m.Prev,
+		Next:    m.Next,
+		Tail:    m.Tail,
+		Learner: m.Learner,
+	}
+}
+
+func metaFromProto(m *craqpb.NodeMeta) *transport.NodeMeta {
+	return &transport.NodeMeta{
+		IsHead:  m.IsHead,
+		IsTail:  m.IsTail,
+		Prev:    m.Prev,
+		Next:    m.Next,
+		Tail:    m.Tail,
+		Learner: m.Learner,
+	}
+}
+
+func readOptsToProto(opts *transport.ReadOpts) *craqpb.ReadOpts {
+	if opts == nil {
+		return nil
+	}
+	return &craqpb.ReadOpts{
+		Mode:          craqpb.ReadMode(opts.Mode),
+		MaxStaleness:  int64(opts.MaxStaleness),
+		MaxVersionGap: opts.MaxVersionGap,
+	}
+}
+
+func readOptsFromProto(opts *craqpb.ReadOpts) *transport.ReadOpts {
+	if opts == nil {
+		return nil
+	}
+	return &transport.ReadOpts{
+		Mode:          transport.ReadMode(opts.Mode),
+		MaxStaleness:  time.Duration(opts.MaxStaleness),
+		MaxVersionGap: opts.MaxVersionGap,
+	}
+}
+
+func itemsToProto(items []transport.Item) []*craqpb.Item {
+	pb := make([]*craqpb.Item, len(items))
+	for i, item := range items {
+		pb[i] = &craqpb.Item{Key: item.Key, Value: item.Value, Ttl: int64(item.TTL)}
+	}
+	return pb
+}
+
+func itemsFromProto(pb []*craqpb.Item) []transport.Item {
+	items := make([]transport.Item, len(pb))
+	for i, item := range pb {
+		items[i] = transport.Item{
+			Key:   item.Key,
+			Value: item.Value,
+			TTL:   time.Duration(item.Ttl),
+		}
+	}
+	return items
+}
+
+func versionedItemsToProto(items []transport.VersionedItem) []*craqpb.VersionedItem {
+	pb := make([]*craqpb.VersionedItem, len(items))
+	for i, item := range items {
+		pb[i] = &craqpb.VersionedItem{
+			Key:       item.Key,
+			Value:     item.Value,
+			Version:   item.Version,
+			Deleted:   item.Deleted,
+			ExpiresAt: unixNano(item.ExpiresAt),
+		}
+	}
+	return pb
+}
+
+func versionedItemsFromProto(pb []*craqpb.VersionedItem) []transport.VersionedItem {
+	items := make([]transport.VersionedItem, len(pb))
+	for i, item := range pb {
+		items[i] = transport.VersionedItem{
+			Key:       item.Key,
+			Value:     item.Value,
+			Version:   item.Version,
+			Deleted:   item.Deleted,
+			ExpiresAt: fromUnixNano(item.ExpiresAt),
+		}
+	}
+	return items
+}
+
+func txnToProto(txn *transport.Transaction) *craqpb.TransactionRequest {
+	pb := &craqpb.TransactionRequest{Writes: itemsToProto(txn.Writes)}
+	for _, p := range txn.Preconditions {
+		pb.Preconditions = append(pb.Preconditions, &craqpb.Precondition{
+			Key:     p.Key,
+			Version: p.Version,
+			Absent:  p.Absent,
+		})
+	}
+	return pb
+}
+
+func txnFromProto(pb *craqpb.TransactionRequest) *transport.Transaction {
+	txn := &transport.Transaction{Writes: itemsFromProto(pb.Writes)}
+	for _, p := range pb.Preconditions {
+		txn.Preconditions = append(txn.Preconditions, transport.Precondition{
+			Key:     p.Key,
+			Version: p.Version,
+			Absent:  p.Absent,
+		})
+	}
+	return txn
+}
+
+func propagateResponseToProto(r *transport.PropagateResponse) *craqpb.PropagateResponse {
+	pb := &craqpb.PropagateResponse{Items: make(map[string]*craqpb.ValueVersions)}
+	for key, vals := range *r {
+		versions := &craqpb.ValueVersions{}
+		for _, v := range vals {
+			versions.Versions = append(versions.Versions, &craqpb.ValueVersion{
+				Value:     v.Value,
+				Version:   v.Version,
+				Deleted:   v.Deleted,
+				ExpiresAt: unixNano(v.ExpiresAt),
+			})
+		}
+		pb.Items[key] = versions
+	}
+	return pb
+}
+
+func propagateResponseFromProto(pb *craqpb.PropagateResponse) *transport.PropagateResponse {
+	r := transport.PropagateResponse{}
+	for key, versions := range pb.Items {
+		vals := []transport.ValueVersion{}
+		for _, v := range versions.Versions {
+			vals = append(vals, transport.ValueVersion{
+				Value:     v.Value,
+				Version:   v.Version,
+				Deleted:   v.Deleted,
+				ExpiresAt: fromUnixNano(v.ExpiresAt),
+			})
+		}
+		r[key] = vals
+	}
+	return &r
+}
+
+func drainStatusToProto(s *transport.DrainStatus) *craqpb.DrainStatus {
+	return &craqpb.DrainStatus{
+		Dirty:       int32(s.Dirty),
+		Replicating: int32(s.Replicating),
+		Committing:  int32(s.Committing),
+		Writing:     int32(s.Writing),
+	}
+}
+
+func drainStatusFromProto(pb *craqpb.DrainStatus) *transport.DrainStatus {
+	return &transport.DrainStatus{
+		Dirty:       int(pb.GetDirty()),
+		Replicating: int(pb.GetReplicating()),
+		Committing:  int(pb.GetCommitting()),
+		Writing:     int(pb.GetWriting()),
+	}
+}
@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
+	"github.com/google/go-cmp/cmp"
+)
+
+// FakeNode implements the methods of transport.NodeService used by the tests.
+// Calling any other method panics.
+type FakeNode struct {
+	transport.NodeService
+	items map[string][]byte
+	batch []transport.Item // items of the last batch
+}
+
+func (f *FakeNode) Read(
+	key string,
+	_ *transport.ReadOpts,
+) (string, []byte, uint64, error) {
+	v, has := f.items[key]
+	if !has {
+		return "", nil, 0, transport.ErrNotFound
+	}
+	return key, v, 1, nil
+}
+
+func (f *FakeNode) CompareAndSwap(
+	key string,
+	expected uint64,
+	_ []byte,
+) (uint64, error) {
+	return 0, &transport.ConflictError{Key: key, Latest: expected + 1, Exists: true}
+}
+
+func (f *FakeNode) ClientWriteBatch(items []transport.Item) ([]uint64, error) {
+	f.batch = items
+	versions := make([]uint64, len(items))
+	for i := range items {
+		versions[i] = uint64(i + 1)
+	}
+	return versions, nil
+}
+
+func setup(t *testing.T, node *FakeNode) transport.NodeClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer()
+	craqpb.RegisterNodeServiceServer(srv, &NodeBinding{Svc: node})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	client := NewNodeClient()
+	if err := client.Connect(lis.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRead(t *testing.T) {
+	// Not valid UTF-8, to check values are sent as bytes.
+	value := []byte{0xff, 0xfe, 0x00, 'a'}
+	client := setup(t, &FakeNode{items: map[string][]byte{"hello": value}})
+
+	key, got, version, err := client.Read("hello", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if key != "hello" || version != 1 {
+		t.Errorf("unexpected key or version\n  got: %q %d", key, version)
+	}
+	if diff := cmp.Diff(value, got); diff != "" {
+		t.Errorf("unexpected value (-want +got):\n%s", diff)
+	}
+
+	if _, _, _, err := client.Read("missing", nil); !transport.IsNotFound(err) {
+		t.Errorf("unexpected error\n  want: %#v\n  got: %#v", transport.ErrNotFound, err)
+	}
+}
+
+func TestCompareAndSwapConflict(t *testing.T) {
+	client := setup(t, &FakeNode{})
+
+	_, err := client.CompareAndSwap("hello", 3, []byte("world"))
+	want := &transport.ConflictError{Key: "hello", Latest: 4, Exists: true}
+	if diff := cmp.Diff(want, err); diff != "" {
+		t.Errorf("unexpected error (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientWriteBatch(t *testing.T) {
+	node := &FakeNode{}
+	client := setup(t, node)
+
+	items := []transport.Item{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2"), TTL: 30 * time.Second},
+	}
+	versions, err := client.ClientWriteBatch(items)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if diff := cmp.Diff([]uint64{1, 2}, versions); diff != "" {
+		t.Errorf("unexpected versions (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(items, node.batch); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,630 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
+)
+
+func NewNodeClient() transport.NodeClient {
+	return &NodeClient{Client: &Client{}}
+}
+
+// NodeClient is for invoking gRPC methods on a Node.
+type NodeClient struct {
+	*Client
+	rpc craqpb.NodeServiceClient
+}
+
+func (nc *NodeClient) Connect(addr string) error {
+	if err := nc.Client.Connect(addr); err != nil {
+		return err
+	}
+	nc.rpc = craqpb.NewNodeServiceClient(nc.conn)
+	return nil
+}
+
+func (nc *NodeClient) Ping() error {
+	_, err := nc.rpc.Ping(context.Background(), &craqpb.Empty{})
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) Update(meta *transport.NodeMeta) error {
+	_, err := nc.rpc.Update(context.Background(), metaToProto(meta))
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) LatestVersion(key string) (string, uint64, error) {
+	reply, err := nc.rpc.LatestVersion(context.Background(), &craqpb.KeyRequest{Key: key})
+	if err != nil {
+		return "", 0, fromStatus(err)
+	}
+	return reply.Key, reply.Version, nil
+}
+
+func (nc *NodeClient) Commit(key string, version uint64) error {
+	args := &craqpb.CommitRequest{Key: key, Version: version}
+	_, err := nc.rpc.Commit(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) CommitBatch(versions map[string]uint64) error {
+	args := &craqpb.CommitBatchRequest{Versions: versions}
+	_, err := nc.rpc.CommitBatch(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) Read(
+	key string,
+	opts *transport.ReadOpts,
+) (string, []byte, uint64, error) {
+	args := &craqpb.ReadRequest{Key: key, Opts: readOptsToProto(opts)}
+	reply, err := nc.rpc.Read(context.Background(), args)
+	if err != nil {
+		return "", nil, 0, fromStatus(err)
+	}
+	return reply.Key, reply.Value, reply.Version, nil
+}
+
+func (nc *NodeClient) ReadAtVersion(
+	key string,
+	version uint64,
+) (string, []byte, error) {
+	args := &craqpb.ReadAtVersionRequest{Key: key, Version: version}
+	reply, err := nc.rpc.ReadAtVersion(context.Background(), args)
+	if err != nil {
+		return "", nil, fromStatus(err)
+	}
+	return reply.Key, reply.Value, nil
+}
+
+func (nc *NodeClient) History(key string) ([]transport.HistoryItem, error) {
+	reply, err := nc.rpc.History(context.Background(), &craqpb.KeyRequest{Key: key})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	items := make([]transport.HistoryItem, len(reply.Items))
+	for i, item := range reply.Items {
+		items[i] = transport.HistoryItem{
+			Value:       item.Value,
+			Version:     item.Version,
+			Deleted:     item.Deleted,
+			CommittedAt: fromUnixNano(item.CommittedAt),
+		}
+	}
+	return items, nil
+}
+
+func (nc *NodeClient) Scan(start, end string, limit int) (*transport.ScanResult, error) {
+	args := &craqpb.ScanRequest{Start: start, End: end, Limit: int32(limit)}
+	reply, err := nc.rpc.Scan(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.ScanResult{
+		Items: versionedItemsFromProto(reply.Items),
+		Next:  reply.Next,
+	}, nil
+}
+
+func (nc *NodeClient) ScanPrefix(
+	prefix, cursor string,
+	limit int,
+) (*transport.ScanResult, error) {
+	args := &craqpb.ScanPrefixRequest{Prefix: prefix, Cursor: cursor, Limit: int32(limit)}
+	reply, err := nc.rpc.ScanPrefix(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.ScanResult{
+		Items: versionedItemsFromProto(reply.Items),
+		Next:  reply.Next,
+	}, nil
+}
+
+func (nc *NodeClient) ReadAll() (*[]transport.Item, error) {
+	reply, err := nc.rpc.ReadAll(context.Background(), &craqpb.Empty{})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	items := itemsFromProto(reply.Items)
+	return &items, nil
+}
+
+func (nc *NodeClient) Export(cursor string, limit int) (*transport.ExportChunk, error) {
+	args := &craqpb.ExportRequest{Cursor: cursor, Limit: int32(limit)}
+	reply, err := nc.rpc.Export(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.ExportChunk{
+		Items: versionedItemsFromProto(reply.Items),
+		Next:  reply.Next,
+	}, nil
+}
+
+func (nc *NodeClient) Watch(prefix string, from uint64) (*transport.WatchResult, error) {
+	args := &craqpb.WatchRequest{Prefix: prefix, From: from}
+	reply, err := nc.rpc.Watch(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	result := &transport.WatchResult{
+		Events:   make([]transport.WatchEvent, len(reply.Events)),
+		Revision: reply.Revision,
+	}
+	for i, e := range reply.Events {
+		result.Events[i] = transport.WatchEvent{
+			Key:      e.Key,
+			Value:    e.Value,
+			Version:  e.Version,
+			Deleted:  e.Deleted,
+			Revision: e.Revision,
+		}
+	}
+	return result, nil
+}
+
+func (nc *NodeClient) Drain() (*transport.DrainStatus, error) {
+	reply, err := nc.rpc.Drain(context.Background(), &craqpb.Empty{})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return drainStatusFromProto(reply), nil
+}
+
+func (nc *NodeClient) Undrain() error {
+	_, err := nc.rpc.Undrain(context.Background(), &craqpb.Empty{})
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) Status() (*transport.NodeStatus, error) {
+	reply, err := nc.rpc.Status(context.Background(), &craqpb.Empty{})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.NodeStatus{
+		Address: reply.Address,
+		State:   transport.NodeState(reply.State),
+		IsHead:  reply.IsHead,
+		IsTail:  reply.IsTail,
+		Prev:    reply.Prev,
+		Next:    reply.Next,
+		Tail:    reply.Tail,
+	}, nil
+}
+
+func (nc *NodeClient) Write(key string, value []byte, version uint64) error {
+	args := &craqpb.WriteRequest{Key: key, Value: value, Version: version}
+	_, err := nc.rpc.Write(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) ClientWrite(key string, value []byte) (uint64, error) {
+	args := &craqpb.ClientWriteRequest{Key: key, Value: value}
+	return fromWriteReply(nc.rpc.ClientWrite(context.Background(), args))
+}
+
+func (nc *NodeClient) ClientDelete(key string) (uint64, error) {
+	args := &craqpb.KeyRequest{Key: key}
+	return fromWriteReply(nc.rpc.ClientDelete(context.Background(), args))
+}
+
+func (nc *NodeClient) Delete(key string, version uint64) error {
+	args := &craqpb.DeleteRequest{Key: key, Version: version}
+	_, err := nc.rpc.Delete(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) CompareAndSwap(
+	key string,
+	expected uint64,
+	value []byte,
+) (uint64, error) {
+	args := &craqpb.CompareAndSwapRequest{Key: key, Expected: expected, Value: value}
+	return fromWriteReply(nc.rpc.CompareAndSwap(context.Background(), args))
+}
+
+func (nc *NodeClient) PutIfAbsent(key string, value []byte) (uint64, error) {
+	args := &craqpb.ClientWriteRequest{Key: key, Value: value}
+	return fromWriteReply(nc.rpc.PutIfAbsent(context.Background(), args))
+}
+
+func (nc *NodeClient) ClientWriteBatch(items []transport.Item) ([]uint64, error) {
+	args := &craqpb.WriteBatchRequest{Items: itemsToProto(items)}
+	return fromBatchReply(nc.rpc.ClientWriteBatch(context.Background(), args))
+}
+
+func (nc *NodeClient) ClientTransaction(
+	txn *transport.Transaction,
+) ([]uint64, error) {
+	return fromBatchReply(nc.rpc.ClientTransaction(context.Background(), txnToProto(txn)))
+}
+
+func (nc *NodeClient) WriteBatch(items []transport.VersionedItem) error {
+	args := &craqpb.VersionedItems{Items: versionedItemsToProto(items)}
+	_, err := nc.rpc.WriteBatch(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) Replicate(msg *transport.Replication) error {
+	args := &craqpb.Replication{
+		From:   msg.From,
+		Stream: msg.Stream,
+		Seq:    msg.Seq,
+		Items:  versionedItemsToProto(msg.Items),
+	}
+	_, err := nc.rpc.Replicate(context.Background(), args)
+	return fromStatus(err)
+}
+
+func (nc *NodeClient) FwdPropagate(
+	vByK *transport.PropagateRequest,
+) (*transport.PropagateResponse, error) {
+	args := &craqpb.PropagateRequest{Versions: *vByK}
+	reply, err := nc.rpc.FwdPropagate(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return propagateResponseFromProto(reply), nil
+}
+
+func (nc *NodeClient) BackPropagate(
+	vByK *transport.PropagateRequest,
+) (*transport.PropagateResponse, error) {
+	args := &craqpb.PropagateRequest{Versions: *vByK}
+	reply, err := nc.rpc.BackPropagate(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return propagateResponseFromProto(reply), nil
+}
+
+func (nc *NodeClient) MerkleHashes(level int, indexes []int) ([]uint64, error) {
+	args := &craqpb.MerkleHashesRequest{Level: int32(level), Indexes: toInt32s(indexes)}
+	reply, err := nc.rpc.MerkleHashes(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return reply.Hashes, nil
+}
+
+func (nc *NodeClient) BackPropagateBuckets(
+	buckets []int,
+	vByK *transport.PropagateRequest,
+) (*transport.PropagateResponse, error) {
+	args := &craqpb.BackPropagateBucketsRequest{
+		Buckets:  toInt32s(buckets),
+		Versions: *vByK,
+	}
+	reply, err := nc.rpc.BackPropagateBuckets(context.Background(), args)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return propagateResponseFromProto(reply), nil
+}
+
+func toInt32s(ints []int) []int32 {
+	out := make([]int32, len(ints))
+	for i, n := range ints {
+		out[i] = int32(n)
+	}
+	return out
+}
+
+func fromInt32s(ints []int32) []int {
+	out := make([]int, len(ints))
+	for i, n := range ints {
+		out[i] = int(n)
+	}
+	return out
+}
+
+// NodeBinding provides a layer of translation between the NodeService which is
+// transport agnostic and gRPC. It implements craqpb.NodeServiceServer, so it
+// can be registered with craqpb.RegisterNodeServiceServer.
+type NodeBinding struct {
+	craqpb.UnimplementedNodeServiceServer
+	Svc transport.NodeService
+}
+
+func (n *NodeBinding) Ping(_ context.Context, _ *craqpb.Empty) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.Ping())
+}
+
+func (n *NodeBinding) Update(
+	_ context.Context,
+	meta *craqpb.NodeMeta,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.Update(metaFromProto(meta)))
+}
+
+func (n *NodeBinding) ClientWrite(
+	_ context.Context,
+	args *craqpb.ClientWriteRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(n.Svc.ClientWrite(args.Key, args.Value))
+}
+
+func (n *NodeBinding) Write(
+	_ context.Context,
+	args *craqpb.WriteRequest,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.Write(args.Key, args.Value, args.Version))
+}
+
+func (n *NodeBinding) ClientDelete(
+	_ context.Context,
+	args *craqpb.KeyRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(n.Svc.ClientDelete(args.Key))
+}
+
+func (n *NodeBinding) Delete(
+	_ context.Context,
+	args *craqpb.DeleteRequest,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.Delete(args.Key, args.Version))
+}
+
+func (n *NodeBinding) CompareAndSwap(
+	_ context.Context,
+	args *craqpb.CompareAndSwapRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(n.Svc.CompareAndSwap(args.Key, args.Expected, args.Value))
+}
+
+func (n *NodeBinding) PutIfAbsent(
+	_ context.Context,
+	args *craqpb.ClientWriteRequest,
+) (*craqpb.WriteResponse, error) {
+	return writeReply(n.Svc.PutIfAbsent(args.Key, args.Value))
+}
+
+func (n *NodeBinding) ClientWriteBatch(
+	_ context.Context,
+	args *craqpb.WriteBatchRequest,
+) (*craqpb.WriteBatchResponse, error) {
+	return batchReply(n.Svc.ClientWriteBatch(itemsFromProto(args.Items)))
+}
+
+func (n *NodeBinding) ClientTransaction(
+	_ context.Context,
+	txn *craqpb.TransactionRequest,
+) (*craqpb.WriteBatchResponse, error) {
+	return batchReply(n.Svc.ClientTransaction(txnFromProto(txn)))
+}
+
+func (n *NodeBinding) WriteBatch(
+	_ context.Context,
+	args *craqpb.VersionedItems,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.WriteBatch(versionedItemsFromProto(args.Items)))
+}
+
+func (n *NodeBinding) Replicate(
+	_ context.Context,
+	msg *craqpb.Replication,
+) (*craqpb.Empty, error) {
+	err := n.Svc.Replicate(&transport.Replication{
+		From:   msg.From,
+		Stream: msg.Stream,
+		Seq:    msg.Seq,
+		Items:  versionedItemsFromProto(msg.Items),
+	})
+	return &craqpb.Empty{}, toStatus(err)
+}
+
+func (n *NodeBinding) LatestVersion(
+	_ context.Context,
+	args *craqpb.KeyRequest,
+) (*craqpb.VersionResponse, error) {
+	key, version, err := n.Svc.LatestVersion(args.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.VersionResponse{Key: key, Version: version}, nil
+}
+
+func (n *NodeBinding) FwdPropagate(
+	_ context.Context,
+	args *craqpb.PropagateRequest,
+) (*craqpb.PropagateResponse, error) {
+	verByKey := transport.PropagateRequest(args.Versions)
+	r, err := n.Svc.FwdPropagate(&verByKey)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return propagateResponseToProto(r), nil
+}
+
+func (n *NodeBinding) BackPropagate(
+	_ context.Context,
+	args *craqpb.PropagateRequest,
+) (*craqpb.PropagateResponse, error) {
+	verByKey := transport.PropagateRequest(args.Versions)
+	r, err := n.Svc.BackPropagate(&verByKey)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return propagateResponseToProto(r), nil
+}
+
+func (n *NodeBinding) MerkleHashes(
+	_ context.Context,
+	args *craqpb.MerkleHashesRequest,
+) (*craqpb.MerkleHashesResponse, error) {
+	hashes, err := n.Svc.MerkleHashes(int(args.Level), fromInt32s(args.Indexes))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.MerkleHashesResponse{Hashes: hashes}, nil
+}
+
+func (n *NodeBinding) BackPropagateBuckets(
+	_ context.Context,
+	args *craqpb.BackPropagateBucketsRequest,
+) (*craqpb.PropagateResponse, error) {
+	verByKey := transport.PropagateRequest(args.Versions)
+	r, err := n.Svc.BackPropagateBuckets(fromInt32s(args.Buckets), &verByKey)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return propagateResponseToProto(r), nil
+}
+
+func (n *NodeBinding) Commit(
+	_ context.Context,
+	args *craqpb.CommitRequest,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.Commit(args.Key, args.Version))
+}
+
+func (n *NodeBinding) CommitBatch(
+	_ context.Context,
+	args *craqpb.CommitBatchRequest,
+) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.CommitBatch(args.Versions))
+}
+
+func (n *NodeBinding) Read(
+	_ context.Context,
+	args *craqpb.ReadRequest,
+) (*craqpb.VersionedItem, error) {
+	key, value, version, err := n.Svc.Read(args.Key, readOptsFromProto(args.Opts))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.VersionedItem{Key: key, Value: value, Version: version}, nil
+}
+
+func (n *NodeBinding) ReadAtVersion(
+	_ context.Context,
+	args *craqpb.ReadAtVersionRequest,
+) (*craqpb.Item, error) {
+	key, value, err := n.Svc.ReadAtVersion(args.Key, args.Version)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.Item{Key: key, Value: value}, nil
+}
+
+func (n *NodeBinding) History(
+	_ context.Context,
+	args *craqpb.KeyRequest,
+) (*craqpb.HistoryResponse, error) {
+	items, err := n.Svc.History(args.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &craqpb.HistoryResponse{}
+	for _, item := range items {
+		reply.Items = append(reply.Items, &craqpb.HistoryItem{
+			Value:       item.Value,
+			Version:     item.Version,
+			Deleted:     item.Deleted,
+			CommittedAt: unixNano(item.CommittedAt),
+		})
+	}
+	return reply, nil
+}
+
+func (n *NodeBinding) Scan(
+	_ context.Context,
+	args *craqpb.ScanRequest,
+) (*craqpb.ScanResponse, error) {
+	result, err := n.Svc.Scan(args.Start, args.End, int(args.Limit))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.ScanResponse{
+		Items: versionedItemsToProto(result.Items),
+		Next:  result.Next,
+	}, nil
+}
+
+func (n *NodeBinding) ScanPrefix(
+	_ context.Context,
+	args *craqpb.ScanPrefixRequest,
+) (*craqpb.ScanResponse, error) {
+	result, err := n.Svc.ScanPrefix(args.Prefix, args.Cursor, int(args.Limit))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.ScanResponse{
+		Items: versionedItemsToProto(result.Items),
+		Next:  result.Next,
+	}, nil
+}
+
+func (n *NodeBinding) ReadAll(_ context.Context, _ *craqpb.Empty) (*craqpb.Items, error) {
+	items, err := n.Svc.ReadAll()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.Items{Items: itemsToProto(*items)}, nil
+}
+
+func (n *NodeBinding) Export(
+	_ context.Context,
+	args *craqpb.ExportRequest,
+) (*craqpb.ExportResponse, error) {
+	chunk, err := n.Svc.Export(args.Cursor, int(args.Limit))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.ExportResponse{
+		Items: versionedItemsToProto(chunk.Items),
+		Next:  chunk.Next,
+	}, nil
+}
+
+func (n *NodeBinding) Watch(
+	_ context.Context,
+	args *craqpb.WatchRequest,
+) (*craqpb.WatchResponse, error) {
+	result, err := n.Svc.Watch(args.Prefix, args.From)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &craqpb.WatchResponse{Revision: result.Revision}
+	for _, e := range result.Events {
+		reply.Events = append(reply.Events, &craqpb.WatchEvent{
+			Key:      e.Key,
+			Value:    e.Value,
+			Version:  e.Version,
+			Deleted:  e.Deleted,
+			Revision: e.Revision,
+		})
+	}
+	return reply, nil
+}
+
+func (n *NodeBinding) Drain(_ context.Context, _ *craqpb.Empty) (*craqpb.DrainStatus, error) {
+	status, err := n.Svc.Drain()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return drainStatusToProto(status), nil
+}
+
+func (n *NodeBinding) Undrain(_ context.Context, _ *craqpb.Empty) (*craqpb.Empty, error) {
+	return &craqpb.Empty{}, toStatus(n.Svc.Undrain())
+}
+
+func (n *NodeBinding) Status(_ context.Context, _ *craqpb.Empty) (*craqpb.NodeStatus, error) {
+	status, err := n.Svc.Status()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.NodeStatus{
+		Address: status.Address,
+		State:   craqpb.NodeState(status.State),
+		IsHead:  status.IsHead,
+		IsTail:  status.IsTail,
+		Prev:    status.Prev,
+		Next:    status.Next,
+		Tail:    status.Tail,
+	}, nil
+}
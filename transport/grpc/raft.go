@@ -0,0 +1,118 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/despreston/go-craq/transport/grpc/craqpb"
+)
+
+func NewRaftClient() transport.RaftClient {
+	return &RaftClient{Client: &Client{}}
+}
+
+// RaftBinding provides a layer of translation between the RaftService which is
+// transport agnostic and gRPC. It implements craqpb.RaftServiceServer, so it
+// can be registered with craqpb.RegisterRaftServiceServer on the same server
+// as the CoordinatorBinding.
+type RaftBinding struct {
+	craqpb.UnimplementedRaftServiceServer
+	Svc transport.RaftService
+}
+
+func (r *RaftBinding) RequestVote(
+	_ context.Context,
+	args *craqpb.VoteRequest,
+) (*craqpb.VoteResponse, error) {
+	res, err := r.Svc.RequestVote(&transport.VoteRequest{
+		Term:         args.Term,
+		Candidate:    args.Candidate,
+		LastLogIndex: args.LastLogIndex,
+		LastLogTerm:  args.LastLogTerm,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.VoteResponse{Term: res.Term, Granted: res.Granted}, nil
+}
+
+func (r *RaftBinding) AppendEntries(
+	_ context.Context,
+	args *craqpb.AppendRequest,
+) (*craqpb.AppendResponse, error) {
+	entries := make([]transport.RaftEntry, len(args.Entries))
+	for i, e := range args.Entries {
+		entries[i] = transport.RaftEntry{Term: e.Term, Command: e.Command}
+	}
+	res, err := r.Svc.AppendEntries(&transport.AppendRequest{
+		Term:         args.Term,
+		Leader:       args.Leader,
+		PrevLogIndex: args.PrevLogIndex,
+		PrevLogTerm:  args.PrevLogTerm,
+		Entries:      entries,
+		LeaderCommit: args.LeaderCommit,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &craqpb.AppendResponse{
+		Term:          res.Term,
+		Success:       res.Success,
+		ConflictIndex: res.ConflictIndex,
+	}, nil
+}
+
+// RaftClient is for invoking gRPC methods on another Coordinator in the
+// cluster.
+type RaftClient struct {
+	*Client
+	rpc craqpb.RaftServiceClient
+}
+
+func (rc *RaftClient) Connect(addr string) error {
+	if err := rc.Client.Connect(addr); err != nil {
+		return err
+	}
+	rc.rpc = craqpb.NewRaftServiceClient(rc.conn)
+	return nil
+}
+
+func (rc *RaftClient) RequestVote(
+	args *transport.VoteRequest,
+) (*transport.VoteResponse, error) {
+	reply, err := rc.rpc.RequestVote(context.Background(), &craqpb.VoteRequest{
+		Term:         args.Term,
+		Candidate:    args.Candidate,
+		LastLogIndex: args.LastLogIndex,
+		LastLogTerm:  args.LastLogTerm,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.VoteResponse{Term: reply.Term, Granted: reply.Granted}, nil
+}
+
+func (rc *RaftClient) AppendEntries(
+	args *transport.AppendRequest,
+) (*transport.AppendResponse, error) {
+	entries := make([]*craqpb.RaftEntry, len(args.Entries))
+	for i, e := range args.Entries {
+		entries[i] = &craqpb.RaftEntry{Term: e.Term, Command: e.Command}
+	}
+	reply, err := rc.rpc.AppendEntries(context.Background(), &craqpb.AppendRequest{
+		Term:         args.Term,
+		Leader:       args.Leader,
+		PrevLogIndex: args.PrevLogIndex,
+		PrevLogTerm:  args.PrevLogTerm,
+		Entries:      entries,
+		LeaderCommit: args.LeaderCommit,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &transport.AppendResponse{
+		Term:          reply.Term,
+		Success:       reply.Success,
+		ConflictIndex: reply.ConflictIndex,
+	}, nil
+}
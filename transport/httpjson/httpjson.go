@@ -0,0 +1,327 @@
+// httpjson package serves reads, writes and the membership of the chains over
+// plain HTTP with JSON bodies, so the chain can be used from curl, scripts and
+// browsers.
+//
+//	GET /keys/{key}  latest committed value of key
+//	GET /keys/{key}?version={version}  a committed version of key
+//	PUT /keys/{key}  write a new value for key. Body: {"value": "..."}
+//	DELETE /keys/{key}  delete key
+//	GET /keys        every committed key/value pair, streamed as a JSON array
+//	GET /chain       addresses of the nodes in each chain
+//
+// Values are arbitrary bytes, so they're sent and received as base64 encoded
+// JSON strings, e.g. {"value": "d29ybGQ="} for "world". Successful reads,
+// writes and deletes return the version of the key in the ETag header.
+//
+// PUT requests can be made conditional. With an If-Match header holding a
+// version, the write is a CompareAndSwap against that version. With
+// "If-None-Match: *", the write only succeeds if the key doesn't exist. If the
+// condition fails, the response is 412 Precondition Failed and the ETag header
+// holds the newest version of the key, if it exists.
+//
+// Unconditional PUT requests can set a TTL in the body, e.g.
+// {"value": "...", "ttl": "30s"}. The key is deleted once the TTL has passed,
+// unless it's written again first.
+//
+// GET /keys/{key} is a strong read unless the consistency query parameter says
+// otherwise. With consistency=eventual, the node returns the newest version it
+// has, committed or not. With consistency=bounded, the node returns the version
+// it has committed if it's no more than max-version-gap versions behind, or
+// has been out of date for no longer than max-staleness (e.g. 5s).
+//
+//	GET /keys/{key}?consistency=bounded&max-staleness=5s&max-version-gap=10
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+)
+
+const keysPrefix = "/keys/"
+
+var (
+	errBadVersion = errors.New("If-Match must be a version number")
+	errBadTTL     = errors.New("ttl must be a duration, e.g. 30s, and can't be used with If-Match or If-None-Match")
+)
+
+// badQuery is an error in the query parameters of a request.
+type badQuery struct{ error }
+
+// Backend is what the Gateway uses to serve requests. client.Client satisfies
+// it; reads are served by NodeService.Read and ReadAll and writes by
+// CoordinatorService.Write and Delete. GET /keys is served by NodeService.Export
+// a chunk at a time.
+type Backend interface {
+	GetWithOpts(ctx context.Context, key string, opts *transport.ReadOpts) ([]byte, uint64, error)
+	GetVersion(ctx context.Context, key string, version uint64) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) (uint64, error)
+	PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) (uint64, error)
+	Delete(ctx context.Context, key string) (uint64, error)
+	CompareAndSwap(ctx context.Context, key string, expected uint64, value []byte) (uint64, error)
+	PutIfAbsent(ctx context.Context, key string, value []byte) (uint64, error)
+	Export(ctx context.Context, fn func(transport.VersionedItem) error) error
+	Routes() *transport.RoutingTable
+}
+
+// Item is the JSON representation of a key/value pair.
+type Item struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// WriteRequest is the body of a PUT request.
+type WriteRequest struct {
+	Value []byte `json:"value"`
+	// TTL is how long the key lives, e.g. 30s. Empty means forever.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Chains is the JSON representation of the routing table.
+type Chains struct {
+	Chains [][]string `json:"chains"`
+}
+
+// Error is the body of every response with a non-2xx status.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Gateway is an http.Handler that translates HTTP requests into calls to a
+// Backend.
+type Gateway struct {
+	backend Backend
+	mux     *http.ServeMux
+}
+
+// NewGateway creates a new Gateway.
+func NewGateway(b Backend) *Gateway {
+	g := &Gateway{backend: b, mux: http.NewServeMux()}
+	g.mux.HandleFunc("/keys", g.handleKeys)
+	g.mux.HandleFunc(keysPrefix, g.handleKey)
+	g.mux.HandleFunc("/chain", g.handleChain)
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *Gateway) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	// The array is written as the items arrive, so the response starts with the
+	// first item and the status can't change after that.
+	enc := json.NewEncoder(w)
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "[")
+		started = true
+	}
+
+	err := g.backend.Export(r.Context(), func(item transport.VersionedItem) error {
+		if started {
+			io.WriteString(w, ",")
+		} else {
+			start()
+		}
+		return enc.Encode(Item{Key: item.Key, Value: item.Value})
+	})
+
+	if err != nil {
+		if !started {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		// Cut the response off so the client sees a broken array instead of a
+		// partial one.
+		panic(http.ErrAbortHandler)
+	}
+
+	if !started {
+		start()
+	}
+	io.WriteString(w, "]\n")
+}
+
+func (g *Gateway) handleKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, keysPrefix)
+	if key == "" {
+		g.handleKeys(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, version, err := g.get(r, key)
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		setVersion(w, version)
+		writeJSON(w, http.StatusOK, Item{Key: key, Value: value})
+	case http.MethodPut:
+		var req WriteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		version, err := g.put(r, key, &req)
+		if err != nil {
+			var conflict *transport.ConflictError
+			if errors.As(err, &conflict) && conflict.Exists {
+				setVersion(w, conflict.Latest)
+			}
+			writeError(w, statusFor(err), err)
+			return
+		}
+		setVersion(w, version)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		version, err := g.backend.Delete(r.Context(), key)
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		setVersion(w, version)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// put writes the value using the condition in the request headers, if any.
+func (g *Gateway) put(r *http.Request, key string, req *WriteRequest) (uint64, error) {
+	value := req.Value
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Match") != "" {
+			return 0, errBadTTL
+		}
+		return g.backend.PutWithTTL(r.Context(), key, value, ttl)
+	}
+
+	if r.Header.Get("If-None-Match") == "*" {
+		return g.backend.PutIfAbsent(r.Context(), key, value)
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		expected, err := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+		if err != nil {
+			return 0, errBadVersion
+		}
+		return g.backend.CompareAndSwap(r.Context(), key, expected, value)
+	}
+
+	return g.backend.Put(r.Context(), key, value)
+}
+
+// get reads the version of key in the query parameters, or the latest version
+// with the consistency in the query parameters.
+func (g *Gateway) get(r *http.Request, key string) ([]byte, uint64, error) {
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, 0, badQuery{errors.New("version must be a version number")}
+		}
+		value, err := g.backend.GetVersion(r.Context(), key, version)
+		return value, version, err
+	}
+
+	opts, err := readOpts(r)
+	if err != nil {
+		return nil, 0, badQuery{err}
+	}
+	return g.backend.GetWithOpts(r.Context(), key, opts)
+}
+
+// readOpts reads the consistency of a read from the query parameters.
+func readOpts(r *http.Request) (*transport.ReadOpts, error) {
+	query := r.URL.Query()
+	opts := &transport.ReadOpts{}
+
+	if c := query.Get("consistency"); c != "" {
+		mode, err := transport.ParseReadMode(c)
+		if err != nil {
+			return nil, err
+		}
+		opts.Mode = mode
+	}
+
+	if s := query.Get("max-staleness"); s != "" {
+		staleness, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		opts.MaxStaleness = staleness
+	}
+
+	if g := query.Get("max-version-gap"); g != "" {
+		gap, err := strconv.ParseUint(g, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		opts.MaxVersionGap = gap
+	}
+
+	return opts, nil
+}
+
+func (g *Gateway) handleChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	writeJSON(w, http.StatusOK, Chains{Chains: g.backend.Routes().Chains})
+}
+
+// statusFor maps errors from the Backend to HTTP status codes.
+func statusFor(err error) int {
+	var conflict *transport.ConflictError
+	var query badQuery
+
+	switch {
+	case transport.IsCommitTimeout(err):
+		return http.StatusGatewayTimeout
+	case transport.IsDraining(err), transport.IsNotServing(err):
+		return http.StatusServiceUnavailable
+	case err == errBadVersion, err == errBadTTL, errors.As(err, &query):
+		return http.StatusBadRequest
+	case errors.As(err, &conflict):
+		return http.StatusPreconditionFailed
+	case transport.IsNotFound(err), transport.IsVersionNotFound(err):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// setVersion sets the ETag header to the version of the key.
+func setVersion(w http.ResponseWriter, version uint64) {
+	w.Header().Set("ETag", strconv.FormatUint(version, 10))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Error{Error: err.Error()})
+}
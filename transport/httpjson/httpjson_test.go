@@ -0,0 +1,442 @@
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
+	"github.com/google/go-cmp/cmp"
+)
+
+type FakeBackend struct {
+	items    map[string][]byte
+	versions map[string]uint64
+	readOpts *transport.ReadOpts // options of the last read
+}
+
+func (f *FakeBackend) GetWithOpts(
+	_ context.Context,
+	key string,
+	opts *transport.ReadOpts,
+) ([]byte, uint64, error) {
+	f.readOpts = opts
+	v, has := f.items[key]
+	if !has {
+		return nil, 0, transport.ErrNotFound
+	}
+	return v, f.versions[key], nil
+}
+
+// GetVersion only knows the latest version of each key.
+func (f *FakeBackend) GetVersion(
+	ctx context.Context,
+	key string,
+	version uint64,
+) ([]byte, error) {
+	v, latest, err := f.GetWithOpts(ctx, key, nil)
+	if err == nil && version != latest {
+		return nil, transport.ErrVersionNotFound
+	}
+	return v, err
+}
+
+func (f *FakeBackend) Put(_ context.Context, key string, value []byte) (uint64, error) {
+	if _, has := f.items[key]; has {
+		f.versions[key]++
+	}
+	f.items[key] = value
+	return f.versions[key], nil
+}
+
+// PutWithTTL fails for anything but a 30s TTL, so tests can tell the TTL got
+// here.
+func (f *FakeBackend) PutWithTTL(
+	ctx context.Context,
+	key string,
+	value []byte,
+	ttl time.Duration,
+) (uint64, error) {
+	if ttl != 30*time.Second {
+		return 0, errors.New("unexpected ttl")
+	}
+	return f.Put(ctx, key, value)
+}
+
+func (f *FakeBackend) CompareAndSwap(
+	ctx context.Context,
+	key string,
+	expected uint64,
+	value []byte,
+) (uint64, error) {
+	if _, has := f.items[key]; !has || f.versions[key] != expected {
+		return 0, &transport.ConflictError{Key: key, Latest: f.versions[key], Exists: has}
+	}
+	return f.Put(ctx, key, value)
+}
+
+func (f *FakeBackend) PutIfAbsent(
+	ctx context.Context,
+	key string,
+	value []byte,
+) (uint64, error) {
+	if _, has := f.items[key]; has {
+		return 0, &transport.ConflictError{Key: key, Latest: f.versions[key], Exists: true}
+	}
+	return f.Put(ctx, key, value)
+}
+
+func (f *FakeBackend) Delete(_ context.Context, key string) (uint64, error) {
+	delete(f.items, key)
+	f.versions[key]++
+	return f.versions[key], nil
+}
+
+func (f *FakeBackend) Export(
+	_ context.Context,
+	fn func(transport.VersionedItem) error,
+) error {
+	keys := []string{}
+	for k := range f.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		item := transport.VersionedItem{Key: k, Value: f.items[k], Version: f.versions[k]}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FakeBackend) Routes() *transport.RoutingTable {
+	return &transport.RoutingTable{Chains: [][]string{{"a", "b"}}}
+}
+
+func TestGateway(t *testing.T) {
+	g := NewGateway(&FakeBackend{
+		items:    make(map[string][]byte),
+		versions: make(map[string]uint64),
+	})
+
+	tests := []struct {
+		id     string
+		method string
+		path   string
+		header map[string]string
+		body   string
+		status int
+		etag   string // expected ETag header
+		want   string // expected response body
+	}{
+		{
+			id:     "read unknown key",
+			method: http.MethodGet,
+			path:   "/keys/hello",
+			status: http.StatusNotFound,
+			want:   `{"error":"key doesn't exist"}`,
+		},
+		{
+			id:     "write",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			body:   `{"value":"d29ybGQ="}`,
+			status: http.StatusNoContent,
+			etag:   "0",
+		},
+		{
+			id:     "put if absent conflict",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			header: map[string]string{"If-None-Match": "*"},
+			body:   `{"value":"bm9wZQ=="}`,
+			status: http.StatusPreconditionFailed,
+			etag:   "0",
+		},
+		{
+			id:     "compare and swap conflict",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			header: map[string]string{"If-Match": "1"},
+			body:   `{"value":"bm9wZQ=="}`,
+			status: http.StatusPreconditionFailed,
+			etag:   "0",
+		},
+		{
+			id:     "compare and swap bad version",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			header: map[string]string{"If-Match": "abc"},
+			body:   `{"value":"bm9wZQ=="}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			id:     "compare and swap",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			header: map[string]string{"If-Match": `"0"`},
+			body:   `{"value":"d29ybGQ="}`,
+			status: http.StatusNoContent,
+			etag:   "1",
+		},
+		{
+			id:     "put if absent",
+			method: http.MethodPut,
+			path:   "/keys/other",
+			header: map[string]string{"If-None-Match": "*"},
+			body:   `{"value":"dGhpbmc="}`,
+			status: http.StatusNoContent,
+			etag:   "0",
+		},
+		{
+			id:     "delete other",
+			method: http.MethodDelete,
+			path:   "/keys/other",
+			status: http.StatusNoContent,
+			etag:   "1",
+		},
+		{
+			id:     "read",
+			method: http.MethodGet,
+			path:   "/keys/hello",
+			status: http.StatusOK,
+			etag:   "1",
+			want:   `{"key":"hello","value":"d29ybGQ="}`,
+		},
+		{
+			id:     "read version",
+			method: http.MethodGet,
+			path:   "/keys/hello?version=1",
+			status: http.StatusOK,
+			etag:   "1",
+			want:   `{"key":"hello","value":"d29ybGQ="}`,
+		},
+		{
+			id:     "read unknown version",
+			method: http.MethodGet,
+			path:   "/keys/hello?version=0",
+			status: http.StatusNotFound,
+			want:   `{"error":"version of key doesn't exist"}`,
+		},
+		{
+			id:     "read bad version",
+			method: http.MethodGet,
+			path:   "/keys/hello?version=abc",
+			status: http.StatusBadRequest,
+		},
+		{
+			id:     "read all",
+			method: http.MethodGet,
+			path:   "/keys",
+			status: http.StatusOK,
+			want:   `[{"key":"hello","value":"d29ybGQ="}]`,
+		},
+		{
+			id:     "delete",
+			method: http.MethodDelete,
+			path:   "/keys/hello",
+			status: http.StatusNoContent,
+			etag:   "2",
+		},
+		{
+			id:     "read deleted key",
+			method: http.MethodGet,
+			path:   "/keys/hello",
+			status: http.StatusNotFound,
+			want:   `{"error":"key doesn't exist"}`,
+		},
+		{
+			id:     "bad body",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			body:   `nope`,
+			status: http.StatusBadRequest,
+		},
+		{
+			id:     "write with ttl",
+			method: http.MethodPut,
+			path:   "/keys/session",
+			body:   `{"value":"YWJj","ttl":"30s"}`,
+			status: http.StatusNoContent,
+			etag:   "0",
+		},
+		{
+			id:     "write with bad ttl",
+			method: http.MethodPut,
+			path:   "/keys/session",
+			body:   `{"value":"YWJj","ttl":"soon"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			id:     "conditional write with ttl",
+			method: http.MethodPut,
+			path:   "/keys/session",
+			header: map[string]string{"If-None-Match": "*"},
+			body:   `{"value":"YWJj","ttl":"30s"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			id:     "write bytes that aren't utf-8",
+			method: http.MethodPut,
+			path:   "/keys/bytes",
+			body:   `{"value":"//4A"}`,
+			status: http.StatusNoContent,
+			etag:   "0",
+		},
+		{
+			id:     "read bytes that aren't utf-8",
+			method: http.MethodGet,
+			path:   "/keys/bytes",
+			status: http.StatusOK,
+			etag:   "0",
+			want:   `{"key":"bytes","value":"//4A"}`,
+		},
+		{
+			id:     "write value that isn't base64",
+			method: http.MethodPut,
+			path:   "/keys/hello",
+			body:   `{"value":"world!"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			id:     "chain",
+			method: http.MethodGet,
+			path:   "/chain",
+			status: http.StatusOK,
+			want:   `{"chains":[["a","b"]]}`,
+		},
+		{
+			id:     "method not allowed",
+			method: http.MethodDelete,
+			path:   "/chain",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("unexpected status\n  want: %d\n  got: %d", tt.status, rec.Code)
+			}
+
+			if got := rec.Header().Get("ETag"); got != tt.etag {
+				t.Fatalf("unexpected ETag\n  want: %q\n  got: %q", tt.etag, got)
+			}
+
+			if tt.want == "" {
+				return
+			}
+
+			var want, got interface{}
+			json.Unmarshal([]byte(tt.want), &want)
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected response (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// GET /keys streams the array one item at a time, and an empty store is still
+// an array.
+func TestGatewayExport(t *testing.T) {
+	b := &FakeBackend{
+		items:    make(map[string][]byte),
+		versions: make(map[string]uint64),
+	}
+	g := NewGateway(b)
+
+	for _, items := range []map[string][]byte{
+		{},
+		{"hello": []byte("world"), "foo": []byte("bar")},
+	} {
+		b.items = items
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status\n  want: %d\n  got: %d", http.StatusOK, rec.Code)
+		}
+
+		want := []Item{}
+		for k, v := range items {
+			want = append(want, Item{Key: k, Value: v})
+		}
+		sort.Slice(want, func(i, j int) bool { return want[i].Key < want[j].Key })
+
+		var got []Item
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("unexpected response (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestGatewayReadOpts(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		want   *transport.ReadOpts
+	}{
+		{
+			query:  "",
+			status: http.StatusOK,
+			want:   &transport.ReadOpts{Mode: transport.ReadStrong},
+		},
+		{
+			query:  "?consistency=eventual",
+			status: http.StatusOK,
+			want:   &transport.ReadOpts{Mode: transport.ReadEventual},
+		},
+		{
+			query:  "?consistency=bounded&max-staleness=5s&max-version-gap=10",
+			status: http.StatusOK,
+			want: &transport.ReadOpts{
+				Mode:          transport.ReadBounded,
+				MaxStaleness:  5 * time.Second,
+				MaxVersionGap: 10,
+			},
+		},
+		{query: "?consistency=sometimes", status: http.StatusBadRequest},
+		{query: "?consistency=bounded&max-staleness=soon", status: http.StatusBadRequest},
+		{query: "?consistency=bounded&max-version-gap=-1", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			backend := &FakeBackend{items: map[string][]byte{"hello": []byte("world")}}
+			g := NewGateway(backend)
+
+			req := httptest.NewRequest(http.MethodGet, "/keys/hello"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("unexpected status\n  want: %d\n  got: %d", tt.status, rec.Code)
+			}
+			if diff := cmp.Diff(tt.want, backend.readOpts); diff != "" {
+				t.Fatalf("unexpected read options (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
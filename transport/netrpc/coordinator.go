@@ -1,6 +1,8 @@
 package netrpc
 
 import (
+	"time"
+
 	"github.com/despreston/go-craq/transport"
 )
 
@@ -15,8 +17,11 @@ type CoordinatorBinding struct {
 	Svc transport.CoordinatorService
 }
 
-func (c *CoordinatorBinding) AddNode(addr *string, r *transport.NodeMeta) error {
-	meta, err := c.Svc.AddNode(*addr)
+func (c *CoordinatorBinding) AddNode(
+	args *transport.AddNodeArgs,
+	r *transport.NodeMeta,
+) error {
+	meta, err := c.Svc.AddNode(args)
 	if err != nil {
 		return err
 	}
@@ -28,8 +33,75 @@ func (c *CoordinatorBinding) RemoveNode(addr *string, r *EmptyReply) error {
 	return c.Svc.RemoveNode(*addr)
 }
 
-func (c *CoordinatorBinding) Write(args *ClientWriteArgs, r *EmptyReply) error {
-	return c.Svc.Write(args.Key, args.Value)
+func (c *CoordinatorBinding) ReportState(
+	args *ReportStateArgs,
+	r *EmptyReply,
+) error {
+	return c.Svc.ReportState(args.Address, args.State)
+}
+
+func (c *CoordinatorBinding) Decommission(
+	addr *string,
+	r *transport.DecommissionStatus,
+) error {
+	status, err := c.Svc.Decommission(*addr)
+	if err != nil {
+		return err
+	}
+	*r = *status
+	return nil
+}
+
+func (c *CoordinatorBinding) Write(args *ClientWriteArgs, reply *WriteReply) error {
+	version, err := c.Svc.Write(args.Key, args.Value, args.TTL)
+	return writeReply(version, err, reply)
+}
+
+func (c *CoordinatorBinding) Delete(key string, reply *WriteReply) error {
+	version, err := c.Svc.Delete(key)
+	return writeReply(version, err, reply)
+}
+
+func (c *CoordinatorBinding) CompareAndSwap(
+	args *CompareAndSwapArgs,
+	reply *WriteReply,
+) error {
+	version, err := c.Svc.CompareAndSwap(args.Key, args.Expected, args.Value)
+	return writeReply(version, err, reply)
+}
+
+func (c *CoordinatorBinding) PutIfAbsent(
+	args *ClientWriteArgs,
+	reply *WriteReply,
+) error {
+	version, err := c.Svc.PutIfAbsent(args.Key, args.Value)
+	return writeReply(version, err, reply)
+}
+
+func (c *CoordinatorBinding) WriteBatch(
+	args *ClientWriteBatchArgs,
+	reply *WriteBatchReply,
+) error {
+	versions, err := c.Svc.WriteBatch(args.Items)
+	reply.Versions = versions
+	return err
+}
+
+func (c *CoordinatorBinding) Transaction(
+	txn *transport.Transaction,
+	reply *WriteBatchReply,
+) error {
+	versions, err := c.Svc.Transaction(txn)
+	return batchReply(versions, err, reply)
+}
+
+func (c *CoordinatorBinding) Routes(_ *EmptyArgs, r *transport.RoutingTable) error {
+	rt, err := c.Svc.Routes()
+	if err != nil {
+		return err
+	}
+	*r = *rt
+	return nil
 }
 
 // CoordinatorClient is for invoking net/rpc methods on a Coordinator.
@@ -37,9 +109,11 @@ type CoordinatorClient struct {
 	*Client
 }
 
-func (cc *CoordinatorClient) AddNode(addr string) (*transport.NodeMeta, error) {
+func (cc *CoordinatorClient) AddNode(
+	args *transport.AddNodeArgs,
+) (*transport.NodeMeta, error) {
 	reply := &transport.NodeMeta{}
-	err := cc.Client.rpc.Call("RPC.AddNode", addr, reply)
+	err := cc.Client.rpc.Call("RPC.AddNode", args, reply)
 	return reply, err
 }
 
@@ -47,7 +121,78 @@ func (cc *CoordinatorClient) RemoveNode(addr string) error {
 	return cc.Client.rpc.Call("RPC.RemoveNode", addr, &EmptyReply{})
 }
 
-func (cc *CoordinatorClient) Write(k string, v []byte) error {
+func (cc *CoordinatorClient) ReportState(
+	addr string,
+	state transport.NodeState,
+) error {
+	args := ReportStateArgs{Address: addr, State: state}
+	return cc.Client.rpc.Call("RPC.ReportState", &args, &EmptyReply{})
+}
+
+func (cc *CoordinatorClient) Decommission(
+	addr string,
+) (*transport.DecommissionStatus, error) {
+	reply := &transport.DecommissionStatus{}
+	if err := cc.Client.rpc.Call("RPC.Decommission", addr, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (cc *CoordinatorClient) Write(
+	k string,
+	v []byte,
+	ttl time.Duration,
+) (uint64, error) {
+	reply := &WriteReply{}
+	args := ClientWriteArgs{Key: k, Value: v, TTL: ttl}
+	err := cc.Client.rpc.Call("RPC.Write", &args, reply)
+	return fromWriteReply(err, reply)
+}
+
+func (cc *CoordinatorClient) Delete(k string) (uint64, error) {
+	reply := &WriteReply{}
+	err := cc.Client.rpc.Call("RPC.Delete", k, reply)
+	return fromWriteReply(err, reply)
+}
+
+func (cc *CoordinatorClient) CompareAndSwap(
+	k string,
+	expected uint64,
+	v []byte,
+) (uint64, error) {
+	reply := &WriteReply{}
+	args := CompareAndSwapArgs{Key: k, Expected: expected, Value: v}
+	err := cc.Client.rpc.Call("RPC.CompareAndSwap", &args, reply)
+	return fromWriteReply(err, reply)
+}
+
+func (cc *CoordinatorClient) PutIfAbsent(k string, v []byte) (uint64, error) {
+	reply := &WriteReply{}
 	args := ClientWriteArgs{Key: k, Value: v}
-	return cc.Client.rpc.Call("RPC.Write", &args, &EmptyReply{})
+	err := cc.Client.rpc.Call("RPC.PutIfAbsent", &args, reply)
+	return fromWriteReply(err, reply)
+}
+
+func (cc *CoordinatorClient) WriteBatch(items []transport.Item) ([]uint64, error) {
+	reply := &WriteBatchReply{}
+	args := ClientWriteBatchArgs{Items: items}
+	err := cc.Client.rpc.Call("RPC.WriteBatch", &args, reply)
+	return reply.Versions, err
+}
+
+func (cc *CoordinatorClient) Transaction(
+	txn *transport.Transaction,
+) ([]uint64, error) {
+	reply := &WriteBatchReply{}
+	err := cc.Client.rpc.Call("RPC.Transaction", txn, reply)
+	return fromBatchReply(err, reply)
+}
+
+func (cc *CoordinatorClient) Routes() (*transport.RoutingTable, error) {
+	reply := &transport.RoutingTable{}
+	if err := cc.Client.rpc.Call("RPC.Routes", &EmptyArgs{}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
 }
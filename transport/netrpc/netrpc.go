@@ -4,6 +4,9 @@ package netrpc
 
 import (
 	"net/rpc"
+	"time"
+
+	"github.com/despreston/go-craq/transport"
 )
 
 type Client struct {
@@ -23,6 +26,48 @@ func (c *Client) Close() error {
 	return c.rpc.Close()
 }
 
+// writeReply fills reply with the result of a client write. A
+// *transport.ConflictError is moved into the reply so that the caller can get
+// it back with fromWriteReply.
+func writeReply(version uint64, err error, reply *WriteReply) error {
+	reply.Version = version
+	if conflict, ok := err.(*transport.ConflictError); ok {
+		reply.Conflict = conflict
+		return nil
+	}
+	return err
+}
+
+func fromWriteReply(err error, reply *WriteReply) (uint64, error) {
+	if err != nil {
+		return 0, err
+	}
+	if reply.Conflict != nil {
+		return 0, reply.Conflict
+	}
+	return reply.Version, nil
+}
+
+// batchReply is writeReply for writes that return several versions.
+func batchReply(versions []uint64, err error, reply *WriteBatchReply) error {
+	reply.Versions = versions
+	if conflict, ok := err.(*transport.ConflictError); ok {
+		reply.Conflict = conflict
+		return nil
+	}
+	return err
+}
+
+func fromBatchReply(err error, reply *WriteBatchReply) ([]uint64, error) {
+	if err != nil {
+		return nil, err
+	}
+	if reply.Conflict != nil {
+		return nil, reply.Conflict
+	}
+	return reply.Versions, nil
+}
+
 // ----------------------------------------------------------------------------
 // net/rpc argument and reply structs
 type (
@@ -34,9 +79,43 @@ type (
 		Version uint64
 	}
 
+	ReadArgs struct {
+		Key  string
+		Opts *transport.ReadOpts
+	}
+
+	ReadAtVersionArgs struct {
+		Key     string
+		Version uint64
+	}
+
+	ScanArgs struct {
+		Start string
+		End   string
+		Limit int
+	}
+
+	ScanPrefixArgs struct {
+		Prefix string
+		Cursor string
+		Limit  int
+	}
+
+	ExportArgs struct {
+		Cursor string
+		Limit  int
+	}
+
+	WatchArgs struct {
+		Prefix string
+		From   uint64
+	}
+
 	ClientWriteArgs struct {
 		Key   string
 		Value []byte
+		// Only used by the Coordinator's Write.
+		TTL time.Duration
 	}
 
 	WriteArgs struct {
@@ -45,8 +124,62 @@ type (
 		Version uint64
 	}
 
+	CompareAndSwapArgs struct {
+		Key      string
+		Expected uint64
+		Value    []byte
+	}
+
+	// WriteReply is the committed version of a client write. It also carries
+	// a *transport.ConflictError back to the caller. net/rpc only sends the
+	// message of returned errors, so the conflict is sent in the reply instead.
+	WriteReply struct {
+		Version  uint64
+		Conflict *transport.ConflictError
+	}
+
+	DeleteArgs struct {
+		Key     string
+		Version uint64
+	}
+
+	ClientWriteBatchArgs struct {
+		Items []transport.Item
+	}
+
+	// WriteBatchReply is the committed versions of a batch or transaction, in
+	// the same order as the items. Like WriteReply, it carries a
+	// *transport.ConflictError if a precondition failed.
+	WriteBatchReply struct {
+		Versions []uint64
+		Conflict *transport.ConflictError
+	}
+
+	WriteBatchArgs struct {
+		Items []transport.VersionedItem
+	}
+
+	CommitBatchArgs struct {
+		Versions map[string]uint64
+	}
+
 	VersionResponse struct {
 		Key     string
 		Version uint64
 	}
+
+	MerkleHashesArgs struct {
+		Level   int
+		Indexes []int
+	}
+
+	BackPropagateBucketsArgs struct {
+		Buckets  []int
+		Versions transport.PropagateRequest
+	}
+
+	ReportStateArgs struct {
+		Address string
+		State   transport.NodeState
+	}
 )
@@ -28,7 +28,7 @@ func (nc *NodeClient) Update(meta *transport.NodeMeta) error {
 
 func (nc *NodeClient) LatestVersion(key string) (string, uint64, error) {
 	reply := VersionResponse{}
-	err := nc.Client.rpc.Call("RPC.Commit", key, &reply)
+	err := nc.Client.rpc.Call("RPC.LatestVersion", key, &reply)
 	return reply.Key, reply.Version, err
 }
 
@@ -40,12 +40,105 @@ func (nc *NodeClient) Commit(key string, version uint64) error {
 	)
 }
 
-func (nc *NodeClient) Read(key string) (string, []byte, error) {
+func (nc *NodeClient) Read(
+	key string,
+	opts *transport.ReadOpts,
+) (string, []byte, uint64, error) {
+	reply := &transport.VersionedItem{}
+	err := nc.Client.rpc.Call("RPC.Read", &ReadArgs{Key: key, Opts: opts}, reply)
+	return reply.Key, reply.Value, reply.Version, err
+}
+
+func (nc *NodeClient) ReadAtVersion(
+	key string,
+	version uint64,
+) (string, []byte, error) {
 	reply := &transport.Item{}
-	err := nc.Client.rpc.Call("RPC.Read", key, reply)
+	err := nc.Client.rpc.Call(
+		"RPC.ReadAtVersion",
+		&ReadAtVersionArgs{Key: key, Version: version},
+		reply,
+	)
 	return reply.Key, reply.Value, err
 }
 
+func (nc *NodeClient) History(key string) ([]transport.HistoryItem, error) {
+	var reply []transport.HistoryItem
+	err := nc.Client.rpc.Call("RPC.History", key, &reply)
+	return reply, err
+}
+
+func (nc *NodeClient) Scan(start, end string, limit int) (*transport.ScanResult, error) {
+	reply := &transport.ScanResult{}
+	err := nc.Client.rpc.Call(
+		"RPC.Scan",
+		&ScanArgs{Start: start, End: end, Limit: limit},
+		reply,
+	)
+	return reply, err
+}
+
+func (nc *NodeClient) ScanPrefix(
+	prefix, cursor string,
+	limit int,
+) (*transport.ScanResult, error) {
+	reply := &transport.ScanResult{}
+	err := nc.Client.rpc.Call(
+		"RPC.ScanPrefix",
+		&ScanPrefixArgs{Prefix: prefix, Cursor: cursor, Limit: limit},
+		reply,
+	)
+	return reply, err
+}
+
+func (nc *NodeClient) Export(cursor string, limit int) (*transport.ExportChunk, error) {
+	reply := &transport.ExportChunk{}
+	err := nc.Client.rpc.Call(
+		"RPC.Export",
+		&ExportArgs{Cursor: cursor, Limit: limit},
+		reply,
+	)
+	return reply, err
+}
+
+func (nc *NodeClient) Watch(prefix string, from uint64) (*transport.WatchResult, error) {
+	reply := &transport.WatchResult{}
+	err := nc.Client.rpc.Call(
+		"RPC.Watch",
+		&WatchArgs{Prefix: prefix, From: from},
+		reply,
+	)
+	return reply, err
+}
+
+func (nc *NodeClient) Drain() (*transport.DrainStatus, error) {
+	reply := &transport.DrainStatus{}
+	err := nc.Client.rpc.Call(
+		"RPC.Drain",
+		&EmptyArgs{},
+		reply,
+	)
+	return reply, err
+}
+
+func (nc *NodeClient) Undrain() error {
+	return nc.Client.rpc.Call(
+		"RPC.Undrain",
+		&EmptyArgs{},
+		&EmptyReply{},
+	)
+}
+
+func (nc *NodeClient) Status() (*transport.NodeStatus, error) {
+	reply := &transport.NodeStatus{}
+	err := nc.Client.rpc.Call(
+		"RPC.Status",
+		&EmptyArgs{},
+		reply,
+	)
+	return reply, err
+}
+
 func (nc *NodeClient) Write(key string, value []byte, version uint64) error {
 	return nc.Client.rpc.Call(
 		"RPC.Write",
@@ -54,10 +147,88 @@ func (nc *NodeClient) Write(key string, value []byte, version uint64) error {
 	)
 }
 
-func (nc *NodeClient) ClientWrite(key string, value []byte) error {
-	return nc.Client.rpc.Call(
+func (nc *NodeClient) ClientWrite(key string, value []byte) (uint64, error) {
+	reply := &WriteReply{}
+	err := nc.Client.rpc.Call(
 		"RPC.ClientWrite",
 		&ClientWriteArgs{Key: key, Value: value},
+		reply,
+	)
+	return fromWriteReply(err, reply)
+}
+
+func (nc *NodeClient) ClientDelete(key string) (uint64, error) {
+	reply := &WriteReply{}
+	err := nc.Client.rpc.Call("RPC.ClientDelete", key, reply)
+	return fromWriteReply(err, reply)
+}
+
+func (nc *NodeClient) Delete(key string, version uint64) error {
+	return nc.Client.rpc.Call(
+		"RPC.Delete",
+		&DeleteArgs{Key: key, Version: version},
+		&EmptyReply{},
+	)
+}
+
+func (nc *NodeClient) CompareAndSwap(
+	key string,
+	expected uint64,
+	value []byte,
+) (uint64, error) {
+	reply := &WriteReply{}
+	err := nc.Client.rpc.Call(
+		"RPC.CompareAndSwap",
+		&CompareAndSwapArgs{Key: key, Expected: expected, Value: value},
+		reply,
+	)
+	return fromWriteReply(err, reply)
+}
+
+func (nc *NodeClient) PutIfAbsent(key string, value []byte) (uint64, error) {
+	reply := &WriteReply{}
+	err := nc.Client.rpc.Call(
+		"RPC.PutIfAbsent",
+		&ClientWriteArgs{Key: key, Value: value},
+		reply,
+	)
+	return fromWriteReply(err, reply)
+}
+
+func (nc *NodeClient) ClientWriteBatch(items []transport.Item) ([]uint64, error) {
+	reply := &WriteBatchReply{}
+	err := nc.Client.rpc.Call(
+		"RPC.ClientWriteBatch",
+		&ClientWriteBatchArgs{Items: items},
+		reply,
+	)
+	return reply.Versions, err
+}
+
+func (nc *NodeClient) ClientTransaction(
+	txn *transport.Transaction,
+) ([]uint64, error) {
+	reply := &WriteBatchReply{}
+	err := nc.Client.rpc.Call("RPC.ClientTransaction", txn, reply)
+	return fromBatchReply(err, reply)
+}
+
+func (nc *NodeClient) WriteBatch(items []transport.VersionedItem) error {
+	return nc.Client.rpc.Call(
+		"RPC.WriteBatch",
+		&WriteBatchArgs{Items: items},
+		&EmptyReply{},
+	)
+}
+
+func (nc *NodeClient) Replicate(msg *transport.Replication) error {
+	return nc.Client.rpc.Call("RPC.Replicate", msg, &EmptyReply{})
+}
+
+func (nc *NodeClient) CommitBatch(versions map[string]uint64) error {
+	return nc.Client.rpc.Call(
+		"RPC.CommitBatch",
+		&CommitBatchArgs{Versions: versions},
 		&EmptyReply{},
 	)
 }
@@ -72,6 +243,27 @@ func (nc *NodeClient) BackPropagate(
 	return reply, nil
 }
 
+func (nc *NodeClient) MerkleHashes(level int, indexes []int) ([]uint64, error) {
+	reply := []uint64{}
+	args := MerkleHashesArgs{Level: level, Indexes: indexes}
+	if err := nc.Client.rpc.Call("RPC.MerkleHashes", &args, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (nc *NodeClient) BackPropagateBuckets(
+	buckets []int,
+	vByK *transport.PropagateRequest,
+) (*transport.PropagateResponse, error) {
+	reply := &transport.PropagateResponse{}
+	args := BackPropagateBucketsArgs{Buckets: buckets, Versions: *vByK}
+	if err := nc.Client.rpc.Call("RPC.BackPropagateBuckets", &args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
 func (nc *NodeClient) FwdPropagate(
 	vByK *transport.PropagateRequest,
 ) (*transport.PropagateResponse, error) {
@@ -105,14 +297,65 @@ func (n *NodeBinding) Update(args *transport.NodeMeta, _ *EmptyReply) error {
 	return n.Svc.Update(args)
 }
 
-func (n *NodeBinding) ClientWrite(args *ClientWriteArgs, _ *EmptyReply) error {
-	return n.Svc.ClientWrite(args.Key, args.Value)
+func (n *NodeBinding) ClientWrite(args *ClientWriteArgs, reply *WriteReply) error {
+	version, err := n.Svc.ClientWrite(args.Key, args.Value)
+	return writeReply(version, err, reply)
 }
 
 func (n *NodeBinding) Write(args *WriteArgs, _ *EmptyReply) error {
 	return n.Svc.Write(args.Key, args.Value, args.Version)
 }
 
+func (n *NodeBinding) ClientDelete(key string, reply *WriteReply) error {
+	version, err := n.Svc.ClientDelete(key)
+	return writeReply(version, err, reply)
+}
+
+func (n *NodeBinding) Delete(args *DeleteArgs, _ *EmptyReply) error {
+	return n.Svc.Delete(args.Key, args.Version)
+}
+
+func (n *NodeBinding) CompareAndSwap(
+	args *CompareAndSwapArgs,
+	reply *WriteReply,
+) error {
+	version, err := n.Svc.CompareAndSwap(args.Key, args.Expected, args.Value)
+	return writeReply(version, err, reply)
+}
+
+func (n *NodeBinding) PutIfAbsent(
+	args *ClientWriteArgs,
+	reply *WriteReply,
+) error {
+	version, err := n.Svc.PutIfAbsent(args.Key, args.Value)
+	return writeReply(version, err, reply)
+}
+
+func (n *NodeBinding) ClientWriteBatch(
+	args *ClientWriteBatchArgs,
+	reply *WriteBatchReply,
+) error {
+	versions, err := n.Svc.ClientWriteBatch(args.Items)
+	reply.Versions = versions
+	return err
+}
+
+func (n *NodeBinding) ClientTransaction(
+	txn *transport.Transaction,
+	reply *WriteBatchReply,
+) error {
+	versions, err := n.Svc.ClientTransaction(txn)
+	return batchReply(versions, err, reply)
+}
+
+func (n *NodeBinding) WriteBatch(args *WriteBatchArgs, _ *EmptyReply) error {
+	return n.Svc.WriteBatch(args.Items)
+}
+
+func (n *NodeBinding) Replicate(msg *transport.Replication, _ *EmptyReply) error {
+	return n.Svc.Replicate(msg)
+}
+
 func (n *NodeBinding) LatestVersion(key string, reply *VersionResponse) error {
 	key, version, err := n.Svc.LatestVersion(key)
 	if err != nil {
@@ -141,17 +384,126 @@ func (n *NodeBinding) BackPropagate(
 	return err
 }
 
+func (n *NodeBinding) MerkleHashes(args *MerkleHashesArgs, reply *[]uint64) error {
+	hashes, err := n.Svc.MerkleHashes(args.Level, args.Indexes)
+	if err != nil {
+		return err
+	}
+	*reply = hashes
+	return nil
+}
+
+func (n *NodeBinding) BackPropagateBuckets(
+	args *BackPropagateBucketsArgs,
+	reply *transport.PropagateResponse,
+) error {
+	r, err := n.Svc.BackPropagateBuckets(args.Buckets, &args.Versions)
+	if err != nil {
+		return err
+	}
+	*reply = *r
+	return nil
+}
+
 func (n *NodeBinding) Commit(args *CommitArgs, _ *EmptyReply) error {
 	return n.Svc.Commit(args.Key, args.Version)
 }
 
-func (n *NodeBinding) Read(key string, reply *transport.Item) error {
-	key, value, err := n.Svc.Read(key)
+func (n *NodeBinding) CommitBatch(args *CommitBatchArgs, _ *EmptyReply) error {
+	return n.Svc.CommitBatch(args.Versions)
+}
+
+func (n *NodeBinding) Read(args *ReadArgs, reply *transport.VersionedItem) error {
+	key, value, version, err := n.Svc.Read(args.Key, args.Opts)
 	if err != nil {
 		return err
 	}
 	reply.Key = key
 	reply.Value = value
+	reply.Version = version
+	return nil
+}
+
+func (n *NodeBinding) ReadAtVersion(
+	args *ReadAtVersionArgs,
+	reply *transport.Item,
+) error {
+	key, value, err := n.Svc.ReadAtVersion(args.Key, args.Version)
+	if err != nil {
+		return err
+	}
+	reply.Key = key
+	reply.Value = value
+	return nil
+}
+
+func (n *NodeBinding) History(key string, reply *[]transport.HistoryItem) error {
+	items, err := n.Svc.History(key)
+	if err != nil {
+		return err
+	}
+	*reply = items
+	return nil
+}
+
+func (n *NodeBinding) Scan(args *ScanArgs, reply *transport.ScanResult) error {
+	result, err := n.Svc.Scan(args.Start, args.End, args.Limit)
+	if err != nil {
+		return err
+	}
+	*reply = *result
+	return nil
+}
+
+func (n *NodeBinding) ScanPrefix(
+	args *ScanPrefixArgs,
+	reply *transport.ScanResult,
+) error {
+	result, err := n.Svc.ScanPrefix(args.Prefix, args.Cursor, args.Limit)
+	if err != nil {
+		return err
+	}
+	*reply = *result
+	return nil
+}
+
+func (n *NodeBinding) Export(args *ExportArgs, reply *transport.ExportChunk) error {
+	chunk, err := n.Svc.Export(args.Cursor, args.Limit)
+	if err != nil {
+		return err
+	}
+	*reply = *chunk
+	return nil
+}
+
+func (n *NodeBinding) Watch(args *WatchArgs, reply *transport.WatchResult) error {
+	result, err := n.Svc.Watch(args.Prefix, args.From)
+	if err != nil {
+		return err
+	}
+	*reply = *result
+	return nil
+}
+
+func (n *NodeBinding) Drain(_ *EmptyArgs, reply *transport.DrainStatus) error {
+	status, err := n.Svc.Drain()
+	if err != nil {
+		return err
+	}
+	*reply = *status
+	return nil
+}
+
+func (n *NodeBinding) Undrain(_ *EmptyArgs, _ *EmptyReply) error {
+	return n.Svc.Undrain()
+}
+
+func (n *NodeBinding) Status(_ *EmptyArgs, reply *transport.NodeStatus) error {
+	status, err := n.Svc.Status()
+	if err != nil {
+		return err
+	}
+	*reply = *status
 	return nil
 }
 
@@ -0,0 +1,64 @@
+package netrpc
+
+import "github.com/despreston/go-craq/transport"
+
+func NewRaftClient() transport.RaftClient {
+	return &RaftClient{Client: &Client{}}
+}
+
+// RaftBinding provides a layer of translation between the RaftService which is
+// transport agnostic and the net/rpc package. It should be registered under
+// the name "Raft" so it can live alongside the CoordinatorBinding.
+type RaftBinding struct {
+	Svc transport.RaftService
+}
+
+func (r *RaftBinding) RequestVote(
+	args *transport.VoteRequest,
+	reply *transport.VoteResponse,
+) error {
+	res, err := r.Svc.RequestVote(args)
+	if err != nil {
+		return err
+	}
+	*reply = *res
+	return nil
+}
+
+func (r *RaftBinding) AppendEntries(
+	args *transport.AppendRequest,
+	reply *transport.AppendResponse,
+) error {
+	res, err := r.Svc.AppendEntries(args)
+	if err != nil {
+		return err
+	}
+	*reply = *res
+	return nil
+}
+
+// RaftClient is for invoking net/rpc methods on another Coordinator in the
+// cluster.
+type RaftClient struct {
+	*Client
+}
+
+func (rc *RaftClient) RequestVote(
+	args *transport.VoteRequest,
+) (*transport.VoteResponse, error) {
+	reply := &transport.VoteResponse{}
+	if err := rc.Client.rpc.Call("Raft.RequestVote", args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (rc *RaftClient) AppendEntries(
+	args *transport.AppendRequest,
+) (*transport.AppendResponse, error) {
+	reply := &transport.AppendResponse{}
+	if err := rc.Client.rpc.Call("Raft.AppendEntries", args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,62 @@
+package transport
+
+import "hash/fnv"
+
+// AnyChain can be given as the chain in AddNodeArgs to let the Coordinator put
+// the node in the chain with the fewest nodes.
+const AnyChain = -1
+
+// Positions that can be given in AddNodeArgs. Any other position is counted
+// from the head, so 2 puts the node right after the head.
+const (
+	// TailPosition appends the node to the chain as the new tail.
+	TailPosition = 0
+	// HeadPosition makes the node the new head.
+	HeadPosition = 1
+)
+
+// RoutingTable describes every chain managed by a Coordinator. Keys are
+// sharded across the chains, so writes and reads for a key must go to the
+// chain returned by ChainFor.
+type RoutingTable struct {
+	// Addresses of the nodes in each chain, ordered from head to tail. The
+	// index of a chain is it's ID.
+	Chains [][]string
+	// States of the nodes in the chains that aren't serving, by address, as
+	// last reported to the Coordinator. Their reads are better sent to other
+	// nodes.
+	States map[string]NodeState
+}
+
+// Serving reports whether the node at address was serving when the routing
+// table was made.
+func (rt *RoutingTable) Serving(address string) bool {
+	_, notServing := rt.States[address]
+	return !notServing
+}
+
+// ChainFor returns the ID of the chain responsible for key.
+func (rt *RoutingTable) ChainFor(key string) int {
+	return ChainForKey(key, len(rt.Chains))
+}
+
+// ChainForKey assigns a key to one of n chains using jump consistent hashing
+// (https://arxiv.org/abs/1406.2294). If the number of chains grows, only the
+// keys that move to the new chains change assignment.
+func ChainForKey(key string, n int) int {
+	if n < 2 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	k := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
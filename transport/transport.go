@@ -6,6 +6,117 @@
 
 package transport
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned by a Node's Read method if the key doesn't exist.
+// Transports may not preserve the error value, so compare the message with
+// IsNotFound.
+var ErrNotFound = errors.New("key doesn't exist")
+
+// IsNotFound reports whether err, possibly received over the network, is
+// ErrNotFound.
+func IsNotFound(err error) bool {
+	return err != nil && err.Error() == ErrNotFound.Error()
+}
+
+// ErrVersionNotFound is returned by a Node's ReadAtVersion method if the version
+// of the key isn't committed, or is no longer kept by the node's store.
+var ErrVersionNotFound = errors.New("version of key doesn't exist")
+
+// IsVersionNotFound reports whether err, possibly received over the network, is
+// ErrVersionNotFound.
+func IsVersionNotFound(err error) bool {
+	return err != nil && err.Error() == ErrVersionNotFound.Error()
+}
+
+// ErrCommitTimeout is returned by writes if the version wasn't committed in
+// time. The write may still be committed later.
+var ErrCommitTimeout = errors.New("timed out waiting for commit")
+
+// IsCommitTimeout reports whether err, possibly received over the network, is
+// ErrCommitTimeout.
+func IsCommitTimeout(err error) bool {
+	return err != nil && err.Error() == ErrCommitTimeout.Error()
+}
+
+// ErrStaleVersion is returned by a Node's Write and Delete methods if the node
+// already has the version, or a newer version, of the key. Versions are
+// assigned by the head, so this means the same write was sent twice or
+// arrived out of order.
+var ErrStaleVersion = errors.New("version is not newer than the newest version of the key")
+
+// ErrStaleStream is returned by a Node's Replicate method if the message is
+// from an old replication stream, or from a node that isn't the predecessor.
+// The sender should stop sending on that stream. Transports may not preserve
+// the error value, so compare the message with IsStaleStream.
+var ErrStaleStream = errors.New("replication stream is no longer accepted")
+
+// IsStaleStream reports whether err, possibly received over the network, is
+// ErrStaleStream.
+func IsStaleStream(err error) bool {
+	return err != nil && err.Error() == ErrStaleStream.Error()
+}
+
+// ErrWatchCompacted is returned by a Node's Watch method if the node no longer
+// has the commits after the revision to watch from. The watcher fell too far
+// behind, the node restarted, or the revision is from another node. The
+// watcher should reload the keys it's watching and watch again from the
+// node's current revision. Transports may not preserve the error value, so
+// compare the message with IsWatchCompacted.
+var ErrWatchCompacted = errors.New("watch revision is no longer kept by the node")
+
+// IsWatchCompacted reports whether err, possibly received over the network, is
+// ErrWatchCompacted.
+func IsWatchCompacted(err error) bool {
+	return err != nil && err.Error() == ErrWatchCompacted.Error()
+}
+
+// ErrDraining is returned by a Node's client reads once it's draining to
+// leave the chain. Clients should go to another node in the chain.
+// Transports may not preserve the error value, so compare the message with
+// IsDraining.
+var ErrDraining = errors.New("node is draining")
+
+// IsDraining reports whether err, possibly received over the network, is
+// ErrDraining.
+func IsDraining(err error) bool {
+	return err != nil && err.Error() == ErrDraining.Error()
+}
+
+// ErrNotServing is returned by a Node's client reads while it's joining the
+// chain, before it has caught up, and by client writes that waited too long for
+// it to catch up. Clients should go to another node in the chain. Transports
+// may not preserve the error value, so compare the message with IsNotServing.
+var ErrNotServing = errors.New("node is not serving yet")
+
+// IsNotServing reports whether err, possibly received over the network, is
+// ErrNotServing.
+func IsNotServing(err error) bool {
+	return err != nil && err.Error() == ErrNotServing.Error()
+}
+
+// ConflictError is returned by conditional writes (CompareAndSwap and
+// PutIfAbsent) if the key doesn't match the condition. Latest and Exists
+// describe the key at the head of the chain when the write was rejected.
+type ConflictError struct {
+	Key string
+	// Newest version of the key. Only set if Exists is true.
+	Latest uint64
+	// False if the key has never been written or is deleted.
+	Exists bool
+}
+
+func (e *ConflictError) Error() string {
+	if !e.Exists {
+		return fmt.Sprintf("conflict: key %s doesn't exist", e.Key)
+	}
+	return fmt.Sprintf("conflict: key %s is at version %d", e.Key, e.Latest)
+}
+
 // Position of neighbor node on the chain. Head nodes have no previous
 // neighbors, and tail nodes have no next neighbors.
 type NeighborPos int
@@ -16,25 +127,93 @@ const (
 	NeighborPosTail
 )
 
-// CoordinatorService is the API provided by the Coordinator.
+// CoordinatorService is the API provided by the Coordinator. Writes return
+// once the new version has been committed by every node in the chain, and
+// return the committed version.
 type CoordinatorService interface {
-	AddNode(address string) (*NodeMeta, error)
-	Write(key string, value []byte) error
+	AddNode(args *AddNodeArgs) (*NodeMeta, error)
+	// Write writes a new version of key. A ttl above zero makes the key expire
+	// like an Item with a TTL.
+	Write(key string, value []byte, ttl time.Duration) (uint64, error)
+	Delete(key string) (uint64, error)
+	CompareAndSwap(key string, expected uint64, value []byte) (uint64, error)
+	PutIfAbsent(key string, value []byte) (uint64, error)
+	// WriteBatch writes every item and returns the committed versions in the
+	// same order as items.
+	WriteBatch(items []Item) ([]uint64, error)
+	// Transaction applies the writes of txn atomically if every precondition
+	// holds, and returns the committed versions in the same order as the
+	// writes. Every key in txn has to belong to the same chain.
+	Transaction(txn *Transaction) ([]uint64, error)
 	RemoveNode(address string) error
+	// ReportState records the state a node is in. Nodes report every change.
+	ReportState(address string, state NodeState) error
+	// Decommission starts taking the node out of it's chain gracefully, if it
+	// hasn't started yet, and returns how far along it is. Call it again to
+	// follow the progress.
+	Decommission(address string) (*DecommissionStatus, error)
+	Routes() (*RoutingTable, error)
 }
 
 // NodeService is the API provided by a Node.
 type NodeService interface {
 	Ping() error
 	Update(meta *NodeMeta) error
-	ClientWrite(key string, value []byte) error
+	ClientWrite(key string, value []byte) (uint64, error)
 	Write(key string, value []byte, version uint64) error
+	ClientDelete(key string) (uint64, error)
+	CompareAndSwap(key string, expected uint64, value []byte) (uint64, error)
+	PutIfAbsent(key string, value []byte) (uint64, error)
+	Delete(key string, version uint64) error
+	ClientWriteBatch(items []Item) ([]uint64, error)
+	ClientTransaction(txn *Transaction) ([]uint64, error)
+	WriteBatch(items []VersionedItem) error
+	Replicate(msg *Replication) error
 	LatestVersion(key string) (string, uint64, error)
 	FwdPropagate(verByKey *PropagateRequest) (*PropagateResponse, error)
 	BackPropagate(verByKey *PropagateRequest) (*PropagateResponse, error)
+	// MerkleHashes returns the hashes of the nodes of the Merkle tree over the
+	// node's committed versions at level, in the same order as indexes. Level 0
+	// is the root. Level MerkleDepth holds the buckets.
+	MerkleHashes(level int, indexes []int) ([]uint64, error)
+	// BackPropagateBuckets is BackPropagate for the keys in the given buckets
+	// of the Merkle tree. verByKey only needs the keys in those buckets.
+	BackPropagateBuckets(buckets []int, verByKey *PropagateRequest) (*PropagateResponse, error)
 	Commit(key string, version uint64) error
-	Read(key string) (string, []byte, error)
+	CommitBatch(versions map[string]uint64) error
+	// Read returns the value of key and it's version. A nil opts is a
+	// ReadStrong read.
+	Read(key string, opts *ReadOpts) (string, []byte, uint64, error)
+	// ReadAtVersion returns the value of a committed version of key.
+	ReadAtVersion(key string, version uint64) (string, []byte, error)
+	// History returns the committed versions of key the node keeps, oldest
+	// first.
+	History(key string) ([]HistoryItem, error)
+	// Scan returns a page of up to limit keys from start to end, in key order,
+	// with the latest committed value of each, like Read. An empty end means
+	// there's no upper bound.
+	Scan(start, end string, limit int) (*ScanResult, error)
+	// ScanPrefix returns a page of up to limit keys that start with prefix and
+	// come after cursor. An empty cursor starts from the first key.
+	ScanPrefix(prefix, cursor string, limit int) (*ScanResult, error)
 	ReadAll() (*[]Item, error)
+	// Export returns a chunk of up to limit committed items, in key order,
+	// starting after cursor. An empty cursor starts from the first key.
+	Export(cursor string, limit int) (*ExportChunk, error)
+	// Watch returns the commits of keys that start with prefix after revision
+	// from. If there are none yet, it waits for one, up to a timeout set by the
+	// node, and returns no events. A from of 0 returns right away with the
+	// node's current revision.
+	Watch(prefix string, from uint64) (*WatchResult, error)
+	// Drain stops the node from serving client reads and writes, so it can
+	// leave the chain, and returns what it still has to pass on to it's
+	// neighbors.
+	Drain() (*DrainStatus, error)
+	// Undrain puts a draining node back to work, after it's decommission
+	// failed.
+	Undrain() error
+	// Status returns the state of the node and where it sits in the chain.
+	Status() (*NodeStatus, error)
 }
 
 // Client facilitates communication.
@@ -45,6 +224,14 @@ type Client interface {
 	Connect(address string) error
 }
 
+// RaftService is the API provided by a Coordinator that is a member of a
+// replicated cluster of Coordinators. Coordinators use it to elect a leader and
+// to replicate changes to the chain's membership.
+type RaftService interface {
+	RequestVote(args *VoteRequest) (*VoteResponse, error)
+	AppendEntries(args *AppendRequest) (*AppendResponse, error)
+}
+
 type NodeClient interface {
 	Client
 	NodeService
@@ -55,17 +242,57 @@ type CoordinatorClient interface {
 	CoordinatorService
 }
 
+type RaftClient interface {
+	Client
+	RaftService
+}
+
 // NodeClientFactory is for creating NodeClients. The Coordinator and Node
 // services use when creating new connections to nodes.
 type NodeClientFactory func() NodeClient
 
+// CoordinatorClientFactory is for creating CoordinatorClients. Coordinators
+// that are not the leader of the cluster use it to forward requests.
+type CoordinatorClientFactory func() CoordinatorClient
+
+// RaftClientFactory is for creating RaftClients. Coordinators use it to create
+// connections to the other members of the cluster.
+type RaftClientFactory func() RaftClient
+
+// AddNodeArgs is sent by a node to announce itself to the Coordinator.
+type AddNodeArgs struct {
+	// Address of the node, reachable by the Coordinator and other nodes.
+	Address string
+	// Chain the node should join. Use AnyChain to let the Coordinator choose.
+	Chain int
+	// Where in the chain the node is inserted, counting from HeadPosition at 1.
+	// TailPosition, the default, and positions past the tail append the node as
+	// the new tail.
+	Position int
+	// Learner stages the node outside the chain until it has caught up. The
+	// Coordinator replies with Learner set, and the node joins with another
+	// AddNode once it's copied everything from the node in Prev.
+	Learner bool
+}
+
 // NodeMeta is for sending info to a node to let the node know where in the
 // chain it sits. The node will update itself when receiving this message.
 type NodeMeta struct {
 	IsHead, IsTail   bool
 	Prev, Next, Tail string // host + port to neighbors and tail node
+	// Learner is set in the reply to AddNode if the node isn't in the chain
+	// yet. It should catch up from Prev and then call AddNode again.
+	Learner bool
 }
 
+// Shape of the Merkle trees nodes keep over their committed versions. Every
+// node in a chain has to use the same shape to compare trees. Each tree node
+// has MerkleFanout children, so there are MerkleFanout^MerkleDepth buckets.
+const (
+	MerkleFanout = 16
+	MerkleDepth  = 3
+)
+
 // PropagateRequest is the request a node should send to the predecessor or
 // successor (depending on whether it's forward or backward propagation) to ask
 // for objects it needs in order to catch up with the rest of the chain.
@@ -78,10 +305,306 @@ type PropagateResponse map[string][]ValueVersion
 type ValueVersion struct {
 	Value   []byte
 	Version uint64
+	// Deleted is true if this version is a tombstone.
+	Deleted bool
+	// ExpiresAt is when the key expires. It's the zero time if it doesn't.
+	ExpiresAt time.Time
 }
 
 // Item is a single key/val pair.
 type Item struct {
 	Key   string
 	Value []byte
+	// TTL is how long the key lives before it's deleted. The head of the chain
+	// turns it into an expiry time when the item is written. Zero means the key
+	// doesn't expire.
+	TTL time.Duration
+}
+
+// VersionedItem is a single version of a key/val pair. Batches of writes are
+// sent down the chain as VersionedItems.
+type VersionedItem struct {
+	Key     string
+	Value   []byte
+	Version uint64
+	// Deleted is true if this version is a tombstone.
+	Deleted bool
+	// ExpiresAt is when the head of the chain deletes the key, unless a newer
+	// version is written first. It's the zero time if the key doesn't expire.
+	ExpiresAt time.Time
+}
+
+// HistoryItem is one committed version of a key.
+type HistoryItem struct {
+	Value   []byte
+	Version uint64
+	// Deleted is true if this version is a tombstone.
+	Deleted bool
+	// CommittedAt is when the node serving the request committed the version.
+	// It's the zero time if the tail has committed the version but the node
+	// hasn't heard about it yet.
+	CommittedAt time.Time
+}
+
+// ScanResult is one page of a Scan or ScanPrefix. Deleted keys are left out,
+// so a page can have fewer items than the limit even if there are more keys.
+type ScanResult struct {
+	Items []VersionedItem
+	// Next continues the scan: it's the start of the next page for Scan, and the
+	// cursor for ScanPrefix. It's empty once there are no more keys.
+	Next string
+}
+
+// ExportChunk is one chunk of an Export. Deleted keys are left out, so a chunk
+// can have fewer items than the limit even if there are more keys.
+type ExportChunk struct {
+	Items []VersionedItem
+	// Next is the cursor for the next chunk. It's empty once every key has
+	// been exported.
+	Next string
+}
+
+// WatchEvent is a version of a key committed by the node being watched.
+type WatchEvent struct {
+	Key     string
+	Value   []byte
+	Version uint64
+	Deleted bool
+	// Revision orders the commits made by a single node. It's only meaningful
+	// to the node that sent the event.
+	Revision uint64
+}
+
+// WatchResult is a reply to Watch.
+type WatchResult struct {
+	Events []WatchEvent
+	// Revision to watch from next. It can be newer than the last event, since
+	// commits of keys that don't match the prefix are skipped.
+	Revision uint64
+}
+
+// DrainStatus is what a draining node still has to pass on to it's neighbors
+// before it can leave the chain without them relying on propagation to catch
+// up.
+type DrainStatus struct {
+	// Keys with versions the tail hasn't committed yet, as far as the node
+	// knows.
+	Dirty int
+	// Replication messages the successor hasn't acknowledged.
+	Replicating int
+	// Commits the predecessor hasn't been sent.
+	Committing int
+	// Client writes the node is waiting on. Only the head has any.
+	Writing int
+}
+
+// CaughtUp reports whether the node's neighbors have everything it has. Dirty
+// keys don't count, since the successor has the dirty versions once they've
+// been replicated.
+func (s *DrainStatus) CaughtUp() bool {
+	return s.Replicating == 0 && s.Committing == 0 && s.Writing == 0
+}
+
+// DecommissionStage is how far along the decommission of a node is.
+type DecommissionStage int
+
+const (
+	// DecommissionDraining is waiting for the node's neighbors to catch up.
+	// The node no longer serves client reads.
+	DecommissionDraining DecommissionStage = iota
+	// DecommissionRemoving is splicing the node out of the chain.
+	DecommissionRemoving
+	// DecommissionDone means the node has left the chain and can be stopped.
+	DecommissionDone
+	// DecommissionFailed means the node couldn't be decommissioned. If it's
+	// neighbors didn't catch up, it's back to serving in the chain.
+	// Decommission can be called again to retry.
+	DecommissionFailed
+)
+
+func (s DecommissionStage) String() string {
+	switch s {
+	case DecommissionDraining:
+		return "draining"
+	case DecommissionRemoving:
+		return "removing"
+	case DecommissionDone:
+		return "done"
+	case DecommissionFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// DecommissionStatus is the progress of decommissioning a node.
+type DecommissionStatus struct {
+	Address string
+	Stage   DecommissionStage
+	// Last status reported by the draining node.
+	Node DrainStatus
+	// Why the decommission failed.
+	Error string
+}
+
+// NodeState is how far along a node is in joining, serving and leaving it's
+// chain.
+type NodeState int
+
+const (
+	// NodeJoining nodes are announcing themselves to the Coordinator.
+	NodeJoining NodeState = iota
+	// NodeSyncing nodes are copying the keys they're missing from other nodes.
+	// They may already be in the chain.
+	NodeSyncing
+	// NodeServing nodes are caught up and serve client reads and writes.
+	NodeServing
+	// NodeDraining nodes are leaving the chain. See Drain.
+	NodeDraining
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodeJoining:
+		return "joining"
+	case NodeSyncing:
+		return "syncing"
+	case NodeServing:
+		return "serving"
+	case NodeDraining:
+		return "draining"
+	}
+	return "unknown"
+}
+
+// NodeStatus is what a node reports about itself.
+type NodeStatus struct {
+	Address        string
+	State          NodeState
+	IsHead, IsTail bool
+	// Addresses of the neighbors and the tail of the node's chain.
+	Prev, Next, Tail string
+}
+
+// Replication is one message in the ordered stream of writes that a node
+// pipelines to it's successor. The successor applies the messages in Seq
+// order, whatever order they arrive in.
+type Replication struct {
+	// Address of the sending node.
+	From string
+	// Stream identifies the sender's connection to the successor. A node starts
+	// a new stream, with a higher id, whenever it connects to a new successor.
+	Stream uint64
+	// Position of the message in the stream, starting at 1.
+	Seq   uint64
+	Items []VersionedItem
+}
+
+// ReadMode is the consistency of a read.
+type ReadMode int
+
+const (
+	// ReadStrong returns the latest version committed by the tail. If the key is
+	// dirty on the node serving the read, the node asks the tail for the latest
+	// committed version.
+	ReadStrong ReadMode = iota
+	// ReadEventual returns the newest version the node has, committed or not,
+	// without asking the tail.
+	ReadEventual
+	// ReadBounded returns the node's latest committed version of a dirty key
+	// if it's within one of the bounds in ReadOpts. Otherwise it's a ReadStrong
+	// read.
+	ReadBounded
+)
+
+// ParseReadMode parses the name of a read mode: strong, eventual or bounded.
+func ParseReadMode(name string) (ReadMode, error) {
+	switch name {
+	case "strong":
+		return ReadStrong, nil
+	case "eventual":
+		return ReadEventual, nil
+	case "bounded":
+		return ReadBounded, nil
+	}
+	return ReadStrong, fmt.Errorf("unknown read mode %s", name)
+}
+
+// ReadOpts are the options for a read.
+type ReadOpts struct {
+	Mode ReadMode
+	// For ReadBounded reads, the longest the node's committed version may have
+	// been out of date.
+	MaxStaleness time.Duration
+	// For ReadBounded reads, the most versions the node's committed version may
+	// be behind the newest version the node has.
+	MaxVersionGap uint64
+}
+
+// Precondition is a check on the newest version of a key at the head of the
+// chain, committed or not.
+type Precondition struct {
+	Key string
+	// Version the key has to be at. Ignored if Absent is true.
+	Version uint64
+	// Absent requires the key to not exist, or to be deleted.
+	Absent bool
+}
+
+// Transaction is a group of writes that are applied atomically, and only if
+// every precondition holds. If one doesn't, a *ConflictError is returned for the
+// first precondition that failed and nothing is written.
+type Transaction struct {
+	Preconditions []Precondition
+	Writes        []Item
+}
+
+// Keys returns every key the transaction checks or writes.
+func (t *Transaction) Keys() []string {
+	keys := make([]string, 0, len(t.Preconditions)+len(t.Writes))
+	for _, p := range t.Preconditions {
+		keys = append(keys, p.Key)
+	}
+	for _, w := range t.Writes {
+		keys = append(keys, w.Key)
+	}
+	return keys
+}
+
+// RaftEntry is a single entry in the replicated log of the Coordinator cluster.
+type RaftEntry struct {
+	Term    uint64
+	Command []byte
+}
+
+// VoteRequest is sent by a candidate to ask for a vote during an election.
+type VoteRequest struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// VoteResponse is the reply to a VoteRequest.
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest is sent by the leader to replicate log entries. An
+// AppendRequest without any entries is a heartbeat.
+type AppendRequest struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []RaftEntry
+	LeaderCommit uint64
+}
+
+// AppendResponse is the reply to an AppendRequest. If Success is false,
+// ConflictIndex is a hint for where the leader should continue from.
+type AppendResponse struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
 }